@@ -29,8 +29,12 @@ func main() {
 	stop := ctx.Done()
 
 	secretInformer := informers.GetSecretInformer(maroonedpodsCli, maroonedpodsNS)
+	configInformer := informers.GetMaroonedPodsConfigInformer(maroonedpodsCli)
+	namespaceInformer := informers.GetNamespaceInformer(maroonedpodsCli)
 	go secretInformer.Run(stop)
-	if !cache.WaitForCacheSync(stop, secretInformer.HasSynced) {
+	go configInformer.Run(stop)
+	go namespaceInformer.Run(stop)
+	if !cache.WaitForCacheSync(stop, secretInformer.HasSynced, configInformer.HasSynced, namespaceInformer.HasSynced) {
 		os.Exit(1)
 	}
 
@@ -50,6 +54,8 @@ func main() {
 		util.DefaultPort,
 		secretCertManager,
 		maroonedpodsCli,
+		configInformer,
+		namespaceInformer,
 	)
 	if err != nil {
 		klog.Fatalf("UploadProxy failed to initialize: %v\n", errors.WithStack(err))
@@ -2,10 +2,15 @@
 #
 # MaroonedPods Node Boot Script
 # Reads configuration from cloud-init and starts k3s agent with pod-specific configuration
+# Warm pool VMs join without a pod and pre-pull the images of their pool
 
 set -e
 
 MAROONED_CONFIG="/etc/marooned/join-info.yaml"
+SCRATCH_DISK="/dev/disk/by-id/virtio-marooned-scratch"
+SCRATCH_MOUNT="/var/lib/marooned/scratch"
+# containerd and kubelet data, moved to the scratch disk
+SCRATCH_PATHS="/var/lib/rancher/k3s/agent/containerd /var/lib/kubelet"
 LOG_PREFIX="[marooned-node-boot]"
 
 log() {
@@ -46,6 +51,10 @@ parse_config() {
     TOKEN=$(grep "^token:" "$MAROONED_CONFIG" | awk '{print $2}' | tr -d '"' | tr -d "'")
     POD_UID=$(grep "^pod_uid:" "$MAROONED_CONFIG" | awk '{print $2}' | tr -d '"' | tr -d "'")
     TAINT_KEY=$(grep "^taint_key:" "$MAROONED_CONFIG" | awk '{print $2}' | tr -d '"' | tr -d "'")
+    POOL_TAINT=$(grep "^pool_taint:" "$MAROONED_CONFIG" | awk '{print $2}' | tr -d '"' | tr -d "'")
+    NETWORK_BINDING=$(grep "^network_binding:" "$MAROONED_CONFIG" | awk '{print $2}' | tr -d '"' | tr -d "'")
+    NODE_PASSWORD=$(grep "^node_password:" "$MAROONED_CONFIG" | awk '{print $2}' | tr -d '"' | tr -d "'")
+    PREPULL_IMAGES=$(grep "^prepull_images:" "$MAROONED_CONFIG" | awk '{print $2}' | tr -d '"' | tr -d "'")
 
     # Validate required fields
     if [ -z "$SERVER_URL" ]; then
@@ -56,8 +65,9 @@ parse_config() {
         error "token not found in configuration"
     fi
 
+    # Warm pool VMs are dedicated to a pod once claimed
     if [ -z "$POD_UID" ]; then
-        error "pod_uid not found in configuration"
+        log "pod_uid not specified, joining as a warm pool node"
     fi
 
     if [ -z "$TAINT_KEY" ]; then
@@ -65,33 +75,143 @@ parse_config() {
         log "taint_key not specified, using default: $TAINT_KEY"
     fi
 
+    # Nothing may run on a warm pool node before it is claimed
+    if [ -z "$POD_UID" ] && [ -z "$POOL_TAINT" ]; then
+        POOL_TAINT="$TAINT_KEY/pool=$(hostname):NoExecute"
+    fi
+
+    if [ -z "$NETWORK_BINDING" ]; then
+        NETWORK_BINDING="masquerade"
+    fi
+
     log "Configuration parsed:"
     log "  server_url: $SERVER_URL"
     log "  pod_uid: $POD_UID"
     log "  taint_key: $TAINT_KEY"
+    log "  pool_taint: $POOL_TAINT"
+    log "  network_binding: $NETWORK_BINDING"
+    log "  prepull_images: $PREPULL_IMAGES"
+}
+
+# Detect the address the node is reachable on
+# With bridge and passt bindings the guest owns the pod IP, with masquerade
+# the guest sits behind NAT and k3s keeps its default address detection
+detect_node_ip() {
+    NODE_IP=""
+    if [ "$NETWORK_BINDING" = "masquerade" ]; then
+        return
+    fi
+
+    local timeout=60
+    local elapsed=0
+    local iface=""
+
+    log "Waiting for the pod network address..."
+    while [ -z "$NODE_IP" ]; do
+        if [ $elapsed -ge $timeout ]; then
+            error "Timeout waiting for the pod network address"
+        fi
+        iface=$(ip -4 route show default | awk '{print $5; exit}')
+        if [ -n "$iface" ]; then
+            NODE_IP=$(ip -4 -o addr show dev "$iface" scope global | awk '{split($4, a, "/"); print a[1]; exit}')
+        fi
+        if [ -z "$NODE_IP" ]; then
+            sleep 1
+            elapsed=$((elapsed + 1))
+        fi
+    done
+
+    log "Node IP: $NODE_IP ($iface)"
+}
+
+# Put the containerd and kubelet data on the scratch disk sized for the pod
+# Image layers, emptyDirs and logs would otherwise fill up the root disk
+setup_scratch_disk() {
+    if [ ! -b "$SCRATCH_DISK" ]; then
+        log "No scratch disk attached, keeping data on the root disk"
+        return
+    fi
+
+    if ! blkid "$SCRATCH_DISK" >/dev/null 2>&1; then
+        log "Formatting scratch disk $SCRATCH_DISK..."
+        mkfs.ext4 -q -L marooned-scratch "$SCRATCH_DISK" || error "Failed to format scratch disk"
+    fi
+
+    mkdir -p "$SCRATCH_MOUNT"
+    if ! mountpoint -q "$SCRATCH_MOUNT"; then
+        mount "$SCRATCH_DISK" "$SCRATCH_MOUNT" || error "Failed to mount scratch disk"
+    fi
+
+    local path
+    local target
+    for path in $SCRATCH_PATHS; do
+        if mountpoint -q "$path"; then
+            continue
+        fi
+        target="$SCRATCH_MOUNT/$(basename "$path")"
+        mkdir -p "$path"
+        # Keep data shipped with the node image, like pre-pulled images
+        if [ ! -d "$target" ]; then
+            mkdir -p "$target"
+            cp -a "$path/." "$target/"
+        fi
+        mount --bind "$target" "$path" || error "Failed to mount $target at $path"
+        log "  $path -> $target"
+    done
+
+    log "Scratch disk mounted at $SCRATCH_MOUNT ($(df -h --output=size "$SCRATCH_MOUNT" | tail -1 | tr -d ' '))"
 }
 
 # Start k3s agent with pod-specific configuration
 start_k3s_agent() {
     log "Starting k3s agent..."
 
-    # Build node labels
-    NODE_LABELS="maroonedpods.io/pod-uid=$POD_UID"
+    # Build node labels and taints, the controller swaps the pool taint of warm pool nodes when claimed.
+    # The virtual-node label is what the maroonedpods RuntimeClass selects.
+    NODE_LABELS="maroonedpods.io/virtual-node=true"
+    NODE_TAINTS=""
+    # Ties the node to its VMI, the controller checks it before releasing the pods of an island
+    VM_UUID=$(cat /sys/class/dmi/id/product_uuid 2>/dev/null || true)
+    if [ -n "$VM_UUID" ]; then
+        NODE_LABELS="$NODE_LABELS maroonedpods.io/vm-uuid=$VM_UUID"
+    fi
+    if [ -n "$POD_UID" ]; then
+        NODE_LABELS="$NODE_LABELS maroonedpods.io/pod-uid=$POD_UID"
+        NODE_TAINTS="$TAINT_KEY/dedicated=$POD_UID:NoSchedule"
+    else
+        NODE_TAINTS="$POOL_TAINT"
+    fi
 
-    # Build node taints
-    NODE_TAINTS="$TAINT_KEY/dedicated=$POD_UID:NoSchedule"
+    # Rejoin under the same node name when the VM is restarted with an empty root disk
+    if [ -n "$NODE_PASSWORD" ]; then
+        mkdir -p /etc/rancher/node
+        echo "$NODE_PASSWORD" > /etc/rancher/node/password
+        chmod 600 /etc/rancher/node/password
+    fi
 
     # Create k3s agent config file
     mkdir -p /etc/rancher/k3s
     cat > /etc/rancher/k3s/config.yaml <<EOF
 server: ${SERVER_URL}
 token: ${TOKEN}
-node-label:
-  - ${NODE_LABELS}
-node-taint:
-  - ${NODE_TAINTS}
 EOF
 
+    echo "node-label:" >> /etc/rancher/k3s/config.yaml
+    for label in $NODE_LABELS; do
+        echo "  - $label" >> /etc/rancher/k3s/config.yaml
+    done
+
+    if [ -n "$NODE_TAINTS" ]; then
+        echo "node-taint:" >> /etc/rancher/k3s/config.yaml
+        for taint in $NODE_TAINTS; do
+            echo "  - $taint" >> /etc/rancher/k3s/config.yaml
+        done
+    fi
+
+    if [ -n "$NODE_IP" ]; then
+        echo "node-ip: ${NODE_IP}" >> /etc/rancher/k3s/config.yaml
+    fi
+
     log "Starting k3s-agent service with configuration:"
     log "  Labels: $NODE_LABELS"
     log "  Taints: $NODE_TAINTS"
@@ -137,14 +257,51 @@ check_readiness() {
     return 0
 }
 
+# Pull the images of the warm pool, and report the pulled images on the node
+# The controller only hands out the VM once the node carries the annotation
+prepull_images() {
+    if [ -z "$PREPULL_IMAGES" ]; then
+        return
+    fi
+
+    log "Pre-pulling images..."
+
+    local image
+    local pulled=""
+    for image in $(echo "$PREPULL_IMAGES" | tr ',' ' '); do
+        if k3s crictl pull "$image" >/dev/null; then
+            log "  pulled $image"
+            pulled="${pulled:+$pulled,}$image"
+        else
+            log "Warning: failed to pull $image"
+        fi
+    done
+
+    local timeout=60
+    local elapsed=0
+    until k3s kubectl --kubeconfig /var/lib/rancher/k3s/agent/kubelet.kubeconfig \
+        annotate --overwrite node "$(hostname)" "maroonedpods.io/pre-pulled-images=$pulled" >/dev/null; do
+        if [ $elapsed -ge $timeout ]; then
+            error "Timeout reporting the pre-pulled images"
+        fi
+        sleep 1
+        elapsed=$((elapsed + 1))
+    done
+
+    log "Pre-pulled images reported on node $(hostname)"
+}
+
 # Main execution
 main() {
     log "MaroonedPods Node Boot Starting..."
 
     wait_for_config
     parse_config
+    detect_node_ip
+    setup_scratch_disk
     start_k3s_agent
     check_readiness
+    prepull_images
 
     log "MaroonedPods Node Boot Complete"
 }
@@ -0,0 +1,49 @@
+#!/bin/bash
+#
+# MaroonedPods Registries Sync Script
+# Applies the registries Secret passed to the VM by the controller to the k3s agent:
+# the credentials of the image pull secrets of the pods and the registry mirror
+
+set -e
+
+REGISTRIES_DIR="/var/lib/marooned/registries"
+K3S_REGISTRIES="/etc/rancher/k3s/registries.yaml"
+REGISTRY_MIRROR_CA="/etc/marooned/registry-mirror-ca.crt"
+LOG_PREFIX="[marooned-registries-sync]"
+
+CHANGED=false
+
+log() {
+    echo "$LOG_PREFIX $*"
+}
+
+# Copy a key of the Secret to its place in the guest, if it changed
+sync_file() {
+    local source="$REGISTRIES_DIR/$1"
+    local target="$2"
+
+    if [ ! -f "$source" ]; then
+        return
+    fi
+    if cmp -s "$source" "$target"; then
+        return
+    fi
+
+    install -D -m 600 "$source" "$target"
+    log "Updated $target"
+    CHANGED=true
+}
+
+if ! mountpoint -q "$REGISTRIES_DIR"; then
+    log "Registries Secret not mounted at $REGISTRIES_DIR, nothing to apply"
+    exit 0
+fi
+
+sync_file registry-mirror-ca.crt "$REGISTRY_MIRROR_CA"
+sync_file registries.yaml "$K3S_REGISTRIES"
+
+# k3s reads registries.yaml when it starts, running containers are left alone by the restart
+if [ "$CHANGED" = true ] && systemctl is-active --quiet k3s-agent.service; then
+    log "Restarting k3s-agent to apply the registries"
+    systemctl restart k3s-agent.service
+fi
@@ -44,6 +44,11 @@ func GetNodesInformer(maroonedpodsCli client.MaroonedPodsClient) cache.SharedInd
 	return cache.NewSharedIndexInformer(listWatcher, &v1.Node{}, 1*time.Hour, cache.Indexers{})
 }
 
+func GetNamespaceInformer(maroonedpodsCli client.MaroonedPodsClient) cache.SharedIndexInformer {
+	listWatcher := NewListWatchFromClient(maroonedpodsCli.CoreV1().RESTClient(), "namespaces", metav1.NamespaceAll, fields.Everything(), labels.Everything())
+	return cache.NewSharedIndexInformer(listWatcher, &v1.Namespace{}, 1*time.Hour, cache.Indexers{})
+}
+
 func GetSecretInformer(maroonedpodsCli client.MaroonedPodsClient, ns string) cache.SharedIndexInformer {
 	listWatcher := NewListWatchFromClient(maroonedpodsCli.CoreV1().RESTClient(), "secrets", ns, fields.Everything(), labels.Everything())
 	return cache.NewSharedIndexInformer(listWatcher, &v1.Secret{}, 1*time.Hour, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
@@ -54,6 +59,11 @@ func GetVMIInformer(maroonedpodsCli client.MaroonedPodsClient) cache.SharedIndex
 	return cache.NewSharedIndexInformer(listWatcher, &k6tv1.VirtualMachineInstance{}, 1*time.Hour, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
 }
 
+func GetMigrationInformer(maroonedpodsCli client.MaroonedPodsClient) cache.SharedIndexInformer {
+	listWatcher := NewListWatchFromClient(maroonedpodsCli.KubevirtClient().KubevirtV1().RESTClient(), "virtualmachineinstancemigrations", metav1.NamespaceAll, fields.Everything(), labels.Everything())
+	return cache.NewSharedIndexInformer(listWatcher, &k6tv1.VirtualMachineInstanceMigration{}, 1*time.Hour, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+}
+
 // NewListWatchFromClient creates a new ListWatch from the specified client, resource, kubevirtNamespace and field selector.
 func NewListWatchFromClient(c cache.Getter, resource string, namespace string, fieldSelector fields.Selector, labelSelector labels.Selector) *cache.ListWatch {
 	listFunc := func(options metav1.ListOptions) (runtime.Object, error) {
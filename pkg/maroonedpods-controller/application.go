@@ -56,6 +56,8 @@ type MaroonedPodsControllerApp struct {
 	configInformer               cache.SharedIndexInformer
 	vmiInformer                  cache.SharedIndexInformer
 	nodeInformer                 cache.SharedIndexInformer
+	migrationInformer            cache.SharedIndexInformer
+	namespaceInformer            cache.SharedIndexInformer
 	readyChan                    chan bool
 	enqueueAllGateControllerChan chan struct{}
 	leaderElector                *leaderelection.LeaderElector
@@ -96,6 +98,8 @@ func Execute() {
 	app.configInformer = informers.GetMaroonedPodsConfigInformer(app.maroonedpodsCli)
 	app.vmiInformer = informers.GetVMIInformer(app.maroonedpodsCli)
 	app.nodeInformer = informers.GetNodesInformer(app.maroonedpodsCli)
+	app.migrationInformer = informers.GetMigrationInformer(app.maroonedpodsCli)
+	app.namespaceInformer = informers.GetNamespaceInformer(app.maroonedpodsCli)
 	stop := ctx.Done()
 
 	app.initMaroonedPodsGateController(stop)
@@ -131,6 +135,9 @@ func (mca *MaroonedPodsControllerApp) initMaroonedPodsGateController(stop <-chan
 		mca.vmiInformer,
 		mca.nodeInformer,
 		mca.configInformer,
+		mca.maroonedpodsInformer,
+		mca.migrationInformer,
+		mca.namespaceInformer,
 		stop,
 		mca.enqueueAllGateControllerChan,
 	)
@@ -216,6 +223,8 @@ func (mca *MaroonedPodsControllerApp) onStartedLeading() func(ctx context.Contex
 		go mca.configInformer.Run(stop)
 		go mca.vmiInformer.Run(stop)
 		go mca.nodeInformer.Run(stop)
+		go mca.migrationInformer.Run(stop)
+		go mca.namespaceInformer.Run(stop)
 
 		if !cache.WaitForCacheSync(stop,
 			mca.podInformer.HasSynced,
@@ -223,6 +232,8 @@ func (mca *MaroonedPodsControllerApp) onStartedLeading() func(ctx context.Contex
 			mca.nodeInformer.HasSynced,
 			mca.maroonedpodsInformer.HasSynced,
 			mca.configInformer.HasSynced,
+			mca.migrationInformer.HasSynced,
+			mca.namespaceInformer.HasSynced,
 		) {
 			klog.Warningf("failed to wait for caches to sync")
 		}
@@ -0,0 +1,148 @@
+package mp_controller
+
+import (
+	"encoding/json"
+
+	. "github.com/onsi/gomega"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/workqueue"
+	virtv1 "kubevirt.io/api/core/v1"
+	"maroonedpods.io/maroonedpods/pkg/client"
+	kubevirtclient "maroonedpods.io/maroonedpods/pkg/generated/kubevirt/clientset/versioned"
+	kubevirtfake "maroonedpods.io/maroonedpods/pkg/generated/kubevirt/clientset/versioned/fake"
+	generatedclient "maroonedpods.io/maroonedpods/pkg/generated/maroonedpods/clientset/versioned"
+	"maroonedpods.io/maroonedpods/pkg/util"
+	v1alpha1 "maroonedpods.io/maroonedpods/staging/src/maroonedpods.io/api/pkg/apis/core/v1alpha1"
+)
+
+// fakeMaroonedPodsClient serves the kubernetes and KubeVirt APIs from fake clientsets
+type fakeMaroonedPodsClient struct {
+	*k8sfake.Clientset
+	kubevirt *kubevirtfake.Clientset
+}
+
+func (f *fakeMaroonedPodsClient) RestClient() *rest.RESTClient { return nil }
+
+func (f *fakeMaroonedPodsClient) MaroonedPods() client.MaroonedPodsInterface { return nil }
+
+func (f *fakeMaroonedPodsClient) GeneratedMaroonedPodsClient() generatedclient.Interface { return nil }
+
+func (f *fakeMaroonedPodsClient) KubevirtClient() kubevirtclient.Interface { return f.kubevirt }
+
+func (f *fakeMaroonedPodsClient) Config() *rest.Config { return nil }
+
+func newInformer(objType runtime.Object, objects ...interface{}) cache.SharedIndexInformer {
+	informer := cache.NewSharedIndexInformer(&cache.ListWatch{}, objType, 0, cache.Indexers{})
+	for _, obj := range objects {
+		Expect(informer.GetStore().Add(obj)).To(Succeed())
+	}
+	return informer
+}
+
+// newTestController returns a controller backed by fake clients and unstarted informers
+func newTestController(config *v1alpha1.MaroonedPodsConfig, objects ...runtime.Object) (*MaroonedPodsGateController, *fakeMaroonedPodsClient) {
+	cli := &fakeMaroonedPodsClient{
+		Clientset: k8sfake.NewSimpleClientset(objects...),
+		kubevirt:  kubevirtfake.NewSimpleClientset(),
+	}
+	var configs, namespaces []interface{}
+	for _, obj := range objects {
+		if ns, ok := obj.(*v1.Namespace); ok {
+			namespaces = append(namespaces, ns)
+		}
+	}
+	if config != nil {
+		configs = append(configs, config)
+	}
+	ctrl := &MaroonedPodsGateController{
+		maroonedpodsCli:      cli,
+		podInformer:          newInformer(&v1.Pod{}),
+		vmiInformer:          newInformer(&virtv1.VirtualMachineInstance{}),
+		nodeInformer:         newInformer(&v1.Node{}),
+		configInformer:       newInformer(&v1alpha1.MaroonedPodsConfig{}, configs...),
+		maroonedpodsInformer: newInformer(&v1alpha1.MaroonedPods{}),
+		migrationInformer:    newInformer(&virtv1.VirtualMachineInstanceMigration{}),
+		namespaceInformer:    newInformer(&v1.Namespace{}, namespaces...),
+		recorder:             record.NewFakeRecorder(100),
+		queue:                workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "test-queue"),
+	}
+	return ctrl, cli
+}
+
+// podOption customizes the pod returned by newPod
+type podOption func(pod *v1.Pod)
+
+// newPod returns a pod of a "web" Deployment in the tenant namespace, with a single container
+func newPod(name string, options ...podOption) *v1.Pod {
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "tenant",
+			UID:       types.UID(name + "-uid"),
+			Labels:    map[string]string{"app": "web", "pod-template-hash": "abc"},
+		},
+		Spec: v1.PodSpec{
+			Containers: []v1.Container{{Name: "nginx", Image: "nginx:latest"}},
+		},
+	}
+	for _, option := range options {
+		option(pod)
+	}
+	return pod
+}
+
+func inNamespace(namespace string) podOption {
+	return func(pod *v1.Pod) {
+		pod.Namespace = namespace
+	}
+}
+
+func withAnnotation(key, value string) podOption {
+	return func(pod *v1.Pod) {
+		if pod.Annotations == nil {
+			pod.Annotations = map[string]string{}
+		}
+		pod.Annotations[key] = value
+	}
+}
+
+// withVolumes adds the volumes and records the passthrough annotation the webhook sets for them
+func withVolumes(volumes []v1.Volume, devices []v1.VolumeDevice) podOption {
+	return func(pod *v1.Pod) {
+		pod.Spec.Volumes = volumes
+		pod.Spec.Containers[0].VolumeDevices = devices
+		annotation, err := json.Marshal(util.SelectPassthroughVolumes(pod))
+		Expect(err).ToNot(HaveOccurred())
+		withAnnotation(util.PassthroughVolumesAnnotation, string(annotation))(pod)
+	}
+}
+
+// ownedBy makes the pod a pod of the given ReplicaSet
+func ownedBy(replicaSet string) podOption {
+	return func(pod *v1.Pod) {
+		isController := true
+		pod.OwnerReferences = []metav1.OwnerReference{{
+			APIVersion: "apps/v1", Kind: "ReplicaSet", Name: replicaSet, UID: types.UID(replicaSet + "-uid"), Controller: &isController,
+		}}
+	}
+}
+
+func withPriority(priority int32) podOption {
+	return func(pod *v1.Pod) {
+		pod.Spec.Priority = &priority
+	}
+}
+
+func gated() podOption {
+	return func(pod *v1.Pod) {
+		pod.Spec.SchedulingGates = []v1.PodSchedulingGate{{Name: util.MaroonedPodsGate}}
+	}
+}
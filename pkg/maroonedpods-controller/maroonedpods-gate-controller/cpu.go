@@ -0,0 +1,80 @@
+package mp_controller
+
+import (
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	virtv1 "kubevirt.io/api/core/v1"
+	"maroonedpods.io/maroonedpods/pkg/util"
+	v1alpha1 "maroonedpods.io/maroonedpods/staging/src/maroonedpods.io/api/pkg/apis/core/v1alpha1"
+)
+
+// vmiCPU returns the CPU of the VMI of the pod with at least the given vCPUs, laid out in the
+// configured topology, and the host CPU the VMI requests
+func (ctrl *MaroonedPodsGateController) vmiCPU(pod *v1.Pod, vcpus uint32) (*virtv1.CPU, virtv1.ResourceRequirements) {
+	cpu := &virtv1.CPU{Sockets: 1, Threads: 1, Cores: vcpus}
+	resources := virtv1.ResourceRequirements{}
+
+	config := ctrl.getConfig()
+	if config == nil || config.Spec.CPU == nil {
+		return cpu, resources
+	}
+	topology := config.Spec.CPU
+
+	cpu.Model = topology.Model
+	if topology.Sockets > 0 {
+		cpu.Sockets = topology.Sockets
+	}
+	if topology.Threads > 0 {
+		cpu.Threads = topology.Threads
+	}
+	// Round the vCPUs up to fill every socket
+	threadsPerCore := cpu.Sockets * cpu.Threads
+	cpu.Cores = (vcpus + threadsPerCore - 1) / threadsPerCore
+
+	// KubeVirt gives the virt-launcher pod of dedicated VMs Guaranteed QoS itself
+	if topology.DedicatedCPUPlacement && pod.Status.QOSClass == v1.PodQOSGuaranteed {
+		cpu.DedicatedCPUPlacement = true
+		cpu.IsolateEmulatorThread = topology.IsolateEmulatorThread
+		return cpu, resources
+	}
+
+	if topology.FractionalCPU {
+		resources.Requests, resources.Limits = ctrl.fractionalCPU(pod)
+	}
+	return cpu, resources
+}
+
+// fractionalCPU returns the host CPU requests and limits matching the CPU of the pod plus the overhead.
+// Limits are only set when every container of the pod is CPU limited.
+func (ctrl *MaroonedPodsGateController) fractionalCPU(pod *v1.Pod) (requests v1.ResourceList, limits v1.ResourceList) {
+	overheadCPUMillis, _ := ctrl.getResourceOverhead()
+	withOverhead := func(cpu resource.Quantity) v1.ResourceList {
+		return v1.ResourceList{
+			v1.ResourceCPU: *resource.NewMilliQuantity(cpu.MilliValue()+overheadCPUMillis, resource.DecimalSI),
+		}
+	}
+
+	// Explicit VM size requested by the pod
+	if cpuOverride, _, err := util.GetVMResourcesOverride(pod); err == nil && cpuOverride != nil {
+		return v1.ResourceList{v1.ResourceCPU: *cpuOverride}, v1.ResourceList{v1.ResourceCPU: *cpuOverride}
+	}
+
+	requests = withOverhead(podEffectiveResources(pod, ctrl.getResourceSizing())[v1.ResourceCPU])
+	if !allContainersCPULimited(pod) {
+		return requests, nil
+	}
+	limits = withOverhead(podEffectiveResources(pod, v1alpha1.ResourceSizingLimits)[v1.ResourceCPU])
+	return requests, limits
+}
+
+// allContainersCPULimited checks whether every container of the pod has a CPU limit
+func allContainersCPULimited(pod *v1.Pod) bool {
+	for _, containers := range [][]v1.Container{pod.Spec.InitContainers, pod.Spec.Containers} {
+		for _, container := range containers {
+			if _, ok := container.Resources.Limits[v1.ResourceCPU]; !ok {
+				return false
+			}
+		}
+	}
+	return true
+}
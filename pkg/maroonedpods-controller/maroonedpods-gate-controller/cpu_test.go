@@ -0,0 +1,101 @@
+package mp_controller
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"maroonedpods.io/maroonedpods/pkg/util"
+	v1alpha1 "maroonedpods.io/maroonedpods/staging/src/maroonedpods.io/api/pkg/apis/core/v1alpha1"
+)
+
+var _ = Describe("VM CPU", func() {
+	newCPUConfig := func(topology *v1alpha1.CPUTopology) *v1alpha1.MaroonedPodsConfig {
+		return &v1alpha1.MaroonedPodsConfig{
+			ObjectMeta: metav1.ObjectMeta{Name: "default"},
+			Spec:       v1alpha1.MaroonedPodsConfigSpec{CPU: topology},
+		}
+	}
+
+	newGuaranteedPod := func() *v1.Pod {
+		pod := newPod("builder")
+		withRequests(&pod.Spec.Containers[0], cpuMemory("2", "2Gi"))
+		withLimits(&pod.Spec.Containers[0], cpuMemory("2", "2Gi"))
+		pod.Status.QOSClass = v1.PodQOSGuaranteed
+		return pod
+	}
+
+	It("should use a single socket on shared CPUs by default", func() {
+		ctrl, _ := newTestController(nil)
+		cpu, resources := ctrl.vmiCPU(newGuaranteedPod(), 3)
+		Expect(cpu.Sockets).To(Equal(uint32(1)))
+		Expect(cpu.Threads).To(Equal(uint32(1)))
+		Expect(cpu.Cores).To(Equal(uint32(3)))
+		Expect(cpu.Model).To(BeEmpty())
+		Expect(cpu.DedicatedCPUPlacement).To(BeFalse())
+		Expect(resources.Requests).To(BeEmpty())
+	})
+
+	It("should lay out the vCPUs in the configured topology", func() {
+		ctrl, _ := newTestController(newCPUConfig(&v1alpha1.CPUTopology{Model: "host-passthrough", Sockets: 2, Threads: 2}))
+		cpu, _ := ctrl.vmiCPU(newPod("builder"), 5)
+		Expect(cpu.Model).To(Equal("host-passthrough"))
+		Expect(cpu.Sockets).To(Equal(uint32(2)))
+		Expect(cpu.Threads).To(Equal(uint32(2)))
+		Expect(cpu.Cores).To(Equal(uint32(2)))
+	})
+
+	It("should dedicate CPUs to Guaranteed pods only", func() {
+		ctrl, _ := newTestController(newCPUConfig(&v1alpha1.CPUTopology{DedicatedCPUPlacement: true, IsolateEmulatorThread: true}))
+		cpu, _ := ctrl.vmiCPU(newGuaranteedPod(), 3)
+		Expect(cpu.DedicatedCPUPlacement).To(BeTrue())
+		Expect(cpu.IsolateEmulatorThread).To(BeTrue())
+
+		burstable := newGuaranteedPod()
+		burstable.Status.QOSClass = v1.PodQOSBurstable
+		cpu, _ = ctrl.vmiCPU(burstable, 3)
+		Expect(cpu.DedicatedCPUPlacement).To(BeFalse())
+		Expect(cpu.IsolateEmulatorThread).To(BeFalse())
+	})
+
+	Context("fractional CPU", func() {
+		var ctrl *MaroonedPodsGateController
+
+		BeforeEach(func() {
+			ctrl, _ = newTestController(newCPUConfig(&v1alpha1.CPUTopology{FractionalCPU: true}))
+		})
+
+		It("should request the pod CPU plus overhead", func() {
+			pod := newPod("builder")
+			withRequests(&pod.Spec.Containers[0], cpuMemory("100m", "128Mi"))
+			_, resources := ctrl.vmiCPU(pod, 2)
+			Expect(resources.Requests.Cpu().String()).To(Equal("600m"))
+			Expect(resources.Limits).To(BeEmpty())
+		})
+
+		It("should limit the CPU when every container is limited", func() {
+			pod := newPod("builder")
+			withRequests(&pod.Spec.Containers[0], cpuMemory("100m", "128Mi"))
+			withLimits(&pod.Spec.Containers[0], cpuMemory("1500m", "128Mi"))
+			_, resources := ctrl.vmiCPU(pod, 2)
+			Expect(resources.Requests.Cpu().String()).To(Equal("600m"))
+			Expect(resources.Limits.Cpu().String()).To(Equal("2"))
+		})
+
+		It("should use the CPU override of the pod", func() {
+			pod := newPod("builder")
+			pod.Annotations = map[string]string{util.VMCPUAnnotation: "750m"}
+			_, resources := ctrl.vmiCPU(pod, 1)
+			Expect(resources.Requests.Cpu().Cmp(resource.MustParse("750m"))).To(Equal(0))
+			Expect(resources.Limits.Cpu().Cmp(resource.MustParse("750m"))).To(Equal(0))
+		})
+
+		It("should be set on the VMI", func() {
+			vmi, err := ctrl.createVMIFromPod(newPod("builder"))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(vmi.Spec.Domain.Resources.Requests.Cpu().String()).To(Equal("500m"))
+		})
+	})
+})
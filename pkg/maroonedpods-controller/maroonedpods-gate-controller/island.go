@@ -0,0 +1,312 @@
+package mp_controller
+
+import (
+	"context"
+	"fmt"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	k8smetav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/uuid"
+	"k8s.io/klog/v2"
+	virtv1 "kubevirt.io/api/core/v1"
+	"maroonedpods.io/maroonedpods/pkg/util"
+	v1alpha1 "maroonedpods.io/maroonedpods/staging/src/maroonedpods.io/api/pkg/apis/core/v1alpha1"
+	"sort"
+)
+
+// islandPods returns all pods labelled with the island in the namespace, including the ones leaving it
+func (ctrl *MaroonedPodsGateController) islandPods(namespace, island string) []*v1.Pod {
+	var pods []*v1.Pod
+	for _, obj := range ctrl.podInformer.GetStore().List() {
+		pod := obj.(*v1.Pod)
+		if pod.Namespace == namespace && util.GetIsland(pod) == island {
+			pods = append(pods, pod)
+		}
+	}
+	return pods
+}
+
+// islandMembers returns the pods sharing the VM of the island, oldest first.
+// Deleted pods and pods that ran to completion have left the island.
+func (ctrl *MaroonedPodsGateController) islandMembers(namespace, island string) []*v1.Pod {
+	var members []*v1.Pod
+	for _, pod := range ctrl.islandPods(namespace, island) {
+		if pod.DeletionTimestamp == nil && !isPodTerminal(pod) {
+			members = append(members, pod)
+		}
+	}
+	sort.Slice(members, func(i, j int) bool {
+		if !members[i].CreationTimestamp.Equal(&members[j].CreationTimestamp) {
+			return members[i].CreationTimestamp.Before(&members[j].CreationTimestamp)
+		}
+		return members[i].Name < members[j].Name
+	})
+	return members
+}
+
+// newIslandPod returns the pod the VM of the island is built from: the pod creating the VM, with one
+// container per member carrying the effective resources of that member, so the VM is sized to their sum,
+// and the pull secrets of all members. The members share the host constraints of the pod creating the VM.
+// Islands can't pass volumes through, attach networks or size the VM by annotation, so those are left out.
+func newIslandPod(pod *v1.Pod, members []*v1.Pod) *v1.Pod {
+	islandPod := &v1.Pod{
+		ObjectMeta: k8smetav1.ObjectMeta{
+			Name:      util.VirtualNodeName(pod),
+			Namespace: pod.Namespace,
+			UID:       pod.UID,
+			Labels:    map[string]string{util.IslandLabel: util.GetIsland(pod)},
+		},
+		Spec: *pod.Spec.DeepCopy(),
+	}
+	islandPod.Spec.InitContainers = nil
+	islandPod.Spec.Volumes = nil
+	islandPod.Spec.Overhead = nil
+	islandPod.Spec.Containers = nil
+	islandPod.Spec.ImagePullSecrets = nil
+	for _, member := range members {
+		for _, ref := range member.Spec.ImagePullSecrets {
+			if !hasPullSecret(islandPod.Spec.ImagePullSecrets, ref.Name) {
+				islandPod.Spec.ImagePullSecrets = append(islandPod.Spec.ImagePullSecrets, ref)
+			}
+		}
+		islandPod.Spec.Containers = append(islandPod.Spec.Containers, v1.Container{
+			Name: member.Name,
+			Resources: v1.ResourceRequirements{
+				Requests: podEffectiveResources(member, v1alpha1.ResourceSizingRequests),
+				Limits:   podEffectiveResources(member, v1alpha1.ResourceSizingLimits),
+			},
+		})
+		// The guest gets the longest grace period of the members to shut down
+		if gracePeriod := vmiTerminationGracePeriod(member); *gracePeriod > *vmiTerminationGracePeriod(islandPod) {
+			islandPod.Spec.TerminationGracePeriodSeconds = gracePeriod
+		}
+	}
+	return islandPod
+}
+
+// islandMemberReference returns the owner reference of a pod sharing the VM of its island.
+// Every member owns the VM without controlling it, so it is garbage collected with the last member.
+func islandMemberReference(pod *v1.Pod) k8smetav1.OwnerReference {
+	return k8smetav1.OwnerReference{
+		APIVersion: v1.SchemeGroupVersion.String(),
+		Kind:       "Pod",
+		Name:       pod.Name,
+		UID:        pod.UID,
+	}
+}
+
+// createIslandVMI returns the VMI shared by the members of the island of the pod.
+// The VMI gets a firmware UUID of its own, which the guest labels its node with: the node of the
+// island is registered by whichever member created the VM, so its pod UID doesn't identify the VMI.
+func (ctrl *MaroonedPodsGateController) createIslandVMI(pod *v1.Pod) (*virtv1.VirtualMachineInstance, error) {
+	// Members with other host constraints are left out, and kept gated when they try to join
+	var members []*v1.Pod
+	for _, member := range ctrl.islandMembers(pod.Namespace, util.GetIsland(pod)) {
+		if samePodPlacement(member, pod) {
+			members = append(members, member)
+		}
+	}
+	vmi, err := ctrl.createVMIFromPod(newIslandPod(pod, members))
+	if err != nil {
+		return nil, err
+	}
+	vmi.Spec.Domain.Firmware = &virtv1.Firmware{UUID: uuid.NewUUID()}
+	vmi.Labels[util.IslandLabel] = util.GetIsland(pod)
+	vmi.OwnerReferences = nil
+	for _, member := range members {
+		vmi.OwnerReferences = append(vmi.OwnerReferences, islandMemberReference(member))
+	}
+	return vmi, nil
+}
+
+// joinIsland adds the pod to the owners of the VM of its island.
+// The VM is sized when it is created, pods joining later must fit into the running VM
+// and stay gated until enough members left otherwise.
+func (ctrl *MaroonedPodsGateController) joinIsland(pod *v1.Pod, vmi *virtv1.VirtualMachineInstance) error {
+	if vmi.DeletionTimestamp != nil {
+		// Torn down after the last member left, recreated once it is gone
+		return fmt.Errorf("waiting for VMI %s of island %s to shut down", vmi.Name, util.GetIsland(pod))
+	}
+	// Pods join before they are released to the virtual node
+	if len(pod.Spec.SchedulingGates) == 0 || hasOwner(vmi.OwnerReferences, pod) {
+		return nil
+	}
+	owners := ctrl.islandOwners(pod, vmi)
+	if len(owners) > 0 && !samePodPlacement(pod, owners[0]) {
+		ctrl.recorder.Eventf(pod, v1.EventTypeWarning, "IslandPlacementConflict", "VMI %s of island %s is placed for the host constraints of pod %s, waiting for its members to leave", vmi.Name, util.GetIsland(pod), owners[0].Name)
+		return fmt.Errorf("host constraints of pod %s/%s differ from the ones of island %s", pod.Namespace, pod.Name, util.GetIsland(pod))
+	}
+	if missing, err := ctrl.missingIslandPullSecrets(pod, vmi, owners); err != nil {
+		return err
+	} else if len(missing) > 0 {
+		ctrl.recorder.Eventf(pod, v1.EventTypeWarning, "IslandPullSecretsConflict", "VMI %s of island %s applies pull secrets when it boots, it has none of %v, waiting for its members to leave", vmi.Name, util.GetIsland(pod), missing)
+		return fmt.Errorf("VMI %s of island %s can't pull with the secrets %v of pod %s/%s", vmi.Name, util.GetIsland(pod), missing, pod.Namespace, pod.Name)
+	}
+	if !ctrl.islandVMIFits(pod, vmi) {
+		ctrl.recorder.Eventf(pod, v1.EventTypeWarning, "IslandFull", "VMI %s of island %s has no room for the pod, waiting for members to leave", vmi.Name, util.GetIsland(pod))
+		return fmt.Errorf("VMI %s of island %s has no room for pod %s/%s", vmi.Name, util.GetIsland(pod), pod.Namespace, pod.Name)
+	}
+	err := ctrl.updateIslandOwners(vmi.Namespace, vmi.Name, func(owners []k8smetav1.OwnerReference) []k8smetav1.OwnerReference {
+		if hasOwner(owners, pod) {
+			return owners
+		}
+		return append(owners, islandMemberReference(pod))
+	})
+	if err != nil || !hasRegistriesVolume(vmi) {
+		return err
+	}
+	// Guests following registries updates pull with the secrets of the pod right away
+	joined := vmi.DeepCopy()
+	joined.OwnerReferences = append(joined.OwnerReferences, islandMemberReference(pod))
+	return ctrl.ensureRegistriesSecret(joined)
+}
+
+// islandOwners returns the members of the island other than the pod that share the VMI, oldest first
+func (ctrl *MaroonedPodsGateController) islandOwners(pod *v1.Pod, vmi *virtv1.VirtualMachineInstance) []*v1.Pod {
+	var owners []*v1.Pod
+	for _, member := range ctrl.islandMembers(pod.Namespace, util.GetIsland(pod)) {
+		if member.UID != pod.UID && hasOwner(vmi.OwnerReferences, member) {
+			owners = append(owners, member)
+		}
+	}
+	return owners
+}
+
+// missingIslandPullSecrets returns the pull secrets of the pod a VMI reading its registries from a disk
+// didn't boot with. Those guests only apply the pull secrets of the members sharing the VMI from the start.
+func (ctrl *MaroonedPodsGateController) missingIslandPullSecrets(pod *v1.Pod, vmi *virtv1.VirtualMachineInstance, owners []*v1.Pod) ([]string, error) {
+	if !hasRegistriesVolume(vmi) || followsRegistriesUpdates(vmi) {
+		return nil, nil
+	}
+	names, err := ctrl.podPullSecrets(pod)
+	if err != nil {
+		return nil, err
+	}
+	applied := map[string]bool{}
+	for _, owner := range owners {
+		ownerNames, err := ctrl.podPullSecrets(owner)
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range ownerNames {
+			applied[name] = true
+		}
+	}
+	var missing []string
+	for _, name := range names {
+		if !applied[name] {
+			missing = append(missing, name)
+		}
+	}
+	return missing, nil
+}
+
+// islandVMIFits checks whether the VMI of the island has room for the pod:
+// a VMI sized for the pod and the members sharing it wouldn't be any larger.
+func (ctrl *MaroonedPodsGateController) islandVMIFits(pod *v1.Pod, vmi *virtv1.VirtualMachineInstance) bool {
+	islandPod := newIslandPod(pod, append([]*v1.Pod{pod}, ctrl.islandOwners(pod, vmi)...))
+	cpuCores, memoryMi := ctrl.calculateVMResourcesFromPod(islandPod)
+	cpu, _ := ctrl.vmiCPU(islandPod, cpuCores)
+	memory, _, _ := ctrl.vmiMemory(islandPod, memoryMi, cpu.DedicatedCPUPlacement)
+
+	domain := vmi.Spec.Domain
+	if domain.CPU != nil && cpu.Sockets*cpu.Cores*cpu.Threads > domain.CPU.Sockets*domain.CPU.Cores*domain.CPU.Threads {
+		return false
+	}
+	return domain.Memory == nil || domain.Memory.Guest == nil || memory.Guest.Cmp(*domain.Memory.Guest) <= 0
+}
+
+func hasPullSecret(refs []v1.LocalObjectReference, name string) bool {
+	for _, ref := range refs {
+		if ref.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func hasOwner(owners []k8smetav1.OwnerReference, pod *v1.Pod) bool {
+	for _, owner := range owners {
+		if owner.UID == pod.UID {
+			return true
+		}
+	}
+	return false
+}
+
+// leaveIsland removes the pod from the owners of the VM of its island, and tears the VM down when the
+// last member leaves, reporting whether the pod is done with the VM
+func (ctrl *MaroonedPodsGateController) leaveIsland(pod *v1.Pod) (bool, error) {
+	vmName := util.VirtualNodeName(pod)
+	err := ctrl.updateIslandOwners(pod.Namespace, vmName, func(owners []k8smetav1.OwnerReference) []k8smetav1.OwnerReference {
+		var remaining []k8smetav1.OwnerReference
+		for _, owner := range owners {
+			if owner.UID != pod.UID {
+				remaining = append(remaining, owner)
+			}
+		}
+		return remaining
+	})
+	if err != nil {
+		return false, err
+	}
+	if members := ctrl.islandMembers(pod.Namespace, util.GetIsland(pod)); len(members) > 0 {
+		klog.V(2).Infof("Pod %s/%s left island %s, %d members remain", pod.Namespace, pod.Name, util.GetIsland(pod), len(members))
+		return true, nil
+	}
+	return ctrl.tearDownVirtualNode(pod, vmName)
+}
+
+// updateIslandOwners updates the owners of the VM of an island, on the VirtualMachine when it has one
+func (ctrl *MaroonedPodsGateController) updateIslandOwners(namespace, name string, update func([]k8smetav1.OwnerReference) []k8smetav1.OwnerReference) error {
+	kubevirtClient := ctrl.maroonedpodsCli.KubevirtClient().KubevirtV1()
+	vm, err := kubevirtClient.VirtualMachines(namespace).Get(context.Background(), name, k8smetav1.GetOptions{})
+	if err == nil {
+		owners := update(vm.OwnerReferences)
+		if ownerReferencesEqual(owners, vm.OwnerReferences) {
+			return nil
+		}
+		vm = vm.DeepCopy()
+		vm.OwnerReferences = owners
+		_, err = kubevirtClient.VirtualMachines(namespace).Update(context.Background(), vm, k8smetav1.UpdateOptions{})
+		return err
+	}
+	if !errors.IsNotFound(err) {
+		return err
+	}
+
+	vmi, err := kubevirtClient.VirtualMachineInstances(namespace).Get(context.Background(), name, k8smetav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	owners := update(vmi.OwnerReferences)
+	if ownerReferencesEqual(owners, vmi.OwnerReferences) {
+		return nil
+	}
+	vmi = vmi.DeepCopy()
+	vmi.OwnerReferences = owners
+	_, err = kubevirtClient.VirtualMachineInstances(namespace).Update(context.Background(), vmi, k8smetav1.UpdateOptions{})
+	return err
+}
+
+func ownerReferencesEqual(a, b []k8smetav1.OwnerReference) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].UID != b[i].UID {
+			return false
+		}
+	}
+	return true
+}
+
+// releaseVirtualNode lets go of the VM of a deleted or completed pod, reporting whether the pod is done with it
+func (ctrl *MaroonedPodsGateController) releaseVirtualNode(pod *v1.Pod) (bool, error) {
+	if util.GetIsland(pod) != "" {
+		return ctrl.leaveIsland(pod)
+	}
+	return ctrl.tearDownVirtualNode(pod, pod.Name)
+}
@@ -0,0 +1,261 @@
+package mp_controller
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/tools/record"
+	virtv1 "kubevirt.io/api/core/v1"
+	"maroonedpods.io/maroonedpods/pkg/util"
+	v1alpha1 "maroonedpods.io/maroonedpods/staging/src/maroonedpods.io/api/pkg/apis/core/v1alpha1"
+)
+
+var _ = Describe("Islands", func() {
+	vmName := util.IslandVMName("tenant", "shop")
+
+	newIslandMember := func(name, cpu, memory string, age time.Duration) *v1.Pod {
+		pod := newPod(name)
+		pod.Labels[util.IslandLabel] = "shop"
+		pod.CreationTimestamp = metav1.NewTime(time.Now().Add(-age))
+		pod.Spec.SchedulingGates = []v1.PodSchedulingGate{{Name: util.MaroonedPodsGate}}
+		pod.Spec.Containers[0].Resources.Requests = v1.ResourceList{
+			v1.ResourceCPU:    resource.MustParse(cpu),
+			v1.ResourceMemory: resource.MustParse(memory),
+		}
+		return pod
+	}
+	newIslandVMI := func(members ...*v1.Pod) *virtv1.VirtualMachineInstance {
+		vmi := virtv1.NewVMIReferenceFromNameWithNS("tenant", vmName)
+		vmi.Labels = map[string]string{util.MaroonedVMILabel: vmName, util.IslandLabel: "shop"}
+		for _, member := range members {
+			vmi.OwnerReferences = append(vmi.OwnerReferences, islandMemberReference(member))
+		}
+		vmi.Status.Phase = virtv1.Running
+		return vmi
+	}
+	setup := func(vmi *virtv1.VirtualMachineInstance, pods ...*v1.Pod) (*MaroonedPodsGateController, *fakeMaroonedPodsClient) {
+		ctrl, cli := newTestController(nil)
+		for _, pod := range pods {
+			Expect(ctrl.podInformer.GetStore().Add(pod)).To(Succeed())
+		}
+		if vmi != nil {
+			Expect(ctrl.vmiInformer.GetStore().Add(vmi)).To(Succeed())
+			_, err := cli.KubevirtClient().KubevirtV1().VirtualMachineInstances("tenant").Create(context.Background(), vmi, metav1.CreateOptions{})
+			Expect(err).ToNot(HaveOccurred())
+		}
+		return ctrl, cli
+	}
+	vmiOwners := func(cli *fakeMaroonedPodsClient) []types.UID {
+		vmi, err := cli.KubevirtClient().KubevirtV1().VirtualMachineInstances("tenant").Get(context.Background(), vmName, metav1.GetOptions{})
+		Expect(err).ToNot(HaveOccurred())
+		var uids []types.UID
+		for _, owner := range vmi.OwnerReferences {
+			uids = append(uids, owner.UID)
+		}
+		return uids
+	}
+
+	It("should size one VM to the sum of the members", func() {
+		web := newIslandMember("web", "500m", "256Mi", time.Minute)
+		gracePeriod := int64(90)
+		web.Spec.TerminationGracePeriodSeconds = &gracePeriod
+		cache := newIslandMember("cache", "1", "512Mi", time.Second)
+		ctrl, _ := setup(nil, cache, web)
+
+		vmi, err := ctrl.createIslandVMI(cache)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(vmi.Name).To(Equal(vmName))
+		Expect(vmi.Labels).To(HaveKeyWithValue(util.IslandLabel, "shop"))
+		Expect(vmi.Labels).To(HaveKeyWithValue(util.MaroonedVMILabel, vmName))
+		Expect(*vmi.Spec.TerminationGracePeriodSeconds).To(Equal(gracePeriod))
+
+		Expect(vmi.OwnerReferences).To(HaveLen(2))
+		Expect(vmi.OwnerReferences[0].Name).To(Equal("web"))
+		Expect(vmi.OwnerReferences[1].Name).To(Equal("cache"))
+		for _, owner := range vmi.OwnerReferences {
+			Expect(owner.Controller).To(BeNil())
+		}
+
+		islandPod := newIslandPod(cache, ctrl.islandMembers("tenant", "shop"))
+		requests := podEffectiveResources(islandPod, v1alpha1.ResourceSizingRequests)
+		Expect(requests.Cpu().MilliValue()).To(Equal(int64(1500)))
+		Expect(requests.Memory().Value()).To(Equal(int64(768 * 1024 * 1024)))
+	})
+
+	It("should leave out deleted and completed pods", func() {
+		web := newIslandMember("web", "500m", "256Mi", time.Minute)
+		deleted := newIslandMember("deleted", "500m", "256Mi", time.Minute)
+		now := metav1.Now()
+		deleted.DeletionTimestamp = &now
+		done := newIslandMember("done", "500m", "256Mi", time.Minute)
+		done.Status.Phase = v1.PodSucceeded
+		other := newPod("other")
+		ctrl, _ := setup(nil, web, deleted, done, other)
+
+		members := ctrl.islandMembers("tenant", "shop")
+		Expect(members).To(HaveLen(1))
+		Expect(members[0].Name).To(Equal("web"))
+		Expect(ctrl.islandPods("tenant", "shop")).To(HaveLen(3))
+	})
+
+	It("should add joining pods to the owners of the VM", func() {
+		web := newIslandMember("web", "500m", "256Mi", time.Minute)
+		cache := newIslandMember("cache", "1", "512Mi", time.Second)
+		vmi := newIslandVMI(web)
+		ctrl, cli := setup(vmi, web, cache)
+
+		Expect(ctrl.joinIsland(cache, vmi)).To(Succeed())
+		Expect(vmiOwners(cli)).To(ConsistOf(web.UID, cache.UID))
+
+		Expect(ctrl.joinIsland(cache, vmi)).To(Succeed())
+		Expect(vmiOwners(cli)).To(HaveLen(2))
+	})
+
+	It("should keep pods that don't fit into the VM gated", func() {
+		web := newIslandMember("web", "500m", "256Mi", time.Minute)
+		sizing, _ := setup(nil, web)
+		vmi, err := sizing.createIslandVMI(web)
+		Expect(err).ToNot(HaveOccurred())
+
+		small := newIslandMember("small", "250m", "256Mi", time.Second)
+		large := newIslandMember("large", "4", "8Gi", time.Second)
+		ctrl, cli := setup(vmi, web, small, large)
+
+		Expect(ctrl.joinIsland(small, vmi)).To(Succeed())
+		Expect(ctrl.joinIsland(large, vmi)).To(MatchError(ContainSubstring("has no room")))
+		Expect(ctrl.recorder.(*record.FakeRecorder).Events).To(Receive(ContainSubstring("IslandFull")))
+		Expect(vmiOwners(cli)).To(ConsistOf(web.UID, small.UID))
+	})
+
+	It("should keep pods with other host constraints out of the VM", func() {
+		web := newIslandMember("web", "500m", "256Mi", time.Minute)
+		web.Spec.NodeSelector = map[string]string{v1.LabelTopologyZone: "zone-a"}
+		cache := newIslandMember("cache", "500m", "256Mi", time.Second)
+		cache.Spec.NodeSelector = map[string]string{v1.LabelTopologyZone: "zone-b"}
+		sizing, _ := setup(nil, web, cache)
+		vmi, err := sizing.createIslandVMI(web)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(vmi.OwnerReferences).To(HaveLen(1))
+		Expect(vmi.Spec.NodeSelector).To(HaveKeyWithValue(v1.LabelTopologyZone, "zone-a"))
+
+		vmi.Status.Phase = virtv1.Running
+		ctrl, cli := setup(vmi, web, cache)
+		Expect(ctrl.joinIsland(cache, vmi)).To(MatchError(ContainSubstring("host constraints")))
+		Expect(ctrl.recorder.(*record.FakeRecorder).Events).To(Receive(ContainSubstring("IslandPlacementConflict")))
+		Expect(vmiOwners(cli)).To(ConsistOf(web.UID))
+	})
+
+	It("should pass the pull secrets of all members to the VM", func() {
+		web := newIslandMember("web", "500m", "256Mi", time.Minute)
+		web.Spec.ImagePullSecrets = []v1.LocalObjectReference{{Name: "shared"}, {Name: "web"}}
+		cache := newIslandMember("cache", "500m", "256Mi", time.Second)
+		cache.Spec.ImagePullSecrets = []v1.LocalObjectReference{{Name: "shared"}, {Name: "cache"}}
+
+		islandPod := newIslandPod(cache, []*v1.Pod{web, cache})
+		Expect(islandPod.Spec.ImagePullSecrets).To(Equal([]v1.LocalObjectReference{{Name: "shared"}, {Name: "web"}, {Name: "cache"}}))
+	})
+
+	It("should keep pods with new pull secrets out of VMs applying them at boot", func() {
+		web := newIslandMember("web", "500m", "256Mi", time.Minute)
+		web.Spec.ImagePullSecrets = []v1.LocalObjectReference{{Name: "shared"}}
+		cache := newIslandMember("cache", "500m", "256Mi", time.Second)
+		cache.Spec.ImagePullSecrets = []v1.LocalObjectReference{{Name: "shared"}}
+		worker := newIslandMember("worker", "500m", "256Mi", time.Second)
+		worker.Spec.ImagePullSecrets = []v1.LocalObjectReference{{Name: "worker"}}
+		vmi := newIslandVMI(web)
+		_, _, volume, _ := registriesVolume(vmName, false)
+		vmi.Spec.Volumes = append(vmi.Spec.Volumes, volume)
+		ctrl, cli := setup(vmi, web, cache, worker)
+
+		Expect(ctrl.joinIsland(cache, vmi)).To(Succeed())
+		Expect(ctrl.joinIsland(worker, vmi)).To(MatchError(ContainSubstring("can't pull")))
+		Expect(ctrl.recorder.(*record.FakeRecorder).Events).To(Receive(ContainSubstring("IslandPullSecretsConflict")))
+		Expect(vmiOwners(cli)).To(ConsistOf(web.UID, cache.UID))
+	})
+
+	It("should not join a VM that is shutting down", func() {
+		web := newIslandMember("web", "500m", "256Mi", time.Minute)
+		vmi := newIslandVMI()
+		now := metav1.Now()
+		vmi.DeletionTimestamp = &now
+		ctrl, _ := setup(vmi, web)
+
+		Expect(ctrl.joinIsland(web, vmi)).To(MatchError(ContainSubstring("to shut down")))
+	})
+
+	It("should keep the VM while members remain", func() {
+		web := newIslandMember("web", "500m", "256Mi", time.Minute)
+		cache := newIslandMember("cache", "1", "512Mi", time.Second)
+		now := metav1.Now()
+		cache.DeletionTimestamp = &now
+		ctrl, cli := setup(newIslandVMI(web, cache), web, cache)
+
+		done, err := ctrl.releaseVirtualNode(cache)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(done).To(BeTrue())
+		Expect(vmiOwners(cli)).To(ConsistOf(web.UID))
+	})
+
+	It("should tear the VM down when the last member leaves", func() {
+		web := newIslandMember("web", "500m", "256Mi", time.Minute)
+		web.Status.Phase = v1.PodSucceeded
+		ctrl, cli := setup(newIslandVMI(web), web)
+
+		done, err := ctrl.releaseVirtualNode(web)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(done).To(BeFalse())
+		_, err = cli.KubevirtClient().KubevirtV1().VirtualMachineInstances("tenant").Get(context.Background(), vmName, metav1.GetOptions{})
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should requeue all pods of the island when its VMI is deleted", func() {
+		web := newIslandMember("web", "500m", "256Mi", time.Minute)
+		cache := newIslandMember("cache", "1", "512Mi", time.Second)
+		ctrl, _ := setup(nil, web, cache)
+
+		ctrl.deleteVMI(newIslandVMI(web, cache))
+		Expect(ctrl.queue.Len()).To(Equal(2))
+	})
+
+	It("should not claim pool VMIs or replace the node of the island", func() {
+		web := newIslandMember("web", "500m", "256Mi", time.Minute)
+		Expect(canClaimPoolVMI(web)).To(BeFalse())
+
+		node := &v1.Node{ObjectMeta: metav1.ObjectMeta{
+			Name:   vmName,
+			Labels: map[string]string{util.MaroonedNodePodUIDLabel: "cache-uid"},
+		}}
+		Expect(staleNode(node, web, newIslandVMI(web))).To(BeFalse())
+	})
+
+	It("should only release pods to the node of the island VMI", func() {
+		web := newIslandMember("web", "500m", "256Mi", time.Minute)
+		ctrl, _ := setup(nil, web)
+		vmi, err := ctrl.createIslandVMI(web)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(vmi.Spec.Domain.Firmware.UUID).ToNot(BeEmpty())
+
+		node := &v1.Node{ObjectMeta: metav1.ObjectMeta{
+			Name:   vmName,
+			Labels: map[string]string{util.MaroonedNodeVMUUIDLabel: string(vmi.Spec.Domain.Firmware.UUID)},
+		}}
+		Expect(staleNode(node, web, vmi)).To(BeFalse())
+		node.Labels[util.MaroonedNodeVMUUIDLabel] = "other-uuid"
+		Expect(staleNode(node, web, vmi)).To(BeTrue())
+	})
+
+	It("should name island VMs uniquely per namespace and island", func() {
+		Expect(util.IslandVMName("a-b", "c")).ToNot(Equal(util.IslandVMName("a", "b-c")))
+		Expect(util.IslandVMName("tenant", "shop")).To(HavePrefix(util.IslandVMNamePrefix))
+		Expect(validation.IsDNS1123Label(util.IslandVMName(strings.Repeat("n", 63), strings.Repeat("i", 63)))).To(BeEmpty())
+	})
+})
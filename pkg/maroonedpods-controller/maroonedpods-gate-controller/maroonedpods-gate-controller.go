@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"math/rand"
 	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
 	"k8s.io/apimachinery/pkg/api/resource"
 	k8smetav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
@@ -21,6 +22,7 @@ import (
 	"maroonedpods.io/maroonedpods/pkg/log"
 	"maroonedpods.io/maroonedpods/pkg/util"
 	v1alpha1 "maroonedpods.io/maroonedpods/staging/src/maroonedpods.io/api/pkg/apis/core/v1alpha1"
+	"strings"
 	"time"
 )
 
@@ -37,11 +39,33 @@ const (
 	BackOff   enqueueState = "BackOff"
 )
 
+const (
+	scratchDiskName = "scratchdisk"
+	// The node boot script finds the disk at /dev/disk/by-id/virtio-<serial>
+	scratchDiskSerial = "marooned-scratch"
+	// Room for image layers and logs on top of the ephemeral storage of the pod
+	defaultScratchDiskOverhead = "10Gi"
+)
+
+const (
+	// bootc+k3s node image joined by the node boot script
+	k3sNodeImage = "quay.io/vladikr/marooned-node:latest"
+	// Kubernetes API server endpoint
+	// TODO: Make this configurable via config or environment
+	k3sServerURL = "https://kubernetes.default.svc:6443"
+	// TODO: Get actual token from kubeadm or secret
+	// For now using placeholder - in production this should come from kubeadm bootstrap token or service account
+	k3sJoinToken = "abcdef.1234567890123456"
+)
+
 type MaroonedPodsGateController struct {
 	podInformer                  cache.SharedIndexInformer
 	vmiInformer                  cache.SharedIndexInformer
 	nodeInformer                 cache.SharedIndexInformer
 	configInformer               cache.SharedIndexInformer
+	maroonedpodsInformer         cache.SharedIndexInformer
+	migrationInformer            cache.SharedIndexInformer
+	namespaceInformer            cache.SharedIndexInformer
 	maroonedpodsCli              client.MaroonedPodsClient
 	recorder                     record.EventRecorder
 	stop                         <-chan struct{}
@@ -54,6 +78,9 @@ func NewMaroonedPodsGateController(maroonedpodsCli client.MaroonedPodsClient,
 	vmiInformer cache.SharedIndexInformer,
 	nodeInformer cache.SharedIndexInformer,
 	configInformer cache.SharedIndexInformer,
+	maroonedpodsInformer cache.SharedIndexInformer,
+	migrationInformer cache.SharedIndexInformer,
+	namespaceInformer cache.SharedIndexInformer,
 	stop <-chan struct{},
 	enqueueAllGateControllerChan <-chan struct{},
 ) *MaroonedPodsGateController {
@@ -61,17 +88,23 @@ func NewMaroonedPodsGateController(maroonedpodsCli client.MaroonedPodsClient,
 	eventBroadcaster.StartRecordingToSink(&v14.EventSinkImpl{Interface: maroonedpodsCli.CoreV1().Events(v1.NamespaceAll)})
 
 	ctrl := MaroonedPodsGateController{
-		maroonedpodsCli: maroonedpodsCli,
-		podInformer:     podInformer,
-		vmiInformer:     vmiInformer,
-		nodeInformer:    nodeInformer,
-		configInformer:  configInformer,
-		queue:           workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "maroonedpods-queue"),
+		maroonedpodsCli:      maroonedpodsCli,
+		podInformer:          podInformer,
+		vmiInformer:          vmiInformer,
+		nodeInformer:         nodeInformer,
+		configInformer:       configInformer,
+		maroonedpodsInformer: maroonedpodsInformer,
+		migrationInformer:    migrationInformer,
+		namespaceInformer:    namespaceInformer,
 
 		recorder:                     eventBroadcaster.NewRecorder(scheme.Scheme, v1.EventSource{Component: util.ControllerPodName}),
 		stop:                         stop,
 		enqueueAllGateControllerChan: enqueueAllGateControllerChan,
 	}
+	// Gated pods are provisioned in order of priority
+	ctrl.queue = workqueue.NewRateLimitingQueueWithDelayingInterface(
+		workqueue.NewDelayingQueueWithCustomQueue(newPriorityQueue(ctrl.podKeyPriority), "maroonedpods-queue"),
+		workqueue.DefaultControllerRateLimiter())
 
 	_, err := ctrl.podInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
 		AddFunc:    ctrl.addPod,
@@ -83,6 +116,21 @@ func NewMaroonedPodsGateController(maroonedpodsCli client.MaroonedPodsClient,
 
 	}
 
+	_, err = ctrl.vmiInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		DeleteFunc: ctrl.deleteVMI,
+	})
+	if err != nil {
+		panic("something is wrong")
+	}
+
+	_, err = ctrl.migrationInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    ctrl.addMigration,
+		UpdateFunc: ctrl.updateMigration,
+	})
+	if err != nil {
+		panic("something is wrong")
+	}
+
 	return &ctrl
 }
 
@@ -120,6 +168,17 @@ func (ctrl *MaroonedPodsGateController) updatePod(old, curr interface{}) {
 		return
 	}
 
+	// The finalizer keeps the VM until the containers of the pod terminated,
+	// and the VM of a pod that ran to completion is torn down
+	if (pod.DeletionTimestamp != nil || isPodTerminal(pod)) && hasMaroonedPodsFinalizer(pod) {
+		key, err := KeyFunc(pod)
+		if err != nil {
+			log.Log.Info("Failed to obtain pod key function")
+		}
+		ctrl.queue.Add(key)
+		return
+	}
+
 	// Check if resource requests changed (future: trigger VMI resize)
 	// Note: Kubernetes doesn't allow changing resource requests on running pods
 	// without in-place pod resize (alpha/beta feature). This is for future use.
@@ -174,13 +233,14 @@ func (ctrl *MaroonedPodsGateController) deletePod(obj interface{}) {
 		return
 	}
 
-	if !exists {
+	var vmi *virtv1.VirtualMachineInstance
+	if exists {
+		vmi = vmiObj.(*virtv1.VirtualMachineInstance)
+	} else if vmi = ctrl.claimedPoolVMI(pod.Namespace, pod.Name); vmi == nil {
 		klog.V(3).Infof("No VMI found for deleted pod %s", key)
 		return
 	}
 
-	vmi := vmiObj.(*virtv1.VirtualMachineInstance)
-
 	// Check if this is a pool VMI
 	if ctrl.isPoolVMI(vmi) {
 		klog.Infof("Returning pool VMI %s to available pool after pod %s/%s deletion", vmi.Name, pod.Namespace, pod.Name)
@@ -191,8 +251,7 @@ func (ctrl *MaroonedPodsGateController) deletePod(obj interface{}) {
 	} else {
 		// Not a pool VMI, delete it (original behavior for on-demand VMs)
 		klog.Infof("Deleting on-demand VMI %s for pod %s/%s", vmi.Name, pod.Namespace, pod.Name)
-		err = ctrl.maroonedpodsCli.KubevirtClient().KubevirtV1().VirtualMachineInstances(vmi.Namespace).Delete(
-			context.Background(), vmi.Name, k8smetav1.DeleteOptions{})
+		err = ctrl.deleteVirtualNode(vmi.Namespace, vmi.Name)
 		if err != nil {
 			klog.Errorf("Failed to delete VMI %s: %v", vmi.Name, err)
 		}
@@ -207,9 +266,13 @@ func (ctrl *MaroonedPodsGateController) reconcileWarmPool() {
 		return
 	}
 
+	// Tenant pools are reconciled and reported apart from the shared warm pool
+	tenantPools := ctrl.reconcileTenantPools(config)
+
 	desiredPoolSize := config.Spec.WarmPoolSize
 	if desiredPoolSize == 0 {
 		klog.V(4).Info("Warm pool disabled (size=0), skipping reconciliation")
+		ctrl.updateConfigStatus(0, 0, 0, tenantPools)
 		return
 	}
 
@@ -218,34 +281,17 @@ func (ctrl *MaroonedPodsGateController) reconcileWarmPool() {
 	available := 0
 	claimed := 0
 
-	vmis := ctrl.vmiInformer.GetStore().List()
-	for _, obj := range vmis {
+	var vmis []*virtv1.VirtualMachineInstance
+	for _, obj := range ctrl.vmiInformer.GetStore().List() {
 		vmi := obj.(*virtv1.VirtualMachineInstance)
-		if vmi.Labels == nil {
+		if !ctrl.isPoolVMI(vmi) || poolName(vmi) != "" {
 			continue
 		}
+		vmis = append(vmis, vmi)
 
-		state, hasLabel := vmi.Labels[util.WarmPoolStateLabel]
-		if !hasLabel {
-			continue
-		}
-
-		switch state {
+		switch ctrl.poolVMIState(vmi) {
 		case util.PoolStateCreating:
-			// Check if VMI is now running and node has joined
-			if vmi.Status.Phase == virtv1.Running {
-				// Check if node exists
-				_, nodeExists, _ := ctrl.nodeInformer.GetStore().GetByKey(vmi.Name)
-				if nodeExists {
-					// Mark as available
-					ctrl.markVMIAvailable(vmi)
-					available++
-				} else {
-					creating++
-				}
-			} else {
-				creating++
-			}
+			creating++
 		case util.PoolStateAvailable:
 			available++
 		case util.PoolStateClaimed:
@@ -258,7 +304,7 @@ func (ctrl *MaroonedPodsGateController) reconcileWarmPool() {
 		desiredPoolSize, creating, available, claimed, totalPool)
 
 	// Update config status with pool metrics
-	ctrl.updateConfigStatus(int32(totalPool), int32(available), int32(claimed))
+	ctrl.updateConfigStatus(int32(totalPool), int32(available), int32(claimed), tenantPools)
 
 	// Create new VMs if below desired size
 	if totalPool < int(desiredPoolSize) {
@@ -270,7 +316,7 @@ func (ctrl *MaroonedPodsGateController) reconcileWarmPool() {
 		namespace := util.DefaultMaroonedPodsNs
 
 		for i := 0; i < toCreate; i++ {
-			_, err := ctrl.createPoolVMI(namespace)
+			_, err := ctrl.createPoolVMI(namespace, "")
 			if err != nil {
 				klog.Errorf("Failed to create pool VMI: %v", err)
 			}
@@ -281,28 +327,52 @@ func (ctrl *MaroonedPodsGateController) reconcileWarmPool() {
 	if available > int(desiredPoolSize) {
 		toDelete := available - int(desiredPoolSize)
 		klog.Infof("Warm pool above desired size, deleting %d available VMs", toDelete)
+		ctrl.deleteAvailablePoolVMIs(vmis, toDelete)
+	}
+}
 
-		deleted := 0
-		for _, obj := range vmis {
-			if deleted >= toDelete {
-				break
-			}
-
-			vmi := obj.(*virtv1.VirtualMachineInstance)
-			if vmi.Labels == nil {
-				continue
-			}
+// poolVMIState returns the state of a pool VMI, marking a creating VMI available once it runs,
+// its node joined and the images of its pool are pulled
+func (ctrl *MaroonedPodsGateController) poolVMIState(vmi *virtv1.VirtualMachineInstance) string {
+	state := vmi.Labels[util.WarmPoolStateLabel]
+	if state != util.PoolStateCreating || vmi.Status.Phase != virtv1.Running {
+		return state
+	}
+	if _, nodeExists, _ := ctrl.nodeInformer.GetStore().GetByKey(vmi.Name); !nodeExists {
+		return state
+	}
+	if !ctrl.prePullFinished(vmi) {
+		klog.V(3).Infof("Waiting for pool VMI %s/%s to pre-pull images", vmi.Namespace, vmi.Name)
+		return state
+	}
+	if err := ctrl.markVMIAvailable(vmi); err != nil {
+		klog.Errorf("Failed to mark pool VMI %s/%s available: %v", vmi.Namespace, vmi.Name, err)
+	}
+	return util.PoolStateAvailable
+}
 
-			if state, ok := vmi.Labels[util.WarmPoolStateLabel]; ok && state == util.PoolStateAvailable {
-				err := ctrl.maroonedpodsCli.KubevirtClient().KubevirtV1().VirtualMachineInstances(vmi.Namespace).Delete(
-					context.Background(), vmi.Name, k8smetav1.DeleteOptions{})
-				if err != nil {
-					klog.Errorf("Failed to delete excess pool VMI %s: %v", vmi.Name, err)
-				} else {
-					klog.Infof("Deleted excess pool VMI %s", vmi.Name)
-					deleted++
-				}
-			}
+// deleteAvailablePoolVMIs deletes up to count of the available VMIs, leaving claimed and creating ones alone
+func (ctrl *MaroonedPodsGateController) deleteAvailablePoolVMIs(vmis []*virtv1.VirtualMachineInstance, count int) {
+	deleted := 0
+	for _, vmi := range vmis {
+		if deleted >= count {
+			break
+		}
+		if vmi.Labels[util.WarmPoolStateLabel] != util.PoolStateAvailable {
+			continue
+		}
+		err := ctrl.deleteVirtualNode(vmi.Namespace, vmi.Name)
+		if err != nil {
+			klog.Errorf("Failed to delete excess pool VMI %s: %v", vmi.Name, err)
+			continue
+		}
+		klog.Infof("Deleted excess pool VMI %s/%s", vmi.Namespace, vmi.Name)
+		deleted++
+		if err := ctrl.deleteNetworkPolicy(vmi.Namespace, vmi.Name); err != nil {
+			klog.Errorf("Failed to clean up network isolation of pool VMI %s: %v", vmi.Name, err)
+		}
+		if err := ctrl.deleteRegistriesSecret(vmi.Namespace, vmi.Name); err != nil {
+			klog.Errorf("Failed to clean up the registries of pool VMI %s: %v", vmi.Name, err)
 		}
 	}
 }
@@ -322,6 +392,9 @@ func (ctrl *MaroonedPodsGateController) markVMIAvailable(vmi *virtv1.VirtualMach
 	if err != nil {
 		return fmt.Errorf("failed to mark VMI as available: %v", err)
 	}
+	if err := ctrl.syncPoolStateLabels(vmiCopy); err != nil {
+		return fmt.Errorf("failed to mark VirtualMachine as available: %v", err)
+	}
 
 	klog.Infof("VMI %s is now available in warm pool", vmi.Name)
 	return nil
@@ -383,16 +456,22 @@ func (ctrl *MaroonedPodsGateController) execute(key string) (error, enqueueState
 		return ctrl.handlePodDeletion(pod, podKey)
 	}
 
-	// Try to find an existing Virtual Machine Instance
+	// Pods of Jobs run to completion, their VM is no longer needed
+	if isPodTerminal(pod) {
+		return ctrl.handlePodCompletion(pod, podKey)
+	}
+
+	// Try to find an existing Virtual Machine Instance, shared by the pods of an island
 	var vmi *virtv1.VirtualMachineInstance
-	vmiObj, exist, err := ctrl.vmiInformer.GetStore().GetByKey(podKey)
+	vmiObj, exist, err := ctrl.vmiInformer.GetStore().GetByKey(fmt.Sprintf("%s/%s", pod.Namespace, util.VirtualNodeName(pod)))
 	if err != nil {
 		logger.Reason(err).Error("Failed to fetch vmi for namespace from cache.")
 		return err, BackOff
 	}
 	if !exist {
 		logger.V(4).Infof("VirtualMachineInstance not found in cache %s", key)
-		vmi = nil
+		// The pod may run on a VMI claimed from a warm pool
+		vmi = ctrl.claimedPoolVMI(pod.Namespace, pod.Name)
 	} else {
 		vmi = vmiObj.(*virtv1.VirtualMachineInstance)
 	}
@@ -435,42 +514,26 @@ func (ctrl *MaroonedPodsGateController) updatePodNodeSelector(pod *v1.Pod, nodeN
 
 // handlePodDeletion handles pod deletion and VMI cleanup when pod has finalizer
 func (ctrl *MaroonedPodsGateController) handlePodDeletion(pod *v1.Pod, key string) (error, enqueueState) {
-	// Check if pod has our finalizer
-	hasFinalizer := false
-	for _, f := range pod.Finalizers {
-		if f == util.MaroonedPodsFinalizer {
-			hasFinalizer = true
-			break
-		}
-	}
-
-	if !hasFinalizer {
+	if !hasMaroonedPodsFinalizer(pod) {
 		// No finalizer, nothing to do
 		klog.V(3).Infof("Pod %s/%s being deleted, no finalizer present", pod.Namespace, pod.Name)
 		return nil, Forget
 	}
 
-	klog.Infof("Pod %s/%s being deleted, cleaning up VMI", pod.Namespace, pod.Name)
+	// Give the guest kubelet the grace period of the pod to run preStop hooks and stop the containers.
+	// Requeued once the grace period expired, or by the update of the pod when they stopped.
+	if ctrl.waitForGuestContainers(pod, key) {
+		return nil, Forget
+	}
 
-	// Try to find and delete the VMI
-	vmiObj, exist, err := ctrl.vmiInformer.GetStore().GetByKey(key)
+	done, err := ctrl.releaseVirtualNode(pod)
 	if err != nil {
-		klog.Errorf("Failed to fetch VMI for pod %s: %v", key, err)
 		return err, BackOff
 	}
-
-	if exist {
-		vmi := vmiObj.(*virtv1.VirtualMachineInstance)
-		klog.Infof("Deleting VMI %s/%s for pod %s", vmi.Namespace, vmi.Name, pod.Name)
-		err = ctrl.maroonedpodsCli.KubevirtClient().KubevirtV1().VirtualMachineInstances(vmi.Namespace).Delete(
-			context.Background(), vmi.Name, k8smetav1.DeleteOptions{})
-		if err != nil {
-			klog.Errorf("Failed to delete VMI %s/%s: %v", vmi.Namespace, vmi.Name, err)
-			return err, BackOff
-		}
-		ctrl.recorder.Eventf(pod, v1.EventTypeNormal, "VMIDeleted", "Deleted VMI %s for marooned pod", vmi.Name)
-	} else {
-		klog.V(3).Infof("No VMI found for pod %s, skipping VMI deletion", key)
+	if !done {
+		// Requeued by the deletion of the VMI
+		klog.V(2).Infof("Waiting for VMI %s/%s of deleted pod to shut down", pod.Namespace, util.VirtualNodeName(pod))
+		return nil, Forget
 	}
 
 	// Remove our finalizer
@@ -518,8 +581,19 @@ func (ctrl *MaroonedPodsGateController) releasePod(key string) error {
 
 func (ctrl *MaroonedPodsGateController) sync(pod *v1.Pod, vmi *virtv1.VirtualMachineInstance, key string) error {
 	if vmi == nil {
+		if ctrl.preemptorPending(pod) {
+			return fmt.Errorf("waiting for pod %s to be provisioned before recreating the preempted VMI", pod.Annotations[util.PreemptedByAnnotation])
+		}
+
 		// Try to claim from warm pool first
-		poolVMI := ctrl.getAvailablePoolVMI()
+		var poolVMI *virtv1.VirtualMachineInstance
+		if canClaimPoolVMI(pod) {
+			pool, err := ctrl.tenantPoolOf(pod.Namespace)
+			if err != nil {
+				return err
+			}
+			poolVMI = ctrl.getAvailablePoolVMI(pod, pool)
+		}
 		if poolVMI != nil {
 			klog.Infof("Found available pool VMI %s for pod %s/%s", poolVMI.Name, pod.Namespace, pod.Name)
 			err := ctrl.claimPoolVMI(poolVMI, pod)
@@ -533,6 +607,9 @@ func (ctrl *MaroonedPodsGateController) sync(pod *v1.Pod, vmi *virtv1.VirtualMac
 					klog.Errorf("Failed to update pod nodeSelector: %v", err)
 					return err
 				}
+				if err := ctrl.syncTopologyLabels(poolVMI); err != nil {
+					klog.Errorf("Failed to sync topology labels of node %s: %v", poolVMI.Name, err)
+				}
 				vmi = poolVMI
 				return nil // Pool VMI is already running, no need to wait
 			}
@@ -540,11 +617,34 @@ func (ctrl *MaroonedPodsGateController) sync(pod *v1.Pod, vmi *virtv1.VirtualMac
 
 		// No available pool VMI, create new one
 		klog.Infof("No available pool VMI, creating new VMI for pod %s/%s", pod.Namespace, pod.Name)
-		vmi := ctrl.createVMIFromPod(pod)
-		vmi, err := ctrl.maroonedpodsCli.KubevirtClient().KubevirtV1().VirtualMachineInstances(pod.Namespace).Create(context.Background(), vmi, k8smetav1.CreateOptions{})
+		if err := ctrl.ensureNetworkPolicy(pod.Namespace, util.VirtualNodeName(pod)); err != nil {
+			ctrl.recorder.Eventf(pod, v1.EventTypeWarning, "NetworkPolicyFailed", "Failed to isolate VMI network: %v", err)
+			return err
+		}
+		var err error
+		if util.GetIsland(pod) != "" {
+			vmi, err = ctrl.createIslandVMI(pod)
+		} else {
+			vmi, err = ctrl.createVMIFromPod(pod)
+		}
+		if err != nil {
+			ctrl.recorder.Eventf(pod, v1.EventTypeWarning, "VMICreationFailed", "Failed to create VMI: %v", err)
+			return err
+		}
+		// Read by the guest before k3s starts, the VMI can't start without it
+		if err := ctrl.ensureRegistriesSecret(vmi); err != nil {
+			ctrl.recorder.Eventf(pod, v1.EventTypeWarning, "VMICreationFailed", "Failed to propagate image pull secrets: %v", err)
+			return err
+		}
+		vmi, err = ctrl.createVirtualNode(vmi)
 		if err != nil {
 			log.Log.Reason(err).Error("failed to create VMI")
 			ctrl.recorder.Eventf(pod, v1.EventTypeWarning, "VMICreationFailed", "Failed to create VMI: %v", err)
+			if isQuotaExceeded(err) {
+				if err := ctrl.preemptFor(pod, true); err != nil {
+					return err
+				}
+			}
 			return err
 		}
 		klog.Infof("Created VMI %s/%s for pod %s", vmi.Namespace, vmi.Name, pod.Name)
@@ -552,9 +652,16 @@ func (ctrl *MaroonedPodsGateController) sync(pod *v1.Pod, vmi *virtv1.VirtualMac
 		return fmt.Errorf("waiting for VMI %s to start", vmi.Name)
 	}
 
+	// Pods joining an island share the VMI of the pods before them
+	if util.GetIsland(pod) != "" {
+		if err := ctrl.joinIsland(pod, vmi); err != nil {
+			return err
+		}
+	}
+
 	if vmi.Status.Phase == virtv1.Running {
 
-		vmiObj, exist, err1 := ctrl.vmiInformer.GetStore().GetByKey(key)
+		vmiObj, exist, err1 := ctrl.vmiInformer.GetStore().GetByKey(fmt.Sprintf("%s/%s", vmi.Namespace, vmi.Name))
 		if err1 != nil {
 			log.Log.Reason(err1).Error("Failed to fetch vmi for namespace from cache.")
 		}
@@ -569,22 +676,40 @@ func (ctrl *MaroonedPodsGateController) sync(pod *v1.Pod, vmi *virtv1.VirtualMac
 		}
 
 	} else {
+		if reason, namespaced := vmiCapacityShortage(vmi); reason != "" {
+			klog.V(2).Infof("VMI %s can't be provisioned: %s", vmi.Name, reason)
+			if err := ctrl.preemptFor(pod, namespaced); err != nil {
+				return err
+			}
+		}
 		klog.V(2).Infof("VMI %s not yet Running, current phase: %s", vmi.Name, string(vmi.Status.Phase))
 		ctrl.recorder.Eventf(pod, v1.EventTypeNormal, "WaitingForVMI", "Waiting for VMI %s to become Running (current: %s)", vmi.Name, string(vmi.Status.Phase))
 		return fmt.Errorf("waiting for VMI %s to become Running, currently %s", vmi.Name, string(vmi.Status.Phase))
 	}
 
-	_, nodeExist, err := ctrl.nodeInformer.GetStore().GetByKey(vmi.Name)
+	nodeObj, nodeExist, err := ctrl.nodeInformer.GetStore().GetByKey(vmi.Name)
 	if err != nil {
 		log.Log.Reason(err).Error("Failed to fetch node from cache.")
 		return err
 	}
+	if nodeExist && staleNode(nodeObj.(*v1.Node), pod, vmi) {
+		// Left behind by the VM of an earlier pod of the same name, e.g. a recreated StatefulSet pod
+		klog.Infof("Node %s was registered for an earlier pod %s, deleting it", vmi.Name, key)
+		if err := ctrl.deleteNode(vmi.Name); err != nil {
+			return err
+		}
+		return fmt.Errorf("waiting for node %s to register again", vmi.Name)
+	}
 	if !nodeExist {
 		klog.V(2).Infof("Waiting for node %s to register", vmi.Name)
 		ctrl.recorder.Eventf(pod, v1.EventTypeNormal, "WaitingForNode", "Waiting for node %s to join cluster", vmi.Name)
 		return fmt.Errorf("waiting for node %s to register", vmi.Name)
 	} else {
 		klog.Infof("Node %s is ready, releasing pod %s", vmi.Name, pod.Name)
+		// Zone constraints of the pod are checked against the virtual node
+		if err := ctrl.syncTopologyLabels(vmi); err != nil {
+			return err
+		}
 		ctrl.recorder.Eventf(pod, v1.EventTypeNormal, "NodeReady", "Node %s joined cluster, releasing pod for scheduling", vmi.Name)
 		err = ctrl.releasePod(key)
 		if err != nil {
@@ -620,6 +745,8 @@ func (ctrl *MaroonedPodsGateController) Run(ctx context.Context, threadiness int
 
 	// Start warm pool reconciler
 	go wait.Until(ctrl.reconcileWarmPool, 30*time.Second, ctrl.stop)
+	// Follow changes of the pull secrets of running VMIs
+	go wait.Until(ctrl.rotateRegistriesSecrets, registriesRotationPeriod, ctrl.stop)
 
 	for i := 0; i < threadiness; i++ {
 		go wait.Until(ctrl.runWorker, time.Second, ctrl.stop)
@@ -641,7 +768,7 @@ func (ctrl *MaroonedPodsGateController) getConfig() *v1alpha1.MaroonedPodsConfig
 }
 
 // updateConfigStatus updates the MaroonedPodsConfig status with warm pool metrics
-func (ctrl *MaroonedPodsGateController) updateConfigStatus(total, available, claimed int32) {
+func (ctrl *MaroonedPodsGateController) updateConfigStatus(total, available, claimed int32, tenantPools []v1alpha1.TenantPoolStatus) {
 	config := ctrl.getConfig()
 	if config == nil {
 		return
@@ -650,7 +777,8 @@ func (ctrl *MaroonedPodsGateController) updateConfigStatus(total, available, cla
 	// Check if status actually changed
 	if config.Status.WarmPoolTotal == total &&
 		config.Status.WarmPoolAvailable == available &&
-		config.Status.WarmPoolClaimed == claimed {
+		config.Status.WarmPoolClaimed == claimed &&
+		equality.Semantic.DeepEqual(config.Status.TenantPools, tenantPools) {
 		return // No change, skip update
 	}
 
@@ -658,6 +786,7 @@ func (ctrl *MaroonedPodsGateController) updateConfigStatus(total, available, cla
 	configCopy.Status.WarmPoolTotal = total
 	configCopy.Status.WarmPoolAvailable = available
 	configCopy.Status.WarmPoolClaimed = claimed
+	configCopy.Status.TenantPools = tenantPools
 
 	// Use the generated client to update status
 	_, err := ctrl.maroonedpodsCli.RestClient().Put().
@@ -682,7 +811,7 @@ func (ctrl *MaroonedPodsGateController) getVMResourcesFromConfig() (cpuCores uin
 	cpuCores = 2
 	memoryMi = 3072
 	nodeImage = "quay.io/capk/ubuntu-2004-container-disk:v1.26.0"
-	taintKey = "maroonedpods.io"
+	taintKey = util.DefaultNodeTaintKey
 
 	config := ctrl.getConfig()
 	if config == nil {
@@ -708,11 +837,37 @@ func (ctrl *MaroonedPodsGateController) getVMResourcesFromConfig() (cpuCores uin
 	return
 }
 
-// getAvailablePoolVMI returns an available VMI from the warm pool, or nil if none available
-func (ctrl *MaroonedPodsGateController) getAvailablePoolVMI() *virtv1.VirtualMachineInstance {
+// canClaimPoolVMI checks whether a pre-booted VMI can serve the pod.
+// Pool VMIs can't attach the volumes of the pod, and are already placed without the host constraints of the pod.
+// The pods of an island share a VM named after the island.
+func canClaimPoolVMI(pod *v1.Pod) bool {
+	if _, ok := pod.Annotations[util.PassthroughVolumesAnnotation]; ok {
+		return false
+	}
+	if util.GetIsland(pod) != "" {
+		return false
+	}
+	return !hasHostConstraints(pod)
+}
+
+// getAvailablePoolVMI returns an available VMI from the warm pool the pod is entitled to,
+// the tenant pool of its namespace or else the shared warm pool, or nil if none available.
+// Of the available VMIs the one that pre-pulled the most images of the pod is picked.
+// Pods with pull secrets skip the VMIs whose guest doesn't follow updates of its registries.
+func (ctrl *MaroonedPodsGateController) getAvailablePoolVMI(pod *v1.Pod, pool *v1alpha1.TenantPool) *virtv1.VirtualMachineInstance {
+	pullSecrets, err := ctrl.podPullSecrets(pod)
+	needsPullSecrets := err != nil || len(pullSecrets) > 0
+
+	var candidates []*virtv1.VirtualMachineInstance
 	vmis := ctrl.vmiInformer.GetStore().List()
 	for _, obj := range vmis {
 		vmi := obj.(*virtv1.VirtualMachineInstance)
+		if !poolVMIEntitled(vmi, pod.Namespace, pool) {
+			continue
+		}
+		if needsPullSecrets && !followsRegistriesUpdates(vmi) {
+			continue
+		}
 
 		// Check if this is a pool VM and is available
 		if vmi.Labels != nil {
@@ -720,13 +875,16 @@ func (ctrl *MaroonedPodsGateController) getAvailablePoolVMI() *virtv1.VirtualMac
 				// Verify VMI is actually running
 				if vmi.Status.Phase == virtv1.Running {
 					klog.V(3).Infof("Found available pool VMI: %s/%s", vmi.Namespace, vmi.Name)
-					return vmi
+					candidates = append(candidates, vmi)
 				}
 			}
 		}
 	}
-	klog.V(3).Info("No available pool VMIs found")
-	return nil
+	if len(candidates) == 0 {
+		klog.V(3).Info("No available pool VMIs found")
+		return nil
+	}
+	return ctrl.bestMatchingPoolVMI(candidates, pod)
 }
 
 // isPoolVMI checks if a VMI is part of the warm pool
@@ -738,6 +896,29 @@ func (ctrl *MaroonedPodsGateController) isPoolVMI(vmi *virtv1.VirtualMachineInst
 	return hasLabel
 }
 
+// claimedPoolVMI returns the pool VMI claimed by the pod, or nil if it claimed none
+func (ctrl *MaroonedPodsGateController) claimedPoolVMI(namespace, podName string) *virtv1.VirtualMachineInstance {
+	podKey := fmt.Sprintf("%s/%s", namespace, podName)
+	for _, obj := range ctrl.vmiInformer.GetStore().List() {
+		vmi := obj.(*virtv1.VirtualMachineInstance)
+		if ctrl.isPoolVMI(vmi) && claimingPodKey(vmi) == podKey {
+			return vmi
+		}
+	}
+	return nil
+}
+
+// claimingPodKey returns the namespace/name key of the pod claiming the pool VMI, or an empty string
+// if it is unclaimed. The claimed-by labels hold the name and namespace of the pod, since label values
+// can't hold the key itself.
+func claimingPodKey(vmi *virtv1.VirtualMachineInstance) string {
+	podName := vmi.Labels[util.WarmPoolClaimedByLabel]
+	if podName == "" {
+		return ""
+	}
+	return fmt.Sprintf("%s/%s", vmi.Labels[util.WarmPoolClaimedByNamespaceLabel], podName)
+}
+
 // claimPoolVMI claims an available pool VMI for a specific pod
 func (ctrl *MaroonedPodsGateController) claimPoolVMI(vmi *virtv1.VirtualMachineInstance, pod *v1.Pod) error {
 	klog.Infof("Claiming pool VMI %s/%s for pod %s/%s", vmi.Namespace, vmi.Name, pod.Namespace, pod.Name)
@@ -748,13 +929,22 @@ func (ctrl *MaroonedPodsGateController) claimPoolVMI(vmi *virtv1.VirtualMachineI
 		vmiCopy.Labels = make(map[string]string)
 	}
 	vmiCopy.Labels[util.WarmPoolStateLabel] = util.PoolStateClaimed
-	vmiCopy.Labels[util.WarmPoolClaimedByLabel] = fmt.Sprintf("%s/%s", pod.Namespace, pod.Name)
+	vmiCopy.Labels[util.WarmPoolClaimedByLabel] = pod.Name
+	vmiCopy.Labels[util.WarmPoolClaimedByNamespaceLabel] = pod.Namespace
+
+	// The pull secrets of the pod, applied by the guest before the kubelet gives up on the image pull
+	if err := ctrl.ensureRegistriesSecret(vmiCopy); err != nil {
+		return fmt.Errorf("failed to propagate image pull secrets: %v", err)
+	}
 
 	_, err := ctrl.maroonedpodsCli.KubevirtClient().KubevirtV1().VirtualMachineInstances(vmiCopy.Namespace).Update(
 		context.Background(), vmiCopy, k8smetav1.UpdateOptions{})
 	if err != nil {
 		return fmt.Errorf("failed to update VMI labels: %v", err)
 	}
+	if err := ctrl.syncPoolStateLabels(vmiCopy); err != nil {
+		return fmt.Errorf("failed to update VirtualMachine labels: %v", err)
+	}
 
 	// Update the node with pod-specific taint
 	// Get config for taint key
@@ -772,13 +962,14 @@ func (ctrl *MaroonedPodsGateController) claimPoolVMI(vmi *virtv1.VirtualMachineI
 
 	node := nodeObj.(*v1.Node).DeepCopy()
 
-	// Add pod-specific taint
+	// Swap the pool taint for the pod-specific taint in one update,
+	// so the node is never open to other pods in between
 	podTaint := v1.Taint{
 		Key:    fmt.Sprintf("%s/%s", pod.Name, taintKey),
 		Value:  "claimed",
 		Effect: v1.TaintEffectNoSchedule,
 	}
-	node.Spec.Taints = append(node.Spec.Taints, podTaint)
+	node.Spec.Taints = append(withoutTaint(node.Spec.Taints, poolTaint(taintKey, vmi.Name).Key), podTaint)
 
 	_, err = ctrl.maroonedpodsCli.CoreV1().Nodes().Update(context.Background(), node, k8smetav1.UpdateOptions{})
 	if err != nil {
@@ -802,12 +993,20 @@ func (ctrl *MaroonedPodsGateController) returnVMIToPool(vmi *virtv1.VirtualMachi
 	}
 	vmiCopy.Labels[util.WarmPoolStateLabel] = util.PoolStateAvailable
 	delete(vmiCopy.Labels, util.WarmPoolClaimedByLabel)
+	delete(vmiCopy.Labels, util.WarmPoolClaimedByNamespaceLabel)
 
 	_, err := ctrl.maroonedpodsCli.KubevirtClient().KubevirtV1().VirtualMachineInstances(vmiCopy.Namespace).Update(
 		context.Background(), vmiCopy, k8smetav1.UpdateOptions{})
 	if err != nil {
 		return fmt.Errorf("failed to update VMI labels: %v", err)
 	}
+	if err := ctrl.syncPoolStateLabels(vmiCopy); err != nil {
+		return fmt.Errorf("failed to update VirtualMachine labels: %v", err)
+	}
+	// Drop the pull secrets of the pod, the next pod must not pull with them
+	if err := ctrl.ensureRegistriesSecret(vmiCopy); err != nil {
+		return fmt.Errorf("failed to reset the registries: %v", err)
+	}
 
 	// Remove pod-specific taint from node
 	_, _, _, taintKey := ctrl.getVMResourcesFromConfig()
@@ -825,15 +1024,10 @@ func (ctrl *MaroonedPodsGateController) returnVMIToPool(vmi *virtv1.VirtualMachi
 
 	node := nodeObj.(*v1.Node).DeepCopy()
 
-	// Remove pod-specific taint
+	// Swap the pod-specific taint back for the pool taint
 	podTaintKey := fmt.Sprintf("%s/%s", podName, taintKey)
-	newTaints := []v1.Taint{}
-	for _, taint := range node.Spec.Taints {
-		if taint.Key != podTaintKey {
-			newTaints = append(newTaints, taint)
-		}
-	}
-	node.Spec.Taints = newTaints
+	taint := poolTaint(taintKey, vmi.Name)
+	node.Spec.Taints = append(withoutTaint(withoutTaint(node.Spec.Taints, podTaintKey), taint.Key), taint)
 
 	_, err = ctrl.maroonedpodsCli.CoreV1().Nodes().Update(context.Background(), node, k8smetav1.UpdateOptions{})
 	if err != nil {
@@ -844,6 +1038,26 @@ func (ctrl *MaroonedPodsGateController) returnVMIToPool(vmi *virtv1.VirtualMachi
 	return nil
 }
 
+// poolTaint keeps every workload off the node of an unclaimed pool VMI, pool nodes join with it
+func poolTaint(taintKey, vmiName string) v1.Taint {
+	return v1.Taint{
+		Key:    fmt.Sprintf("%s/pool", taintKey),
+		Value:  vmiName,
+		Effect: v1.TaintEffectNoExecute,
+	}
+}
+
+// withoutTaint returns the taints without the ones of the key
+func withoutTaint(taints []v1.Taint, key string) []v1.Taint {
+	result := []v1.Taint{}
+	for _, taint := range taints {
+		if taint.Key != key {
+			result = append(result, taint)
+		}
+	}
+	return result
+}
+
 // generatePoolVMName generates a unique name for a pool VMI
 func generatePoolVMName() string {
 	const charset = "abcdefghijklmnopqrstuvwxyz0123456789"
@@ -854,35 +1068,21 @@ func generatePoolVMName() string {
 	return fmt.Sprintf("%s%s", util.WarmPoolVMNamePrefix, string(suffix))
 }
 
-// createPoolVMI creates a generic VMI for the warm pool (no pod-specific configuration)
-func (ctrl *MaroonedPodsGateController) createPoolVMI(namespace string) (*virtv1.VirtualMachineInstance, error) {
+// createPoolVMI creates a generic VMI for the warm pool (no pod-specific configuration).
+// VMIs of a tenant pool are labelled with the pool, VMIs of the shared warm pool are not.
+func (ctrl *MaroonedPodsGateController) createPoolVMI(namespace, pool string) (*virtv1.VirtualMachineInstance, error) {
 	// Get VM resources from config
-	cpuCores, memoryMi, nodeImage, _ := ctrl.getVMResourcesFromConfig()
+	cpuCores, memoryMi, _, taintKey := ctrl.getVMResourcesFromConfig()
+	// Joined by the node boot script like the VMIs of pods
+	nodeImage := k3sNodeImage
 
 	// Generate unique name
 	vmiName := generatePoolVMName()
 
 	klog.Infof("Creating pool VMI %s in namespace %s", vmiName, namespace)
 
-	// Create cloud-init without pod-specific taint
-	userData := `#!/bin/sh
-
-cat <<EOF >/tmp/kubeadm-join-config.conf
-apiVersion: kubeadm.k8s.io/v1beta3
-kind: JoinConfiguration
-discovery:
-  bootstrapToken:
-    unsafeSkipCAVerification: true
-    apiServerEndpoint: "192.168.66.101:6443"
-    token: "abcdef.1234567890123456"
-EOF
-
-kubeadm join --config /tmp/kubeadm-join-config.conf --ignore-preflight-errors=all --v=5
-useradd -s /bin/bash -d /home/vladik/ -m -G sudo vladik
-passwd vladik
-`
-
-	encodedData := base64.StdEncoding.EncodeToString([]byte(userData))
+	// Images pulled by the boot script once the node joined, before the VMI is available
+	prePullImages := strings.Join(ctrl.poolImages(pool), ",")
 
 	vmi := virtv1.NewVMIReferenceFromNameWithNS(namespace, vmiName)
 	vmi.Spec = virtv1.VirtualMachineInstanceSpec{Domain: virtv1.DomainSpec{}}
@@ -894,17 +1094,24 @@ passwd vladik
 	// Add pool labels
 	vmi.Labels = map[string]string{
 		util.WarmPoolStateLabel: util.PoolStateCreating,
+		util.MaroonedVMILabel:   vmiName,
+	}
+	if pool != "" {
+		vmi.Labels[util.WarmPoolNameLabel] = pool
 	}
+	if prePullImages != "" {
+		vmi.Annotations = map[string]string{util.PrePullImagesAnnotation: prePullImages}
+	}
+	// Garbage collected with the config
+	setConfigOwner(vmi, ctrl.getConfig())
+	ctrl.applyWorkloadPlacement(vmi)
 
 	// Network configuration
-	bridgeBinding := virtv1.Interface{
-		Name: virtv1.DefaultPodNetwork().Name,
-		InterfaceBindingMethod: virtv1.InterfaceBindingMethod{
-			Masquerade: &virtv1.InterfaceMasquerade{},
-		},
-	}
-	vmi.Spec.Domain.Devices.Interfaces = append(vmi.Spec.Domain.Devices.Interfaces, bridgeBinding)
+	vmi.Spec.Domain.Devices.Interfaces = append(vmi.Spec.Domain.Devices.Interfaces, podNetworkInterface(ctrl.getNetworkBinding()))
 	vmi.Spec.Networks = append(vmi.Spec.Networks, *virtv1.DefaultPodNetwork())
+	if reason := ctrl.applyEvictionStrategy(vmi); reason != "" {
+		klog.Infof("Pool VMI %s is shut down when its host is drained: %s", vmiName, reason)
+	}
 
 	// Resources
 	guestMemory := resource.MustParse(fmt.Sprintf("%dMi", memoryMi))
@@ -931,6 +1138,36 @@ passwd vladik
 			}},
 	)
 
+	// k3s registries.yaml of the guest: the registry mirror, plus the pull secrets of the pod once claimed.
+	// Only a Secret shared with virtiofs follows a claim, a live migratable VMI reads it when booting.
+	shared := *vmi.Spec.EvictionStrategy != virtv1.EvictionStrategyLiveMigrate
+	registriesDisks, registriesFilesystems, registriesSecretVolume, registriesScript := registriesVolume(vmiName, shared)
+	vmi.Spec.Domain.Devices.Disks = append(vmi.Spec.Domain.Devices.Disks, registriesDisks...)
+	vmi.Spec.Domain.Devices.Filesystems = append(vmi.Spec.Domain.Devices.Filesystems, registriesFilesystems...)
+	vmi.Spec.Volumes = append(vmi.Spec.Volumes, registriesSecretVolume)
+
+	// Create cloud-init without pod-specific taint, the node joins with the pool taint until claimed
+	taint := poolTaint(taintKey, vmiName)
+	userData := fmt.Sprintf(`#!/bin/sh
+# MaroonedPods warm pool node initialization script
+
+mkdir -p /etc/marooned
+# Write k3s join configuration, without a pod to dedicate the node to
+cat > /etc/marooned/join-info.yaml <<'JOINEOF'
+server_url: %s
+token: %s
+taint_key: %s
+pool_taint: %s
+network_binding: %s
+prepull_images: %s
+JOINEOF
+%s
+# Ensure marooned-node-boot service will run
+systemctl enable marooned-node-boot.service
+`, k3sServerURL, k3sJoinToken, taintKey, taint.ToString(), ctrl.getNetworkBinding(), prePullImages, registriesScript)
+
+	encodedData := base64.StdEncoding.EncodeToString([]byte(userData))
+
 	vmi.Spec.Domain.Devices.Disks = append(vmi.Spec.Domain.Devices.Disks,
 		virtv1.Disk{
 			Name: "cloudinitdisk",
@@ -947,9 +1184,16 @@ passwd vladik
 			}},
 	)
 
+	if err := ctrl.ensureNetworkPolicy(namespace, vmiName); err != nil {
+		return nil, err
+	}
+	// Read by the guest before k3s starts, the VMI can't start without it
+	if err := ctrl.ensureRegistriesSecret(vmi); err != nil {
+		return nil, err
+	}
+
 	// Create the VMI
-	createdVMI, err := ctrl.maroonedpodsCli.KubevirtClient().KubevirtV1().VirtualMachineInstances(namespace).Create(
-		context.Background(), vmi, k8smetav1.CreateOptions{})
+	createdVMI, err := ctrl.createVirtualNode(vmi)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create pool VMI: %v", err)
 	}
@@ -975,31 +1219,17 @@ func (ctrl *MaroonedPodsGateController) calculateVMResourcesFromPod(pod *v1.Pod)
 		}
 	}
 
-	// Default overhead: 500m CPU, 512Mi memory
-	overheadCPUMillis := int64(500)
-	overheadMemoryBytes := int64(512 * 1024 * 1024) // 512Mi
+	overheadCPUMillis, overheadMemoryBytes := ctrl.getResourceOverhead()
 
-	// Apply configured overhead if present
-	if config != nil && config.Spec.ResourceOverhead != nil {
-		if cpu, ok := (*config.Spec.ResourceOverhead)[v1.ResourceCPU]; ok {
-			overheadCPUMillis = cpu.MilliValue()
-		}
-		if mem, ok := (*config.Spec.ResourceOverhead)[v1.ResourceMemory]; ok {
-			overheadMemoryBytes = mem.Value()
-		}
-	}
-
-	// Sum up all container requests
+	// Effective pod resources: app containers and sidecars, the largest init container and the pod overhead
+	podResources := podEffectiveResources(pod, ctrl.getResourceSizing())
 	totalPodCPUMillis := int64(0)
 	totalPodMemoryBytes := int64(0)
-
-	for _, container := range pod.Spec.Containers {
-		if cpu, ok := container.Resources.Requests[v1.ResourceCPU]; ok {
-			totalPodCPUMillis += cpu.MilliValue()
-		}
-		if mem, ok := container.Resources.Requests[v1.ResourceMemory]; ok {
-			totalPodMemoryBytes += mem.Value()
-		}
+	if cpu, ok := podResources[v1.ResourceCPU]; ok {
+		totalPodCPUMillis = cpu.MilliValue()
+	}
+	if mem, ok := podResources[v1.ResourceMemory]; ok {
+		totalPodMemoryBytes = mem.Value()
 	}
 
 	// Add overhead to pod requests
@@ -1030,6 +1260,19 @@ func (ctrl *MaroonedPodsGateController) calculateVMResourcesFromPod(pod *v1.Pod)
 		memoryMi = baseVMMemory
 	}
 
+	// Explicit VM size requested by the pod replaces the calculation
+	cpuOverride, memoryOverride, err := util.GetVMResourcesOverride(pod)
+	if err != nil {
+		klog.Warningf("Ignoring VM resources override of pod %s/%s: %v", pod.Namespace, pod.Name, err)
+	} else {
+		if cpuOverride != nil {
+			cpuCores = uint32((cpuOverride.MilliValue() + 999) / 1000)
+		}
+		if memoryOverride != nil {
+			memoryMi = uint64((memoryOverride.Value() + 1024*1024 - 1) / (1024 * 1024))
+		}
+	}
+
 	klog.V(3).Infof("Pod %s/%s resource calculation: pod_cpu=%dm pod_mem=%dMi overhead_cpu=%dm overhead_mem=%dMi -> VM: cpu=%d mem=%dMi",
 		pod.Namespace, pod.Name,
 		totalPodCPUMillis, totalPodMemoryBytes/(1024*1024),
@@ -1039,74 +1282,101 @@ func (ctrl *MaroonedPodsGateController) calculateVMResourcesFromPod(pod *v1.Pod)
 	return
 }
 
-func (ctrl *MaroonedPodsGateController) createVMIFromPod(pod *v1.Pod) *virtv1.VirtualMachineInstance {
+// calculateScratchDiskSize calculates the size of the scratch disk holding the containerd and
+// kubelet data of the VM: the ephemeral-storage requests and emptyDir size limits of the pod,
+// plus overhead for image layers and logs.
+func (ctrl *MaroonedPodsGateController) calculateScratchDiskSize(pod *v1.Pod) resource.Quantity {
+	config := ctrl.getConfig()
+
+	size := resource.MustParse(defaultScratchDiskOverhead)
+	if config != nil && config.Spec.ResourceOverhead != nil {
+		if storage, ok := (*config.Spec.ResourceOverhead)[v1.ResourceEphemeralStorage]; ok {
+			size = storage.DeepCopy()
+		}
+	}
+
+	containersStorage := podEffectiveResources(pod, ctrl.getResourceSizing())[v1.ResourceEphemeralStorage]
+	size.Add(containersStorage)
+
+	// Memory backed emptyDirs count against the VM memory instead
+	for _, volume := range pod.Spec.Volumes {
+		if volume.EmptyDir != nil && volume.EmptyDir.Medium != v1.StorageMediumMemory && volume.EmptyDir.SizeLimit != nil {
+			size.Add(*volume.EmptyDir.SizeLimit)
+		}
+	}
+
+	klog.V(3).Infof("Pod %s/%s scratch disk: containers=%s -> disk=%s",
+		pod.Namespace, pod.Name, containersStorage.String(), size.String())
+
+	return size
+}
+
+func (ctrl *MaroonedPodsGateController) createVMIFromPod(pod *v1.Pod) (*virtv1.VirtualMachineInstance, error) {
 	// Calculate VM resources based on pod requests + overhead
 	cpuCores, memoryMi := ctrl.calculateVMResourcesFromPod(pod)
 
 	// Get node image and taint key from config - use bootc+k3s image
 	_, _, _, taintKey := ctrl.getVMResourcesFromConfig()
 	// Override with bootc+k3s node image
-	nodeImage := "quay.io/vladikr/marooned-node:latest"
+	nodeImage := k3sNodeImage
 
-	// Get Kubernetes API server endpoint
-	// TODO: Make this configurable via config or environment
-	serverURL := "https://kubernetes.default.svc:6443"
-	// TODO: Get actual token from kubeadm or secret
-	// For now using placeholder - in production this should come from kubeadm bootstrap token or service account
-	token := "abcdef.1234567890123456"
+	serverURL := k3sServerURL
+	token := k3sJoinToken
 
 	// Generate pod UID for unique node identification
 	podUID := string(pod.UID)
+	// Lets the node rejoin when a VirtualMachine restarts the VMI
+	nodePassword, err := generateNodePassword()
+	if err != nil {
+		return nil, err
+	}
 
-	// Generate cloud-init userdata that writes k3s join configuration
-	userData := fmt.Sprintf(`#!/bin/sh
-# MaroonedPods k3s node initialization script
-
-# Create marooned config directory
-mkdir -p /etc/marooned
-
-# Write k3s join configuration
-cat > /etc/marooned/join-info.yaml <<'JOINEOF'
-server_url: %s
-token: %s
-pod_uid: %s
-taint_key: %s
-JOINEOF
-
-# Ensure marooned-node-boot service will run
-systemctl enable marooned-node-boot.service
+	// Pod volumes attached to the VMI, mounted by cloud-init
+	disks, filesystems, volumes, mountScript, err := podVolumes(pod)
+	if err != nil {
+		return nil, err
+	}
 
-echo "MaroonedPods cloud-init complete"
-`, serverURL, token, podUID, taintKey)
+	// CPU and memory of the VM, hugepages are allocated by cloud-init
+	cpu, cpuResources := ctrl.vmiCPU(pod, cpuCores)
+	memory, memoryRequests, hugepagesScript := ctrl.vmiMemory(pod, memoryMi, cpu.DedicatedCPUPlacement)
 
-	encodedData := base64.StdEncoding.EncodeToString([]byte(userData))
 	vmi := virtv1.NewVMIReferenceFromNameWithNS(pod.Namespace, pod.Name)
 	vmi.Spec = virtv1.VirtualMachineInstanceSpec{Domain: virtv1.DomainSpec{}}
 	vmi.TypeMeta = k8smetav1.TypeMeta{
 		APIVersion: virtv1.GroupVersion.String(),
 		Kind:       "VirtualMachineInstance",
 	}
-	bridgeBinding := virtv1.Interface{
-		Name: virtv1.DefaultPodNetwork().Name,
-		/*InterfaceBindingMethod: virtv1.InterfaceBindingMethod{
-			Bridge: &virtv1.InterfaceBridge{},
-		},*/
-		InterfaceBindingMethod: virtv1.InterfaceBindingMethod{
-			Masquerade: &virtv1.InterfaceMasquerade{},
-		},
+	// Propagated to the virt-launcher pod, selected by the NetworkPolicy
+	vmi.Labels = map[string]string{
+		util.MaroonedVMILabel: pod.Name,
+	}
+	// Garbage collected with the pod
+	setPodOwner(vmi, pod)
+	// The guest is shut down with ACPI and given the grace period of the pod to power off
+	vmi.Spec.TerminationGracePeriodSeconds = vmiTerminationGracePeriod(pod)
+	// Host placement: the Workloads placement plus the host constraints of the pod
+	ctrl.applyWorkloadPlacement(vmi)
+	applyPodPlacement(vmi, pod)
+	// Pod network plus the secondary networks requested by the pod
+	interfaces, networks, networkData, err := podNetworks(pod, ctrl.getNetworkBinding())
+	if err != nil {
+		return nil, err
 	}
-	vmi.Spec.Domain.Devices.Interfaces = append(vmi.Spec.Domain.Devices.Interfaces, bridgeBinding)
-	vmi.Spec.Networks = append(vmi.Spec.Networks, *virtv1.DefaultPodNetwork())
+	vmi.Spec.Domain.Devices.Interfaces = append(vmi.Spec.Domain.Devices.Interfaces, interfaces...)
+	vmi.Spec.Networks = append(vmi.Spec.Networks, networks...)
 
-	// Use dynamic VM sizing based on pod requests + overhead
-	guestMemory := resource.MustParse(fmt.Sprintf("%dMi", memoryMi))
-	vmi.Spec.Domain.Memory = &virtv1.Memory{Guest: &guestMemory}
+	// Use dynamically calculated CPU cores, laid out and placed as configured
+	vmi.Spec.Domain.CPU, vmi.Spec.Domain.Resources = cpu, cpuResources
 
-	// Use dynamically calculated CPU cores
-	vmi.Spec.Domain.CPU = &virtv1.CPU{
-		Threads: 1,
-		Sockets: 1,
-		Cores:   cpuCores,
+	// Use dynamic VM sizing based on pod requests + overhead, plus the hugepages of the pod
+	vmi.Spec.Domain.Memory = memory
+	guestMemory := *memory.Guest
+	if memoryRequests != nil {
+		if vmi.Spec.Domain.Resources.Requests == nil {
+			vmi.Spec.Domain.Resources.Requests = v1.ResourceList{}
+		}
+		vmi.Spec.Domain.Resources.Requests[v1.ResourceMemory] = memoryRequests[v1.ResourceMemory]
 	}
 
 	// Optional: Enable kernelBoot for faster startup
@@ -1140,6 +1410,24 @@ echo "MaroonedPods cloud-init complete"
 	//     }
 	// }
 
+	// Scratch disk: containerd and kubelet data, mounted by the node boot script
+	scratchDisk, err := ctrl.scratchDiskVolumeSource(pod, ctrl.calculateScratchDiskSize(pod))
+	if err != nil {
+		return nil, err
+	}
+	vmi.Spec.Domain.Devices.Disks = append(vmi.Spec.Domain.Devices.Disks,
+		virtv1.Disk{
+			Name:   scratchDiskName,
+			Serial: scratchDiskSerial,
+			DiskDevice: virtv1.DiskDevice{
+				Disk: &virtv1.DiskTarget{Bus: virtv1.DiskBusVirtio}}})
+	vmi.Spec.Volumes = append(vmi.Spec.Volumes,
+		virtv1.Volume{
+			Name:         scratchDiskName,
+			VolumeSource: scratchDisk,
+		},
+	)
+
 	// Root disk: bootc-based k3s node image
 	vmi.Spec.Domain.Devices.Disks = append(vmi.Spec.Domain.Devices.Disks,
 		virtv1.Disk{
@@ -1155,6 +1443,47 @@ echo "MaroonedPods cloud-init complete"
 			}},
 	)
 
+	vmi.Spec.Domain.Devices.Disks = append(vmi.Spec.Domain.Devices.Disks, disks...)
+	vmi.Spec.Domain.Devices.Filesystems = append(vmi.Spec.Domain.Devices.Filesystems, filesystems...)
+	vmi.Spec.Volumes = append(vmi.Spec.Volumes, volumes...)
+
+	// Live migrate off drained hosts when the network and volumes allow it
+	if reason := ctrl.applyEvictionStrategy(vmi); reason != "" {
+		ctrl.recorder.Eventf(pod, v1.EventTypeNormal, "LiveMigrationDisabled", "VM is shut down when its host is drained: %s", reason)
+	}
+
+	// k3s registries.yaml of the guest: the pull secrets of the pod and the registry mirror.
+	// Shared with virtiofs to follow its updates, unless that would keep the VMI from live migrating.
+	shared := *vmi.Spec.EvictionStrategy != virtv1.EvictionStrategyLiveMigrate
+	registriesDisks, registriesFilesystems, registriesSecretVolume, registriesScript := registriesVolume(pod.Name, shared)
+	vmi.Spec.Domain.Devices.Disks = append(vmi.Spec.Domain.Devices.Disks, registriesDisks...)
+	vmi.Spec.Domain.Devices.Filesystems = append(vmi.Spec.Domain.Devices.Filesystems, registriesFilesystems...)
+	vmi.Spec.Volumes = append(vmi.Spec.Volumes, registriesSecretVolume)
+
+	// Generate cloud-init userdata that writes k3s join configuration
+	userData := fmt.Sprintf(`#!/bin/sh
+# MaroonedPods k3s node initialization script
+
+# Create marooned config directory
+mkdir -p /etc/marooned
+%s
+# Write k3s join configuration
+cat > /etc/marooned/join-info.yaml <<'JOINEOF'
+server_url: %s
+token: %s
+pod_uid: %s
+taint_key: %s
+network_binding: %s
+node_password: %s
+JOINEOF
+%s
+# Ensure marooned-node-boot service will run
+systemctl enable marooned-node-boot.service
+
+echo "MaroonedPods cloud-init complete"
+`, hugepagesScript+registriesScript, serverURL, token, podUID, taintKey, ctrl.getNetworkBinding(), nodePassword, mountScript)
+
+	encodedData := base64.StdEncoding.EncodeToString([]byte(userData))
 	// Cloud-init disk: provides k3s join configuration
 	vmi.Spec.Domain.Devices.Disks = append(vmi.Spec.Domain.Devices.Disks,
 		virtv1.Disk{
@@ -1166,12 +1495,14 @@ echo "MaroonedPods cloud-init complete"
 			Name: "cloudinitdisk",
 			VolumeSource: virtv1.VolumeSource{
 				CloudInitNoCloud: &virtv1.CloudInitNoCloudSource{
-					UserData:       "",
-					UserDataBase64: encodedData,
+					UserData:          "",
+					UserDataBase64:    encodedData,
+					NetworkDataBase64: base64.StdEncoding.EncodeToString([]byte(networkData)),
 				},
 			}},
 	)
 
+
 	// Readiness probe: Check if k3s-agent is active
 	// This replaces the generic VM running check with k3s-specific health
 	vmi.Spec.ReadinessProbe = &virtv1.Probe{
@@ -1194,5 +1525,5 @@ echo "MaroonedPods cloud-init complete"
 	klog.Infof("Created VMI spec for pod %s/%s: image=%s, cpu=%d, memory=%s",
 		pod.Namespace, pod.Name, nodeImage, vmi.Spec.Domain.CPU.Cores, guestMemory.String())
 
-	return vmi
+	return vmi, nil
 }
@@ -0,0 +1,13 @@
+package mp_controller_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestMaroonedPodsGateController(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "MaroonedPods Gate Controller Suite")
+}
@@ -0,0 +1,79 @@
+package mp_controller
+
+import (
+	"fmt"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	virtv1 "kubevirt.io/api/core/v1"
+	"maroonedpods.io/maroonedpods/pkg/util"
+	v1alpha1 "maroonedpods.io/maroonedpods/staging/src/maroonedpods.io/api/pkg/apis/core/v1alpha1"
+	"sort"
+	"strings"
+)
+
+// vmiMemory returns the memory of the VMI of the pod: the given guest memory plus the hugepages
+// the pod requests, backed by host hugepages when there are any. It also returns the host memory
+// the VMI requests when overcommitted, and the cloud-init script allocating the hugepages of the
+// pod in the guest before the kubelet starts.
+func (ctrl *MaroonedPodsGateController) vmiMemory(pod *v1.Pod, memoryMi uint64, dedicatedCPU bool) (*virtv1.Memory, v1.ResourceList, string) {
+	config := ctrl.getConfig()
+	memory := &virtv1.Memory{}
+
+	hugepages := podHugepagesMi(pod)
+	var pageSizesMi []uint64
+	for pageMi := range hugepages {
+		pageSizesMi = append(pageSizesMi, pageMi)
+	}
+	sort.Slice(pageSizesMi, func(i, j int) bool { return pageSizesMi[i] < pageSizesMi[j] })
+
+	guestMi := memoryMi
+	var hugepagesScript strings.Builder
+	for _, pageMi := range pageSizesMi {
+		pages := (hugepages[pageMi] + pageMi - 1) / pageMi
+		guestMi += pages * pageMi
+		fmt.Fprintf(&hugepagesScript, "echo %d > /sys/kernel/mm/hugepages/hugepages-%dkB/nr_hugepages\n", pages, pageMi*1024)
+	}
+
+	if len(pageSizesMi) > 0 {
+		backingPageMi := pageSizesMi[len(pageSizesMi)-1]
+		if config != nil && config.Spec.Memory != nil && config.Spec.Memory.HugepagesPageSize != "" {
+			backingPageMi = hugepageSizeMi(config.Spec.Memory.HugepagesPageSize)
+		}
+		memory.Hugepages = &virtv1.Hugepages{PageSize: resource.NewQuantity(int64(backingPageMi)*1024*1024, resource.BinarySI).String()}
+		// The guest memory must fill whole host pages
+		guestMi = (guestMi + backingPageMi - 1) / backingPageMi * backingPageMi
+	}
+	guestMemory := resource.MustParse(fmt.Sprintf("%dMi", guestMi))
+	memory.Guest = &guestMemory
+
+	var requests v1.ResourceList
+	// Hugepages and dedicated CPUs need the full guest memory reserved
+	if config != nil && config.Spec.Memory != nil && config.Spec.Memory.OvercommitPercent > 100 &&
+		memory.Hugepages == nil && !dedicatedCPU {
+		requestMi := guestMi * 100 / uint64(config.Spec.Memory.OvercommitPercent)
+		requests = v1.ResourceList{v1.ResourceMemory: resource.MustParse(fmt.Sprintf("%dMi", requestMi))}
+	}
+
+	if hugepagesScript.Len() > 0 {
+		return memory, requests, "\n# Allocate the hugepages of the pod before the kubelet starts\n" + hugepagesScript.String()
+	}
+	return memory, requests, ""
+}
+
+// podHugepagesMi returns the hugepages the pod needs in Mi by page size in Mi
+func podHugepagesMi(pod *v1.Pod) map[uint64]uint64 {
+	hugepages := map[uint64]uint64{}
+	// Hugepage requests always match the limits
+	for name, quantity := range podEffectiveResources(pod, v1alpha1.ResourceSizingRequests) {
+		if size := util.HugepageSize(name); size != "" && util.IsSupportedHugepageSize(size) {
+			hugepages[hugepageSizeMi(size)] += uint64((quantity.Value() + 1024*1024 - 1) / (1024 * 1024))
+		}
+	}
+	return hugepages
+}
+
+// hugepageSizeMi returns a supported hugepage size in Mi
+func hugepageSizeMi(size string) uint64 {
+	quantity := resource.MustParse(size)
+	return uint64(quantity.Value() / (1024 * 1024))
+}
@@ -0,0 +1,116 @@
+package mp_controller
+
+import (
+	"encoding/base64"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	v1alpha1 "maroonedpods.io/maroonedpods/staging/src/maroonedpods.io/api/pkg/apis/core/v1alpha1"
+)
+
+var _ = Describe("VM memory", func() {
+	newMemoryConfig := func(memory *v1alpha1.VMMemory) *v1alpha1.MaroonedPodsConfig {
+		return &v1alpha1.MaroonedPodsConfig{
+			ObjectMeta: metav1.ObjectMeta{Name: "default"},
+			Spec:       v1alpha1.MaroonedPodsConfigSpec{Memory: memory},
+		}
+	}
+
+	newHugepagesPod := func(resources v1.ResourceList) *v1.Pod {
+		pod := newPod("builder")
+		withRequests(&pod.Spec.Containers[0], resources)
+		withLimits(&pod.Spec.Containers[0], resources)
+		return pod
+	}
+
+	It("should only set the guest memory by default", func() {
+		ctrl, _ := newTestController(nil)
+		memory, requests, script := ctrl.vmiMemory(newPod("builder"), 3072, false)
+		Expect(memory.Guest.String()).To(Equal("3Gi"))
+		Expect(memory.Hugepages).To(BeNil())
+		Expect(requests).To(BeNil())
+		Expect(script).To(BeEmpty())
+	})
+
+	It("should back pods requesting hugepages with host hugepages", func() {
+		ctrl, _ := newTestController(nil)
+		pod := newHugepagesPod(v1.ResourceList{
+			v1.ResourceMemory: resource.MustParse("1Gi"),
+			"hugepages-2Mi":   resource.MustParse("512Mi"),
+		})
+		memory, _, script := ctrl.vmiMemory(pod, 3000, false)
+		Expect(memory.Hugepages).ToNot(BeNil())
+		Expect(memory.Hugepages.PageSize).To(Equal("2Mi"))
+		Expect(memory.Guest.String()).To(Equal("3512Mi"))
+		Expect(script).To(ContainSubstring("echo 256 > /sys/kernel/mm/hugepages/hugepages-2048kB/nr_hugepages\n"))
+	})
+
+	It("should back mixed page sizes with the largest page size", func() {
+		ctrl, _ := newTestController(nil)
+		pod := newHugepagesPod(v1.ResourceList{
+			"hugepages-2Mi": resource.MustParse("64Mi"),
+			"hugepages-1Gi": resource.MustParse("2Gi"),
+		})
+		memory, _, script := ctrl.vmiMemory(pod, 3072, false)
+		Expect(memory.Hugepages.PageSize).To(Equal("1Gi"))
+		// 3Gi + 64Mi + 2Gi rounded up to whole 1Gi pages
+		Expect(memory.Guest.String()).To(Equal("6Gi"))
+		Expect(script).To(ContainSubstring("echo 32 > /sys/kernel/mm/hugepages/hugepages-2048kB/nr_hugepages\n"))
+		Expect(script).To(ContainSubstring("echo 2 > /sys/kernel/mm/hugepages/hugepages-1048576kB/nr_hugepages\n"))
+	})
+
+	It("should use the configured host page size", func() {
+		ctrl, _ := newTestController(newMemoryConfig(&v1alpha1.VMMemory{HugepagesPageSize: "2Mi"}))
+		memory, _, _ := ctrl.vmiMemory(newHugepagesPod(v1.ResourceList{"hugepages-1Gi": resource.MustParse("1Gi")}), 3072, false)
+		Expect(memory.Hugepages.PageSize).To(Equal("2Mi"))
+		Expect(memory.Guest.String()).To(Equal("4Gi"))
+	})
+
+	Context("overcommit", func() {
+		var ctrl *MaroonedPodsGateController
+
+		BeforeEach(func() {
+			ctrl, _ = newTestController(newMemoryConfig(&v1alpha1.VMMemory{OvercommitPercent: 150}))
+		})
+
+		It("should request less memory than the guest sees", func() {
+			memory, requests, _ := ctrl.vmiMemory(newPod("builder"), 3072, false)
+			Expect(memory.Guest.String()).To(Equal("3Gi"))
+			Expect(requests.Memory().String()).To(Equal("2Gi"))
+		})
+
+		It("should not overcommit hugepages or dedicated CPUs", func() {
+			_, requests, _ := ctrl.vmiMemory(newHugepagesPod(v1.ResourceList{"hugepages-2Mi": resource.MustParse("64Mi")}), 3072, false)
+			Expect(requests).To(BeNil())
+			_, requests, _ = ctrl.vmiMemory(newPod("builder"), 3072, true)
+			Expect(requests).To(BeNil())
+		})
+
+		It("should set the memory requests on the VMI", func() {
+			vmi, err := ctrl.createVMIFromPod(newPod("builder"))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(vmi.Spec.Domain.Memory.Guest.String()).To(Equal("3Gi"))
+			Expect(vmi.Spec.Domain.Resources.Requests.Memory().String()).To(Equal("2Gi"))
+		})
+	})
+
+	It("should allocate the hugepages in the guest before writing the join configuration", func() {
+		ctrl, _ := newTestController(nil)
+		vmi, err := ctrl.createVMIFromPod(newHugepagesPod(v1.ResourceList{"hugepages-2Mi": resource.MustParse("64Mi")}))
+		Expect(err).ToNot(HaveOccurred())
+
+		var userData string
+		for _, volume := range vmi.Spec.Volumes {
+			if volume.CloudInitNoCloud != nil {
+				decoded, err := base64.StdEncoding.DecodeString(volume.CloudInitNoCloud.UserDataBase64)
+				Expect(err).ToNot(HaveOccurred())
+				userData = string(decoded)
+			}
+		}
+		Expect(userData).To(MatchRegexp(`(?s)nr_hugepages.*join-info\.yaml`))
+	})
+})
@@ -0,0 +1,151 @@
+package mp_controller
+
+import (
+	"context"
+	"fmt"
+	v1 "k8s.io/api/core/v1"
+	k8smetav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+	virtv1 "kubevirt.io/api/core/v1"
+	"maroonedpods.io/maroonedpods/pkg/util"
+	v1alpha1 "maroonedpods.io/maroonedpods/staging/src/maroonedpods.io/api/pkg/apis/core/v1alpha1"
+)
+
+// getEvictionStrategy returns the configured eviction strategy of virtual node VMIs
+func (ctrl *MaroonedPodsGateController) getEvictionStrategy() v1alpha1.EvictionStrategy {
+	config := ctrl.getConfig()
+	if config == nil || config.Spec.EvictionStrategy == "" {
+		return v1alpha1.EvictionStrategyLiveMigrate
+	}
+	return config.Spec.EvictionStrategy
+}
+
+// applyEvictionStrategy sets the eviction strategy of the VMI.
+// KubeVirt refuses to evict a VMI with the LiveMigrate strategy that can't migrate, blocking the
+// drain of its host, so such VMIs are shut down instead. The returned reason explains why.
+func (ctrl *MaroonedPodsGateController) applyEvictionStrategy(vmi *virtv1.VirtualMachineInstance) string {
+	strategy := virtv1.EvictionStrategyNone
+	var reason string
+	if ctrl.getEvictionStrategy() == v1alpha1.EvictionStrategyLiveMigrate {
+		if reason = ctrl.notMigratableReason(vmi); reason == "" {
+			strategy = virtv1.EvictionStrategyLiveMigrate
+		}
+	}
+	vmi.Spec.EvictionStrategy = &strategy
+	return reason
+}
+
+// notMigratableReason returns why the VMI can't be live migrated, or an empty string.
+// The containerDisk, scratch disk and cloud-init disk are copied to the target host by KubeVirt.
+func (ctrl *MaroonedPodsGateController) notMigratableReason(vmi *virtv1.VirtualMachineInstance) string {
+	// The node IP of the guest is the pod IP of the virt-launcher pod, which changes on migration
+	if binding := ctrl.getNetworkBinding(); binding != v1alpha1.NetworkBindingMasquerade {
+		return fmt.Sprintf("the %s binding of the pod network changes the node IP on migration", binding)
+	}
+	if len(vmi.Spec.Domain.Devices.Filesystems) > 0 {
+		return "volumes shared with virtiofs can't be migrated"
+	}
+	for _, volume := range vmi.Spec.Volumes {
+		if volume.PersistentVolumeClaim == nil {
+			continue
+		}
+		claimName := volume.PersistentVolumeClaim.ClaimName
+		pvc, err := ctrl.maroonedpodsCli.CoreV1().PersistentVolumeClaims(vmi.Namespace).Get(context.Background(), claimName, k8smetav1.GetOptions{})
+		if err != nil {
+			return fmt.Sprintf("failed to get PVC %s: %v", claimName, err)
+		}
+		if !hasAccessMode(pvc, v1.ReadWriteMany) {
+			return fmt.Sprintf("PVC %s is not ReadWriteMany", claimName)
+		}
+	}
+	return ""
+}
+
+func hasAccessMode(pvc *v1.PersistentVolumeClaim, mode v1.PersistentVolumeAccessMode) bool {
+	for _, accessMode := range pvc.Spec.AccessModes {
+		if accessMode == mode {
+			return true
+		}
+	}
+	return false
+}
+
+func (ctrl *MaroonedPodsGateController) addMigration(obj interface{}) {
+	migration := obj.(*virtv1.VirtualMachineInstanceMigration)
+	if migration.IsFinal() {
+		return
+	}
+	ctrl.reportMigration(migration)
+}
+
+func (ctrl *MaroonedPodsGateController) updateMigration(old, curr interface{}) {
+	oldMigration := old.(*virtv1.VirtualMachineInstanceMigration)
+	migration := curr.(*virtv1.VirtualMachineInstanceMigration)
+	if oldMigration.Status.Phase == migration.Status.Phase {
+		return
+	}
+	ctrl.reportMigration(migration)
+}
+
+// reportMigration records the progress of the migration on the marooned pod of the VMI,
+// and moves the topology labels of the virtual node to the new host once it completes
+func (ctrl *MaroonedPodsGateController) reportMigration(migration *virtv1.VirtualMachineInstanceMigration) {
+	vmiName := migration.Spec.VMIName
+	pod := ctrl.vmiPod(migration.Namespace, vmiName)
+	if pod == nil {
+		return
+	}
+
+	var sourceNode, targetNode string
+	if state := migration.Status.MigrationState; state != nil {
+		sourceNode, targetNode = state.SourceNode, state.TargetNode
+	}
+	switch migration.Status.Phase {
+	case virtv1.MigrationPhaseUnset, virtv1.MigrationPending:
+		ctrl.recorder.Eventf(pod, v1.EventTypeNormal, "MigrationStarted", "Live migration of VM %s requested", vmiName)
+	case virtv1.MigrationRunning:
+		ctrl.recorder.Eventf(pod, v1.EventTypeNormal, "MigrationRunning", "Live migrating VM %s from host %s to host %s", vmiName, sourceNode, targetNode)
+	case virtv1.MigrationSucceeded:
+		ctrl.recorder.Eventf(pod, v1.EventTypeNormal, "MigrationSucceeded", "VM %s migrated to host %s", vmiName, targetNode)
+		ctrl.syncMigratedTopologyLabels(migration.Namespace, vmiName, targetNode)
+	case virtv1.MigrationFailed:
+		ctrl.recorder.Eventf(pod, v1.EventTypeWarning, "MigrationFailed", "Live migration of VM %s failed, the VM keeps running on host %s", vmiName, sourceNode)
+	}
+}
+
+// syncMigratedTopologyLabels copies the zone of the new host to the virtual node
+func (ctrl *MaroonedPodsGateController) syncMigratedTopologyLabels(namespace, vmiName, targetNode string) {
+	obj, exists, err := ctrl.vmiInformer.GetStore().GetByKey(fmt.Sprintf("%s/%s", namespace, vmiName))
+	if err != nil || !exists || targetNode == "" {
+		return
+	}
+	vmi := obj.(*virtv1.VirtualMachineInstance).DeepCopy()
+	// The cached VMI may not have caught up with the migration yet
+	vmi.Status.NodeName = targetNode
+	if err := ctrl.syncTopologyLabels(vmi); err != nil {
+		klog.Errorf("Failed to sync topology labels of node %s after migration: %v", vmiName, err)
+	}
+}
+
+// vmiPod returns the marooned pod running on the VMI, the oldest member for the VMI of an island,
+// or nil for unclaimed pool VMIs
+func (ctrl *MaroonedPodsGateController) vmiPod(namespace, vmiName string) *v1.Pod {
+	key := fmt.Sprintf("%s/%s", namespace, vmiName)
+	if obj, exists, err := ctrl.vmiInformer.GetStore().GetByKey(key); err == nil && exists {
+		vmi := obj.(*virtv1.VirtualMachineInstance)
+		if claimedBy := claimingPodKey(vmi); claimedBy != "" {
+			key = claimedBy
+		}
+		if island, ok := vmi.Labels[util.IslandLabel]; ok {
+			if members := ctrl.islandMembers(namespace, island); len(members) > 0 {
+				return members[0]
+			}
+			return nil
+		}
+	}
+	obj, exists, err := ctrl.podInformer.GetStore().GetByKey(key)
+	if err != nil || !exists {
+		return nil
+	}
+	return obj.(*v1.Pod)
+}
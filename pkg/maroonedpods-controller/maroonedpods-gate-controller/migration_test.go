@@ -0,0 +1,123 @@
+package mp_controller
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	virtv1 "kubevirt.io/api/core/v1"
+	v1alpha1 "maroonedpods.io/maroonedpods/staging/src/maroonedpods.io/api/pkg/apis/core/v1alpha1"
+)
+
+func newPVC(name string, mode v1.PersistentVolumeAccessMode) *v1.PersistentVolumeClaim {
+	return &v1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "tenant"},
+		Spec:       v1.PersistentVolumeClaimSpec{AccessModes: []v1.PersistentVolumeAccessMode{mode}},
+	}
+}
+
+var _ = Describe("Live migration", func() {
+	blockDevices := []v1.VolumeDevice{{Name: "data", DevicePath: "/dev/xvda"}}
+	blockVolumes := []v1.Volume{{Name: "data", VolumeSource: v1.VolumeSource{
+		PersistentVolumeClaim: &v1.PersistentVolumeClaimVolumeSource{ClaimName: "data-claim"},
+	}}}
+
+	evictionStrategy := func(vmi *virtv1.VirtualMachineInstance) virtv1.EvictionStrategy {
+		Expect(vmi.Spec.EvictionStrategy).ToNot(BeNil())
+		return *vmi.Spec.EvictionStrategy
+	}
+
+	It("should live migrate VMIs by default", func() {
+		ctrl, _ := newTestController(nil)
+		vmi, err := ctrl.createVMIFromPod(newPod("web-1"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(evictionStrategy(vmi)).To(Equal(virtv1.EvictionStrategyLiveMigrate))
+	})
+
+	It("should live migrate VMIs with ReadWriteMany block volumes", func() {
+		ctrl, _ := newTestController(nil, newPVC("data-claim", v1.ReadWriteMany))
+		vmi, err := ctrl.createVMIFromPod(newPod("db", withVolumes(blockVolumes, blockDevices)))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(evictionStrategy(vmi)).To(Equal(virtv1.EvictionStrategyLiveMigrate))
+	})
+
+	DescribeTable("should shut down VMIs that can't migrate", func(config *v1alpha1.MaroonedPodsConfig, pod *v1.Pod, reason string) {
+		ctrl, _ := newTestController(config, newPVC("data-claim", v1.ReadWriteOnce))
+		vmi, err := ctrl.createVMIFromPod(pod)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(evictionStrategy(vmi)).To(Equal(virtv1.EvictionStrategyNone))
+		if reason != "" {
+			Expect(ctrl.recorder.(*record.FakeRecorder).Events).To(Receive(ContainSubstring(reason)))
+		}
+	},
+		Entry("when disabled", &v1alpha1.MaroonedPodsConfig{
+			Spec: v1alpha1.MaroonedPodsConfigSpec{EvictionStrategy: v1alpha1.EvictionStrategyNone},
+		}, newPod("web-1"), ""),
+		Entry("with the bridge binding", &v1alpha1.MaroonedPodsConfig{
+			Spec: v1alpha1.MaroonedPodsConfigSpec{NetworkBinding: v1alpha1.NetworkBindingBridge},
+		}, newPod("web-1"), "bridge binding"),
+		Entry("with virtiofs volumes", nil, newPod("db", withVolumes([]v1.Volume{{Name: "settings", VolumeSource: v1.VolumeSource{
+			ConfigMap: &v1.ConfigMapVolumeSource{LocalObjectReference: v1.LocalObjectReference{Name: "settings"}},
+		}}}, nil)), "virtiofs"),
+		Entry("with ReadWriteOnce block volumes", nil, newPod("db", withVolumes(blockVolumes, blockDevices)), "not ReadWriteMany"),
+	)
+
+	Context("events", func() {
+		newMigration := func(phase virtv1.VirtualMachineInstanceMigrationPhase) *virtv1.VirtualMachineInstanceMigration {
+			return &virtv1.VirtualMachineInstanceMigration{
+				ObjectMeta: metav1.ObjectMeta{Name: "web-1-migration", Namespace: "tenant"},
+				Spec:       virtv1.VirtualMachineInstanceMigrationSpec{VMIName: "web-1"},
+				Status: virtv1.VirtualMachineInstanceMigrationStatus{
+					Phase:          phase,
+					MigrationState: &virtv1.VirtualMachineInstanceMigrationState{SourceNode: "host-1", TargetNode: "host-2"},
+				},
+			}
+		}
+
+		It("should report the progress of the migration on the pod", func() {
+			ctrl, _ := newTestController(nil)
+			Expect(ctrl.podInformer.GetStore().Add(newPod("web-1"))).To(Succeed())
+			events := ctrl.recorder.(*record.FakeRecorder).Events
+
+			ctrl.addMigration(newMigration(virtv1.MigrationPending))
+			Expect(events).To(Receive(ContainSubstring("MigrationStarted")))
+			ctrl.updateMigration(newMigration(virtv1.MigrationPending), newMigration(virtv1.MigrationScheduling))
+			Expect(events).ToNot(Receive())
+			ctrl.updateMigration(newMigration(virtv1.MigrationTargetReady), newMigration(virtv1.MigrationRunning))
+			Expect(events).To(Receive(ContainSubstring("from host host-1 to host host-2")))
+			ctrl.updateMigration(newMigration(virtv1.MigrationRunning), newMigration(virtv1.MigrationFailed))
+			Expect(events).To(Receive(ContainSubstring("MigrationFailed")))
+		})
+
+		It("should move the zone of the virtual node to the new host", func() {
+			hosts := []*v1.Node{
+				{ObjectMeta: metav1.ObjectMeta{Name: "host-1", Labels: map[string]string{v1.LabelTopologyZone: "zone-a"}}},
+				{ObjectMeta: metav1.ObjectMeta{Name: "host-2", Labels: map[string]string{v1.LabelTopologyZone: "zone-b"}}},
+			}
+			node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "web-1", Labels: map[string]string{v1.LabelTopologyZone: "zone-a"}}}
+			ctrl, cli := newTestController(nil, hosts[0], hosts[1], node)
+			for _, n := range []*v1.Node{hosts[0], hosts[1], node} {
+				Expect(ctrl.nodeInformer.GetStore().Add(n)).To(Succeed())
+			}
+			Expect(ctrl.podInformer.GetStore().Add(newPod("web-1"))).To(Succeed())
+			vmi := &virtv1.VirtualMachineInstance{ObjectMeta: metav1.ObjectMeta{Name: "web-1", Namespace: "tenant"}}
+			vmi.Status.NodeName = "host-1"
+			Expect(ctrl.vmiInformer.GetStore().Add(vmi)).To(Succeed())
+
+			ctrl.updateMigration(newMigration(virtv1.MigrationRunning), newMigration(virtv1.MigrationSucceeded))
+			updated, err := cli.CoreV1().Nodes().Get(context.Background(), "web-1", metav1.GetOptions{})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(updated.Labels).To(HaveKeyWithValue(v1.LabelTopologyZone, "zone-b"))
+		})
+
+		It("should ignore migrations of unclaimed pool VMIs", func() {
+			ctrl, _ := newTestController(nil)
+			ctrl.addMigration(newMigration(virtv1.MigrationPending))
+			Expect(ctrl.recorder.(*record.FakeRecorder).Events).ToNot(Receive())
+		})
+	})
+})
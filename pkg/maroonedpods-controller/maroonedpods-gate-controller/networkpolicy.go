@@ -0,0 +1,170 @@
+package mp_controller
+
+import (
+	"context"
+	"fmt"
+	v1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	k8smetav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/klog/v2"
+	"maroonedpods.io/maroonedpods/pkg/util"
+	v1alpha1 "maroonedpods.io/maroonedpods/staging/src/maroonedpods.io/api/pkg/apis/core/v1alpha1"
+)
+
+const (
+	// flannel VXLAN, the default k3s backend
+	defaultOverlayPort = 8472
+	dnsPort            = 53
+)
+
+// networkPolicyName returns the name of the NetworkPolicy isolating the given VMI
+func networkPolicyName(vmiName string) string {
+	return util.NetworkPolicyNamePrefix + vmiName
+}
+
+// getNetworkIsolation returns the network isolation config, or nil when isolation is disabled
+func (ctrl *MaroonedPodsGateController) getNetworkIsolation() *v1alpha1.NetworkIsolation {
+	config := ctrl.getConfig()
+	if config == nil || config.Spec.NetworkIsolation == nil || !config.Spec.NetworkIsolation.Enabled {
+		return nil
+	}
+	return config.Spec.NetworkIsolation
+}
+
+// ensureNetworkPolicy creates the egress NetworkPolicy of a VMI launcher pod.
+// It is created before the VMI so the launcher pod never runs unrestricted.
+func (ctrl *MaroonedPodsGateController) ensureNetworkPolicy(namespace, vmiName string) error {
+	isolation := ctrl.getNetworkIsolation()
+	if isolation == nil {
+		return nil
+	}
+
+	apiServerPeers, apiServerPorts, err := ctrl.apiServerEgress(isolation)
+	if err != nil {
+		return err
+	}
+	policy := newNetworkPolicy(namespace, vmiName, isolation, apiServerPeers, apiServerPorts)
+	if ctrl.getRegistryMirror() != nil {
+		policy.Spec.Egress = append(policy.Spec.Egress, registryMirrorEgress())
+	}
+
+	_, err = ctrl.maroonedpodsCli.NetworkingV1().NetworkPolicies(namespace).Create(context.Background(), policy, k8smetav1.CreateOptions{})
+	if err != nil && !errors.IsAlreadyExists(err) {
+		return fmt.Errorf("failed to create NetworkPolicy %s/%s: %v", namespace, policy.Name, err)
+	}
+	klog.V(3).Infof("NetworkPolicy %s/%s isolates VMI %s", namespace, policy.Name, vmiName)
+	return nil
+}
+
+// deleteNetworkPolicy removes the NetworkPolicy of a VMI launcher pod, if any
+func (ctrl *MaroonedPodsGateController) deleteNetworkPolicy(namespace, vmiName string) error {
+	err := ctrl.maroonedpodsCli.NetworkingV1().NetworkPolicies(namespace).Delete(context.Background(), networkPolicyName(vmiName), k8smetav1.DeleteOptions{})
+	if err != nil && !errors.IsNotFound(err) {
+		return fmt.Errorf("failed to delete NetworkPolicy %s/%s: %v", namespace, networkPolicyName(vmiName), err)
+	}
+	return nil
+}
+
+// apiServerEgress returns the peers and ports the API server is reachable on
+func (ctrl *MaroonedPodsGateController) apiServerEgress(isolation *v1alpha1.NetworkIsolation) ([]networkingv1.NetworkPolicyPeer, []networkingv1.NetworkPolicyPort, error) {
+	if len(isolation.APIServerCIDRs) > 0 {
+		return ipBlockPeers(isolation.APIServerCIDRs), tcpPorts(443, 6443), nil
+	}
+
+	endpoints, err := ctrl.maroonedpodsCli.CoreV1().Endpoints(v1.NamespaceDefault).Get(context.Background(), "kubernetes", k8smetav1.GetOptions{})
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to look up the API server endpoints: %v", err)
+	}
+	var cidrs []string
+	var ports []int32
+	for _, subset := range endpoints.Subsets {
+		for _, address := range subset.Addresses {
+			cidrs = append(cidrs, address.IP+"/32")
+		}
+		for _, port := range subset.Ports {
+			ports = append(ports, port.Port)
+		}
+	}
+	if len(cidrs) == 0 {
+		return nil, nil, fmt.Errorf("the kubernetes service has no endpoints")
+	}
+	return ipBlockPeers(cidrs), tcpPorts(ports...), nil
+}
+
+// newNetworkPolicy builds the egress policy of a VMI launcher pod: the API server,
+// cluster DNS and the CNI overlay, plus any configured additional rules
+func newNetworkPolicy(namespace, vmiName string, isolation *v1alpha1.NetworkIsolation,
+	apiServerPeers []networkingv1.NetworkPolicyPeer, apiServerPorts []networkingv1.NetworkPolicyPort) *networkingv1.NetworkPolicy {
+	udp := v1.ProtocolUDP
+	tcp := v1.ProtocolTCP
+	dns := intstr.FromInt(dnsPort)
+
+	overlayPorts := isolation.OverlayPorts
+	if len(overlayPorts) == 0 {
+		overlay := intstr.FromInt(defaultOverlayPort)
+		overlayPorts = []networkingv1.NetworkPolicyPort{{Protocol: &udp, Port: &overlay}}
+	}
+
+	egress := []networkingv1.NetworkPolicyEgressRule{
+		{
+			To:    apiServerPeers,
+			Ports: apiServerPorts,
+		},
+		{
+			To: []networkingv1.NetworkPolicyPeer{{
+				NamespaceSelector: &k8smetav1.LabelSelector{
+					MatchLabels: map[string]string{v1.LabelMetadataName: k8smetav1.NamespaceSystem},
+				},
+				PodSelector: &k8smetav1.LabelSelector{
+					MatchLabels: map[string]string{"k8s-app": "kube-dns"},
+				},
+			}},
+			Ports: []networkingv1.NetworkPolicyPort{
+				{Protocol: &udp, Port: &dns},
+				{Protocol: &tcp, Port: &dns},
+			},
+		},
+		{
+			To:    ipBlockPeers(isolation.OverlayCIDRs),
+			Ports: overlayPorts,
+		},
+	}
+	egress = append(egress, isolation.AdditionalEgress...)
+
+	return &networkingv1.NetworkPolicy{
+		ObjectMeta: k8smetav1.ObjectMeta{
+			Name:      networkPolicyName(vmiName),
+			Namespace: namespace,
+			Labels: map[string]string{
+				util.MaroonedVMILabel: vmiName,
+			},
+		},
+		Spec: networkingv1.NetworkPolicySpec{
+			PodSelector: k8smetav1.LabelSelector{
+				MatchLabels: map[string]string{util.MaroonedVMILabel: vmiName},
+			},
+			PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeEgress},
+			Egress:      egress,
+		},
+	}
+}
+
+func ipBlockPeers(cidrs []string) []networkingv1.NetworkPolicyPeer {
+	var peers []networkingv1.NetworkPolicyPeer
+	for _, cidr := range cidrs {
+		peers = append(peers, networkingv1.NetworkPolicyPeer{IPBlock: &networkingv1.IPBlock{CIDR: cidr}})
+	}
+	return peers
+}
+
+func tcpPorts(ports ...int32) []networkingv1.NetworkPolicyPort {
+	tcp := v1.ProtocolTCP
+	var result []networkingv1.NetworkPolicyPort
+	for _, port := range ports {
+		p := intstr.FromInt(int(port))
+		result = append(result, networkingv1.NetworkPolicyPort{Protocol: &tcp, Port: &p})
+	}
+	return result
+}
@@ -0,0 +1,108 @@
+package mp_controller
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	v1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"maroonedpods.io/maroonedpods/pkg/util"
+	v1alpha1 "maroonedpods.io/maroonedpods/staging/src/maroonedpods.io/api/pkg/apis/core/v1alpha1"
+)
+
+func newConfigWithIsolation(isolation *v1alpha1.NetworkIsolation) *v1alpha1.MaroonedPodsConfig {
+	return &v1alpha1.MaroonedPodsConfig{
+		ObjectMeta: metav1.ObjectMeta{Name: "default"},
+		Spec:       v1alpha1.MaroonedPodsConfigSpec{NetworkIsolation: isolation},
+	}
+}
+
+var _ = Describe("Network isolation", func() {
+	apiServerEndpoints := &v1.Endpoints{
+		ObjectMeta: metav1.ObjectMeta{Name: "kubernetes", Namespace: v1.NamespaceDefault},
+		Subsets: []v1.EndpointSubset{{
+			Addresses: []v1.EndpointAddress{{IP: "192.168.66.101"}},
+			Ports:     []v1.EndpointPort{{Name: "https", Port: 6443}},
+		}},
+	}
+
+	getPolicy := func(cli *fakeMaroonedPodsClient, namespace, vmiName string) (*networkingv1.NetworkPolicy, error) {
+		return cli.NetworkingV1().NetworkPolicies(namespace).Get(context.Background(), networkPolicyName(vmiName), metav1.GetOptions{})
+	}
+
+	It("should not create a NetworkPolicy when isolation is disabled", func() {
+		ctrl, cli := newTestController(newConfigWithIsolation(&v1alpha1.NetworkIsolation{Enabled: false}))
+		Expect(ctrl.ensureNetworkPolicy("tenant", "web")).To(Succeed())
+		_, err := getPolicy(cli, "tenant", "web")
+		Expect(errors.IsNotFound(err)).To(BeTrue())
+	})
+
+	It("should restrict the launcher pod egress to the API server, DNS and the overlay", func() {
+		ctrl, cli := newTestController(newConfigWithIsolation(&v1alpha1.NetworkIsolation{Enabled: true}), apiServerEndpoints)
+		Expect(ctrl.ensureNetworkPolicy("tenant", "web")).To(Succeed())
+
+		policy, err := getPolicy(cli, "tenant", "web")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(policy.Spec.PodSelector.MatchLabels).To(Equal(map[string]string{util.MaroonedVMILabel: "web"}))
+		Expect(policy.Spec.PolicyTypes).To(ConsistOf(networkingv1.PolicyTypeEgress))
+		Expect(policy.Spec.Egress).To(HaveLen(3))
+
+		apiServer := policy.Spec.Egress[0]
+		Expect(apiServer.To).To(ConsistOf(networkingv1.NetworkPolicyPeer{IPBlock: &networkingv1.IPBlock{CIDR: "192.168.66.101/32"}}))
+		Expect(apiServer.Ports).To(HaveLen(1))
+		Expect(apiServer.Ports[0].Port.IntValue()).To(Equal(6443))
+
+		dns := policy.Spec.Egress[1]
+		Expect(dns.To[0].PodSelector.MatchLabels).To(HaveKeyWithValue("k8s-app", "kube-dns"))
+		Expect(dns.Ports).To(HaveLen(2))
+
+		overlay := policy.Spec.Egress[2]
+		Expect(overlay.To).To(BeEmpty())
+		Expect(*overlay.Ports[0].Protocol).To(Equal(v1.ProtocolUDP))
+		Expect(overlay.Ports[0].Port.IntValue()).To(Equal(defaultOverlayPort))
+	})
+
+	It("should use the configured CIDRs, ports and additional rules", func() {
+		udp := v1.ProtocolUDP
+		wireguard := intstr.FromInt(51820)
+		ctrl, cli := newTestController(newConfigWithIsolation(&v1alpha1.NetworkIsolation{
+			Enabled:        true,
+			APIServerCIDRs: []string{"10.0.0.1/32"},
+			OverlayCIDRs:   []string{"10.0.0.0/24"},
+			OverlayPorts:   []networkingv1.NetworkPolicyPort{{Protocol: &udp, Port: &wireguard}},
+			AdditionalEgress: []networkingv1.NetworkPolicyEgressRule{{
+				To: []networkingv1.NetworkPolicyPeer{{IPBlock: &networkingv1.IPBlock{CIDR: "10.1.0.0/16"}}},
+			}},
+		}))
+		Expect(ctrl.ensureNetworkPolicy("tenant", "web")).To(Succeed())
+
+		policy, err := getPolicy(cli, "tenant", "web")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(policy.Spec.Egress).To(HaveLen(4))
+		Expect(policy.Spec.Egress[0].To[0].IPBlock.CIDR).To(Equal("10.0.0.1/32"))
+		Expect(policy.Spec.Egress[2].To[0].IPBlock.CIDR).To(Equal("10.0.0.0/24"))
+		Expect(policy.Spec.Egress[2].Ports[0].Port.IntValue()).To(Equal(51820))
+		Expect(policy.Spec.Egress[3].To[0].IPBlock.CIDR).To(Equal("10.1.0.0/16"))
+	})
+
+	It("should be idempotent and removable", func() {
+		ctrl, cli := newTestController(newConfigWithIsolation(&v1alpha1.NetworkIsolation{Enabled: true}), apiServerEndpoints)
+		Expect(ctrl.ensureNetworkPolicy("tenant", "web")).To(Succeed())
+		Expect(ctrl.ensureNetworkPolicy("tenant", "web")).To(Succeed())
+
+		Expect(ctrl.deleteNetworkPolicy("tenant", "web")).To(Succeed())
+		_, err := getPolicy(cli, "tenant", "web")
+		Expect(errors.IsNotFound(err)).To(BeTrue())
+		Expect(ctrl.deleteNetworkPolicy("tenant", "web")).To(Succeed())
+	})
+
+	It("should fail when the API server endpoints are unknown", func() {
+		ctrl, _ := newTestController(newConfigWithIsolation(&v1alpha1.NetworkIsolation{Enabled: true}))
+		Expect(ctrl.ensureNetworkPolicy("tenant", "web")).ToNot(Succeed())
+	})
+})
@@ -0,0 +1,121 @@
+package mp_controller
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"github.com/ghodss/yaml"
+	v1 "k8s.io/api/core/v1"
+	virtv1 "kubevirt.io/api/core/v1"
+	"maroonedpods.io/maroonedpods/pkg/util"
+	v1alpha1 "maroonedpods.io/maroonedpods/staging/src/maroonedpods.io/api/pkg/apis/core/v1alpha1"
+	"net"
+)
+
+const primaryGuestInterface = "eth0"
+
+// networkConfig is the cloud-init network-config (version 2) of the guest
+type networkConfig struct {
+	Version   int                       `json:"version"`
+	Ethernets map[string]ethernetConfig `json:"ethernets"`
+}
+
+type ethernetConfig struct {
+	Match     map[string]string `json:"match"`
+	SetName   string            `json:"set-name"`
+	DHCP4     bool              `json:"dhcp4"`
+	Addresses []string          `json:"addresses,omitempty"`
+}
+
+// getNetworkBinding returns the configured binding of the pod network interface
+func (ctrl *MaroonedPodsGateController) getNetworkBinding() v1alpha1.NetworkBinding {
+	config := ctrl.getConfig()
+	if config == nil || config.Spec.NetworkBinding == "" {
+		return v1alpha1.NetworkBindingMasquerade
+	}
+	return config.Spec.NetworkBinding
+}
+
+// podNetworkInterface returns the pod network interface of a virtual node VMI
+func podNetworkInterface(binding v1alpha1.NetworkBinding) virtv1.Interface {
+	iface := virtv1.Interface{Name: virtv1.DefaultPodNetwork().Name}
+	switch binding {
+	case v1alpha1.NetworkBindingBridge:
+		iface.Bridge = &virtv1.InterfaceBridge{}
+	case v1alpha1.NetworkBindingPasst:
+		iface.Passt = &virtv1.InterfacePasst{}
+	default:
+		iface.Masquerade = &virtv1.InterfaceMasquerade{}
+	}
+	return iface
+}
+
+// podNetworks builds the interfaces and networks of the virtual node VMI of a pod:
+// the pod network with the given binding, plus a bridge on every NetworkAttachmentDefinition
+// listed in the networks annotation. When secondary networks are attached it also returns
+// the cloud-init network-config addressing them in the guest.
+func podNetworks(pod *v1.Pod, binding v1alpha1.NetworkBinding) ([]virtv1.Interface, []virtv1.Network, string, error) {
+	interfaces := []virtv1.Interface{podNetworkInterface(binding)}
+	networks := []virtv1.Network{*virtv1.DefaultPodNetwork()}
+
+	selections, err := util.GetPodNetworks(pod)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	if len(selections) == 0 {
+		return interfaces, networks, "", nil
+	}
+
+	// Guest interfaces are matched by MAC address, their order on the PCI bus is not stable
+	interfaces[0].MacAddress = generateMAC(string(pod.UID), interfaces[0].Name)
+	config := networkConfig{
+		Version: 2,
+		Ethernets: map[string]ethernetConfig{
+			primaryGuestInterface: {
+				Match:   map[string]string{"macaddress": interfaces[0].MacAddress},
+				SetName: primaryGuestInterface,
+				DHCP4:   true,
+			},
+		},
+	}
+
+	for i, selection := range selections {
+		name := fmt.Sprintf("net%d", i+1)
+		mac := selection.MAC
+		if mac == "" {
+			mac = generateMAC(string(pod.UID), name)
+		}
+		interfaces = append(interfaces, virtv1.Interface{
+			Name:       name,
+			MacAddress: mac,
+			InterfaceBindingMethod: virtv1.InterfaceBindingMethod{
+				Bridge: &virtv1.InterfaceBridge{},
+			},
+		})
+		networks = append(networks, virtv1.Network{
+			Name: name,
+			NetworkSource: virtv1.NetworkSource{
+				Multus: &virtv1.MultusNetwork{NetworkName: selection.NetworkName()},
+			},
+		})
+		config.Ethernets[name] = ethernetConfig{
+			Match:     map[string]string{"macaddress": mac},
+			SetName:   name,
+			DHCP4:     len(selection.IPs) == 0,
+			Addresses: selection.IPs,
+		}
+	}
+
+	networkData, err := yaml.Marshal(config)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	return interfaces, networks, string(networkData), nil
+}
+
+// generateMAC derives a stable, locally administered unicast MAC address
+func generateMAC(seed, name string) string {
+	sum := sha256.Sum256([]byte(seed + "/" + name))
+	mac := net.HardwareAddr(sum[:6])
+	mac[0] = (mac[0] | 0x02) & 0xfe
+	return mac.String()
+}
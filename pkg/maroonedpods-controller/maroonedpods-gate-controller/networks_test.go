@@ -0,0 +1,102 @@
+package mp_controller
+
+import (
+	"encoding/base64"
+
+	"github.com/ghodss/yaml"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	virtv1 "kubevirt.io/api/core/v1"
+	"maroonedpods.io/maroonedpods/pkg/util"
+	v1alpha1 "maroonedpods.io/maroonedpods/staging/src/maroonedpods.io/api/pkg/apis/core/v1alpha1"
+)
+
+var _ = Describe("Secondary networks", func() {
+	It("should only attach the pod network without the annotation", func() {
+		interfaces, networks, networkData, err := podNetworks(newPod("web"), v1alpha1.NetworkBindingMasquerade)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(interfaces).To(HaveLen(1))
+		Expect(interfaces[0].Masquerade).ToNot(BeNil())
+		Expect(interfaces[0].MacAddress).To(BeEmpty())
+		Expect(networks).To(ConsistOf(*virtv1.DefaultPodNetwork()))
+		Expect(networkData).To(BeEmpty())
+	})
+
+	It("should attach NetworkAttachmentDefinitions from a list", func() {
+		interfaces, networks, networkData, err := podNetworks(newPod("web", withAnnotation(util.MaroonedPodNetworksAnnotation, "vlan100, tenant/storage")), v1alpha1.NetworkBindingMasquerade)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(interfaces).To(HaveLen(3))
+		Expect(interfaces[1].Name).To(Equal("net1"))
+		Expect(interfaces[1].Bridge).ToNot(BeNil())
+		Expect(networks[1].Multus.NetworkName).To(Equal("vlan100"))
+		Expect(networks[2].Multus.NetworkName).To(Equal("tenant/storage"))
+
+		config := networkConfig{}
+		Expect(yaml.Unmarshal([]byte(networkData), &config)).To(Succeed())
+		Expect(config.Version).To(Equal(2))
+		Expect(config.Ethernets).To(HaveLen(3))
+		for _, iface := range interfaces {
+			Expect(iface.MacAddress).ToNot(BeEmpty())
+		}
+		Expect(config.Ethernets[primaryGuestInterface].Match).To(HaveKeyWithValue("macaddress", interfaces[0].MacAddress))
+		Expect(config.Ethernets["net1"].Match).To(HaveKeyWithValue("macaddress", interfaces[1].MacAddress))
+		Expect(config.Ethernets["net1"].DHCP4).To(BeTrue())
+	})
+
+	It("should pass static addresses and MAC addresses into the guest", func() {
+		interfaces, _, networkData, err := podNetworks(newPod("web", withAnnotation(util.MaroonedPodNetworksAnnotation,
+			`[{"name":"vlan100","ips":["10.100.0.5/24"],"mac":"02:00:00:00:00:05"}]`)), v1alpha1.NetworkBindingMasquerade)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(interfaces[1].MacAddress).To(Equal("02:00:00:00:00:05"))
+
+		config := networkConfig{}
+		Expect(yaml.Unmarshal([]byte(networkData), &config)).To(Succeed())
+		Expect(config.Ethernets["net1"].DHCP4).To(BeFalse())
+		Expect(config.Ethernets["net1"].Addresses).To(ConsistOf("10.100.0.5/24"))
+	})
+
+	It("should generate stable MAC addresses", func() {
+		first, _, _, err := podNetworks(newPod("web", withAnnotation(util.MaroonedPodNetworksAnnotation, "vlan100")), v1alpha1.NetworkBindingMasquerade)
+		Expect(err).ToNot(HaveOccurred())
+		second, _, _, err := podNetworks(newPod("web", withAnnotation(util.MaroonedPodNetworksAnnotation, "vlan100")), v1alpha1.NetworkBindingMasquerade)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(first[1].MacAddress).To(Equal(second[1].MacAddress))
+		Expect(first[0].MacAddress).ToNot(Equal(first[1].MacAddress))
+	})
+
+	DescribeTable("should apply the configured binding to the pod network", func(binding v1alpha1.NetworkBinding, check func(virtv1.Interface)) {
+		interfaces, _, _, err := podNetworks(newPod("web", withAnnotation(util.MaroonedPodNetworksAnnotation, "vlan100")), binding)
+		Expect(err).ToNot(HaveOccurred())
+		check(interfaces[0])
+		Expect(interfaces[1].Bridge).ToNot(BeNil(), "secondary networks always use a bridge")
+	},
+		Entry("masquerade", v1alpha1.NetworkBindingMasquerade, func(iface virtv1.Interface) { Expect(iface.Masquerade).ToNot(BeNil()) }),
+		Entry("bridge", v1alpha1.NetworkBindingBridge, func(iface virtv1.Interface) { Expect(iface.Bridge).ToNot(BeNil()) }),
+		Entry("passt", v1alpha1.NetworkBindingPasst, func(iface virtv1.Interface) { Expect(iface.Passt).ToNot(BeNil()) }),
+		Entry("default", v1alpha1.NetworkBinding(""), func(iface virtv1.Interface) { Expect(iface.Masquerade).ToNot(BeNil()) }),
+	)
+
+	It("should reject invalid annotations", func() {
+		_, _, _, err := podNetworks(newPod("web", withAnnotation(util.MaroonedPodNetworksAnnotation, `[{"name":""}]`)), v1alpha1.NetworkBindingMasquerade)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should put the network-config on the cloud-init disk", func() {
+		ctrl, _ := newTestController(nil)
+		vmi, err := ctrl.createVMIFromPod(newPod("web", withAnnotation(util.MaroonedPodNetworksAnnotation, "vlan100")))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(vmi.Spec.Networks).To(HaveLen(2))
+		Expect(vmi.Spec.Domain.Devices.Interfaces).To(HaveLen(2))
+
+		var networkData string
+		for _, volume := range vmi.Spec.Volumes {
+			if volume.CloudInitNoCloud != nil {
+				decoded, err := base64.StdEncoding.DecodeString(volume.CloudInitNoCloud.NetworkDataBase64)
+				Expect(err).ToNot(HaveOccurred())
+				networkData = string(decoded)
+			}
+		}
+		Expect(networkData).To(ContainSubstring("net1"))
+	})
+})
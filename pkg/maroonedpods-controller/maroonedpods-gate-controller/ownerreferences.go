@@ -0,0 +1,44 @@
+package mp_controller
+
+import (
+	v1 "k8s.io/api/core/v1"
+	k8smetav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	virtv1 "kubevirt.io/api/core/v1"
+	v1alpha1 "maroonedpods.io/maroonedpods/staging/src/maroonedpods.io/api/pkg/apis/core/v1alpha1"
+)
+
+// setPodOwner makes the pod the controller of its VMI, so Kubernetes garbage collects the VMI
+// when the pod is force deleted or its finalizer is bypassed.
+// Owner references can't cross namespaces, so a VMI outside the namespace of the pod is left alone.
+func setPodOwner(vmi *virtv1.VirtualMachineInstance, pod *v1.Pod) {
+	if vmi.Namespace != pod.Namespace {
+		return
+	}
+	vmi.OwnerReferences = []k8smetav1.OwnerReference{controllerReference(
+		v1.SchemeGroupVersion.String(), "Pod", pod.Name, pod.UID)}
+}
+
+// setConfigOwner makes the cluster scoped MaroonedPodsConfig the controller of a pool VMI,
+// so the warm pool is garbage collected with its config
+func setConfigOwner(vmi *virtv1.VirtualMachineInstance, config *v1alpha1.MaroonedPodsConfig) {
+	if config == nil {
+		return
+	}
+	vmi.OwnerReferences = []k8smetav1.OwnerReference{controllerReference(
+		v1alpha1.SchemeGroupVersion.String(), "MaroonedPodsConfig", config.Name, config.UID)}
+}
+
+// controllerReference returns a controller owner reference.
+// BlockOwnerDeletion is left unset: it would need update permission on the finalizers of the owner,
+// and the finalizer of the pod already holds the pod back until its VMI is cleaned up.
+func controllerReference(apiVersion, kind, name string, uid types.UID) k8smetav1.OwnerReference {
+	isController := true
+	return k8smetav1.OwnerReference{
+		APIVersion: apiVersion,
+		Kind:       kind,
+		Name:       name,
+		UID:        uid,
+		Controller: &isController,
+	}
+}
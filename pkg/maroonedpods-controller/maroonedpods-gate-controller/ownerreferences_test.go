@@ -0,0 +1,65 @@
+package mp_controller
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	virtv1 "kubevirt.io/api/core/v1"
+	"maroonedpods.io/maroonedpods/pkg/util"
+	v1alpha1 "maroonedpods.io/maroonedpods/staging/src/maroonedpods.io/api/pkg/apis/core/v1alpha1"
+)
+
+var _ = Describe("Owner references", func() {
+	config := &v1alpha1.MaroonedPodsConfig{ObjectMeta: metav1.ObjectMeta{Name: "default", UID: "config-uid"}}
+
+	It("should make the pod the controller of its VMI", func() {
+		ctrl, _ := newTestController(nil)
+		pod := newPod("web-1")
+		vmi, err := ctrl.createVMIFromPod(pod)
+		Expect(err).ToNot(HaveOccurred())
+
+		owner := metav1.GetControllerOf(vmi)
+		Expect(owner).ToNot(BeNil())
+		Expect(owner.APIVersion).To(Equal("v1"))
+		Expect(owner.Kind).To(Equal("Pod"))
+		Expect(owner.Name).To(Equal(pod.Name))
+		Expect(owner.UID).To(Equal(pod.UID))
+		Expect(owner.BlockOwnerDeletion).To(BeNil())
+	})
+
+	It("should make the config the controller of pool VMIs", func() {
+		ctrl, cli := newTestController(config)
+		vmi, err := ctrl.createPoolVMI(util.DefaultMaroonedPodsNs, "")
+		Expect(err).ToNot(HaveOccurred())
+
+		created, err := cli.KubevirtClient().KubevirtV1().VirtualMachineInstances(util.DefaultMaroonedPodsNs).Get(context.Background(), vmi.Name, metav1.GetOptions{})
+		Expect(err).ToNot(HaveOccurred())
+		owner := metav1.GetControllerOf(created)
+		Expect(owner).ToNot(BeNil())
+		Expect(owner.APIVersion).To(Equal(v1alpha1.SchemeGroupVersion.String()))
+		Expect(owner.Kind).To(Equal("MaroonedPodsConfig"))
+		Expect(owner.UID).To(Equal(config.UID))
+	})
+
+	It("should hand the owner of the VMI over to its VirtualMachine", func() {
+		vmConfig := config.DeepCopy()
+		vmConfig.Spec.RunStrategy = v1alpha1.RunStrategyAlways
+		ctrl, cli := newTestController(vmConfig)
+		vmi, err := ctrl.createPoolVMI(util.DefaultMaroonedPodsNs, "")
+		Expect(err).ToNot(HaveOccurred())
+
+		vm, err := cli.KubevirtClient().KubevirtV1().VirtualMachines(util.DefaultMaroonedPodsNs).Get(context.Background(), vmi.Name, metav1.GetOptions{})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(metav1.GetControllerOf(vm)).To(HaveField("UID", config.UID))
+		Expect(vm.Spec.Template.ObjectMeta.OwnerReferences).To(BeEmpty())
+	})
+
+	It("should not reference a pod in another namespace", func() {
+		vmi := virtv1.NewVMIReferenceFromNameWithNS(util.DefaultMaroonedPodsNs, "web-1")
+		setPodOwner(vmi, newPod("web-1"))
+		Expect(vmi.OwnerReferences).To(BeEmpty())
+	})
+})
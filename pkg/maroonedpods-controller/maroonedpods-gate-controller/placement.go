@@ -0,0 +1,242 @@
+package mp_controller
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	k8smetav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/klog/v2"
+	virtv1 "kubevirt.io/api/core/v1"
+	sdkapi "kubevirt.io/controller-lifecycle-operator-sdk/api"
+	v1alpha1 "maroonedpods.io/maroonedpods/staging/src/maroonedpods.io/api/pkg/apis/core/v1alpha1"
+	"sort"
+)
+
+// spreadLabelPrefix prefixes the VMI labels standing in for the label selectors of the
+// topology spread constraints of pods
+const spreadLabelPrefix = "spread.maroonedpods.io/"
+
+// topologyLabels are the host node labels pod constraints are translated for, and copied
+// to the virtual node so the constraints of the pod still hold on it
+var topologyLabels = []string{v1.LabelTopologyZone, v1.LabelTopologyRegion}
+
+// getWorkloadPlacement returns the Workloads placement of the active MaroonedPods CR
+func (ctrl *MaroonedPodsGateController) getWorkloadPlacement() *sdkapi.NodePlacement {
+	for _, obj := range ctrl.maroonedpodsInformer.GetStore().List() {
+		cr := obj.(*v1alpha1.MaroonedPods)
+		if cr.Status.Phase != sdkapi.PhaseError {
+			return &cr.Spec.Workloads
+		}
+	}
+	return nil
+}
+
+// applyWorkloadPlacement restricts the VMI to the hosts of the Workloads placement of the MaroonedPods CR
+func (ctrl *MaroonedPodsGateController) applyWorkloadPlacement(vmi *virtv1.VirtualMachineInstance) {
+	placement := ctrl.getWorkloadPlacement()
+	if placement == nil {
+		return
+	}
+	for key, value := range placement.NodeSelector {
+		if vmi.Spec.NodeSelector == nil {
+			vmi.Spec.NodeSelector = map[string]string{}
+		}
+		vmi.Spec.NodeSelector[key] = value
+	}
+	if placement.Affinity != nil {
+		vmi.Spec.Affinity = placement.Affinity.DeepCopy()
+	}
+	vmi.Spec.Tolerations = append(vmi.Spec.Tolerations, placement.Tolerations...)
+}
+
+// applyPodPlacement translates the scheduling constraints of the pod that concern the hosts
+// onto its VMI: the priority class, zone and region selectors and node affinity, and the
+// topology spread constraints, so that spread pods end up in VMs on different hosts
+func applyPodPlacement(vmi *virtv1.VirtualMachineInstance, pod *v1.Pod) {
+	vmi.Spec.PriorityClassName = pod.Spec.PriorityClassName
+
+	for _, key := range topologyLabels {
+		if value, ok := pod.Spec.NodeSelector[key]; ok {
+			if vmi.Spec.NodeSelector == nil {
+				vmi.Spec.NodeSelector = map[string]string{}
+			}
+			vmi.Spec.NodeSelector[key] = value
+		}
+	}
+
+	if pod.Spec.Affinity != nil && pod.Spec.Affinity.NodeAffinity != nil {
+		mergeNodeAffinity(vmi, topologyNodeAffinity(pod.Spec.Affinity.NodeAffinity))
+	}
+
+	for _, constraint := range pod.Spec.TopologySpreadConstraints {
+		translated, spreadLabel, ok := translateSpreadConstraint(pod, constraint)
+		if !ok {
+			continue
+		}
+		if vmi.Labels == nil {
+			vmi.Labels = map[string]string{}
+		}
+		vmi.Labels[spreadLabel] = "true"
+		vmi.Spec.TopologySpreadConstraints = append(vmi.Spec.TopologySpreadConstraints, translated)
+	}
+}
+
+// hasHostConstraints checks whether the pod has scheduling constraints that are translated onto its VMI
+func hasHostConstraints(pod *v1.Pod) bool {
+	vmi := &virtv1.VirtualMachineInstance{}
+	applyPodPlacement(vmi, pod)
+	return len(vmi.Spec.NodeSelector) > 0 || vmi.Spec.Affinity != nil || len(vmi.Spec.TopologySpreadConstraints) > 0
+}
+
+// samePodPlacement checks whether the scheduling constraints of the pods are translated onto the same VMI placement
+func samePodPlacement(pod, other *v1.Pod) bool {
+	placement, otherPlacement := &virtv1.VirtualMachineInstance{}, &virtv1.VirtualMachineInstance{}
+	applyPodPlacement(placement, pod)
+	applyPodPlacement(otherPlacement, other)
+	return equality.Semantic.DeepEqual(placement.Spec, otherPlacement.Spec)
+}
+
+// topologyNodeAffinity returns the part of the node affinity that refers to topology labels
+func topologyNodeAffinity(affinity *v1.NodeAffinity) *v1.NodeAffinity {
+	topologyAffinity := &v1.NodeAffinity{}
+
+	if required := affinity.RequiredDuringSchedulingIgnoredDuringExecution; required != nil {
+		var terms []v1.NodeSelectorTerm
+		for _, term := range required.NodeSelectorTerms {
+			expressions := topologyExpressions(term.MatchExpressions)
+			// A term without topology expressions allows every host
+			if len(expressions) == 0 {
+				terms = nil
+				break
+			}
+			terms = append(terms, v1.NodeSelectorTerm{MatchExpressions: expressions})
+		}
+		if len(terms) > 0 {
+			topologyAffinity.RequiredDuringSchedulingIgnoredDuringExecution = &v1.NodeSelector{NodeSelectorTerms: terms}
+		}
+	}
+
+	for _, term := range affinity.PreferredDuringSchedulingIgnoredDuringExecution {
+		if expressions := topologyExpressions(term.Preference.MatchExpressions); len(expressions) > 0 {
+			topologyAffinity.PreferredDuringSchedulingIgnoredDuringExecution = append(topologyAffinity.PreferredDuringSchedulingIgnoredDuringExecution,
+				v1.PreferredSchedulingTerm{Weight: term.Weight, Preference: v1.NodeSelectorTerm{MatchExpressions: expressions}})
+		}
+	}
+	return topologyAffinity
+}
+
+func topologyExpressions(expressions []v1.NodeSelectorRequirement) []v1.NodeSelectorRequirement {
+	var topologyExpressions []v1.NodeSelectorRequirement
+	for _, expression := range expressions {
+		for _, key := range topologyLabels {
+			if expression.Key == key {
+				topologyExpressions = append(topologyExpressions, *expression.DeepCopy())
+			}
+		}
+	}
+	return topologyExpressions
+}
+
+// mergeNodeAffinity adds the node affinity to the VMI, both its own and the added
+// required terms have to be satisfied
+func mergeNodeAffinity(vmi *virtv1.VirtualMachineInstance, affinity *v1.NodeAffinity) {
+	if affinity.RequiredDuringSchedulingIgnoredDuringExecution == nil && len(affinity.PreferredDuringSchedulingIgnoredDuringExecution) == 0 {
+		return
+	}
+	if vmi.Spec.Affinity == nil {
+		vmi.Spec.Affinity = &v1.Affinity{}
+	}
+	if vmi.Spec.Affinity.NodeAffinity == nil {
+		vmi.Spec.Affinity.NodeAffinity = &v1.NodeAffinity{}
+	}
+	nodeAffinity := vmi.Spec.Affinity.NodeAffinity
+
+	if added := affinity.RequiredDuringSchedulingIgnoredDuringExecution; added != nil {
+		if nodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution == nil {
+			nodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution = added
+		} else {
+			// Terms are ORed, so every existing term is combined with every added one
+			var terms []v1.NodeSelectorTerm
+			for _, existing := range nodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms {
+				for _, term := range added.NodeSelectorTerms {
+					combined := existing.DeepCopy()
+					combined.MatchExpressions = append(combined.MatchExpressions, term.MatchExpressions...)
+					terms = append(terms, *combined)
+				}
+			}
+			nodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution = &v1.NodeSelector{NodeSelectorTerms: terms}
+		}
+	}
+	nodeAffinity.PreferredDuringSchedulingIgnoredDuringExecution = append(nodeAffinity.PreferredDuringSchedulingIgnoredDuringExecution,
+		affinity.PreferredDuringSchedulingIgnoredDuringExecution...)
+}
+
+// translateSpreadConstraint turns a topology spread constraint of the pod into one selecting the
+// launcher pods of the VMIs of every pod matched by the constraint. Those pods share the returned label,
+// derived from the label selector and the values of the matchLabelKeys of the pod.
+func translateSpreadConstraint(pod *v1.Pod, constraint v1.TopologySpreadConstraint) (v1.TopologySpreadConstraint, string, bool) {
+	if constraint.LabelSelector == nil {
+		return v1.TopologySpreadConstraint{}, "", false
+	}
+	selector, err := k8smetav1.LabelSelectorAsSelector(constraint.LabelSelector)
+	if err != nil || !selector.Matches(labels.Set(pod.Labels)) {
+		// The pod isn't part of the group it spreads, there is nothing to group its VMI with
+		return v1.TopologySpreadConstraint{}, "", false
+	}
+
+	group := selector.String()
+	matchLabelKeys := append([]string{}, constraint.MatchLabelKeys...)
+	sort.Strings(matchLabelKeys)
+	for _, key := range matchLabelKeys {
+		group += fmt.Sprintf(",%s=%s", key, pod.Labels[key])
+	}
+	spreadLabel := spreadLabelPrefix + fmt.Sprintf("%x", sha256.Sum256([]byte(group)))[:16]
+
+	translated := *constraint.DeepCopy()
+	translated.LabelSelector = &k8smetav1.LabelSelector{MatchLabels: map[string]string{spreadLabel: "true"}}
+	translated.MatchLabelKeys = nil
+	return translated, spreadLabel, true
+}
+
+// syncTopologyLabels copies the zone and region of the host running the VMI to its virtual node
+func (ctrl *MaroonedPodsGateController) syncTopologyLabels(vmi *virtv1.VirtualMachineInstance) error {
+	if vmi.Status.NodeName == "" {
+		return nil
+	}
+	hostObj, hostExists, err := ctrl.nodeInformer.GetStore().GetByKey(vmi.Status.NodeName)
+	if err != nil {
+		return err
+	}
+	nodeObj, nodeExists, err := ctrl.nodeInformer.GetStore().GetByKey(vmi.Name)
+	if err != nil {
+		return err
+	}
+	if !hostExists || !nodeExists {
+		return nil
+	}
+	host := hostObj.(*v1.Node)
+	node := nodeObj.(*v1.Node).DeepCopy()
+
+	changed := false
+	for _, key := range topologyLabels {
+		if value, ok := host.Labels[key]; ok && node.Labels[key] != value {
+			if node.Labels == nil {
+				node.Labels = map[string]string{}
+			}
+			node.Labels[key] = value
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
+	}
+	_, err = ctrl.maroonedpodsCli.CoreV1().Nodes().Update(context.Background(), node, k8smetav1.UpdateOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to update topology labels of node %s: %v", node.Name, err)
+	}
+	klog.V(3).Infof("Copied topology labels of host %s to node %s", host.Name, node.Name)
+	return nil
+}
@@ -0,0 +1,162 @@
+package mp_controller
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	virtv1 "kubevirt.io/api/core/v1"
+	sdkapi "kubevirt.io/controller-lifecycle-operator-sdk/api"
+	v1alpha1 "maroonedpods.io/maroonedpods/staging/src/maroonedpods.io/api/pkg/apis/core/v1alpha1"
+)
+
+func zoneRequirement(zones ...string) v1.NodeSelectorRequirement {
+	return v1.NodeSelectorRequirement{Key: v1.LabelTopologyZone, Operator: v1.NodeSelectorOpIn, Values: zones}
+}
+
+var _ = Describe("VMI placement", func() {
+	hostnameSpread := v1.TopologySpreadConstraint{
+		MaxSkew:           1,
+		TopologyKey:       v1.LabelHostname,
+		WhenUnsatisfiable: v1.DoNotSchedule,
+		LabelSelector:     &metav1.LabelSelector{MatchLabels: map[string]string{"app": "web"}},
+	}
+
+	It("should apply the Workloads placement of the MaroonedPods CR", func() {
+		ctrl, _ := newTestController(nil)
+		Expect(ctrl.maroonedpodsInformer.GetStore().Add(&v1alpha1.MaroonedPods{
+			ObjectMeta: metav1.ObjectMeta{Name: "maroonedpods"},
+			Spec: v1alpha1.MaroonedPodsSpec{Workloads: sdkapi.NodePlacement{
+				NodeSelector: map[string]string{"node-role.kubernetes.io/virt": ""},
+				Tolerations:  []v1.Toleration{{Key: "virt", Operator: v1.TolerationOpExists}},
+			}},
+		})).To(Succeed())
+
+		vmi, err := ctrl.createVMIFromPod(newPod("web-1"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(vmi.Spec.NodeSelector).To(HaveKeyWithValue("node-role.kubernetes.io/virt", ""))
+		Expect(vmi.Spec.Tolerations).To(ContainElement(HaveField("Key", "virt")))
+	})
+
+	It("should copy the priority class and topology node selectors", func() {
+		pod := newPod("web-1")
+		pod.Spec.PriorityClassName = "critical"
+		pod.Spec.NodeSelector = map[string]string{v1.LabelTopologyZone: "zone-a", v1.LabelHostname: "web-1", "disktype": "ssd"}
+
+		vmi := &virtv1.VirtualMachineInstance{}
+		applyPodPlacement(vmi, pod)
+		Expect(vmi.Spec.PriorityClassName).To(Equal("critical"))
+		Expect(vmi.Spec.NodeSelector).To(Equal(map[string]string{v1.LabelTopologyZone: "zone-a"}))
+	})
+
+	It("should translate zone affinity and combine it with the Workloads affinity", func() {
+		pod := newPod("web-1")
+		pod.Spec.Affinity = &v1.Affinity{NodeAffinity: &v1.NodeAffinity{
+			RequiredDuringSchedulingIgnoredDuringExecution: &v1.NodeSelector{NodeSelectorTerms: []v1.NodeSelectorTerm{
+				{MatchExpressions: []v1.NodeSelectorRequirement{zoneRequirement("zone-a"), {Key: "disktype", Operator: v1.NodeSelectorOpExists}}},
+				{MatchExpressions: []v1.NodeSelectorRequirement{zoneRequirement("zone-b")}},
+			}},
+			PreferredDuringSchedulingIgnoredDuringExecution: []v1.PreferredSchedulingTerm{
+				{Weight: 10, Preference: v1.NodeSelectorTerm{MatchExpressions: []v1.NodeSelectorRequirement{zoneRequirement("zone-a")}}},
+				{Weight: 5, Preference: v1.NodeSelectorTerm{MatchExpressions: []v1.NodeSelectorRequirement{{Key: "gpu", Operator: v1.NodeSelectorOpExists}}}},
+			},
+		}}
+		workloadTerm := v1.NodeSelectorTerm{MatchExpressions: []v1.NodeSelectorRequirement{{Key: "virt", Operator: v1.NodeSelectorOpExists}}}
+		vmi := &virtv1.VirtualMachineInstance{}
+		vmi.Spec.Affinity = &v1.Affinity{NodeAffinity: &v1.NodeAffinity{
+			RequiredDuringSchedulingIgnoredDuringExecution: &v1.NodeSelector{NodeSelectorTerms: []v1.NodeSelectorTerm{workloadTerm}},
+		}}
+
+		applyPodPlacement(vmi, pod)
+		nodeAffinity := vmi.Spec.Affinity.NodeAffinity
+		Expect(nodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms).To(ConsistOf(
+			v1.NodeSelectorTerm{MatchExpressions: []v1.NodeSelectorRequirement{workloadTerm.MatchExpressions[0], zoneRequirement("zone-a")}},
+			v1.NodeSelectorTerm{MatchExpressions: []v1.NodeSelectorRequirement{workloadTerm.MatchExpressions[0], zoneRequirement("zone-b")}},
+		))
+		Expect(nodeAffinity.PreferredDuringSchedulingIgnoredDuringExecution).To(ConsistOf(
+			v1.PreferredSchedulingTerm{Weight: 10, Preference: v1.NodeSelectorTerm{MatchExpressions: []v1.NodeSelectorRequirement{zoneRequirement("zone-a")}}},
+		))
+	})
+
+	It("should ignore required affinity with a term allowing every zone", func() {
+		pod := newPod("web-1")
+		pod.Spec.Affinity = &v1.Affinity{NodeAffinity: &v1.NodeAffinity{
+			RequiredDuringSchedulingIgnoredDuringExecution: &v1.NodeSelector{NodeSelectorTerms: []v1.NodeSelectorTerm{
+				{MatchExpressions: []v1.NodeSelectorRequirement{zoneRequirement("zone-a")}},
+				{MatchExpressions: []v1.NodeSelectorRequirement{{Key: "disktype", Operator: v1.NodeSelectorOpExists}}},
+			}},
+		}}
+		vmi := &virtv1.VirtualMachineInstance{}
+		applyPodPlacement(vmi, pod)
+		Expect(vmi.Spec.Affinity).To(BeNil())
+	})
+
+	It("should spread the VMIs of pods spread across hosts", func() {
+		first, second := &virtv1.VirtualMachineInstance{}, &virtv1.VirtualMachineInstance{}
+		for vmi, pod := range map[*virtv1.VirtualMachineInstance]*v1.Pod{first: newPod("web-1"), second: newPod("web-2")} {
+			pod.Spec.TopologySpreadConstraints = []v1.TopologySpreadConstraint{hostnameSpread}
+			applyPodPlacement(vmi, pod)
+		}
+
+		Expect(first.Spec.TopologySpreadConstraints).To(HaveLen(1))
+		constraint := first.Spec.TopologySpreadConstraints[0]
+		Expect(constraint.TopologyKey).To(Equal(v1.LabelHostname))
+		Expect(constraint.MaxSkew).To(Equal(int32(1)))
+		Expect(constraint.LabelSelector.MatchLabels).To(HaveLen(1))
+		for key, value := range constraint.LabelSelector.MatchLabels {
+			Expect(key).To(HavePrefix(spreadLabelPrefix))
+			Expect(first.Labels).To(HaveKeyWithValue(key, value))
+			Expect(second.Labels).To(HaveKeyWithValue(key, value))
+		}
+		Expect(first.Labels).ToNot(HaveKey("app"))
+	})
+
+	It("should group spread VMIs by the matchLabelKeys of the pod", func() {
+		constraint := hostnameSpread
+		constraint.MatchLabelKeys = []string{"pod-template-hash"}
+		oldPod, newPod := newPod("web-1"), newPod("web-2")
+		newPod.Labels["pod-template-hash"] = "def"
+
+		translatedOld, oldLabel, ok := translateSpreadConstraint(oldPod, constraint)
+		Expect(ok).To(BeTrue())
+		Expect(translatedOld.MatchLabelKeys).To(BeEmpty())
+		_, newLabel, ok := translateSpreadConstraint(newPod, constraint)
+		Expect(ok).To(BeTrue())
+		Expect(newLabel).ToNot(Equal(oldLabel))
+	})
+
+	It("should skip constraints that don't select the pod itself", func() {
+		constraint := hostnameSpread
+		constraint.LabelSelector = &metav1.LabelSelector{MatchLabels: map[string]string{"app": "db"}}
+		_, _, ok := translateSpreadConstraint(newPod("web-1"), constraint)
+		Expect(ok).To(BeFalse())
+	})
+
+	It("should not serve pods with host constraints from the warm pool", func() {
+		pod := newPod("web-1")
+		Expect(canClaimPoolVMI(pod)).To(BeTrue())
+		pod.Spec.TopologySpreadConstraints = []v1.TopologySpreadConstraint{hostnameSpread}
+		Expect(canClaimPoolVMI(pod)).To(BeFalse())
+	})
+
+	It("should copy the zone of the host to the virtual node", func() {
+		host := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "host-1", Labels: map[string]string{
+			v1.LabelTopologyZone: "zone-a", v1.LabelTopologyRegion: "region-1", "disktype": "ssd",
+		}}}
+		node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "web-1"}}
+		ctrl, cli := newTestController(nil, host, node)
+		Expect(ctrl.nodeInformer.GetStore().Add(host)).To(Succeed())
+		Expect(ctrl.nodeInformer.GetStore().Add(node)).To(Succeed())
+
+		vmi := &virtv1.VirtualMachineInstance{ObjectMeta: metav1.ObjectMeta{Name: "web-1", Namespace: "tenant"}}
+		vmi.Status.NodeName = "host-1"
+		Expect(ctrl.syncTopologyLabels(vmi)).To(Succeed())
+
+		updated, err := cli.CoreV1().Nodes().Get(context.Background(), "web-1", metav1.GetOptions{})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(updated.Labels).To(Equal(map[string]string{v1.LabelTopologyZone: "zone-a", v1.LabelTopologyRegion: "region-1"}))
+	})
+})
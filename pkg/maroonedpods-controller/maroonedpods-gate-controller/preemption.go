@@ -0,0 +1,193 @@
+package mp_controller
+
+import (
+	"context"
+	"fmt"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	k8smetav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+	virtv1 "kubevirt.io/api/core/v1"
+	"maroonedpods.io/maroonedpods/pkg/util"
+	"sort"
+	"strings"
+)
+
+const (
+	// Reason of the Synchronized condition when KubeVirt fails to create the virt-launcher pod
+	failedCreatePodReason = "FailedCreate"
+	// Admission error of objects that don't fit the ResourceQuota of their namespace
+	exceededQuotaMessage = "exceeded quota"
+)
+
+// podPriority returns the priority resolved from the priorityClassName of the pod
+func podPriority(pod *v1.Pod) int32 {
+	if pod.Spec.Priority != nil {
+		return *pod.Spec.Priority
+	}
+	return 0
+}
+
+// podKeyPriority returns the priority of the pod queued under the key
+func (ctrl *MaroonedPodsGateController) podKeyPriority(key interface{}) int32 {
+	obj, exists, err := ctrl.podInformer.GetStore().GetByKey(key.(string))
+	if err != nil || !exists {
+		return 0
+	}
+	return podPriority(obj.(*v1.Pod))
+}
+
+// hasMaroonedPodsGate checks whether the pod is still held back by the scheduling gate
+func hasMaroonedPodsGate(pod *v1.Pod) bool {
+	return len(pod.Spec.SchedulingGates) == 1 && pod.Spec.SchedulingGates[0].Name == util.MaroonedPodsGate
+}
+
+// isQuotaExceeded checks whether an API error was caused by the ResourceQuota of the namespace
+func isQuotaExceeded(err error) bool {
+	return errors.IsForbidden(err) && strings.Contains(err.Error(), exceededQuotaMessage)
+}
+
+// vmiCapacityShortage returns why KubeVirt can't provision the VMI, or an empty string.
+// namespaced is set when the VMI is held back by the ResourceQuota of its namespace rather than host capacity.
+func vmiCapacityShortage(vmi *virtv1.VirtualMachineInstance) (reason string, namespaced bool) {
+	for _, condition := range vmi.Status.Conditions {
+		if condition.Status != v1.ConditionFalse {
+			continue
+		}
+		if condition.Type == virtv1.VirtualMachineInstanceSynchronized && condition.Reason == failedCreatePodReason &&
+			strings.Contains(condition.Message, exceededQuotaMessage) {
+			return condition.Message, true
+		}
+		if string(condition.Type) == string(v1.PodScheduled) && condition.Reason == v1.PodReasonUnschedulable {
+			return condition.Message, false
+		}
+	}
+	return "", false
+}
+
+// canPreempt checks whether the pod may tear down the VMs of lower priority pods
+func (ctrl *MaroonedPodsGateController) canPreempt(pod *v1.Pod) bool {
+	config := ctrl.getConfig()
+	if config == nil || config.Spec.PreemptionPolicy != v1.PreemptLowerPriority {
+		return false
+	}
+	return pod.Spec.PreemptionPolicy == nil || *pod.Spec.PreemptionPolicy != v1.PreemptNever
+}
+
+// vmiHoldsCapacity checks whether deleting the VMI frees capacity.
+// A virt-launcher pod counts against the quota of its namespace before it is scheduled to a host.
+func vmiHoldsCapacity(vmi *virtv1.VirtualMachineInstance, namespaced bool) bool {
+	switch vmi.Status.Phase {
+	case virtv1.Scheduled, virtv1.Running:
+		return true
+	case virtv1.Scheduling:
+		return namespaced
+	}
+	return false
+}
+
+// preemptFor tears down the VM of the lowest priority marooned pod to make room for the pod.
+// With namespaced set only pods sharing the namespace, and so the ResourceQuota, of the pod are preempted.
+// One pod is preempted at a time: nothing more is preempted while VMIs are still shutting down.
+func (ctrl *MaroonedPodsGateController) preemptFor(pod *v1.Pod, namespaced bool) error {
+	if !ctrl.canPreempt(pod) {
+		return nil
+	}
+
+	var victims []*v1.Pod
+	for _, obj := range ctrl.vmiInformer.GetStore().List() {
+		vmi := obj.(*virtv1.VirtualMachineInstance)
+		if ctrl.isPoolVMI(vmi) || (namespaced && vmi.Namespace != pod.Namespace) {
+			continue
+		}
+		if vmi.DeletionTimestamp != nil {
+			klog.V(2).Infof("VMI %s/%s is shutting down, not preempting for pod %s/%s", vmi.Namespace, vmi.Name, pod.Namespace, pod.Name)
+			return nil
+		}
+		if !vmiHoldsCapacity(vmi, namespaced) {
+			continue
+		}
+		key, err := KeyFunc(vmi)
+		if err != nil {
+			return err
+		}
+		podObj, exists, err := ctrl.podInformer.GetStore().GetByKey(key)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			continue
+		}
+		victim := podObj.(*v1.Pod)
+		if victim.UID == pod.UID || victim.DeletionTimestamp != nil || podPriority(victim) >= podPriority(pod) {
+			continue
+		}
+		// Nothing would recreate a bare pod once it is deleted
+		if k8smetav1.GetControllerOf(victim) == nil {
+			continue
+		}
+		victims = append(victims, victim)
+	}
+	if len(victims) == 0 {
+		klog.V(2).Infof("No lower priority marooned pods to preempt for pod %s/%s", pod.Namespace, pod.Name)
+		return nil
+	}
+
+	// Prefer the lowest priority, then pods that aren't running yet, then the youngest pod
+	sort.Slice(victims, func(i, j int) bool {
+		if podPriority(victims[i]) != podPriority(victims[j]) {
+			return podPriority(victims[i]) < podPriority(victims[j])
+		}
+		if hasMaroonedPodsGate(victims[i]) != hasMaroonedPodsGate(victims[j]) {
+			return hasMaroonedPodsGate(victims[i])
+		}
+		return victims[j].CreationTimestamp.Before(&victims[i].CreationTimestamp)
+	})
+	return ctrl.preempt(victims[0], pod)
+}
+
+// preempt tears down the VM of the victim for the preemptor.
+// A pod that is still gated keeps waiting for a new VM until the preemptor is provisioned;
+// a pod already running on its VM is deleted, so its controller recreates it gated.
+// Scheduling gates can only be removed, a scheduled pod can't be gated again.
+func (ctrl *MaroonedPodsGateController) preempt(victim, preemptor *v1.Pod) error {
+	preemptorKey := fmt.Sprintf("%s/%s", preemptor.Namespace, preemptor.Name)
+	klog.Infof("Preempting marooned pod %s/%s for pod %s", victim.Namespace, victim.Name, preemptorKey)
+
+	if !hasMaroonedPodsGate(victim) {
+		if err := ctrl.maroonedpodsCli.CoreV1().Pods(victim.Namespace).Delete(context.Background(), victim.Name, k8smetav1.DeleteOptions{}); err != nil {
+			return fmt.Errorf("failed to delete preempted pod %s/%s: %v", victim.Namespace, victim.Name, err)
+		}
+	} else {
+		victimCopy := victim.DeepCopy()
+		if victimCopy.Annotations == nil {
+			victimCopy.Annotations = make(map[string]string)
+		}
+		victimCopy.Annotations[util.PreemptedByAnnotation] = preemptorKey
+		if _, err := ctrl.maroonedpodsCli.CoreV1().Pods(victim.Namespace).Update(context.Background(), victimCopy, k8smetav1.UpdateOptions{}); err != nil {
+			return fmt.Errorf("failed to mark pod %s/%s as preempted: %v", victim.Namespace, victim.Name, err)
+		}
+		if err := ctrl.deleteVirtualNode(victim.Namespace, victim.Name); err != nil {
+			return fmt.Errorf("failed to delete VMI of preempted pod %s/%s: %v", victim.Namespace, victim.Name, err)
+		}
+	}
+
+	ctrl.recorder.Eventf(victim, v1.EventTypeWarning, "Preempted", "VM torn down for higher priority pod %s", preemptorKey)
+	ctrl.recorder.Eventf(preemptor, v1.EventTypeNormal, "Preempting", "Tearing down the VM of lower priority pod %s/%s", victim.Namespace, victim.Name)
+	return nil
+}
+
+// preemptorPending checks whether the pod was preempted for a pod that is still waiting for its VM,
+// in which case the pod must not take the capacity back
+func (ctrl *MaroonedPodsGateController) preemptorPending(pod *v1.Pod) bool {
+	key, ok := pod.Annotations[util.PreemptedByAnnotation]
+	if !ok {
+		return false
+	}
+	obj, exists, err := ctrl.podInformer.GetStore().GetByKey(key)
+	if err != nil || !exists {
+		return false
+	}
+	preemptor := obj.(*v1.Pod)
+	return preemptor.DeletionTimestamp == nil && hasMaroonedPodsGate(preemptor)
+}
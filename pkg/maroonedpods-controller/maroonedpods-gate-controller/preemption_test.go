@@ -0,0 +1,146 @@
+package mp_controller
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	virtv1 "kubevirt.io/api/core/v1"
+	"maroonedpods.io/maroonedpods/pkg/util"
+	v1alpha1 "maroonedpods.io/maroonedpods/staging/src/maroonedpods.io/api/pkg/apis/core/v1alpha1"
+)
+
+func newPodVMI(pod *v1.Pod, phase virtv1.VirtualMachineInstancePhase) *virtv1.VirtualMachineInstance {
+	return &virtv1.VirtualMachineInstance{
+		ObjectMeta: metav1.ObjectMeta{Name: pod.Name, Namespace: pod.Namespace},
+		Status:     virtv1.VirtualMachineInstanceStatus{Phase: phase},
+	}
+}
+
+var _ = Describe("Preemption", func() {
+	preemptingConfig := &v1alpha1.MaroonedPodsConfig{
+		ObjectMeta: metav1.ObjectMeta{Name: "default"},
+		Spec:       v1alpha1.MaroonedPodsConfigSpec{PreemptionPolicy: v1.PreemptLowerPriority},
+	}
+
+	// newPreemptionController seeds the caches and clients with the pods and VMIs
+	newPreemptionController := func(config *v1alpha1.MaroonedPodsConfig, pods []*v1.Pod, vmis []*virtv1.VirtualMachineInstance) (*MaroonedPodsGateController, *fakeMaroonedPodsClient) {
+		var objects []runtime.Object
+		for _, pod := range pods {
+			objects = append(objects, pod)
+		}
+		ctrl, cli := newTestController(config, objects...)
+		for _, pod := range pods {
+			Expect(ctrl.podInformer.GetStore().Add(pod)).To(Succeed())
+		}
+		for _, vmi := range vmis {
+			Expect(ctrl.vmiInformer.GetStore().Add(vmi)).To(Succeed())
+			_, err := cli.KubevirtClient().KubevirtV1().VirtualMachineInstances(vmi.Namespace).Create(context.Background(), vmi, metav1.CreateOptions{})
+			Expect(err).ToNot(HaveOccurred())
+		}
+		return ctrl, cli
+	}
+	vmiExists := func(cli *fakeMaroonedPodsClient, namespace, name string) bool {
+		_, err := cli.KubevirtClient().KubevirtV1().VirtualMachineInstances(namespace).Get(context.Background(), name, metav1.GetOptions{})
+		if errors.IsNotFound(err) {
+			return false
+		}
+		Expect(err).ToNot(HaveOccurred())
+		return true
+	}
+
+	It("should detect VMIs held back by quota or host capacity", func() {
+		vmi := newPodVMI(newPod("web", withPriority(0), gated()), virtv1.Pending)
+		reason, _ := vmiCapacityShortage(vmi)
+		Expect(reason).To(BeEmpty())
+
+		vmi.Status.Conditions = []virtv1.VirtualMachineInstanceCondition{{
+			Type: virtv1.VirtualMachineInstanceSynchronized, Status: v1.ConditionFalse, Reason: failedCreatePodReason,
+			Message: `pods "virt-launcher-web" is forbidden: exceeded quota: compute`,
+		}}
+		reason, namespaced := vmiCapacityShortage(vmi)
+		Expect(reason).To(ContainSubstring("exceeded quota"))
+		Expect(namespaced).To(BeTrue())
+
+		vmi.Status.Conditions = []virtv1.VirtualMachineInstanceCondition{{
+			Type: virtv1.VirtualMachineInstanceConditionType(v1.PodScheduled), Status: v1.ConditionFalse, Reason: v1.PodReasonUnschedulable,
+			Message: "0/3 nodes are available: 3 Insufficient memory.",
+		}}
+		reason, namespaced = vmiCapacityShortage(vmi)
+		Expect(reason).To(ContainSubstring("Insufficient memory"))
+		Expect(namespaced).To(BeFalse())
+	})
+
+	It("should tear down the VMI of the lowest priority gated pod and keep it waiting", func() {
+		preemptor := newPod("critical", withPriority(1000), gated())
+		batch := newPod("batch", ownedBy("batch"), withPriority(0), gated())
+		web := newPod("web", ownedBy("web"), withPriority(100), gated())
+		ctrl, cli := newPreemptionController(preemptingConfig,
+			[]*v1.Pod{preemptor, batch, web},
+			[]*virtv1.VirtualMachineInstance{newPodVMI(batch, virtv1.Running), newPodVMI(web, virtv1.Running)})
+
+		Expect(ctrl.preemptFor(preemptor, false)).To(Succeed())
+		Expect(vmiExists(cli, "tenant", "batch")).To(BeFalse())
+		Expect(vmiExists(cli, "tenant", "web")).To(BeTrue())
+
+		updated, err := cli.CoreV1().Pods("tenant").Get(context.Background(), "batch", metav1.GetOptions{})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(updated.Annotations).To(HaveKeyWithValue(util.PreemptedByAnnotation, "tenant/critical"))
+		Expect(ctrl.podInformer.GetStore().Update(updated)).To(Succeed())
+		Expect(ctrl.preemptorPending(updated)).To(BeTrue())
+
+		preemptor.Spec.SchedulingGates = nil
+		Expect(ctrl.podInformer.GetStore().Update(preemptor)).To(Succeed())
+		Expect(ctrl.preemptorPending(updated)).To(BeFalse())
+	})
+
+	It("should delete released pods so their controller recreates them gated", func() {
+		preemptor := newPod("critical", withPriority(1000), gated())
+		batch := newPod("batch", ownedBy("batch"), withPriority(0))
+		ctrl, cli := newPreemptionController(preemptingConfig,
+			[]*v1.Pod{preemptor, batch}, []*virtv1.VirtualMachineInstance{newPodVMI(batch, virtv1.Running)})
+
+		Expect(ctrl.preemptFor(preemptor, false)).To(Succeed())
+		_, err := cli.CoreV1().Pods("tenant").Get(context.Background(), "batch", metav1.GetOptions{})
+		Expect(errors.IsNotFound(err)).To(BeTrue())
+	})
+
+	DescribeTable("should not preempt", func(config *v1alpha1.MaroonedPodsConfig, mutate func(preemptor, victim *v1.Pod, victimVMI *virtv1.VirtualMachineInstance), namespaced bool) {
+		preemptor := newPod("critical", withPriority(1000), gated())
+		victim := newPod("batch", inNamespace("other"), ownedBy("batch"), withPriority(0), gated())
+		victimVMI := newPodVMI(victim, virtv1.Running)
+		if mutate != nil {
+			mutate(preemptor, victim, victimVMI)
+		}
+		ctrl, cli := newPreemptionController(config, []*v1.Pod{preemptor, victim}, []*virtv1.VirtualMachineInstance{victimVMI})
+
+		Expect(ctrl.preemptFor(preemptor, namespaced)).To(Succeed())
+		Expect(vmiExists(cli, "other", "batch")).To(BeTrue())
+	},
+		Entry("without a preemption policy", nil, nil, false),
+		Entry("when the pod never preempts", preemptingConfig, func(preemptor, _ *v1.Pod, _ *virtv1.VirtualMachineInstance) {
+			never := v1.PreemptNever
+			preemptor.Spec.PreemptionPolicy = &never
+		}, false),
+		Entry("pods of equal priority", preemptingConfig, func(_, victim *v1.Pod, _ *virtv1.VirtualMachineInstance) {
+			priority := int32(1000)
+			victim.Spec.Priority = &priority
+		}, false),
+		Entry("pods in other namespaces for quota", preemptingConfig, nil, true),
+		Entry("pods without a controller", preemptingConfig, func(_, victim *v1.Pod, _ *virtv1.VirtualMachineInstance) {
+			victim.OwnerReferences = nil
+		}, false),
+		Entry("VMIs not holding host capacity", preemptingConfig, func(_, _ *v1.Pod, vmi *virtv1.VirtualMachineInstance) {
+			vmi.Status.Phase = virtv1.Scheduling
+		}, false),
+		Entry("while a VMI is shutting down", preemptingConfig, func(_, _ *v1.Pod, vmi *virtv1.VirtualMachineInstance) {
+			now := metav1.Now()
+			vmi.DeletionTimestamp = &now
+		}, false),
+	)
+})
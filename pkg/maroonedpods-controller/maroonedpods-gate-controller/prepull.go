@@ -0,0 +1,108 @@
+package mp_controller
+
+import (
+	"github.com/docker/distribution/reference"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+	virtv1 "kubevirt.io/api/core/v1"
+	"maroonedpods.io/maroonedpods/pkg/util"
+	"strings"
+)
+
+// normalizeImage returns the fully qualified reference of the image, so "nginx" matches "docker.io/library/nginx:latest"
+func normalizeImage(image string) string {
+	named, err := reference.ParseNormalizedNamed(image)
+	if err != nil {
+		return image
+	}
+	return reference.TagNameOnly(named).String()
+}
+
+// splitImages parses a comma separated image list, as recorded in the pre-pull annotations
+func splitImages(images string) []string {
+	var result []string
+	for _, image := range strings.Split(images, ",") {
+		if image = strings.TrimSpace(image); image != "" {
+			result = append(result, image)
+		}
+	}
+	return result
+}
+
+// podImages returns the normalized images of the containers and init containers of the pod
+func podImages(pod *v1.Pod) []string {
+	var images []string
+	for _, containers := range [][]v1.Container{pod.Spec.InitContainers, pod.Spec.Containers} {
+		for _, container := range containers {
+			images = append(images, normalizeImage(container.Image))
+		}
+	}
+	return images
+}
+
+// prePulledImages returns the normalized images the boot script pulled into the node
+func prePulledImages(node *v1.Node) map[string]bool {
+	pulled := map[string]bool{}
+	for _, image := range splitImages(node.Annotations[util.PrePulledImagesAnnotation]) {
+		pulled[normalizeImage(image)] = true
+	}
+	return pulled
+}
+
+// poolImages returns the images pre-pulled into the VMs of the tenant pool, or of the shared warm pool
+func (ctrl *MaroonedPodsGateController) poolImages(pool string) []string {
+	config := ctrl.getConfig()
+	if config == nil {
+		return nil
+	}
+	if pool == "" {
+		return config.Spec.PrePullImages
+	}
+	for _, tenantPool := range config.Spec.TenantPools {
+		if tenantPool.Name == pool {
+			return tenantPool.PrePullImages
+		}
+	}
+	return nil
+}
+
+// prePullFinished checks whether the node of a pool VMI finished pulling the images of its pool.
+// The boot script reports the pulled images on the node once it is done, images that failed to pull are left out.
+func (ctrl *MaroonedPodsGateController) prePullFinished(vmi *virtv1.VirtualMachineInstance) bool {
+	if _, prePull := vmi.Annotations[util.PrePullImagesAnnotation]; !prePull {
+		return true
+	}
+	obj, exists, err := ctrl.nodeInformer.GetStore().GetByKey(vmi.Name)
+	if err != nil || !exists {
+		return false
+	}
+	_, finished := obj.(*v1.Node).Annotations[util.PrePulledImagesAnnotation]
+	return finished
+}
+
+// bestMatchingPoolVMI returns the pool VMI whose node pre-pulled the most images of the pod,
+// the first one when none of them has any
+func (ctrl *MaroonedPodsGateController) bestMatchingPoolVMI(vmis []*virtv1.VirtualMachineInstance, pod *v1.Pod) *virtv1.VirtualMachineInstance {
+	var best *virtv1.VirtualMachineInstance
+	bestMatches := -1
+	images := podImages(pod)
+	for _, vmi := range vmis {
+		matches := 0
+		if obj, exists, err := ctrl.nodeInformer.GetStore().GetByKey(vmi.Name); err == nil && exists {
+			pulled := prePulledImages(obj.(*v1.Node))
+			for _, image := range images {
+				if pulled[image] {
+					matches++
+				}
+			}
+		}
+		if matches > bestMatches {
+			best, bestMatches = vmi, matches
+		}
+	}
+	if best != nil {
+		klog.V(3).Infof("Pool VMI %s/%s pre-pulled %d of %d images of pod %s/%s",
+			best.Namespace, best.Name, bestMatches, len(images), pod.Namespace, pod.Name)
+	}
+	return best
+}
@@ -0,0 +1,127 @@
+package mp_controller
+
+import (
+	"encoding/base64"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	virtv1 "kubevirt.io/api/core/v1"
+	"maroonedpods.io/maroonedpods/pkg/util"
+	v1alpha1 "maroonedpods.io/maroonedpods/staging/src/maroonedpods.io/api/pkg/apis/core/v1alpha1"
+)
+
+var _ = Describe("Image pre-pull", func() {
+	newConfig := func(images ...string) *v1alpha1.MaroonedPodsConfig {
+		return &v1alpha1.MaroonedPodsConfig{
+			ObjectMeta: metav1.ObjectMeta{Name: "config", UID: "config-uid"},
+			Spec: v1alpha1.MaroonedPodsConfigSpec{
+				PrePullImages: images,
+				TenantPools:   []v1alpha1.TenantPool{{Name: "gold", PrePullImages: []string{"registry.example.com/gold/app:v1"}}},
+			},
+		}
+	}
+	newPoolVMI := func(name string, images string) *virtv1.VirtualMachineInstance {
+		vmi := virtv1.NewVMIReferenceFromNameWithNS(util.DefaultMaroonedPodsNs, name)
+		vmi.Labels = map[string]string{util.WarmPoolStateLabel: util.PoolStateCreating, util.MaroonedVMILabel: name}
+		if images != "" {
+			vmi.Annotations = map[string]string{util.PrePullImagesAnnotation: images}
+		}
+		vmi.Status.Phase = virtv1.Running
+		return vmi
+	}
+	newNode := func(name string, pulled *string) *v1.Node {
+		node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: name}}
+		if pulled != nil {
+			node.Annotations = map[string]string{util.PrePulledImagesAnnotation: *pulled}
+		}
+		return node
+	}
+	pulled := func(images string) *string {
+		return &images
+	}
+
+	DescribeTable("should normalize image references", func(image, expected string) {
+		Expect(normalizeImage(image)).To(Equal(expected))
+	},
+		Entry("short name", "nginx", "docker.io/library/nginx:latest"),
+		Entry("tagged short name", "nginx:1.25", "docker.io/library/nginx:1.25"),
+		Entry("registry host", "quay.io/org/app", "quay.io/org/app:latest"),
+		Entry("digest", "quay.io/org/app@sha256:"+"0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef",
+			"quay.io/org/app@sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"),
+		Entry("invalid reference", "Not/A/Valid:Image:", "Not/A/Valid:Image:"),
+	)
+
+	It("should pre-pull the images of the shared pool or the tenant pool", func() {
+		ctrl, _ := newTestController(newConfig("nginx:latest", "redis"))
+		Expect(ctrl.poolImages("")).To(Equal([]string{"nginx:latest", "redis"}))
+		Expect(ctrl.poolImages("gold")).To(Equal([]string{"registry.example.com/gold/app:v1"}))
+		Expect(ctrl.poolImages("removed")).To(BeEmpty())
+	})
+
+	It("should pass the images to the boot script of pool VMIs", func() {
+		ctrl, _ := newTestController(newConfig("nginx:latest", "redis"))
+		vmi, err := ctrl.createPoolVMI(util.DefaultMaroonedPodsNs, "")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(vmi.Annotations).To(HaveKeyWithValue(util.PrePullImagesAnnotation, "nginx:latest,redis"))
+
+		var userData string
+		for _, volume := range vmi.Spec.Volumes {
+			if volume.CloudInitNoCloud != nil {
+				decoded, err := base64.StdEncoding.DecodeString(volume.CloudInitNoCloud.UserDataBase64)
+				Expect(err).ToNot(HaveOccurred())
+				userData = string(decoded)
+			}
+		}
+		Expect(userData).To(ContainSubstring("prepull_images: nginx:latest,redis\n"))
+		Expect(userData).ToNot(ContainSubstring("pod_uid"))
+		Expect(userData).To(ContainSubstring("pool_taint: maroonedpods.io/pool=" + vmi.Name + ":NoExecute\n"))
+
+		ctrl, _ = newTestController(newConfig())
+		vmi, err = ctrl.createPoolVMI(util.DefaultMaroonedPodsNs, "")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(vmi.Annotations).ToNot(HaveKey(util.PrePullImagesAnnotation))
+	})
+
+	It("should keep a pool VMI creating until its node pre-pulled the images", func() {
+		ctrl, _ := newTestController(newConfig("nginx:latest"))
+		vmi := newPoolVMI("maroonedpods-pool-abc", "nginx:latest")
+		Expect(ctrl.nodeInformer.GetStore().Add(newNode(vmi.Name, nil))).To(Succeed())
+		Expect(ctrl.poolVMIState(vmi)).To(Equal(util.PoolStateCreating))
+
+		Expect(ctrl.nodeInformer.GetStore().Update(newNode(vmi.Name, pulled("")))).To(Succeed())
+		Expect(ctrl.poolVMIState(vmi)).To(Equal(util.PoolStateAvailable))
+	})
+
+	It("should not wait for pool VMIs without images to pre-pull", func() {
+		ctrl, _ := newTestController(nil)
+		vmi := newPoolVMI("maroonedpods-pool-abc", "")
+		Expect(ctrl.nodeInformer.GetStore().Add(newNode(vmi.Name, nil))).To(Succeed())
+		Expect(ctrl.poolVMIState(vmi)).To(Equal(util.PoolStateAvailable))
+	})
+
+	It("should claim the available VMI that pre-pulled the most images of the pod", func() {
+		ctrl, _ := newTestController(nil)
+		for name, images := range map[string]string{
+			"maroonedpods-pool-none":  "",
+			"maroonedpods-pool-nginx": "docker.io/library/nginx:latest",
+			"maroonedpods-pool-both":  "nginx:latest,docker.io/library/busybox:latest",
+			"maroonedpods-pool-redis": "redis",
+		} {
+			vmi := newPoolVMI(name, images)
+			vmi.Labels[util.WarmPoolStateLabel] = util.PoolStateAvailable
+			Expect(ctrl.vmiInformer.GetStore().Add(vmi)).To(Succeed())
+			Expect(ctrl.nodeInformer.GetStore().Add(newNode(name, pulled(images)))).To(Succeed())
+		}
+
+		pod := newPod("web-1")
+		pod.Spec.InitContainers = []v1.Container{{Name: "init", Image: "busybox"}}
+		Expect(ctrl.getAvailablePoolVMI(pod, nil).Name).To(Equal("maroonedpods-pool-both"))
+
+		pod.Spec.InitContainers = nil
+		pod.Spec.Containers[0].Image = "docker.io/library/redis"
+		Expect(ctrl.getAvailablePoolVMI(pod, nil).Name).To(Equal("maroonedpods-pool-redis"))
+	})
+})
@@ -0,0 +1,149 @@
+package mp_controller
+
+import (
+	"container/heap"
+	"k8s.io/client-go/util/workqueue"
+	"sync"
+)
+
+// priorityQueue is a workqueue.Interface handing out the keys of higher priority pods first,
+// so VMs are provisioned for critical pods before batch pods when capacity is short.
+// Keys of equal priority are handed out in the order they were added.
+// Like the default workqueue a key is queued at most once and never processed by two workers.
+type priorityQueue struct {
+	priorityFunc func(item interface{}) int32
+	cond         *sync.Cond
+	items        priorityItems
+	sequence     uint64
+	dirty        map[interface{}]struct{}
+	processing   map[interface{}]struct{}
+	shuttingDown bool
+	drain        bool
+}
+
+var _ workqueue.Interface = &priorityQueue{}
+
+func newPriorityQueue(priorityFunc func(item interface{}) int32) *priorityQueue {
+	return &priorityQueue{
+		priorityFunc: priorityFunc,
+		cond:         sync.NewCond(&sync.Mutex{}),
+		dirty:        map[interface{}]struct{}{},
+		processing:   map[interface{}]struct{}{},
+	}
+}
+
+// Add queues the item unless it is queued already.
+// Items added while processed are queued again once Done is called.
+func (q *priorityQueue) Add(item interface{}) {
+	q.cond.L.Lock()
+	defer q.cond.L.Unlock()
+	if q.shuttingDown {
+		return
+	}
+	if _, ok := q.dirty[item]; ok {
+		return
+	}
+	q.dirty[item] = struct{}{}
+	if _, ok := q.processing[item]; ok {
+		return
+	}
+	q.push(item)
+	q.cond.Signal()
+}
+
+func (q *priorityQueue) Len() int {
+	q.cond.L.Lock()
+	defer q.cond.L.Unlock()
+	return q.items.Len()
+}
+
+// Get blocks until it can return the highest priority item, or the queue shuts down
+func (q *priorityQueue) Get() (interface{}, bool) {
+	q.cond.L.Lock()
+	defer q.cond.L.Unlock()
+	for q.items.Len() == 0 && !q.shuttingDown {
+		q.cond.Wait()
+	}
+	if q.items.Len() == 0 {
+		return nil, true
+	}
+	item := heap.Pop(&q.items).(*priorityItem).item
+	q.processing[item] = struct{}{}
+	delete(q.dirty, item)
+	return item, false
+}
+
+// Done marks the item as processed, queueing it again if it was added meanwhile
+func (q *priorityQueue) Done(item interface{}) {
+	q.cond.L.Lock()
+	defer q.cond.L.Unlock()
+	delete(q.processing, item)
+	if _, ok := q.dirty[item]; ok {
+		q.push(item)
+		q.cond.Signal()
+	} else if len(q.processing) == 0 {
+		q.cond.Broadcast()
+	}
+}
+
+func (q *priorityQueue) ShutDown() {
+	q.cond.L.Lock()
+	defer q.cond.L.Unlock()
+	q.drain = false
+	q.shuttingDown = true
+	q.cond.Broadcast()
+}
+
+// ShutDownWithDrain shuts the queue down and waits for the items being processed
+func (q *priorityQueue) ShutDownWithDrain() {
+	q.cond.L.Lock()
+	defer q.cond.L.Unlock()
+	q.drain = true
+	q.shuttingDown = true
+	q.cond.Broadcast()
+	for len(q.processing) > 0 && q.drain {
+		q.cond.Wait()
+	}
+}
+
+func (q *priorityQueue) ShuttingDown() bool {
+	q.cond.L.Lock()
+	defer q.cond.L.Unlock()
+	return q.shuttingDown
+}
+
+// push adds the item to the heap, looking up its priority at the time it is queued
+func (q *priorityQueue) push(item interface{}) {
+	q.sequence++
+	heap.Push(&q.items, &priorityItem{item: item, priority: q.priorityFunc(item), sequence: q.sequence})
+}
+
+type priorityItem struct {
+	item     interface{}
+	priority int32
+	sequence uint64
+}
+
+// priorityItems implements heap.Interface ordered by descending priority, then by insertion
+type priorityItems []*priorityItem
+
+func (p priorityItems) Len() int { return len(p) }
+
+func (p priorityItems) Less(i, j int) bool {
+	if p[i].priority != p[j].priority {
+		return p[i].priority > p[j].priority
+	}
+	return p[i].sequence < p[j].sequence
+}
+
+func (p priorityItems) Swap(i, j int) { p[i], p[j] = p[j], p[i] }
+
+func (p *priorityItems) Push(x interface{}) { *p = append(*p, x.(*priorityItem)) }
+
+func (p *priorityItems) Pop() interface{} {
+	old := *p
+	item := old[len(old)-1]
+	old[len(old)-1] = nil
+	*p = old[:len(old)-1]
+	return item
+}
@@ -0,0 +1,49 @@
+package mp_controller
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Priority queue", func() {
+	priorities := map[string]int32{"batch-1": 0, "batch-2": 0, "web": 1000, "critical": 2000000000}
+	newQueue := func() *priorityQueue {
+		return newPriorityQueue(func(item interface{}) int32 { return priorities[item.(string)] })
+	}
+	get := func(q *priorityQueue) string {
+		item, shutdown := q.Get()
+		Expect(shutdown).To(BeFalse())
+		return item.(string)
+	}
+
+	It("should hand out higher priority keys first, in insertion order otherwise", func() {
+		q := newQueue()
+		for _, key := range []string{"batch-1", "web", "batch-2", "critical"} {
+			q.Add(key)
+		}
+		Expect(q.Len()).To(Equal(4))
+		Expect([]string{get(q), get(q), get(q), get(q)}).To(Equal([]string{"critical", "web", "batch-1", "batch-2"}))
+	})
+
+	It("should queue a key once and requeue it when added while processed", func() {
+		q := newQueue()
+		q.Add("web")
+		q.Add("web")
+		Expect(q.Len()).To(Equal(1))
+
+		Expect(get(q)).To(Equal("web"))
+		q.Add("web")
+		Expect(q.Len()).To(BeZero())
+		q.Done("web")
+		Expect(q.Len()).To(Equal(1))
+	})
+
+	It("should stop handing out keys when shut down", func() {
+		q := newQueue()
+		q.ShutDown()
+		q.Add("web")
+		Expect(q.ShuttingDown()).To(BeTrue())
+		_, shutdown := q.Get()
+		Expect(shutdown).To(BeTrue())
+	})
+})
@@ -0,0 +1,349 @@
+package mp_controller
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	k8smetav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+	virtv1 "kubevirt.io/api/core/v1"
+	"maroonedpods.io/maroonedpods/pkg/util"
+	"reflect"
+	"sigs.k8s.io/yaml"
+	"strings"
+	"time"
+)
+
+const (
+	// VMI filesystem sharing the registries Secret with the guest
+	registriesVolumeName = "marooned-registries"
+	// Serial of the disk passing the registries Secret to the guest of a live migratable VMI
+	registriesDiskSerial = "marooned-registries"
+	// Where the guest mounts the registries Secret, applied by marooned-registries-sync
+	guestRegistriesDir = "/var/lib/marooned/registries"
+	// Keys of the registries Secret
+	registriesKey       = "registries.yaml"
+	registryMirrorCAKey = "registry-mirror-ca.crt"
+	// How often the registries Secrets follow changes of the pull secrets
+	registriesRotationPeriod = time.Minute
+)
+
+// dockerConfigEntry holds the credentials of a registry in a dockercfg or dockerconfigjson Secret
+type dockerConfigEntry struct {
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+	Auth     string `json:"auth,omitempty"`
+}
+
+type dockerConfigJSON struct {
+	Auths map[string]dockerConfigEntry `json:"auths"`
+}
+
+// registriesSecretName returns the name of the Secret holding the registries configuration of the given VMI
+func registriesSecretName(vmiName string) string {
+	return util.RegistriesSecretNamePrefix + vmiName
+}
+
+// normalizeRegistryHost returns the registry host of a docker config key, which may be a URL.
+// Docker Hub is known to k3s as docker.io.
+func normalizeRegistryHost(key string) string {
+	host := strings.TrimPrefix(strings.TrimPrefix(key, "https://"), "http://")
+	host = strings.SplitN(host, "/", 2)[0]
+	switch host {
+	case "index.docker.io", "registry-1.docker.io":
+		return util.DefaultRegistryMirrorRegistry
+	}
+	return host
+}
+
+// dockerConfigAuths returns the registry credentials of a kubernetes.io/dockerconfigjson or
+// kubernetes.io/dockercfg Secret, by registry host
+func dockerConfigAuths(secret *v1.Secret) (map[string]k3sRegistryAuth, error) {
+	var entries map[string]dockerConfigEntry
+	switch secret.Type {
+	case v1.SecretTypeDockerConfigJson:
+		var config dockerConfigJSON
+		if err := json.Unmarshal(secret.Data[v1.DockerConfigJsonKey], &config); err != nil {
+			return nil, fmt.Errorf("failed to parse pull secret %s/%s: %v", secret.Namespace, secret.Name, err)
+		}
+		entries = config.Auths
+	case v1.SecretTypeDockercfg:
+		if err := json.Unmarshal(secret.Data[v1.DockerConfigKey], &entries); err != nil {
+			return nil, fmt.Errorf("failed to parse pull secret %s/%s: %v", secret.Namespace, secret.Name, err)
+		}
+	default:
+		return nil, fmt.Errorf("pull secret %s/%s has unsupported type %s", secret.Namespace, secret.Name, secret.Type)
+	}
+
+	auths := map[string]k3sRegistryAuth{}
+	for key, entry := range entries {
+		username, password := entry.Username, entry.Password
+		if username == "" && entry.Auth != "" {
+			decoded, err := base64.StdEncoding.DecodeString(entry.Auth)
+			if err != nil {
+				return nil, fmt.Errorf("failed to decode the auth of %s in pull secret %s/%s: %v", key, secret.Namespace, secret.Name, err)
+			}
+			username, password, _ = strings.Cut(string(decoded), ":")
+		}
+		if username == "" {
+			continue
+		}
+		auths[normalizeRegistryHost(key)] = k3sRegistryAuth{Username: username, Password: password}
+	}
+	return auths, nil
+}
+
+// podPullSecrets returns the pull secrets of the pod followed by the ones of its service account,
+// the way the kubelet picks them up
+func (ctrl *MaroonedPodsGateController) podPullSecrets(pod *v1.Pod) ([]string, error) {
+	var names []string
+	for _, ref := range pod.Spec.ImagePullSecrets {
+		names = append(names, ref.Name)
+	}
+
+	serviceAccountName := pod.Spec.ServiceAccountName
+	if serviceAccountName == "" {
+		serviceAccountName = "default"
+	}
+	serviceAccount, err := ctrl.maroonedpodsCli.CoreV1().ServiceAccounts(pod.Namespace).Get(context.Background(), serviceAccountName, k8smetav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		klog.V(3).Infof("Service account %s/%s of pod %s not found, using the pull secrets of the pod", pod.Namespace, serviceAccountName, pod.Name)
+		return names, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get service account %s/%s: %v", pod.Namespace, serviceAccountName, err)
+	}
+	for _, ref := range serviceAccount.ImagePullSecrets {
+		names = append(names, ref.Name)
+	}
+	return names, nil
+}
+
+// registriesSecretData returns the k3s registries.yaml of a guest running the given pods: the credentials of
+// their pull secrets plus the registry mirror and its CA bundle. The first pull secret of a registry wins.
+func (ctrl *MaroonedPodsGateController) registriesSecretData(pods []*v1.Pod) (map[string][]byte, error) {
+	registries := newK3sRegistries()
+	ca, err := ctrl.addRegistryMirror(registries)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := map[string]bool{}
+	for _, pod := range pods {
+		names, err := ctrl.podPullSecrets(pod)
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range names {
+			key := pod.Namespace + "/" + name
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+
+			secret, err := ctrl.maroonedpodsCli.CoreV1().Secrets(pod.Namespace).Get(context.Background(), name, k8smetav1.GetOptions{})
+			if errors.IsNotFound(err) {
+				// Like the kubelet, pull without the missing secret
+				klog.Warningf("Pull secret %s of pod %s/%s not found", name, pod.Namespace, pod.Name)
+				continue
+			}
+			if err != nil {
+				return nil, fmt.Errorf("failed to get pull secret %s: %v", key, err)
+			}
+			auths, err := dockerConfigAuths(secret)
+			if err != nil {
+				klog.Warningf("Skipping pull secret of pod %s/%s: %v", pod.Namespace, pod.Name, err)
+				continue
+			}
+			for host, auth := range auths {
+				if _, exists := registries.Configs[host]; exists {
+					continue
+				}
+				auth := auth
+				registries.Configs[host] = k3sRegistryConfig{Auth: &auth}
+			}
+		}
+	}
+
+	content, err := yaml.Marshal(registries)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate registries.yaml: %v", err)
+	}
+	data := map[string][]byte{registriesKey: content}
+	if ca != "" {
+		data[registryMirrorCAKey] = []byte(ca)
+	}
+	return data, nil
+}
+
+// registriesPods returns the pods running on the guest of a VMI: the members of its island sharing it,
+// the pod claiming a pool VMI or the pod of the VMI
+func (ctrl *MaroonedPodsGateController) registriesPods(vmi *virtv1.VirtualMachineInstance) []*v1.Pod {
+	if island := vmi.Labels[util.IslandLabel]; island != "" {
+		var owners []*v1.Pod
+		for _, member := range ctrl.islandMembers(vmi.Namespace, island) {
+			if hasOwner(vmi.OwnerReferences, member) {
+				owners = append(owners, member)
+			}
+		}
+		return owners
+	}
+	key := fmt.Sprintf("%s/%s", vmi.Namespace, vmi.Name)
+	if ctrl.isPoolVMI(vmi) {
+		if key = claimingPodKey(vmi); key == "" {
+			return nil
+		}
+	}
+	obj, exists, err := ctrl.podInformer.GetStore().GetByKey(key)
+	if err != nil || !exists {
+		return nil
+	}
+	pod := obj.(*v1.Pod)
+	if pod.DeletionTimestamp != nil || isPodTerminal(pod) {
+		return nil
+	}
+	return []*v1.Pod{pod}
+}
+
+// ensureRegistriesSecret creates the registries Secret of a VMI, or updates it when the pull secrets
+// of its pods changed. The Secret is owned by the owners of the VMI when created.
+// Pool VMIs without a pod keep a Secret with the registry mirror only.
+func (ctrl *MaroonedPodsGateController) ensureRegistriesSecret(vmi *virtv1.VirtualMachineInstance) error {
+	pods := ctrl.registriesPods(vmi)
+	if len(pods) == 0 && !ctrl.isPoolVMI(vmi) {
+		return nil
+	}
+	data, err := ctrl.registriesSecretData(pods)
+	if err != nil {
+		return err
+	}
+
+	name := registriesSecretName(vmi.Name)
+	secrets := ctrl.maroonedpodsCli.CoreV1().Secrets(vmi.Namespace)
+	secret, err := secrets.Get(context.Background(), name, k8smetav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		secret = &v1.Secret{
+			ObjectMeta: k8smetav1.ObjectMeta{
+				Name:            name,
+				Namespace:       vmi.Namespace,
+				Labels:          map[string]string{util.MaroonedVMILabel: vmi.Name},
+				OwnerReferences: vmi.OwnerReferences,
+			},
+			Type: v1.SecretTypeOpaque,
+			Data: data,
+		}
+		_, err = secrets.Create(context.Background(), secret, k8smetav1.CreateOptions{})
+		if err != nil && !errors.IsAlreadyExists(err) {
+			return fmt.Errorf("failed to create Secret %s/%s: %v", vmi.Namespace, name, err)
+		}
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get Secret %s/%s: %v", vmi.Namespace, name, err)
+	}
+	if reflect.DeepEqual(secret.Data, data) {
+		return nil
+	}
+
+	secret = secret.DeepCopy()
+	secret.Data = data
+	if _, err := secrets.Update(context.Background(), secret, k8smetav1.UpdateOptions{}); err != nil {
+		return fmt.Errorf("failed to update Secret %s/%s: %v", vmi.Namespace, name, err)
+	}
+	klog.Infof("Rotated the registries of VMI %s/%s", vmi.Namespace, vmi.Name)
+	return nil
+}
+
+// deleteRegistriesSecret removes the registries Secret of a VMI, if any
+func (ctrl *MaroonedPodsGateController) deleteRegistriesSecret(namespace, vmiName string) error {
+	err := ctrl.maroonedpodsCli.CoreV1().Secrets(namespace).Delete(context.Background(), registriesSecretName(vmiName), k8smetav1.DeleteOptions{})
+	if err != nil && !errors.IsNotFound(err) {
+		return fmt.Errorf("failed to delete Secret %s/%s: %v", namespace, registriesSecretName(vmiName), err)
+	}
+	return nil
+}
+
+// rotateRegistriesSecrets keeps the registries Secrets of the running VMIs in sync with the pull secrets
+// of their pods. Guests sharing the Secret with virtiofs restart their k3s agent to apply it, guests
+// reading it from a disk apply it the next time the VM boots.
+func (ctrl *MaroonedPodsGateController) rotateRegistriesSecrets() {
+	for _, obj := range ctrl.vmiInformer.GetStore().List() {
+		vmi := obj.(*virtv1.VirtualMachineInstance)
+		if vmi.DeletionTimestamp != nil || !hasRegistriesVolume(vmi) {
+			continue
+		}
+		if err := ctrl.ensureRegistriesSecret(vmi); err != nil {
+			klog.Errorf("Failed to rotate the registries of VMI %s/%s: %v", vmi.Namespace, vmi.Name, err)
+		}
+	}
+}
+
+// hasRegistriesVolume reports whether the guest of the VMI reads its registries from a registries Secret
+func hasRegistriesVolume(vmi *virtv1.VirtualMachineInstance) bool {
+	for _, volume := range vmi.Spec.Volumes {
+		if volume.Name == registriesVolumeName {
+			return true
+		}
+	}
+	return false
+}
+
+// followsRegistriesUpdates reports whether the guest of the VMI applies updates of its registries Secret,
+// which it only does when the Secret is shared with virtiofs
+func followsRegistriesUpdates(vmi *virtv1.VirtualMachineInstance) bool {
+	for _, filesystem := range vmi.Spec.Domain.Devices.Filesystems {
+		if filesystem.Name == registriesVolumeName {
+			return true
+		}
+	}
+	return false
+}
+
+// registriesVolume returns the device and volume passing the registries Secret of a VMI to its guest,
+// along with the cloud-init commands applying it before k3s starts. A Secret shared with virtiofs is
+// kept applied by a timer as KubeVirt propagates its updates. virtiofs can't be live migrated, so the
+// guest of a live migratable VMI reads the Secret from a disk instead, applied when the guest boots.
+func registriesVolume(vmiName string, shared bool) ([]virtv1.Disk, []virtv1.Filesystem, virtv1.Volume, string) {
+	volume := virtv1.Volume{
+		Name: registriesVolumeName,
+		VolumeSource: virtv1.VolumeSource{
+			Secret: &virtv1.SecretVolumeSource{
+				SecretName: registriesSecretName(vmiName),
+			},
+		},
+	}
+
+	if !shared {
+		disk := virtv1.Disk{
+			Name:   registriesVolumeName,
+			Serial: registriesDiskSerial,
+			DiskDevice: virtv1.DiskDevice{
+				Disk: &virtv1.DiskTarget{Bus: virtv1.DiskBusVirtio}},
+		}
+		script := fmt.Sprintf(`
+# Configure the registries of k3s from the registries Secret
+mkdir -p %s
+echo '/dev/disk/by-id/virtio-%s %s iso9660 ro,nofail 0 0' >> /etc/fstab
+mount %s
+/usr/local/bin/marooned-registries-sync.sh
+`, guestRegistriesDir, registriesDiskSerial, guestRegistriesDir, guestRegistriesDir)
+		return []virtv1.Disk{disk}, nil, volume, script
+	}
+
+	filesystem := virtv1.Filesystem{
+		Name:     registriesVolumeName,
+		Virtiofs: &virtv1.FilesystemVirtiofs{},
+	}
+	script := fmt.Sprintf(`
+# Configure the registries of k3s from the registries Secret, following its updates
+mkdir -p %s
+echo '%s %s virtiofs defaults,nofail 0 0' >> /etc/fstab
+mount %s
+/usr/local/bin/marooned-registries-sync.sh
+systemctl enable --now marooned-registries-sync.timer
+`, guestRegistriesDir, registriesVolumeName, guestRegistriesDir, guestRegistriesDir)
+	return nil, []virtv1.Filesystem{filesystem}, volume, script
+}
@@ -0,0 +1,257 @@
+package mp_controller
+
+import (
+	"context"
+	"encoding/base64"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	virtv1 "kubevirt.io/api/core/v1"
+	"maroonedpods.io/maroonedpods/pkg/util"
+	v1alpha1 "maroonedpods.io/maroonedpods/staging/src/maroonedpods.io/api/pkg/apis/core/v1alpha1"
+)
+
+var _ = Describe("Image pull secrets", func() {
+	newPullSecret := func(name, config string) *v1.Secret {
+		return &v1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "tenant"},
+			Type:       v1.SecretTypeDockerConfigJson,
+			Data:       map[string][]byte{v1.DockerConfigJsonKey: []byte(config)},
+		}
+	}
+	newServiceAccount := func(name string, pullSecrets ...string) *v1.ServiceAccount {
+		serviceAccount := &v1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "tenant"}}
+		for _, pullSecret := range pullSecrets {
+			serviceAccount.ImagePullSecrets = append(serviceAccount.ImagePullSecrets, v1.LocalObjectReference{Name: pullSecret})
+		}
+		return serviceAccount
+	}
+	newPod := func(pullSecrets ...string) *v1.Pod {
+		pod := newPod("web")
+		for _, pullSecret := range pullSecrets {
+			pod.Spec.ImagePullSecrets = append(pod.Spec.ImagePullSecrets, v1.LocalObjectReference{Name: pullSecret})
+		}
+		return pod
+	}
+	newVMI := func(pod *v1.Pod) *virtv1.VirtualMachineInstance {
+		vmi := virtv1.NewVMIReferenceFromNameWithNS(pod.Namespace, pod.Name)
+		vmi.Labels = map[string]string{util.MaroonedVMILabel: pod.Name}
+		setPodOwner(vmi, pod)
+		_, _, volume, _ := registriesVolume(pod.Name, false)
+		vmi.Spec.Volumes = []virtv1.Volume{volume}
+		return vmi
+	}
+	registries := func(cli *fakeMaroonedPodsClient, vmiName string) string {
+		secret, err := cli.CoreV1().Secrets("tenant").Get(context.Background(), registriesSecretName(vmiName), metav1.GetOptions{})
+		Expect(err).ToNot(HaveOccurred())
+		return string(secret.Data[registriesKey])
+	}
+	userData := func(vmi *virtv1.VirtualMachineInstance) string {
+		for _, volume := range vmi.Spec.Volumes {
+			if volume.CloudInitNoCloud != nil {
+				decoded, err := base64.StdEncoding.DecodeString(volume.CloudInitNoCloud.UserDataBase64)
+				Expect(err).ToNot(HaveOccurred())
+				return string(decoded)
+			}
+		}
+		return ""
+	}
+
+	DescribeTable("should normalize registry hosts", func(key, expected string) {
+		Expect(normalizeRegistryHost(key)).To(Equal(expected))
+	},
+		Entry("host", "quay.io", "quay.io"),
+		Entry("host and port", "registry.example.com:5000", "registry.example.com:5000"),
+		Entry("URL", "https://registry.example.com/v2/", "registry.example.com"),
+		Entry("legacy Docker Hub URL", "https://index.docker.io/v1/", "docker.io"),
+		Entry("Docker Hub registry", "registry-1.docker.io", "docker.io"),
+	)
+
+	It("should read the credentials of dockerconfigjson and dockercfg Secrets", func() {
+		auth := base64.StdEncoding.EncodeToString([]byte("robot:pa:ss"))
+		auths, err := dockerConfigAuths(newPullSecret("quay", `{"auths":{
+			"quay.io":{"username":"user","password":"secret"},
+			"https://index.docker.io/v1/":{"auth":"`+auth+`"},
+			"empty.example.com":{}}}`))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(auths).To(Equal(map[string]k3sRegistryAuth{
+			"quay.io":   {Username: "user", Password: "secret"},
+			"docker.io": {Username: "robot", Password: "pa:ss"},
+		}))
+
+		auths, err = dockerConfigAuths(&v1.Secret{
+			Type: v1.SecretTypeDockercfg,
+			Data: map[string][]byte{v1.DockerConfigKey: []byte(`{"ghcr.io":{"username":"octo","password":"cat"}}`)},
+		})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(auths).To(HaveKeyWithValue("ghcr.io", k3sRegistryAuth{Username: "octo", Password: "cat"}))
+
+		_, err = dockerConfigAuths(&v1.Secret{Type: v1.SecretTypeOpaque})
+		Expect(err).To(MatchError(ContainSubstring("unsupported type")))
+	})
+
+	It("should merge the pull secrets of the pod and its service account", func() {
+		ctrl, _ := newTestController(nil,
+			newPullSecret("pod-quay", `{"auths":{"quay.io":{"username":"pod","password":"a"}}}`),
+			newPullSecret("sa-quay", `{"auths":{"quay.io":{"username":"sa","password":"b"},"ghcr.io":{"username":"sa","password":"c"}}}`),
+			newServiceAccount("builder", "sa-quay", "missing"),
+		)
+		pod := newPod("pod-quay")
+		pod.Spec.ServiceAccountName = "builder"
+
+		data, err := ctrl.registriesSecretData([]*v1.Pod{pod})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(data[registriesKey])).To(Equal(`configs:
+  ghcr.io:
+    auth:
+      password: c
+      username: sa
+  quay.io:
+    auth:
+      password: a
+      username: pod
+`))
+		Expect(data).ToNot(HaveKey(registryMirrorCAKey))
+	})
+
+	It("should use the default service account and tolerate it missing", func() {
+		ctrl, _ := newTestController(nil,
+			newPullSecret("default-quay", `{"auths":{"quay.io":{"username":"default","password":"a"}}}`),
+			newServiceAccount("default", "default-quay"),
+		)
+		data, err := ctrl.registriesSecretData([]*v1.Pod{newPod()})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(data[registriesKey])).To(ContainSubstring("username: default"))
+
+		ctrl, _ = newTestController(nil)
+		data, err = ctrl.registriesSecretData([]*v1.Pod{newPod("missing")})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(data[registriesKey])).To(Equal("{}\n"))
+	})
+
+	It("should add the registry mirror and its CA bundle", func() {
+		ctrl, _ := newTestController(nil,
+			&v1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{Name: util.RegistryMirrorCABundleName, Namespace: util.DefaultMaroonedPodsNs},
+				Data:       map[string]string{caBundleKey: "-----BEGIN CERTIFICATE-----\nMIIC\n-----END CERTIFICATE-----"},
+			},
+			newPullSecret("docker", `{"auths":{"docker.io":{"username":"user","password":"a"}}}`),
+		)
+		Expect(ctrl.maroonedpodsInformer.GetStore().Add(&v1alpha1.MaroonedPods{
+			ObjectMeta: metav1.ObjectMeta{Name: "maroonedpods"},
+			Spec:       v1alpha1.MaroonedPodsSpec{RegistryMirror: &v1alpha1.RegistryMirror{}},
+		})).To(Succeed())
+
+		data, err := ctrl.registriesSecretData([]*v1.Pod{newPod("docker")})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(data[registryMirrorCAKey])).To(Equal("-----BEGIN CERTIFICATE-----\nMIIC\n-----END CERTIFICATE-----\n"))
+		Expect(string(data[registriesKey])).To(ContainSubstring("  docker.io:\n    auth:\n"))
+		Expect(string(data[registriesKey])).To(ContainSubstring("    tls:\n      ca_file: " + registryMirrorCAPath + "\n"))
+		Expect(string(data[registriesKey])).To(ContainSubstring("mirrors:\n  docker.io:\n"))
+	})
+
+	It("should create, rotate and delete the registries Secret of a VMI", func() {
+		ctrl, cli := newTestController(nil, newPullSecret("quay", `{"auths":{"quay.io":{"username":"user","password":"a"}}}`))
+		pod := newPod("quay")
+		Expect(ctrl.podInformer.GetStore().Add(pod)).To(Succeed())
+		vmi := newVMI(pod)
+
+		Expect(ctrl.ensureRegistriesSecret(vmi)).To(Succeed())
+		secret, err := cli.CoreV1().Secrets("tenant").Get(context.Background(), registriesSecretName("web"), metav1.GetOptions{})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(secret.Labels).To(HaveKeyWithValue(util.MaroonedVMILabel, "web"))
+		Expect(secret.OwnerReferences).To(Equal(vmi.OwnerReferences))
+		Expect(registries(cli, "web")).To(ContainSubstring("password: a"))
+
+		_, err = cli.CoreV1().Secrets("tenant").Update(context.Background(),
+			newPullSecret("quay", `{"auths":{"quay.io":{"username":"user","password":"b"}}}`), metav1.UpdateOptions{})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(ctrl.vmiInformer.GetStore().Add(vmi)).To(Succeed())
+		ctrl.rotateRegistriesSecrets()
+		Expect(registries(cli, "web")).To(ContainSubstring("password: b"))
+
+		Expect(ctrl.deleteRegistriesSecret("tenant", "web")).To(Succeed())
+		Expect(ctrl.deleteRegistriesSecret("tenant", "web")).To(Succeed())
+	})
+
+	It("should leave VMIs without a registries volume and deleted pods alone", func() {
+		ctrl, cli := newTestController(nil)
+		pod := newPod()
+		Expect(ctrl.podInformer.GetStore().Add(pod)).To(Succeed())
+		poolVMI := virtv1.NewVMIReferenceFromNameWithNS("tenant", "web")
+		Expect(ctrl.vmiInformer.GetStore().Add(poolVMI)).To(Succeed())
+		ctrl.rotateRegistriesSecrets()
+
+		Expect(ctrl.podInformer.GetStore().Delete(pod)).To(Succeed())
+		Expect(ctrl.ensureRegistriesSecret(newVMI(pod))).To(Succeed())
+
+		secrets, err := cli.CoreV1().Secrets("tenant").List(context.Background(), metav1.ListOptions{})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(secrets.Items).To(BeEmpty())
+	})
+
+	It("should pass the pull secrets of the pod claiming a pool VMI to its guest", func() {
+		ctrl, cli := newTestController(&v1alpha1.MaroonedPodsConfig{
+			ObjectMeta: metav1.ObjectMeta{Name: "config"},
+			Spec:       v1alpha1.MaroonedPodsConfigSpec{EvictionStrategy: v1alpha1.EvictionStrategyNone},
+		}, newPullSecret("quay", `{"auths":{"quay.io":{"username":"user","password":"a"}}}`))
+		vmi, err := ctrl.createPoolVMI("tenant", "gold")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(followsRegistriesUpdates(vmi)).To(BeTrue())
+		Expect(registries(cli, vmi.Name)).ToNot(ContainSubstring("quay.io"))
+
+		pod := newPod("quay")
+		Expect(ctrl.podInformer.GetStore().Add(pod)).To(Succeed())
+		node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: vmi.Name}}
+		Expect(ctrl.nodeInformer.GetStore().Add(node)).To(Succeed())
+		_, err = cli.CoreV1().Nodes().Create(context.Background(), node, metav1.CreateOptions{})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(ctrl.claimPoolVMI(vmi, pod)).To(Succeed())
+		Expect(registries(cli, vmi.Name)).To(ContainSubstring("password: a"))
+
+		vmi, err = cli.KubevirtClient().KubevirtV1().VirtualMachineInstances("tenant").Get(context.Background(), vmi.Name, metav1.GetOptions{})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(ctrl.returnVMIToPool(vmi, pod.Name)).To(Succeed())
+		Expect(registries(cli, vmi.Name)).ToNot(ContainSubstring("quay.io"))
+	})
+
+	It("should not hand pool VMIs reading their registries from a disk to pods with pull secrets", func() {
+		ctrl, _ := newTestController(nil)
+		vmi, err := ctrl.createPoolVMI(util.DefaultMaroonedPodsNs, "")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(followsRegistriesUpdates(vmi)).To(BeFalse())
+		vmi.Labels[util.WarmPoolStateLabel] = util.PoolStateAvailable
+		vmi.Status.Phase = virtv1.Running
+		Expect(ctrl.vmiInformer.GetStore().Add(vmi)).To(Succeed())
+
+		Expect(ctrl.getAvailablePoolVMI(newPod(), nil)).ToNot(BeNil())
+		Expect(ctrl.getAvailablePoolVMI(newPod("quay"), nil)).To(BeNil())
+	})
+
+	It("should attach the registries Secret as a disk to live migratable VMIs", func() {
+		ctrl, _ := newTestController(nil)
+		vmi, err := ctrl.createVMIFromPod(newPod())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(*vmi.Spec.EvictionStrategy).To(Equal(virtv1.EvictionStrategyLiveMigrate))
+		Expect(vmi.Spec.Domain.Devices.Filesystems).To(BeEmpty())
+		Expect(vmi.Spec.Domain.Devices.Disks).To(ContainElement(HaveField("Serial", registriesDiskSerial)))
+		Expect(vmi.Spec.Volumes).To(ContainElement(HaveField("VolumeSource.Secret.SecretName", registriesSecretName("web"))))
+		Expect(userData(vmi)).To(ContainSubstring("/dev/disk/by-id/virtio-marooned-registries " + guestRegistriesDir + " iso9660"))
+		Expect(userData(vmi)).ToNot(ContainSubstring("marooned-registries-sync.timer"))
+	})
+
+	It("should share the registries Secret with virtiofs when the VMI can't live migrate", func() {
+		ctrl, _ := newTestController(&v1alpha1.MaroonedPodsConfig{
+			ObjectMeta: metav1.ObjectMeta{Name: "config"},
+			Spec:       v1alpha1.MaroonedPodsConfigSpec{EvictionStrategy: v1alpha1.EvictionStrategyNone},
+		})
+		vmi, err := ctrl.createVMIFromPod(newPod())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(vmi.Spec.Domain.Devices.Filesystems).To(ConsistOf(HaveField("Name", registriesVolumeName)))
+		Expect(userData(vmi)).To(ContainSubstring("echo '" + registriesVolumeName + " " + guestRegistriesDir + " virtiofs"))
+		Expect(userData(vmi)).To(ContainSubstring("systemctl enable --now marooned-registries-sync.timer\n"))
+	})
+})
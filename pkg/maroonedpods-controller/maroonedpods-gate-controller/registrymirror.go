@@ -0,0 +1,124 @@
+package mp_controller
+
+import (
+	"context"
+	"fmt"
+	v1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	k8smetav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	sdkapi "kubevirt.io/controller-lifecycle-operator-sdk/api"
+	"maroonedpods.io/maroonedpods/pkg/util"
+	v1alpha1 "maroonedpods.io/maroonedpods/staging/src/maroonedpods.io/api/pkg/apis/core/v1alpha1"
+	"strings"
+)
+
+const (
+	// Where the guest keeps the CA bundle of the registry mirror
+	registryMirrorCAPath = "/etc/marooned/registry-mirror-ca.crt"
+	// Key of the CA bundle ConfigMap maintained by the operator
+	caBundleKey = "ca-bundle.crt"
+)
+
+// k3sRegistries is the registries.yaml of the k3s agent
+type k3sRegistries struct {
+	Mirrors map[string]k3sMirror         `json:"mirrors,omitempty"`
+	Configs map[string]k3sRegistryConfig `json:"configs,omitempty"`
+}
+
+func newK3sRegistries() *k3sRegistries {
+	return &k3sRegistries{Mirrors: map[string]k3sMirror{}, Configs: map[string]k3sRegistryConfig{}}
+}
+
+type k3sMirror struct {
+	Endpoint []string `json:"endpoint"`
+}
+
+type k3sRegistryConfig struct {
+	Auth *k3sRegistryAuth `json:"auth,omitempty"`
+	TLS  *k3sRegistryTLS  `json:"tls,omitempty"`
+}
+
+type k3sRegistryAuth struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+type k3sRegistryTLS struct {
+	CAFile string `json:"ca_file"`
+}
+
+// getRegistryMirror returns the registry mirror of the active MaroonedPods CR, or nil when none is deployed
+func (ctrl *MaroonedPodsGateController) getRegistryMirror() *v1alpha1.RegistryMirror {
+	for _, obj := range ctrl.maroonedpodsInformer.GetStore().List() {
+		cr := obj.(*v1alpha1.MaroonedPods)
+		if cr.Status.Phase != sdkapi.PhaseError {
+			return cr.Spec.RegistryMirror
+		}
+	}
+	return nil
+}
+
+// registryMirrorEndpoint returns the address guests reach the registry mirror on, covered by its serving cert
+func registryMirrorEndpoint() string {
+	return fmt.Sprintf("%s.%s.svc:%d", util.RegistryMirrorResourceName, util.GetNamespace(), util.RegistryMirrorPort)
+}
+
+// addRegistryMirror points registries to the registry mirror with the credentials of the mirror,
+// returning the CA bundle of the mirror, or nothing without a mirror.
+// k3s falls back to the mirrored registry when the mirror can't serve an image.
+func (ctrl *MaroonedPodsGateController) addRegistryMirror(registries *k3sRegistries) (string, error) {
+	mirror := ctrl.getRegistryMirror()
+	if mirror == nil {
+		return "", nil
+	}
+	namespace := util.GetNamespace()
+
+	bundle, err := ctrl.maroonedpodsCli.CoreV1().ConfigMaps(namespace).Get(context.Background(), util.RegistryMirrorCABundleName, k8smetav1.GetOptions{})
+	if err != nil {
+		return "", fmt.Errorf("failed to get the CA bundle of the registry mirror: %v", err)
+	}
+	ca := bundle.Data[caBundleKey]
+	if ca == "" {
+		return "", fmt.Errorf("the CA bundle of the registry mirror is not issued yet")
+	}
+
+	endpoint := registryMirrorEndpoint()
+	config := k3sRegistryConfig{TLS: &k3sRegistryTLS{CAFile: registryMirrorCAPath}}
+	if mirror.CredentialsSecret != "" {
+		secret, err := ctrl.maroonedpodsCli.CoreV1().Secrets(namespace).Get(context.Background(), mirror.CredentialsSecret, k8smetav1.GetOptions{})
+		if err != nil {
+			return "", fmt.Errorf("failed to get the credentials of the registry mirror: %v", err)
+		}
+		username, password := string(secret.Data["username"]), string(secret.Data["password"])
+		if username == "" || password == "" {
+			return "", fmt.Errorf("secret %s/%s lacks the username or password of the registry mirror", namespace, mirror.CredentialsSecret)
+		}
+		config.Auth = &k3sRegistryAuth{Username: username, Password: password}
+	}
+
+	registry := mirror.Registry
+	if registry == "" {
+		registry = util.DefaultRegistryMirrorRegistry
+	}
+	registries.Mirrors[registry] = k3sMirror{Endpoint: []string{"https://" + endpoint}}
+	registries.Configs[endpoint] = config
+	return strings.TrimSpace(ca) + "\n", nil
+}
+
+// registryMirrorEgress allows the launcher pod of a VMI to reach the registry mirror
+func registryMirrorEgress() networkingv1.NetworkPolicyEgressRule {
+	tcp := v1.ProtocolTCP
+	port := intstr.FromInt(util.RegistryMirrorPort)
+	return networkingv1.NetworkPolicyEgressRule{
+		To: []networkingv1.NetworkPolicyPeer{{
+			NamespaceSelector: &k8smetav1.LabelSelector{
+				MatchLabels: map[string]string{v1.LabelMetadataName: util.GetNamespace()},
+			},
+			PodSelector: &k8smetav1.LabelSelector{
+				MatchLabels: map[string]string{util.MaroonedPodsLabel: util.RegistryMirrorResourceName},
+			},
+		}},
+		Ports: []networkingv1.NetworkPolicyPort{{Protocol: &tcp, Port: &port}},
+	}
+}
@@ -0,0 +1,122 @@
+package mp_controller
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"maroonedpods.io/maroonedpods/pkg/util"
+	v1alpha1 "maroonedpods.io/maroonedpods/staging/src/maroonedpods.io/api/pkg/apis/core/v1alpha1"
+)
+
+var _ = Describe("Registry mirror", func() {
+	const endpoint = "maroonedpods-registry-mirror.maroonedpods.svc:5000"
+
+	caBundle := &v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: util.RegistryMirrorCABundleName, Namespace: util.DefaultMaroonedPodsNs},
+		Data:       map[string]string{caBundleKey: "-----BEGIN CERTIFICATE-----\nMIIC\n-----END CERTIFICATE-----\n"},
+	}
+	credentials := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "mirror-credentials", Namespace: util.DefaultMaroonedPodsNs},
+		Data:       map[string][]byte{"username": []byte("guest"), "password": []byte("s3cret"), "htpasswd": []byte("guest:$2y$05$hash")},
+	}
+	addMirror := func(ctrl *MaroonedPodsGateController, mirror *v1alpha1.RegistryMirror) {
+		Expect(ctrl.maroonedpodsInformer.GetStore().Add(&v1alpha1.MaroonedPods{
+			ObjectMeta: metav1.ObjectMeta{Name: "maroonedpods"},
+			Spec:       v1alpha1.MaroonedPodsSpec{RegistryMirror: mirror},
+		})).To(Succeed())
+	}
+	// registries of a guest without pull secrets
+	mirrorOnly := func(ctrl *MaroonedPodsGateController) (string, error) {
+		data, err := ctrl.registriesSecretData(nil)
+		if err != nil {
+			return "", err
+		}
+		return string(data[registriesKey]) + string(data[registryMirrorCAKey]), nil
+	}
+
+	It("should leave registries alone without a mirror", func() {
+		ctrl, _ := newTestController(nil)
+		addMirror(ctrl, nil)
+		data, err := ctrl.registriesSecretData(nil)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(data).ToNot(HaveKey(registryMirrorCAKey))
+		Expect(string(data[registriesKey])).ToNot(ContainSubstring("mirrors:\n  "))
+	})
+
+	It("should point the guest to the mirror with its CA bundle", func() {
+		ctrl, _ := newTestController(nil, caBundle)
+		addMirror(ctrl, &v1alpha1.RegistryMirror{})
+
+		registries, err := mirrorOnly(ctrl)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(registries).To(ContainSubstring("-----BEGIN CERTIFICATE-----\nMIIC\n-----END CERTIFICATE-----\n"))
+		Expect(registries).To(ContainSubstring(`mirrors:
+  docker.io:
+    endpoint:
+    - https://` + endpoint + `
+`))
+		Expect(registries).To(ContainSubstring(`configs:
+  ` + endpoint + `:
+    tls:
+      ca_file: ` + registryMirrorCAPath + `
+`))
+		Expect(registries).ToNot(ContainSubstring("auth:"))
+	})
+
+	It("should pass the credentials of the mirror to the guest", func() {
+		ctrl, _ := newTestController(nil, caBundle, credentials)
+		addMirror(ctrl, &v1alpha1.RegistryMirror{Registry: "quay.io", CredentialsSecret: "mirror-credentials"})
+
+		registries, err := mirrorOnly(ctrl)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(registries).To(ContainSubstring("  quay.io:\n"))
+		Expect(registries).To(ContainSubstring(`    auth:
+      password: s3cret
+      username: guest
+`))
+		Expect(registries).ToNot(ContainSubstring("htpasswd"))
+	})
+
+	It("should fail until the CA bundle and credentials are available", func() {
+		ctrl, _ := newTestController(nil)
+		addMirror(ctrl, &v1alpha1.RegistryMirror{CredentialsSecret: "mirror-credentials"})
+		_, err := mirrorOnly(ctrl)
+		Expect(err).To(MatchError(ContainSubstring("CA bundle")))
+
+		ctrl, _ = newTestController(nil, caBundle)
+		addMirror(ctrl, &v1alpha1.RegistryMirror{CredentialsSecret: "mirror-credentials"})
+		_, err = mirrorOnly(ctrl)
+		Expect(err).To(MatchError(ContainSubstring("credentials")))
+	})
+
+	It("should configure warm pool VMs to use the mirror", func() {
+		ctrl, cli := newTestController(nil, caBundle)
+		addMirror(ctrl, &v1alpha1.RegistryMirror{})
+
+		vmi, err := ctrl.createPoolVMI(util.DefaultMaroonedPodsNs, "")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(hasRegistriesVolume(vmi)).To(BeTrue())
+		secret, err := cli.CoreV1().Secrets(util.DefaultMaroonedPodsNs).Get(context.Background(), registriesSecretName(vmi.Name), metav1.GetOptions{})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(secret.Data[registriesKey])).To(ContainSubstring("https://" + endpoint))
+		Expect(secret.Data).To(HaveKey(registryMirrorCAKey))
+	})
+
+	It("should let isolated launcher pods reach the mirror", func() {
+		ctrl, cli := newTestController(newConfigWithIsolation(&v1alpha1.NetworkIsolation{Enabled: true, APIServerCIDRs: []string{"10.0.0.1/32"}}))
+		addMirror(ctrl, &v1alpha1.RegistryMirror{})
+		Expect(ctrl.ensureNetworkPolicy("tenant", "web")).To(Succeed())
+
+		policy, err := cli.NetworkingV1().NetworkPolicies("tenant").Get(context.Background(), networkPolicyName("web"), metav1.GetOptions{})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(policy.Spec.Egress).To(HaveLen(4))
+		mirror := policy.Spec.Egress[3]
+		Expect(mirror.To[0].NamespaceSelector.MatchLabels).To(HaveKeyWithValue(v1.LabelMetadataName, util.DefaultMaroonedPodsNs))
+		Expect(mirror.To[0].PodSelector.MatchLabels).To(HaveKeyWithValue(util.MaroonedPodsLabel, util.RegistryMirrorResourceName))
+		Expect(mirror.Ports[0].Port.IntValue()).To(Equal(util.RegistryMirrorPort))
+	})
+})
@@ -0,0 +1,101 @@
+package mp_controller
+
+import (
+	v1 "k8s.io/api/core/v1"
+	"maroonedpods.io/maroonedpods/pkg/util"
+	v1alpha1 "maroonedpods.io/maroonedpods/staging/src/maroonedpods.io/api/pkg/apis/core/v1alpha1"
+)
+
+// getResourceSizing returns whether VMs are sized from pod requests or limits
+func (ctrl *MaroonedPodsGateController) getResourceSizing() v1alpha1.ResourceSizing {
+	config := ctrl.getConfig()
+	if config == nil || config.Spec.ResourceSizing == "" {
+		return v1alpha1.ResourceSizingRequests
+	}
+	return config.Spec.ResourceSizing
+}
+
+// getResourceOverhead returns the CPU and memory added on top of pod resources for the node components
+func (ctrl *MaroonedPodsGateController) getResourceOverhead() (cpuMillis int64, memoryBytes int64) {
+	// Default overhead: 500m CPU, 512Mi memory
+	cpuMillis = int64(500)
+	memoryBytes = int64(512 * 1024 * 1024)
+
+	config := ctrl.getConfig()
+	if config != nil && config.Spec.ResourceOverhead != nil {
+		if cpu, ok := (*config.Spec.ResourceOverhead)[v1.ResourceCPU]; ok {
+			cpuMillis = cpu.MilliValue()
+		}
+		if mem, ok := (*config.Spec.ResourceOverhead)[v1.ResourceMemory]; ok {
+			memoryBytes = mem.Value()
+		}
+	}
+	return cpuMillis, memoryBytes
+}
+
+// podEffectiveResources returns the resources the pod needs at any point of its life, following
+// the rules of the Kubernetes scheduler: app containers and sidecars run together, every init
+// container runs next to the sidecars started before it, and the pod overhead comes on top.
+func podEffectiveResources(pod *v1.Pod, sizing v1alpha1.ResourceSizing) v1.ResourceList {
+	sidecars := util.GetSidecarContainers(pod)
+
+	total := v1.ResourceList{}
+	for _, container := range pod.Spec.Containers {
+		addResources(total, containerResources(container, sizing))
+	}
+
+	sidecarResources := v1.ResourceList{}
+	initResources := v1.ResourceList{}
+	for _, container := range pod.Spec.InitContainers {
+		resources := containerResources(container, sizing)
+		if sidecars[container.Name] {
+			addResources(total, resources)
+			addResources(sidecarResources, resources)
+			resources = sidecarResources
+		} else {
+			running := sidecarResources.DeepCopy()
+			addResources(running, resources)
+			resources = running
+		}
+		maxResources(initResources, resources)
+	}
+
+	maxResources(total, initResources)
+	addResources(total, pod.Spec.Overhead)
+	return total
+}
+
+// containerResources returns the requests of the container, or its limits where set when sizing by limits
+func containerResources(container v1.Container, sizing v1alpha1.ResourceSizing) v1.ResourceList {
+	resources := container.Resources.Requests.DeepCopy()
+	if resources == nil {
+		resources = v1.ResourceList{}
+	}
+	if sizing == v1alpha1.ResourceSizingLimits {
+		for name, limit := range container.Resources.Limits {
+			resources[name] = limit.DeepCopy()
+		}
+	}
+	return resources
+}
+
+// addResources adds the resources of toAdd to list
+func addResources(list, toAdd v1.ResourceList) {
+	for name, quantity := range toAdd {
+		if value, ok := list[name]; ok {
+			value.Add(quantity)
+			list[name] = value
+		} else {
+			list[name] = quantity.DeepCopy()
+		}
+	}
+}
+
+// maxResources raises the resources of list to those of other where they are larger
+func maxResources(list, other v1.ResourceList) {
+	for name, quantity := range other {
+		if value, ok := list[name]; !ok || quantity.Cmp(value) > 0 {
+			list[name] = quantity.DeepCopy()
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package mp_controller
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"maroonedpods.io/maroonedpods/pkg/util"
+	v1alpha1 "maroonedpods.io/maroonedpods/staging/src/maroonedpods.io/api/pkg/apis/core/v1alpha1"
+)
+
+func withRequests(container *v1.Container, requests v1.ResourceList) {
+	container.Resources.Requests = requests
+}
+
+func withLimits(container *v1.Container, limits v1.ResourceList) {
+	container.Resources.Limits = limits
+}
+
+func cpuMemory(cpu, memory string) v1.ResourceList {
+	return v1.ResourceList{v1.ResourceCPU: resource.MustParse(cpu), v1.ResourceMemory: resource.MustParse(memory)}
+}
+
+var _ = Describe("VM sizing", func() {
+	Context("CPU and memory", func() {
+		// No overhead and a minimal floor, so the VM size is the effective pod size
+		newSizingConfig := func(sizing v1alpha1.ResourceSizing) *v1alpha1.MaroonedPodsConfig {
+			return &v1alpha1.MaroonedPodsConfig{
+				ObjectMeta: metav1.ObjectMeta{Name: "default"},
+				Spec: v1alpha1.MaroonedPodsConfigSpec{
+					BaseVMResources:  v1alpha1.VMResources{CPU: 1, MemoryMi: 1},
+					ResourceOverhead: &v1.ResourceList{v1.ResourceCPU: resource.MustParse("0"), v1.ResourceMemory: resource.MustParse("0")},
+					ResourceSizing:   sizing,
+				},
+			}
+		}
+
+		DescribeTable("should follow the effective resources of the pod", func(sizing v1alpha1.ResourceSizing, mutate func(*v1.Pod), expectedCPU uint32, expectedMemoryMi uint64) {
+			pod := newPod("builder")
+			mutate(pod)
+			ctrl, _ := newTestController(newSizingConfig(sizing))
+			cpuCores, memoryMi := ctrl.calculateVMResourcesFromPod(pod)
+			Expect(cpuCores).To(Equal(expectedCPU))
+			Expect(memoryMi).To(Equal(expectedMemoryMi))
+		},
+			Entry("sum of the app containers", v1alpha1.ResourceSizingRequests, func(pod *v1.Pod) {
+				withRequests(&pod.Spec.Containers[0], cpuMemory("1500m", "1Gi"))
+				pod.Spec.Containers = append(pod.Spec.Containers, v1.Container{Name: "proxy"})
+				withRequests(&pod.Spec.Containers[1], cpuMemory("500m", "512Mi"))
+			}, uint32(2), uint64(1536)),
+			Entry("init container larger than the app containers", v1alpha1.ResourceSizingRequests, func(pod *v1.Pod) {
+				withRequests(&pod.Spec.Containers[0], cpuMemory("1", "1Gi"))
+				pod.Spec.InitContainers = []v1.Container{{Name: "migrate"}, {Name: "warmup"}}
+				withRequests(&pod.Spec.InitContainers[0], cpuMemory("3", "512Mi"))
+				withRequests(&pod.Spec.InitContainers[1], cpuMemory("1", "2Gi"))
+			}, uint32(3), uint64(2048)),
+			Entry("sidecars next to the app containers and later init containers", v1alpha1.ResourceSizingRequests, func(pod *v1.Pod) {
+				pod.Annotations = map[string]string{util.SidecarContainersAnnotation: "mesh"}
+				withRequests(&pod.Spec.Containers[0], cpuMemory("1", "1Gi"))
+				pod.Spec.InitContainers = []v1.Container{{Name: "mesh"}, {Name: "migrate"}}
+				withRequests(&pod.Spec.InitContainers[0], cpuMemory("1", "256Mi"))
+				withRequests(&pod.Spec.InitContainers[1], cpuMemory("2", "1Gi"))
+			}, uint32(3), uint64(1280)),
+			Entry("pod overhead", v1alpha1.ResourceSizingRequests, func(pod *v1.Pod) {
+				withRequests(&pod.Spec.Containers[0], cpuMemory("1", "1Gi"))
+				pod.Spec.Overhead = cpuMemory("250m", "128Mi")
+			}, uint32(2), uint64(1152)),
+			Entry("requests when sizing by requests", v1alpha1.ResourceSizingRequests, func(pod *v1.Pod) {
+				withRequests(&pod.Spec.Containers[0], cpuMemory("1", "1Gi"))
+				withLimits(&pod.Spec.Containers[0], cpuMemory("4", "4Gi"))
+			}, uint32(1), uint64(1024)),
+			Entry("limits when sizing by limits", v1alpha1.ResourceSizingLimits, func(pod *v1.Pod) {
+				withRequests(&pod.Spec.Containers[0], cpuMemory("1", "1Gi"))
+				withLimits(&pod.Spec.Containers[0], cpuMemory("4", "4Gi"))
+			}, uint32(4), uint64(4096)),
+			Entry("requests of containers without limits when sizing by limits", v1alpha1.ResourceSizingLimits, func(pod *v1.Pod) {
+				withRequests(&pod.Spec.Containers[0], cpuMemory("1", "1Gi"))
+				withLimits(&pod.Spec.Containers[0], v1.ResourceList{v1.ResourceMemory: resource.MustParse("2Gi")})
+				pod.Spec.Containers = append(pod.Spec.Containers, v1.Container{Name: "proxy"})
+				withRequests(&pod.Spec.Containers[1], cpuMemory("1", "512Mi"))
+			}, uint32(2), uint64(2560)),
+			Entry("annotation override", v1alpha1.ResourceSizingRequests, func(pod *v1.Pod) {
+				pod.Annotations = map[string]string{util.VMCPUAnnotation: "6", util.VMMemoryAnnotation: "12Gi"}
+				withRequests(&pod.Spec.Containers[0], cpuMemory("1", "1Gi"))
+			}, uint32(6), uint64(12288)),
+			Entry("partial annotation override", v1alpha1.ResourceSizingRequests, func(pod *v1.Pod) {
+				pod.Annotations = map[string]string{util.VMMemoryAnnotation: "1500Mi"}
+				withRequests(&pod.Spec.Containers[0], cpuMemory("2", "1Gi"))
+			}, uint32(2), uint64(1500)),
+			Entry("invalid annotation override", v1alpha1.ResourceSizingRequests, func(pod *v1.Pod) {
+				pod.Annotations = map[string]string{util.VMCPUAnnotation: "lots"}
+				withRequests(&pod.Spec.Containers[0], cpuMemory("2", "1Gi"))
+			}, uint32(2), uint64(1024)),
+		)
+
+		It("should apply the default overhead and floor", func() {
+			pod := newPod("builder")
+			withRequests(&pod.Spec.Containers[0], cpuMemory("4", "4Gi"))
+			ctrl, _ := newTestController(nil)
+			cpuCores, memoryMi := ctrl.calculateVMResourcesFromPod(pod)
+			Expect(cpuCores).To(Equal(uint32(5)))
+			Expect(memoryMi).To(Equal(uint64(4608)))
+
+			cpuCores, memoryMi = ctrl.calculateVMResourcesFromPod(newPod("builder"))
+			Expect(cpuCores).To(Equal(uint32(2)))
+			Expect(memoryMi).To(Equal(uint64(3072)))
+		})
+	})
+
+	Context("scratch disk", func() {
+		scratchDiskSize := func(config *v1alpha1.MaroonedPodsConfig, pod *v1.Pod) string {
+			ctrl, _ := newTestController(config)
+			size := ctrl.calculateScratchDiskSize(pod)
+			return size.String()
+		}
+
+		It("should only reserve the overhead without ephemeral storage", func() {
+			Expect(scratchDiskSize(nil, newPod("builder"))).To(Equal("10Gi"))
+		})
+
+		It("should add ephemeral-storage requests and emptyDir size limits", func() {
+			pod := newPod("builder")
+			withRequests(&pod.Spec.Containers[0], v1.ResourceList{v1.ResourceEphemeralStorage: resource.MustParse("4Gi")})
+			pod.Spec.Containers = append(pod.Spec.Containers, v1.Container{Name: "cache", Image: "cache:latest"})
+			withRequests(&pod.Spec.Containers[1], v1.ResourceList{v1.ResourceEphemeralStorage: resource.MustParse("1Gi")})
+			sizeLimit, memoryLimit := resource.MustParse("2Gi"), resource.MustParse("8Gi")
+			pod.Spec.Volumes = []v1.Volume{
+				{Name: "workspace", VolumeSource: v1.VolumeSource{EmptyDir: &v1.EmptyDirVolumeSource{SizeLimit: &sizeLimit}}},
+				{Name: "shm", VolumeSource: v1.VolumeSource{EmptyDir: &v1.EmptyDirVolumeSource{Medium: v1.StorageMediumMemory, SizeLimit: &memoryLimit}}},
+			}
+
+			Expect(scratchDiskSize(nil, pod)).To(Equal("17Gi"))
+		})
+
+		It("should reserve the largest init container request when it exceeds the containers", func() {
+			pod := newPod("builder")
+			withRequests(&pod.Spec.Containers[0], v1.ResourceList{v1.ResourceEphemeralStorage: resource.MustParse("1Gi")})
+			pod.Spec.InitContainers = []v1.Container{{Name: "fetch", Image: "fetch:latest"}}
+			withRequests(&pod.Spec.InitContainers[0], v1.ResourceList{v1.ResourceEphemeralStorage: resource.MustParse("5Gi")})
+
+			Expect(scratchDiskSize(nil, pod)).To(Equal("15Gi"))
+		})
+
+		It("should use the configured ephemeral-storage overhead", func() {
+			config := &v1alpha1.MaroonedPodsConfig{
+				ObjectMeta: metav1.ObjectMeta{Name: "default"},
+				Spec: v1alpha1.MaroonedPodsConfigSpec{
+					ResourceOverhead: &v1.ResourceList{v1.ResourceEphemeralStorage: resource.MustParse("20Gi")},
+				},
+			}
+			Expect(scratchDiskSize(config, newPod("builder"))).To(Equal("20Gi"))
+		})
+
+		It("should attach the scratch disk to the VMI", func() {
+			ctrl, _ := newTestController(nil)
+			vmi, err := ctrl.createVMIFromPod(newPod("builder"))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(vmi.Spec.Domain.Devices.Disks).To(ContainElement(HaveField("Serial", scratchDiskSerial)))
+			for _, volume := range vmi.Spec.Volumes {
+				if volume.Name == scratchDiskName {
+					Expect(volume.EmptyDisk.Capacity.String()).To(Equal("10Gi"))
+				}
+			}
+		})
+	})
+})
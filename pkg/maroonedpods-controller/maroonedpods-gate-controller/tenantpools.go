@@ -0,0 +1,147 @@
+package mp_controller
+
+import (
+	"fmt"
+	v1 "k8s.io/api/core/v1"
+	k8smetav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/klog/v2"
+	virtv1 "kubevirt.io/api/core/v1"
+	"maroonedpods.io/maroonedpods/pkg/util"
+	v1alpha1 "maroonedpods.io/maroonedpods/staging/src/maroonedpods.io/api/pkg/apis/core/v1alpha1"
+)
+
+// poolName returns the tenant pool of a pool VMI, or an empty string for the shared warm pool
+func poolName(vmi *virtv1.VirtualMachineInstance) string {
+	return vmi.Labels[util.WarmPoolNameLabel]
+}
+
+// poolVMIEntitled checks whether a pod of the namespace may claim the pool VMI.
+// Namespaces of a tenant pool only claim the VMIs the pool keeps in the namespace,
+// other namespaces only claim VMIs of the shared warm pool.
+func poolVMIEntitled(vmi *virtv1.VirtualMachineInstance, namespace string, pool *v1alpha1.TenantPool) bool {
+	if pool == nil {
+		return poolName(vmi) == ""
+	}
+	return vmi.Namespace == namespace && poolName(vmi) == pool.Name
+}
+
+// tenantPoolOf returns the first tenant pool selecting the namespace, or nil when its pods use the shared warm pool
+func (ctrl *MaroonedPodsGateController) tenantPoolOf(namespace string) (*v1alpha1.TenantPool, error) {
+	config := ctrl.getConfig()
+	if config == nil || len(config.Spec.TenantPools) == 0 {
+		return nil, nil
+	}
+	obj, exists, err := ctrl.namespaceInformer.GetStore().GetByKey(namespace)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get namespace %s from cache: %v", namespace, err)
+	}
+	if !exists {
+		return nil, fmt.Errorf("namespace %s not found", namespace)
+	}
+	ns := obj.(*v1.Namespace)
+	for i := range config.Spec.TenantPools {
+		pool := &config.Spec.TenantPools[i]
+		selector, err := k8smetav1.LabelSelectorAsSelector(&pool.NamespaceSelector)
+		if err != nil {
+			return nil, fmt.Errorf("invalid namespace selector of tenant pool %s: %v", pool.Name, err)
+		}
+		if selector.Matches(labels.Set(ns.Labels)) {
+			return pool, nil
+		}
+	}
+	return nil, nil
+}
+
+// reconcileTenantPools keeps the VMs of each tenant pool in the namespaces it selects, and reports the pools.
+// VMs left behind by removed pools are deleted once they are no longer claimed.
+func (ctrl *MaroonedPodsGateController) reconcileTenantPools(config *v1alpha1.MaroonedPodsConfig) []v1alpha1.TenantPoolStatus {
+	vmisByPool := map[string][]*virtv1.VirtualMachineInstance{}
+	for _, obj := range ctrl.vmiInformer.GetStore().List() {
+		vmi := obj.(*virtv1.VirtualMachineInstance)
+		if name := poolName(vmi); name != "" && ctrl.isPoolVMI(vmi) {
+			vmisByPool[name] = append(vmisByPool[name], vmi)
+		}
+	}
+
+	var statuses []v1alpha1.TenantPoolStatus
+	for _, pool := range config.Spec.TenantPools {
+		status, err := ctrl.reconcileTenantPool(pool, vmisByPool[pool.Name])
+		if err != nil {
+			klog.Errorf("Failed to reconcile tenant pool %s: %v", pool.Name, err)
+		}
+		statuses = append(statuses, status)
+		delete(vmisByPool, pool.Name)
+	}
+
+	for name, vmis := range vmisByPool {
+		klog.Infof("Tenant pool %s was removed, deleting its available VMs", name)
+		ctrl.deleteAvailablePoolVMIs(vmis, len(vmis))
+	}
+	return statuses
+}
+
+// reconcileTenantPool keeps the size of the pool available in each selected namespace, within the quota of the pool.
+// Available VMs in namespaces the pool no longer selects are deleted.
+func (ctrl *MaroonedPodsGateController) reconcileTenantPool(pool v1alpha1.TenantPool, vmis []*virtv1.VirtualMachineInstance) (v1alpha1.TenantPoolStatus, error) {
+	status := v1alpha1.TenantPoolStatus{Name: pool.Name}
+
+	// Unclaimed VMs of the pool by namespace
+	unclaimed := map[string][]*virtv1.VirtualMachineInstance{}
+	for _, vmi := range vmis {
+		status.Total++
+		switch ctrl.poolVMIState(vmi) {
+		case util.PoolStateAvailable:
+			status.Available++
+		case util.PoolStateClaimed:
+			status.Claimed++
+			continue
+		}
+		unclaimed[vmi.Namespace] = append(unclaimed[vmi.Namespace], vmi)
+	}
+
+	selector, err := k8smetav1.LabelSelectorAsSelector(&pool.NamespaceSelector)
+	if err != nil {
+		return status, fmt.Errorf("invalid namespace selector: %v", err)
+	}
+	var namespaces []*v1.Namespace
+	for _, obj := range ctrl.namespaceInformer.GetStore().List() {
+		if ns := obj.(*v1.Namespace); selector.Matches(labels.Set(ns.Labels)) {
+			namespaces = append(namespaces, ns)
+		}
+	}
+	status.Namespaces = int32(len(namespaces))
+
+	total := status.Total
+	for _, ns := range namespaces {
+		nsVMIs := unclaimed[ns.Name]
+		delete(unclaimed, ns.Name)
+
+		if excess := len(nsVMIs) - int(pool.Size); excess > 0 {
+			klog.Infof("Tenant pool %s above desired size in namespace %s, deleting %d available VMs", pool.Name, ns.Name, excess)
+			ctrl.deleteAvailablePoolVMIs(nsVMIs, excess)
+			continue
+		}
+
+		toCreate := int(pool.Size) - len(nsVMIs)
+		if pool.MaxVMs > 0 && toCreate > int(pool.MaxVMs-total) {
+			toCreate = int(pool.MaxVMs - total)
+			klog.V(2).Infof("Tenant pool %s reached its quota of %d VMs", pool.Name, pool.MaxVMs)
+		}
+		for i := 0; i < toCreate; i++ {
+			if _, err := ctrl.createPoolVMI(ns.Name, pool.Name); err != nil {
+				klog.Errorf("Failed to create VMI of tenant pool %s in namespace %s: %v", pool.Name, ns.Name, err)
+				continue
+			}
+			total++
+		}
+	}
+
+	for namespace, nsVMIs := range unclaimed {
+		klog.Infof("Tenant pool %s no longer selects namespace %s, deleting its available VMs", pool.Name, namespace)
+		ctrl.deleteAvailablePoolVMIs(nsVMIs, len(nsVMIs))
+	}
+	klog.V(3).Infof("Tenant pool %s: namespaces=%d, total=%d, available=%d, claimed=%d",
+		pool.Name, status.Namespaces, status.Total, status.Available, status.Claimed)
+	return status, nil
+}
@@ -0,0 +1,218 @@
+package mp_controller
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	virtv1 "kubevirt.io/api/core/v1"
+	"maroonedpods.io/maroonedpods/pkg/util"
+	v1alpha1 "maroonedpods.io/maroonedpods/staging/src/maroonedpods.io/api/pkg/apis/core/v1alpha1"
+)
+
+var _ = Describe("Tenant pools", func() {
+	goldPool := v1alpha1.TenantPool{
+		Name:              "gold",
+		NamespaceSelector: metav1.LabelSelector{MatchLabels: map[string]string{"tier": "gold"}},
+		Size:              2,
+	}
+	newConfig := func(pools ...v1alpha1.TenantPool) *v1alpha1.MaroonedPodsConfig {
+		return &v1alpha1.MaroonedPodsConfig{
+			ObjectMeta: metav1.ObjectMeta{Name: "config", UID: "config-uid"},
+			Spec:       v1alpha1.MaroonedPodsConfigSpec{TenantPools: pools},
+		}
+	}
+	newNamespace := func(name, tier string) *v1.Namespace {
+		ns := &v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name}}
+		if tier != "" {
+			ns.Labels = map[string]string{"tier": tier}
+		}
+		return ns
+	}
+	newPoolVMI := func(namespace, name, pool, state string) *virtv1.VirtualMachineInstance {
+		vmi := virtv1.NewVMIReferenceFromNameWithNS(namespace, name)
+		vmi.Labels = map[string]string{util.WarmPoolStateLabel: state, util.MaroonedVMILabel: name}
+		if pool != "" {
+			vmi.Labels[util.WarmPoolNameLabel] = pool
+		}
+		vmi.Status.Phase = virtv1.Running
+		return vmi
+	}
+	addVMIs := func(ctrl *MaroonedPodsGateController, cli *fakeMaroonedPodsClient, vmis ...*virtv1.VirtualMachineInstance) {
+		for _, vmi := range vmis {
+			Expect(ctrl.vmiInformer.GetStore().Add(vmi)).To(Succeed())
+			_, err := cli.KubevirtClient().KubevirtV1().VirtualMachineInstances(vmi.Namespace).Create(context.Background(), vmi, metav1.CreateOptions{})
+			Expect(err).ToNot(HaveOccurred())
+		}
+	}
+	listVMIs := func(cli *fakeMaroonedPodsClient, namespace string) []virtv1.VirtualMachineInstance {
+		vmis, err := cli.KubevirtClient().KubevirtV1().VirtualMachineInstances(namespace).List(context.Background(), metav1.ListOptions{})
+		Expect(err).ToNot(HaveOccurred())
+		return vmis.Items
+	}
+
+	It("should entitle namespaces to the first pool selecting them", func() {
+		silverPool := v1alpha1.TenantPool{
+			Name:              "silver",
+			NamespaceSelector: metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{{Key: "tier", Operator: metav1.LabelSelectorOpExists}}},
+		}
+		ctrl, _ := newTestController(newConfig(goldPool, silverPool),
+			newNamespace("tenant-a", "gold"), newNamespace("tenant-b", "bronze"), newNamespace("shared", ""))
+
+		pool, err := ctrl.tenantPoolOf("tenant-a")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(pool.Name).To(Equal("gold"))
+
+		pool, err = ctrl.tenantPoolOf("tenant-b")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(pool.Name).To(Equal("silver"))
+
+		pool, err = ctrl.tenantPoolOf("shared")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(pool).To(BeNil())
+	})
+
+	DescribeTable("should only hand out VMIs of the pool the namespace is entitled to", func(vmiNamespace, vmiPool string, pool *v1alpha1.TenantPool, entitled bool) {
+		vmi := newPoolVMI(vmiNamespace, "maroonedpods-pool-abc", vmiPool, util.PoolStateAvailable)
+		Expect(poolVMIEntitled(vmi, "tenant-a", pool)).To(Equal(entitled))
+	},
+		Entry("tenant VMI in the namespace", "tenant-a", "gold", &goldPool, true),
+		Entry("tenant VMI in another namespace", "tenant-b", "gold", &goldPool, false),
+		Entry("VMI of another tenant pool", "tenant-a", "silver", &goldPool, false),
+		Entry("shared VMI for a tenant namespace", util.DefaultMaroonedPodsNs, "", &goldPool, false),
+		Entry("shared VMI for a shared namespace", util.DefaultMaroonedPodsNs, "", nil, true),
+		Entry("tenant VMI for a shared namespace", "tenant-a", "gold", nil, false),
+	)
+
+	It("should claim available VMIs of the tenant pool only", func() {
+		ctrl, cli := newTestController(newConfig(goldPool))
+		addVMIs(ctrl, cli,
+			newPoolVMI(util.DefaultMaroonedPodsNs, "maroonedpods-pool-shared", "", util.PoolStateAvailable),
+			newPoolVMI("tenant-b", "maroonedpods-pool-other", "gold", util.PoolStateAvailable),
+			newPoolVMI("tenant-a", "maroonedpods-pool-claimed", "gold", util.PoolStateClaimed),
+		)
+		tenantPod := newPod("web-1")
+		tenantPod.Namespace = "tenant-a"
+		sharedPod := newPod("web-2")
+		sharedPod.Namespace = "shared"
+		Expect(ctrl.getAvailablePoolVMI(tenantPod, &goldPool)).To(BeNil())
+
+		addVMIs(ctrl, cli, newPoolVMI("tenant-a", "maroonedpods-pool-own", "gold", util.PoolStateAvailable))
+		Expect(ctrl.getAvailablePoolVMI(tenantPod, &goldPool).Name).To(Equal("maroonedpods-pool-own"))
+		Expect(ctrl.getAvailablePoolVMI(sharedPod, nil).Name).To(Equal("maroonedpods-pool-shared"))
+	})
+
+	It("should find the pool VMI claimed by a pod", func() {
+		ctrl, cli := newTestController(nil)
+		claimed := newPoolVMI("tenant-a", "maroonedpods-pool-abc", "gold", util.PoolStateClaimed)
+		claimed.Labels[util.WarmPoolClaimedByLabel] = "web-1"
+		claimed.Labels[util.WarmPoolClaimedByNamespaceLabel] = "tenant-a"
+		addVMIs(ctrl, cli, claimed)
+
+		Expect(ctrl.claimedPoolVMI("tenant-a", "web-1")).To(Equal(claimed))
+		Expect(ctrl.claimedPoolVMI("tenant-a", "web-2")).To(BeNil())
+		Expect(ctrl.claimedPoolVMI("tenant-b", "web-1")).To(BeNil())
+	})
+
+	It("should label pool VMIs with the pod claiming them", func() {
+		ctrl, cli := newTestController(nil)
+		vmi := newPoolVMI(util.DefaultMaroonedPodsNs, "maroonedpods-pool-abc", "", util.PoolStateAvailable)
+		addVMIs(ctrl, cli, vmi)
+		node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: vmi.Name}}
+		Expect(ctrl.nodeInformer.GetStore().Add(node)).To(Succeed())
+		_, err := cli.CoreV1().Nodes().Create(context.Background(), node, metav1.CreateOptions{})
+		Expect(err).ToNot(HaveOccurred())
+
+		pod := newPod("web-1", inNamespace("tenant-a"))
+		Expect(ctrl.claimPoolVMI(vmi, pod)).To(Succeed())
+		claimed, err := cli.KubevirtClient().KubevirtV1().VirtualMachineInstances(vmi.Namespace).Get(context.Background(), vmi.Name, metav1.GetOptions{})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(claimed.Labels).To(HaveKeyWithValue(util.WarmPoolClaimedByLabel, "web-1"))
+		Expect(claimed.Labels).To(HaveKeyWithValue(util.WarmPoolClaimedByNamespaceLabel, "tenant-a"))
+		for _, value := range claimed.Labels {
+			Expect(validation.IsValidLabelValue(value)).To(BeEmpty())
+		}
+		Expect(ctrl.vmiInformer.GetStore().Update(claimed)).To(Succeed())
+		Expect(ctrl.claimedPoolVMI("tenant-a", "web-1").Name).To(Equal(vmi.Name))
+
+		Expect(ctrl.returnVMIToPool(claimed, pod.Name)).To(Succeed())
+		returned, err := cli.KubevirtClient().KubevirtV1().VirtualMachineInstances(vmi.Namespace).Get(context.Background(), vmi.Name, metav1.GetOptions{})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(returned.Labels).ToNot(HaveKey(util.WarmPoolClaimedByLabel))
+		Expect(returned.Labels).ToNot(HaveKey(util.WarmPoolClaimedByNamespaceLabel))
+	})
+
+	It("should swap the pool taint of the node for the taint of the pod", func() {
+		ctrl, cli := newTestController(nil)
+		vmi := newPoolVMI("tenant-a", "maroonedpods-pool-abc", "gold", util.PoolStateAvailable)
+		addVMIs(ctrl, cli, vmi)
+		pool := poolTaint(util.DefaultNodeTaintKey, vmi.Name)
+		node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: vmi.Name}, Spec: v1.NodeSpec{Taints: []v1.Taint{pool}}}
+		Expect(ctrl.nodeInformer.GetStore().Add(node)).To(Succeed())
+		_, err := cli.CoreV1().Nodes().Create(context.Background(), node, metav1.CreateOptions{})
+		Expect(err).ToNot(HaveOccurred())
+		nodeTaints := func() []v1.Taint {
+			node, err := cli.CoreV1().Nodes().Get(context.Background(), vmi.Name, metav1.GetOptions{})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(ctrl.nodeInformer.GetStore().Update(node)).To(Succeed())
+			return node.Spec.Taints
+		}
+
+		pod := newPod("web-1", inNamespace("tenant-a"))
+		Expect(ctrl.claimPoolVMI(vmi, pod)).To(Succeed())
+		Expect(nodeTaints()).To(ConsistOf(v1.Taint{Key: "web-1/" + util.DefaultNodeTaintKey, Value: "claimed", Effect: v1.TaintEffectNoSchedule}))
+
+		Expect(ctrl.returnVMIToPool(vmi, pod.Name)).To(Succeed())
+		Expect(nodeTaints()).To(ConsistOf(pool))
+	})
+
+	It("should keep the pool size in each selected namespace", func() {
+		ctrl, cli := newTestController(newConfig(goldPool),
+			newNamespace("tenant-a", "gold"), newNamespace("tenant-b", "gold"), newNamespace("shared", ""))
+		addVMIs(ctrl, cli,
+			newPoolVMI("tenant-a", "maroonedpods-pool-available", "gold", util.PoolStateAvailable),
+			newPoolVMI("tenant-a", "maroonedpods-pool-claimed", "gold", util.PoolStateClaimed),
+		)
+
+		statuses := ctrl.reconcileTenantPools(newConfig(goldPool))
+		Expect(statuses).To(Equal([]v1alpha1.TenantPoolStatus{{Name: "gold", Namespaces: 2, Total: 2, Available: 1, Claimed: 1}}))
+
+		Expect(listVMIs(cli, "tenant-a")).To(HaveLen(3))
+		tenantB := listVMIs(cli, "tenant-b")
+		Expect(tenantB).To(HaveLen(2))
+		for _, vmi := range tenantB {
+			Expect(vmi.Labels).To(HaveKeyWithValue(util.WarmPoolNameLabel, "gold"))
+			Expect(vmi.Labels).To(HaveKeyWithValue(util.WarmPoolStateLabel, util.PoolStateCreating))
+			Expect(vmi.OwnerReferences).To(ConsistOf(HaveField("Kind", "MaroonedPodsConfig")))
+		}
+		Expect(listVMIs(cli, "shared")).To(BeEmpty())
+	})
+
+	It("should stop creating VMs at the quota of the pool", func() {
+		pool := goldPool
+		pool.MaxVMs = 3
+		ctrl, cli := newTestController(newConfig(pool), newNamespace("tenant-a", "gold"), newNamespace("tenant-b", "gold"))
+		addVMIs(ctrl, cli, newPoolVMI("tenant-a", "maroonedpods-pool-claimed", "gold", util.PoolStateClaimed))
+
+		ctrl.reconcileTenantPools(newConfig(pool))
+		Expect(len(listVMIs(cli, "tenant-a")) + len(listVMIs(cli, "tenant-b"))).To(Equal(3))
+	})
+
+	It("should delete available VMs the pool no longer needs", func() {
+		ctrl, cli := newTestController(newConfig(goldPool), newNamespace("tenant-a", "bronze"))
+		addVMIs(ctrl, cli,
+			newPoolVMI("tenant-a", "maroonedpods-pool-available", "gold", util.PoolStateAvailable),
+			newPoolVMI("tenant-a", "maroonedpods-pool-claimed", "gold", util.PoolStateClaimed),
+			newPoolVMI("tenant-a", "maroonedpods-pool-removed", "silver", util.PoolStateAvailable),
+		)
+
+		ctrl.reconcileTenantPools(newConfig(goldPool))
+		remaining := listVMIs(cli, "tenant-a")
+		Expect(remaining).To(HaveLen(1))
+		Expect(remaining[0].Name).To(Equal("maroonedpods-pool-claimed"))
+	})
+})
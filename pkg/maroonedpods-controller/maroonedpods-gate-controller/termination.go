@@ -0,0 +1,133 @@
+package mp_controller
+
+import (
+	"fmt"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+	virtv1 "kubevirt.io/api/core/v1"
+	"maroonedpods.io/maroonedpods/pkg/util"
+	"time"
+)
+
+// hasMaroonedPodsFinalizer checks whether the pod is held back from deletion until its VM is cleaned up
+func hasMaroonedPodsFinalizer(pod *v1.Pod) bool {
+	for _, finalizer := range pod.Finalizers {
+		if finalizer == util.MaroonedPodsFinalizer {
+			return true
+		}
+	}
+	return false
+}
+
+// vmiTerminationGracePeriod returns how long KubeVirt waits for the guest to power off after the ACPI
+// shutdown of a deleted VMI, which is the termination grace period of the pod
+func vmiTerminationGracePeriod(pod *v1.Pod) *int64 {
+	gracePeriod := int64(v1.DefaultTerminationGracePeriodSeconds)
+	if pod.Spec.TerminationGracePeriodSeconds != nil {
+		gracePeriod = *pod.Spec.TerminationGracePeriodSeconds
+	}
+	return &gracePeriod
+}
+
+// guestContainersTerminated checks whether the guest kubelet is done terminating the containers of the pod,
+// so tearing down the VM doesn't cut the preStop hooks and grace period of the containers short
+func guestContainersTerminated(pod *v1.Pod) bool {
+	// Pods that never reached the virtual node have no containers
+	if pod.Spec.NodeName == "" || pod.Status.Phase == v1.PodSucceeded || pod.Status.Phase == v1.PodFailed {
+		return true
+	}
+	// The kubelet deletes the pod without grace period once its containers are gone
+	if pod.DeletionGracePeriodSeconds != nil && *pod.DeletionGracePeriodSeconds == 0 {
+		return true
+	}
+	for _, statuses := range [][]v1.ContainerStatus{pod.Status.InitContainerStatuses, pod.Status.ContainerStatuses} {
+		for _, status := range statuses {
+			if status.State.Running != nil {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// gracePeriodRemaining returns how long the containers of the deleted pod may still take to terminate.
+// The deletion timestamp of a pod is set to the end of its grace period.
+func gracePeriodRemaining(pod *v1.Pod) time.Duration {
+	return time.Until(pod.DeletionTimestamp.Time)
+}
+
+// waitForGuestContainers checks whether the VM of the deleted pod has to be kept until the guest kubelet
+// terminated its containers, and requeues the pod for the end of its grace period
+func (ctrl *MaroonedPodsGateController) waitForGuestContainers(pod *v1.Pod, key string) bool {
+	if guestContainersTerminated(pod) {
+		return false
+	}
+	remaining := gracePeriodRemaining(pod)
+	if remaining <= 0 {
+		klog.Infof("Grace period of pod %s expired before its containers terminated", key)
+		return false
+	}
+	klog.V(2).Infof("Waiting up to %v for the containers of pod %s to terminate", remaining, key)
+	ctrl.queue.AddAfter(key, remaining)
+	return true
+}
+
+// deleteVMI requeues the pods of a deleted VMI, whose finalizers wait for the VM to shut down
+func (ctrl *MaroonedPodsGateController) deleteVMI(obj interface{}) {
+	vmi, ok := obj.(*virtv1.VirtualMachineInstance)
+	if !ok || ctrl.isPoolVMI(vmi) {
+		return
+	}
+	if _, marooned := vmi.Labels[util.MaroonedVMILabel]; !marooned {
+		return
+	}
+	if island, ok := vmi.Labels[util.IslandLabel]; ok {
+		for _, pod := range ctrl.islandPods(vmi.Namespace, island) {
+			ctrl.queue.Add(fmt.Sprintf("%s/%s", pod.Namespace, pod.Name))
+		}
+		return
+	}
+	ctrl.queue.Add(fmt.Sprintf("%s/%s", vmi.Namespace, vmi.Name))
+}
+
+// tearDownVirtualNode shuts down the VM of the pod and cleans up after it, reporting whether the VM is gone.
+// Deleting the VMI makes KubeVirt shut the guest down with ACPI, powering it off after the
+// termination grace period.
+func (ctrl *MaroonedPodsGateController) tearDownVirtualNode(pod *v1.Pod, vmiName string) (bool, error) {
+	key := fmt.Sprintf("%s/%s", pod.Namespace, vmiName)
+	vmiObj, exist, err := ctrl.vmiInformer.GetStore().GetByKey(key)
+	if err != nil {
+		klog.Errorf("Failed to fetch VMI for pod %s: %v", key, err)
+		return false, err
+	}
+
+	if exist {
+		vmi := vmiObj.(*virtv1.VirtualMachineInstance)
+		if vmi.DeletionTimestamp == nil {
+			klog.Infof("Shutting down VMI %s/%s for pod %s", vmi.Namespace, vmi.Name, pod.Name)
+			if err := ctrl.deleteVirtualNode(vmi.Namespace, vmi.Name); err != nil {
+				klog.Errorf("Failed to delete VMI %s/%s: %v", vmi.Namespace, vmi.Name, err)
+				return false, err
+			}
+			ctrl.recorder.Eventf(pod, v1.EventTypeNormal, "VMIDeleted", "Shutting down VMI %s for marooned pod", vmi.Name)
+		}
+		return false, nil
+	}
+
+	// A VirtualMachine may be restarting the VMI
+	klog.V(3).Infof("No VMI found for pod %s, deleting its VirtualMachine if any", key)
+	if err := ctrl.deleteVirtualNode(pod.Namespace, vmiName); err != nil {
+		klog.Errorf("Failed to delete VirtualMachine of pod %s: %v", key, err)
+		return false, err
+	}
+
+	if err := ctrl.deleteNetworkPolicy(pod.Namespace, vmiName); err != nil {
+		klog.Errorf("Failed to clean up network isolation of VMI %s: %v", key, err)
+		return false, err
+	}
+	if err := ctrl.deleteRegistriesSecret(pod.Namespace, vmiName); err != nil {
+		klog.Errorf("Failed to clean up the registries of VMI %s: %v", key, err)
+		return false, err
+	}
+	return true, ctrl.deleteNode(vmiName)
+}
@@ -0,0 +1,145 @@
+package mp_controller
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	virtv1 "kubevirt.io/api/core/v1"
+	"maroonedpods.io/maroonedpods/pkg/util"
+)
+
+var _ = Describe("Graceful termination", func() {
+	newDeletedPod := func(deadline time.Duration, running bool) *v1.Pod {
+		pod := newPod("web-1")
+		pod.Finalizers = []string{util.MaroonedPodsFinalizer}
+		pod.Spec.NodeName = "web-1"
+		deletionTimestamp := metav1.NewTime(time.Now().Add(deadline))
+		pod.DeletionTimestamp = &deletionTimestamp
+		state := v1.ContainerState{Terminated: &v1.ContainerStateTerminated{ExitCode: 0}}
+		if running {
+			state = v1.ContainerState{Running: &v1.ContainerStateRunning{}}
+		}
+		pod.Status.ContainerStatuses = []v1.ContainerStatus{{Name: "web", State: state}}
+		return pod
+	}
+	newRunningVMI := func() *virtv1.VirtualMachineInstance {
+		vmi := virtv1.NewVMIReferenceFromNameWithNS("tenant", "web-1")
+		vmi.Labels = map[string]string{util.MaroonedVMILabel: "web-1"}
+		vmi.Status.Phase = virtv1.Running
+		return vmi
+	}
+	vmiExists := func(cli *fakeMaroonedPodsClient) bool {
+		vmis, err := cli.KubevirtClient().KubevirtV1().VirtualMachineInstances("tenant").List(context.Background(), metav1.ListOptions{})
+		Expect(err).ToNot(HaveOccurred())
+		return len(vmis.Items) > 0
+	}
+	podFinalizers := func(cli *fakeMaroonedPodsClient) []string {
+		pod, err := cli.CoreV1().Pods("tenant").Get(context.Background(), "web-1", metav1.GetOptions{})
+		Expect(err).ToNot(HaveOccurred())
+		return pod.Finalizers
+	}
+	setup := func(pod *v1.Pod, vmi *virtv1.VirtualMachineInstance) (*MaroonedPodsGateController, *fakeMaroonedPodsClient) {
+		ctrl, cli := newTestController(nil, pod)
+		if vmi != nil {
+			Expect(ctrl.vmiInformer.GetStore().Add(vmi)).To(Succeed())
+			_, err := cli.KubevirtClient().KubevirtV1().VirtualMachineInstances("tenant").Create(context.Background(), vmi, metav1.CreateOptions{})
+			Expect(err).ToNot(HaveOccurred())
+		}
+		return ctrl, cli
+	}
+
+	It("should give the VMI the grace period of the pod", func() {
+		ctrl, _ := newTestController(nil)
+		pod := newPod("web-1")
+		vmi, err := ctrl.createVMIFromPod(pod)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(*vmi.Spec.TerminationGracePeriodSeconds).To(Equal(int64(v1.DefaultTerminationGracePeriodSeconds)))
+
+		gracePeriod := int64(120)
+		pod.Spec.TerminationGracePeriodSeconds = &gracePeriod
+		vmi, err = ctrl.createVMIFromPod(pod)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(*vmi.Spec.TerminationGracePeriodSeconds).To(Equal(gracePeriod))
+	})
+
+	DescribeTable("should tell when the guest containers terminated", func(mutate func(*v1.Pod), terminated bool) {
+		pod := newDeletedPod(time.Minute, true)
+		mutate(pod)
+		Expect(guestContainersTerminated(pod)).To(Equal(terminated))
+	},
+		Entry("running container", func(pod *v1.Pod) {}, false),
+		Entry("running init container", func(pod *v1.Pod) {
+			pod.Status.ContainerStatuses = nil
+			pod.Status.InitContainerStatuses = []v1.ContainerStatus{{Name: "init", State: v1.ContainerState{Running: &v1.ContainerStateRunning{}}}}
+		}, false),
+		Entry("terminated containers", func(pod *v1.Pod) {
+			pod.Status.ContainerStatuses[0].State = v1.ContainerState{Terminated: &v1.ContainerStateTerminated{}}
+		}, true),
+		Entry("never scheduled", func(pod *v1.Pod) { pod.Spec.NodeName = "" }, true),
+		Entry("completed pod", func(pod *v1.Pod) { pod.Status.Phase = v1.PodSucceeded }, true),
+		Entry("deleted by the kubelet", func(pod *v1.Pod) {
+			gracePeriod := int64(0)
+			pod.DeletionGracePeriodSeconds = &gracePeriod
+		}, true),
+	)
+
+	It("should keep the VM while the containers terminate", func() {
+		pod := newDeletedPod(time.Minute, true)
+		ctrl, cli := setup(pod, newRunningVMI())
+
+		err, state := ctrl.handlePodDeletion(pod, "tenant/web-1")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(state).To(Equal(Forget))
+		Expect(vmiExists(cli)).To(BeTrue())
+		Expect(podFinalizers(cli)).To(ContainElement(util.MaroonedPodsFinalizer))
+	})
+
+	It("should shut the VM down once the grace period expired", func() {
+		pod := newDeletedPod(-time.Second, true)
+		ctrl, cli := setup(pod, newRunningVMI())
+
+		err, state := ctrl.handlePodDeletion(pod, "tenant/web-1")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(state).To(Equal(Forget))
+		Expect(vmiExists(cli)).To(BeFalse())
+		Expect(podFinalizers(cli)).To(ContainElement(util.MaroonedPodsFinalizer))
+	})
+
+	It("should keep the finalizer until the VM shut down", func() {
+		pod := newDeletedPod(time.Minute, false)
+		vmi := newRunningVMI()
+		now := metav1.Now()
+		vmi.DeletionTimestamp = &now
+		ctrl, cli := setup(pod, vmi)
+
+		err, state := ctrl.handlePodDeletion(pod, "tenant/web-1")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(state).To(Equal(Forget))
+		Expect(podFinalizers(cli)).To(ContainElement(util.MaroonedPodsFinalizer))
+
+		Expect(ctrl.vmiInformer.GetStore().Delete(vmi)).To(Succeed())
+		err, state = ctrl.handlePodDeletion(pod, "tenant/web-1")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(state).To(Equal(Forget))
+		Expect(podFinalizers(cli)).ToNot(ContainElement(util.MaroonedPodsFinalizer))
+	})
+
+	It("should requeue the pod of a deleted VMI", func() {
+		ctrl, _ := newTestController(nil)
+		ctrl.deleteVMI(newRunningVMI())
+		Expect(ctrl.queue.Len()).To(Equal(1))
+		key, _ := ctrl.queue.Get()
+		Expect(key).To(Equal("tenant/web-1"))
+
+		poolVMI := newRunningVMI()
+		poolVMI.Labels[util.WarmPoolStateLabel] = util.PoolStateAvailable
+		ctrl.deleteVMI(poolVMI)
+		ctrl.deleteVMI(virtv1.NewVMIReferenceFromNameWithNS("tenant", "other"))
+		Expect(ctrl.queue.Len()).To(Equal(0))
+	})
+})
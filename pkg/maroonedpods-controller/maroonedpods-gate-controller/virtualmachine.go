@@ -0,0 +1,141 @@
+package mp_controller
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"k8s.io/apimachinery/pkg/api/errors"
+	k8smetav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+	virtv1 "kubevirt.io/api/core/v1"
+	"maroonedpods.io/maroonedpods/pkg/util"
+)
+
+// getRunStrategy returns the run strategy of the VirtualMachines backing virtual nodes,
+// or nil when virtual nodes run as bare VMIs
+func (ctrl *MaroonedPodsGateController) getRunStrategy() *virtv1.VirtualMachineRunStrategy {
+	config := ctrl.getConfig()
+	if config == nil || config.Spec.RunStrategy == "" {
+		return nil
+	}
+	strategy := virtv1.VirtualMachineRunStrategy(config.Spec.RunStrategy)
+	return &strategy
+}
+
+// createVirtualNode creates the VMI, or a VirtualMachine running it when a run strategy is configured.
+// The VirtualMachine takes over the owner of the VMI, so it is garbage collected with the pod
+// even if the finalizer is removed behind the controller's back.
+func (ctrl *MaroonedPodsGateController) createVirtualNode(vmi *virtv1.VirtualMachineInstance) (*virtv1.VirtualMachineInstance, error) {
+	runStrategy := ctrl.getRunStrategy()
+	if runStrategy == nil {
+		return ctrl.maroonedpodsCli.KubevirtClient().KubevirtV1().VirtualMachineInstances(vmi.Namespace).Create(context.Background(), vmi, k8smetav1.CreateOptions{})
+	}
+
+	vm := newVirtualMachine(vmi, *runStrategy)
+	_, err := ctrl.maroonedpodsCli.KubevirtClient().KubevirtV1().VirtualMachines(vm.Namespace).Create(context.Background(), vm, k8smetav1.CreateOptions{})
+	if err != nil && !errors.IsAlreadyExists(err) {
+		return nil, err
+	}
+	klog.Infof("VirtualMachine %s/%s runs VMI with run strategy %s", vm.Namespace, vm.Name, *runStrategy)
+	return vmi, nil
+}
+
+// newVirtualMachine returns a VirtualMachine running the VMI.
+// KubeVirt makes the VirtualMachine the controller of the VMI, so the owner of the VMI moves to the VirtualMachine.
+func newVirtualMachine(vmi *virtv1.VirtualMachineInstance, runStrategy virtv1.VirtualMachineRunStrategy) *virtv1.VirtualMachine {
+	labels := map[string]string{}
+	for key, value := range vmi.Labels {
+		labels[key] = value
+	}
+	return &virtv1.VirtualMachine{
+		TypeMeta: k8smetav1.TypeMeta{
+			APIVersion: virtv1.GroupVersion.String(),
+			Kind:       "VirtualMachine",
+		},
+		ObjectMeta: k8smetav1.ObjectMeta{
+			Name:            vmi.Name,
+			Namespace:       vmi.Namespace,
+			Labels:          labels,
+			OwnerReferences: vmi.OwnerReferences,
+		},
+		Spec: virtv1.VirtualMachineSpec{
+			RunStrategy: &runStrategy,
+			Template: &virtv1.VirtualMachineInstanceTemplateSpec{
+				ObjectMeta: k8smetav1.ObjectMeta{
+					Labels:      vmi.Labels,
+					Annotations: vmi.Annotations,
+				},
+				Spec: vmi.Spec,
+			},
+		},
+	}
+}
+
+// vmiVirtualMachine returns the name of the VirtualMachine controlling the VMI, if any
+func vmiVirtualMachine(vmi *virtv1.VirtualMachineInstance) string {
+	if owner := k8smetav1.GetControllerOf(vmi); owner != nil && owner.Kind == "VirtualMachine" {
+		return owner.Name
+	}
+	return ""
+}
+
+// deleteVirtualNode deletes the virtual node VMI, through its VirtualMachine when it has one.
+// Deleting only the VMI of a VirtualMachine would get it restarted.
+// Without a cached VMI a VirtualMachine of the same name may be restarting it, so that is deleted.
+func (ctrl *MaroonedPodsGateController) deleteVirtualNode(namespace, name string) error {
+	kubevirtClient := ctrl.maroonedpodsCli.KubevirtClient().KubevirtV1()
+	obj, exists, err := ctrl.vmiInformer.GetStore().GetByKey(fmt.Sprintf("%s/%s", namespace, name))
+	if err != nil {
+		return err
+	}
+	if !exists {
+		err = kubevirtClient.VirtualMachines(namespace).Delete(context.Background(), name, k8smetav1.DeleteOptions{})
+	} else if vmName := vmiVirtualMachine(obj.(*virtv1.VirtualMachineInstance)); vmName != "" {
+		err = kubevirtClient.VirtualMachines(namespace).Delete(context.Background(), vmName, k8smetav1.DeleteOptions{})
+	} else {
+		err = kubevirtClient.VirtualMachineInstances(namespace).Delete(context.Background(), name, k8smetav1.DeleteOptions{})
+	}
+	if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+// syncPoolStateLabels copies the pool state of the VMI to the template of its VirtualMachine,
+// so a restarted pool VMI keeps its state
+func (ctrl *MaroonedPodsGateController) syncPoolStateLabels(vmi *virtv1.VirtualMachineInstance) error {
+	vmName := vmiVirtualMachine(vmi)
+	if vmName == "" {
+		return nil
+	}
+	vms := ctrl.maroonedpodsCli.KubevirtClient().KubevirtV1().VirtualMachines(vmi.Namespace)
+	vm, err := vms.Get(context.Background(), vmName, k8smetav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	if vm.Spec.Template.ObjectMeta.Labels == nil {
+		vm.Spec.Template.ObjectMeta.Labels = make(map[string]string)
+	}
+	templateLabels := vm.Spec.Template.ObjectMeta.Labels
+	for _, label := range []string{util.WarmPoolStateLabel, util.WarmPoolClaimedByLabel, util.WarmPoolClaimedByNamespaceLabel} {
+		if value, ok := vmi.Labels[label]; ok {
+			templateLabels[label] = value
+		} else {
+			delete(templateLabels, label)
+		}
+	}
+	_, err = vms.Update(context.Background(), vm, k8smetav1.UpdateOptions{})
+	return err
+}
+
+// generateNodePassword returns the k3s node password of a virtual node.
+// k3s only lets a node rejoin under its name with the password it registered with, and the
+// root disk of a restarted VMI starts out empty, so the password is kept in the VM spec.
+func generateNodePassword() (string, error) {
+	password := make([]byte, 16)
+	if _, err := rand.Read(password); err != nil {
+		return "", fmt.Errorf("failed to generate node password: %v", err)
+	}
+	return hex.EncodeToString(password), nil
+}
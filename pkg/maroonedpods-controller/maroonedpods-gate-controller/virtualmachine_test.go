@@ -0,0 +1,126 @@
+package mp_controller
+
+import (
+	"context"
+	"encoding/base64"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	virtv1 "kubevirt.io/api/core/v1"
+	"maroonedpods.io/maroonedpods/pkg/util"
+	v1alpha1 "maroonedpods.io/maroonedpods/staging/src/maroonedpods.io/api/pkg/apis/core/v1alpha1"
+)
+
+var _ = Describe("VirtualMachine backing", func() {
+	rerunConfig := &v1alpha1.MaroonedPodsConfig{
+		ObjectMeta: metav1.ObjectMeta{Name: "default"},
+		Spec:       v1alpha1.MaroonedPodsConfigSpec{RunStrategy: v1alpha1.RunStrategyRerunOnFailure},
+	}
+
+	newVMOwnedVMI := func(name string) *virtv1.VirtualMachineInstance {
+		isController := true
+		return &virtv1.VirtualMachineInstance{ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "tenant",
+			Labels:    map[string]string{util.WarmPoolStateLabel: util.PoolStateClaimed, util.WarmPoolClaimedByLabel: "web-1"},
+			OwnerReferences: []metav1.OwnerReference{{
+				APIVersion: virtv1.GroupVersion.String(), Kind: "VirtualMachine", Name: name, Controller: &isController,
+			}},
+		}}
+	}
+	vmExists := func(cli *fakeMaroonedPodsClient, name string) bool {
+		_, err := cli.KubevirtClient().KubevirtV1().VirtualMachines("tenant").Get(context.Background(), name, metav1.GetOptions{})
+		if errors.IsNotFound(err) {
+			return false
+		}
+		Expect(err).ToNot(HaveOccurred())
+		return true
+	}
+
+	It("should create a VirtualMachine owned by the pod", func() {
+		ctrl, cli := newTestController(rerunConfig)
+		pod := newPod("web-1")
+		Expect(ctrl.sync(pod, nil, "tenant/web-1")).To(MatchError(ContainSubstring("waiting for VMI web-1")))
+
+		vm, err := cli.KubevirtClient().KubevirtV1().VirtualMachines("tenant").Get(context.Background(), "web-1", metav1.GetOptions{})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(*vm.Spec.RunStrategy).To(Equal(virtv1.RunStrategyRerunOnFailure))
+		Expect(vm.OwnerReferences).To(ConsistOf(HaveField("UID", pod.UID)))
+		Expect(vm.Spec.Template.ObjectMeta.Labels).To(HaveKeyWithValue(util.MaroonedVMILabel, "web-1"))
+		Expect(vm.Spec.Template.Spec.Domain.Devices.Disks).ToNot(BeEmpty())
+
+		vmis, err := cli.KubevirtClient().KubevirtV1().VirtualMachineInstances("tenant").List(context.Background(), metav1.ListOptions{})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(vmis.Items).To(BeEmpty())
+
+		// The VirtualMachine already exists while KubeVirt starts its VMI
+		Expect(ctrl.sync(pod, nil, "tenant/web-1")).To(MatchError(ContainSubstring("waiting for VMI web-1")))
+	})
+
+	It("should give the node a password to rejoin with", func() {
+		ctrl, _ := newTestController(nil)
+		vmi, err := ctrl.createVMIFromPod(newPod("web-1"))
+		Expect(err).ToNot(HaveOccurred())
+		var userData string
+		for _, volume := range vmi.Spec.Volumes {
+			if volume.CloudInitNoCloud != nil {
+				decoded, err := base64.StdEncoding.DecodeString(volume.CloudInitNoCloud.UserDataBase64)
+				Expect(err).ToNot(HaveOccurred())
+				userData = string(decoded)
+			}
+		}
+		Expect(userData).To(MatchRegexp(`node_password: [0-9a-f]{32}\n`))
+	})
+
+	It("should delete the VirtualMachine of a VMI rather than the VMI", func() {
+		vmi := newVMOwnedVMI("web-1")
+		ctrl, cli := newTestController(nil)
+		Expect(ctrl.vmiInformer.GetStore().Add(vmi)).To(Succeed())
+		_, err := cli.KubevirtClient().KubevirtV1().VirtualMachineInstances("tenant").Create(context.Background(), vmi, metav1.CreateOptions{})
+		Expect(err).ToNot(HaveOccurred())
+		_, err = cli.KubevirtClient().KubevirtV1().VirtualMachines("tenant").Create(context.Background(), newVirtualMachine(vmi, virtv1.RunStrategyAlways), metav1.CreateOptions{})
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(ctrl.deleteVirtualNode("tenant", "web-1")).To(Succeed())
+		Expect(vmExists(cli, "web-1")).To(BeFalse())
+	})
+
+	It("should delete the VirtualMachine of a restarting VMI", func() {
+		ctrl, cli := newTestController(nil)
+		_, err := cli.KubevirtClient().KubevirtV1().VirtualMachines("tenant").Create(context.Background(), newVirtualMachine(newVMOwnedVMI("web-1"), virtv1.RunStrategyAlways), metav1.CreateOptions{})
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(ctrl.deleteVirtualNode("tenant", "web-1")).To(Succeed())
+		Expect(vmExists(cli, "web-1")).To(BeFalse())
+		Expect(ctrl.deleteVirtualNode("tenant", "web-1")).To(Succeed())
+	})
+
+	It("should keep the pool state of restarted VMIs in the VirtualMachine template", func() {
+		vmi := newVMOwnedVMI("maroonedpods-pool-abc")
+		ctrl, cli := newTestController(nil)
+		vm := newVirtualMachine(vmi, virtv1.RunStrategyAlways)
+		vm.Spec.Template.ObjectMeta.Labels = map[string]string{util.WarmPoolStateLabel: util.PoolStateCreating}
+		_, err := cli.KubevirtClient().KubevirtV1().VirtualMachines("tenant").Create(context.Background(), vm, metav1.CreateOptions{})
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(ctrl.syncPoolStateLabels(vmi)).To(Succeed())
+		updated, err := cli.KubevirtClient().KubevirtV1().VirtualMachines("tenant").Get(context.Background(), vmi.Name, metav1.GetOptions{})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(updated.Spec.Template.ObjectMeta.Labels).To(Equal(vmi.Labels))
+
+		delete(vmi.Labels, util.WarmPoolClaimedByLabel)
+		vmi.Labels[util.WarmPoolStateLabel] = util.PoolStateAvailable
+		Expect(ctrl.syncPoolStateLabels(vmi)).To(Succeed())
+		updated, err = cli.KubevirtClient().KubevirtV1().VirtualMachines("tenant").Get(context.Background(), vmi.Name, metav1.GetOptions{})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(updated.Spec.Template.ObjectMeta.Labels).To(Equal(map[string]string{util.WarmPoolStateLabel: util.PoolStateAvailable}))
+	})
+
+	It("should leave bare VMIs alone when syncing pool state", func() {
+		ctrl, _ := newTestController(nil)
+		Expect(ctrl.syncPoolStateLabels(&virtv1.VirtualMachineInstance{ObjectMeta: metav1.ObjectMeta{Name: "pool", Namespace: "tenant"}})).To(Succeed())
+	})
+})
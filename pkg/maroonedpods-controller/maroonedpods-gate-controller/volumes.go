@@ -0,0 +1,83 @@
+package mp_controller
+
+import (
+	"fmt"
+	v1 "k8s.io/api/core/v1"
+	virtv1 "kubevirt.io/api/core/v1"
+	"maroonedpods.io/maroonedpods/pkg/util"
+	"strings"
+)
+
+// podVolumes builds the devices and volumes passing the PVC, ConfigMap and Secret volumes
+// recorded on the pod through to its virtual node VMI. Block PVCs become virtio disks found
+// by serial in the guest, everything else is shared with virtiofs and mounted by the returned
+// cloud-init script at the path the webhook pointed the pod volume to.
+func podVolumes(pod *v1.Pod) ([]virtv1.Disk, []virtv1.Filesystem, []virtv1.Volume, string, error) {
+	passthroughVolumes, err := util.GetPassthroughVolumes(pod)
+	if err != nil {
+		return nil, nil, nil, "", err
+	}
+
+	var disks []virtv1.Disk
+	var filesystems []virtv1.Filesystem
+	var volumes []virtv1.Volume
+	var mountScript strings.Builder
+	for _, volume := range passthroughVolumes {
+		name := volume.VMIVolumeName()
+		switch {
+		case volume.PersistentVolumeClaim != nil:
+			volumes = append(volumes, virtv1.Volume{
+				Name: name,
+				VolumeSource: virtv1.VolumeSource{
+					PersistentVolumeClaim: &virtv1.PersistentVolumeClaimVolumeSource{
+						PersistentVolumeClaimVolumeSource: *volume.PersistentVolumeClaim,
+					},
+				},
+			})
+		case volume.ConfigMap != nil:
+			volumes = append(volumes, virtv1.Volume{
+				Name: name,
+				VolumeSource: virtv1.VolumeSource{
+					ConfigMap: &virtv1.ConfigMapVolumeSource{
+						LocalObjectReference: volume.ConfigMap.LocalObjectReference,
+						Optional:             volume.ConfigMap.Optional,
+					},
+				},
+			})
+		case volume.Secret != nil:
+			volumes = append(volumes, virtv1.Volume{
+				Name: name,
+				VolumeSource: virtv1.VolumeSource{
+					Secret: &virtv1.SecretVolumeSource{
+						SecretName: volume.Secret.SecretName,
+						Optional:   volume.Secret.Optional,
+					},
+				},
+			})
+		default:
+			return nil, nil, nil, "", fmt.Errorf("volume %s can't be passed through to the VMI", volume.Name)
+		}
+
+		if volume.Block {
+			disks = append(disks, virtv1.Disk{
+				Name:   name,
+				Serial: volume.DiskSerial(),
+				DiskDevice: virtv1.DiskDevice{
+					Disk: &virtv1.DiskTarget{Bus: virtv1.DiskBusVirtio}},
+			})
+			continue
+		}
+		filesystems = append(filesystems, virtv1.Filesystem{
+			Name:     name,
+			Virtiofs: &virtv1.FilesystemVirtiofs{},
+		})
+		fmt.Fprintf(&mountScript, "mkdir -p %s\n", volume.GuestPath())
+		fmt.Fprintf(&mountScript, "echo '%s %s virtiofs defaults,nofail 0 0' >> /etc/fstab\n", name, volume.GuestPath())
+		fmt.Fprintf(&mountScript, "mount %s\n", volume.GuestPath())
+	}
+
+	if mountScript.Len() > 0 {
+		return disks, filesystems, volumes, "\n# Mount the pod volumes shared with virtiofs\n" + mountScript.String(), nil
+	}
+	return disks, filesystems, volumes, mountScript.String(), nil
+}
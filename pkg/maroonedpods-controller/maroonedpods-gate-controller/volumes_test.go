@@ -0,0 +1,105 @@
+package mp_controller
+
+import (
+	"encoding/base64"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	v1 "k8s.io/api/core/v1"
+	"maroonedpods.io/maroonedpods/pkg/util"
+)
+
+var _ = Describe("Volume passthrough", func() {
+	pvcVolume := func(name, claimName string) v1.Volume {
+		return v1.Volume{Name: name, VolumeSource: v1.VolumeSource{
+			PersistentVolumeClaim: &v1.PersistentVolumeClaimVolumeSource{ClaimName: claimName},
+		}}
+	}
+
+	It("should not attach anything without the annotation", func() {
+		disks, filesystems, volumes, mountScript, err := podVolumes(newPod("web"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(disks).To(BeEmpty())
+		Expect(filesystems).To(BeEmpty())
+		Expect(volumes).To(BeEmpty())
+		Expect(mountScript).To(BeEmpty())
+	})
+
+	It("should share filesystem volumes with virtiofs", func() {
+		optional := true
+		pod := newPod("db", withVolumes([]v1.Volume{
+			pvcVolume("data", "data-claim"),
+			{Name: "settings", VolumeSource: v1.VolumeSource{ConfigMap: &v1.ConfigMapVolumeSource{
+				LocalObjectReference: v1.LocalObjectReference{Name: "settings"},
+			}}},
+			{Name: "credentials", VolumeSource: v1.VolumeSource{Secret: &v1.SecretVolumeSource{
+				SecretName: "credentials", Optional: &optional,
+			}}},
+		}, nil))
+
+		disks, filesystems, volumes, mountScript, err := podVolumes(pod)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(disks).To(BeEmpty())
+		Expect(filesystems).To(HaveLen(3))
+		for _, filesystem := range filesystems {
+			Expect(filesystem.Virtiofs).ToNot(BeNil())
+		}
+		Expect(volumes).To(HaveLen(3))
+		Expect(volumes[0].Name).To(Equal("pod-data"))
+		Expect(volumes[0].PersistentVolumeClaim.ClaimName).To(Equal("data-claim"))
+		Expect(volumes[1].ConfigMap.Name).To(Equal("settings"))
+		Expect(volumes[2].Secret.SecretName).To(Equal("credentials"))
+		Expect(volumes[2].Secret.Optional).To(HaveValue(BeTrue()))
+
+		Expect(mountScript).To(ContainSubstring("mkdir -p /var/lib/marooned/volumes/data\n"))
+		Expect(mountScript).To(ContainSubstring("'pod-data /var/lib/marooned/volumes/data virtiofs defaults,nofail 0 0' >> /etc/fstab"))
+		Expect(mountScript).To(ContainSubstring("mount /var/lib/marooned/volumes/credentials\n"))
+	})
+
+	It("should attach block PVCs as disks identified by serial", func() {
+		pod := newPod("db", withVolumes(
+			[]v1.Volume{pvcVolume("raw", "raw-claim")},
+			[]v1.VolumeDevice{{Name: "raw", DevicePath: "/dev/xvda"}},
+		))
+
+		disks, filesystems, volumes, mountScript, err := podVolumes(pod)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(filesystems).To(BeEmpty())
+		Expect(mountScript).To(BeEmpty())
+		Expect(volumes).To(HaveLen(1))
+		Expect(disks).To(HaveLen(1))
+		Expect(disks[0].Name).To(Equal("pod-raw"))
+		Expect(disks[0].Serial).To(HavePrefix("mp-"))
+		Expect(disks[0].Serial).To(HaveLen(20))
+
+		passthroughVolumes, err := util.GetPassthroughVolumes(pod)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(passthroughVolumes[0].GuestPath()).To(Equal("/dev/disk/by-id/virtio-" + disks[0].Serial))
+	})
+
+	It("should reject an invalid annotation", func() {
+		pod := newPod("web")
+		pod.Annotations = map[string]string{util.PassthroughVolumesAnnotation: "not json"}
+		_, _, _, _, err := podVolumes(pod)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should add the volumes and mounts to the VMI", func() {
+		ctrl, _ := newTestController(nil)
+		vmi, err := ctrl.createVMIFromPod(newPod("db", withVolumes([]v1.Volume{pvcVolume("data", "data-claim")}, nil)))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(vmi.Spec.Domain.Devices.Filesystems).To(ContainElement(HaveField("Name", "pod-data")))
+		Expect(vmi.Spec.Volumes).To(ContainElement(HaveField("Name", "pod-data")))
+
+		var userData string
+		for _, volume := range vmi.Spec.Volumes {
+			if volume.CloudInitNoCloud != nil {
+				decoded, err := base64.StdEncoding.DecodeString(volume.CloudInitNoCloud.UserDataBase64)
+				Expect(err).ToNot(HaveOccurred())
+				userData = string(decoded)
+			}
+		}
+		Expect(userData).To(ContainSubstring("mount /var/lib/marooned/volumes/data"))
+	})
+})
@@ -0,0 +1,117 @@
+package mp_controller
+
+import (
+	"context"
+	"fmt"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	k8smetav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+	virtv1 "kubevirt.io/api/core/v1"
+	"maroonedpods.io/maroonedpods/pkg/util"
+)
+
+// Suffix of the PVC holding the scratch disk of a StatefulSet pod
+const statefulSetDiskSuffix = "-marooned-scratch"
+
+// podControllerOf returns the controller of the pod if it is of the given kind
+func podControllerOf(pod *v1.Pod, kind string) *k8smetav1.OwnerReference {
+	if owner := k8smetav1.GetControllerOf(pod); owner != nil && owner.Kind == kind {
+		return owner
+	}
+	return nil
+}
+
+// isPodTerminal checks whether all containers of the pod ran to completion, as the pods of Jobs do
+func isPodTerminal(pod *v1.Pod) bool {
+	return pod.Status.Phase == v1.PodSucceeded || pod.Status.Phase == v1.PodFailed
+}
+
+// scratchDiskVolumeSource returns the volume of the scratch disk of the pod.
+// A StatefulSet pod keeps its scratch disk on a PVC named after the pod when configured, so the
+// VM of the pod recreated with the same ordinal finds the images and kubelet data it left behind.
+func (ctrl *MaroonedPodsGateController) scratchDiskVolumeSource(pod *v1.Pod, size resource.Quantity) (virtv1.VolumeSource, error) {
+	config := ctrl.getConfig()
+	statefulSet := podControllerOf(pod, "StatefulSet")
+	if config == nil || config.Spec.StatefulSetDisk == nil || statefulSet == nil {
+		return virtv1.VolumeSource{EmptyDisk: &virtv1.EmptyDiskSource{Capacity: size}}, nil
+	}
+
+	claimName := pod.Name + statefulSetDiskSuffix
+	pvc := &v1.PersistentVolumeClaim{
+		ObjectMeta: k8smetav1.ObjectMeta{
+			Name:      claimName,
+			Namespace: pod.Namespace,
+			Labels:    map[string]string{util.MaroonedVMILabel: pod.Name},
+			// Removed with the StatefulSet rather than the pod
+			OwnerReferences: []k8smetav1.OwnerReference{controllerReference(
+				statefulSet.APIVersion, statefulSet.Kind, statefulSet.Name, statefulSet.UID)},
+		},
+		Spec: v1.PersistentVolumeClaimSpec{
+			AccessModes:      []v1.PersistentVolumeAccessMode{v1.ReadWriteOnce},
+			StorageClassName: config.Spec.StatefulSetDisk.StorageClassName,
+			Resources: v1.ResourceRequirements{
+				Requests: v1.ResourceList{v1.ResourceStorage: size},
+			},
+		},
+	}
+	_, err := ctrl.maroonedpodsCli.CoreV1().PersistentVolumeClaims(pod.Namespace).Create(context.Background(), pvc, k8smetav1.CreateOptions{})
+	if errors.IsAlreadyExists(err) {
+		klog.V(3).Infof("Reusing scratch disk %s/%s of StatefulSet pod %s", pod.Namespace, claimName, pod.Name)
+	} else if err != nil {
+		return virtv1.VolumeSource{}, fmt.Errorf("failed to create scratch disk PVC %s/%s: %v", pod.Namespace, claimName, err)
+	}
+	return virtv1.VolumeSource{
+		PersistentVolumeClaim: &virtv1.PersistentVolumeClaimVolumeSource{
+			PersistentVolumeClaimVolumeSource: v1.PersistentVolumeClaimVolumeSource{ClaimName: claimName},
+		},
+	}, nil
+}
+
+// handlePodCompletion tears down the VM of a pod whose containers ran to completion.
+// The pod keeps its finalizer and status until it is deleted, by its Job or otherwise.
+func (ctrl *MaroonedPodsGateController) handlePodCompletion(pod *v1.Pod, key string) (error, enqueueState) {
+	done, err := ctrl.releaseVirtualNode(pod)
+	if err != nil {
+		return err, BackOff
+	}
+	if !done {
+		// Requeued by the deletion of the VMI
+		klog.V(2).Infof("Waiting for VMI %s/%s of completed pod to shut down", pod.Namespace, util.VirtualNodeName(pod))
+		return nil, Forget
+	}
+	return nil, Forget
+}
+
+// staleNode checks whether the node named after the pod was registered by the VM of an earlier pod
+// of the same name, as a recreated StatefulSet pod finds it.
+// The node of an island is registered by whichever member created the VM, so it is matched
+// with the firmware UUID of the VMI instead.
+func staleNode(node *v1.Node, pod *v1.Pod, vmi *virtv1.VirtualMachineInstance) bool {
+	if util.GetIsland(pod) != "" {
+		firmware := vmi.Spec.Domain.Firmware
+		return firmware != nil && node.Labels[util.MaroonedNodeVMUUIDLabel] != string(firmware.UUID)
+	}
+	podUID, ok := node.Labels[util.MaroonedNodePodUIDLabel]
+	return ok && podUID != string(pod.UID)
+}
+
+// deleteNode removes the node of a torn down VM, so a pod recreated under the same name doesn't find it
+// and the k3s server forgets the node password of the VM.
+// Only nodes registered by a marooned VM are deleted, never a host that happens to share the name of the pod.
+func (ctrl *MaroonedPodsGateController) deleteNode(name string) error {
+	obj, exists, err := ctrl.nodeInformer.GetStore().GetByKey(name)
+	if err != nil || !exists {
+		return err
+	}
+	if _, marooned := obj.(*v1.Node).Labels[util.MaroonedNodePodUIDLabel]; !marooned {
+		return nil
+	}
+	err = ctrl.maroonedpodsCli.CoreV1().Nodes().Delete(context.Background(), name, k8smetav1.DeleteOptions{})
+	if err != nil && !errors.IsNotFound(err) {
+		return fmt.Errorf("failed to delete node %s: %v", name, err)
+	}
+	klog.Infof("Deleted node %s of torn down VM", name)
+	return nil
+}
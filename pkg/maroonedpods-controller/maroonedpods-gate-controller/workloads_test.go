@@ -0,0 +1,152 @@
+package mp_controller
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	virtv1 "kubevirt.io/api/core/v1"
+	"maroonedpods.io/maroonedpods/pkg/util"
+	v1alpha1 "maroonedpods.io/maroonedpods/staging/src/maroonedpods.io/api/pkg/apis/core/v1alpha1"
+)
+
+var _ = Describe("Workload controllers", func() {
+	isController := true
+	newStatefulSetPod := func() *v1.Pod {
+		pod := newPod("db-0")
+		pod.OwnerReferences = []metav1.OwnerReference{{
+			APIVersion: "apps/v1", Kind: "StatefulSet", Name: "db", UID: "sts-uid", Controller: &isController,
+		}}
+		return pod
+	}
+	newMaroonedNode := func(name, podUID string) *v1.Node {
+		return &v1.Node{ObjectMeta: metav1.ObjectMeta{
+			Name:   name,
+			Labels: map[string]string{util.MaroonedNodePodUIDLabel: podUID},
+		}}
+	}
+	scratchVolume := func(vmi *virtv1.VirtualMachineInstance) virtv1.Volume {
+		for _, volume := range vmi.Spec.Volumes {
+			if volume.Name == scratchDiskName {
+				return volume
+			}
+		}
+		Fail("no scratch disk volume")
+		return virtv1.Volume{}
+	}
+	nodeExists := func(cli *fakeMaroonedPodsClient, name string) bool {
+		_, err := cli.CoreV1().Nodes().Get(context.Background(), name, metav1.GetOptions{})
+		if errors.IsNotFound(err) {
+			return false
+		}
+		Expect(err).ToNot(HaveOccurred())
+		return true
+	}
+
+	Context("StatefulSet scratch disks", func() {
+		storageClass := "fast"
+		config := &v1alpha1.MaroonedPodsConfig{
+			ObjectMeta: metav1.ObjectMeta{Name: "default"},
+			Spec: v1alpha1.MaroonedPodsConfigSpec{
+				StatefulSetDisk: &v1alpha1.StatefulSetDisk{StorageClassName: &storageClass},
+			},
+		}
+
+		It("should keep the scratch disk of StatefulSet pods on a PVC owned by the StatefulSet", func() {
+			ctrl, cli := newTestController(config)
+			vmi, err := ctrl.createVMIFromPod(newStatefulSetPod())
+			Expect(err).ToNot(HaveOccurred())
+
+			volume := scratchVolume(vmi)
+			Expect(volume.PersistentVolumeClaim).ToNot(BeNil())
+			Expect(volume.PersistentVolumeClaim.ClaimName).To(Equal("db-0-marooned-scratch"))
+
+			pvc, err := cli.CoreV1().PersistentVolumeClaims("tenant").Get(context.Background(), "db-0-marooned-scratch", metav1.GetOptions{})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(pvc.Spec.StorageClassName).To(HaveValue(Equal("fast")))
+			Expect(pvc.Spec.Resources.Requests).To(HaveKey(v1.ResourceStorage))
+			Expect(metav1.GetControllerOf(pvc)).To(HaveField("Kind", "StatefulSet"))
+			Expect(metav1.GetControllerOf(pvc)).To(HaveField("Name", "db"))
+		})
+
+		It("should reuse the scratch disk of the recreated pod", func() {
+			ctrl, cli := newTestController(config)
+			existing := &v1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Name: "db-0-marooned-scratch", Namespace: "tenant"}}
+			existing.Spec.Resources.Requests = v1.ResourceList{v1.ResourceStorage: resource.MustParse("50Gi")}
+			_, err := cli.CoreV1().PersistentVolumeClaims("tenant").Create(context.Background(), existing, metav1.CreateOptions{})
+			Expect(err).ToNot(HaveOccurred())
+
+			vmi, err := ctrl.createVMIFromPod(newStatefulSetPod())
+			Expect(err).ToNot(HaveOccurred())
+			Expect(scratchVolume(vmi).PersistentVolumeClaim.ClaimName).To(Equal("db-0-marooned-scratch"))
+		})
+
+		It("should give other pods an empty scratch disk", func() {
+			ctrl, _ := newTestController(config)
+			vmi, err := ctrl.createVMIFromPod(newPod("web-1"))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(scratchVolume(vmi).EmptyDisk).ToNot(BeNil())
+
+			ctrl, _ = newTestController(nil)
+			vmi, err = ctrl.createVMIFromPod(newStatefulSetPod())
+			Expect(err).ToNot(HaveOccurred())
+			Expect(scratchVolume(vmi).EmptyDisk).ToNot(BeNil())
+		})
+	})
+
+	It("should replace the node left behind by an earlier pod of the same name", func() {
+		pod := newStatefulSetPod()
+		node := newMaroonedNode("db-0", "earlier-uid")
+		ctrl, cli := newTestController(nil, pod, node)
+		Expect(ctrl.nodeInformer.GetStore().Add(node)).To(Succeed())
+		vmi := virtv1.NewVMIReferenceFromNameWithNS("tenant", "db-0")
+		vmi.Status.Phase = virtv1.Running
+		Expect(ctrl.vmiInformer.GetStore().Add(vmi)).To(Succeed())
+
+		Expect(ctrl.sync(pod, vmi, "tenant/db-0")).To(MatchError(ContainSubstring("to register again")))
+		Expect(nodeExists(cli, "db-0")).To(BeFalse())
+	})
+
+	It("should tear down the VM of a completed Job pod", func() {
+		pod := newPod("job-abcde")
+		pod.Finalizers = []string{util.MaroonedPodsFinalizer}
+		pod.Status.Phase = v1.PodSucceeded
+		node := newMaroonedNode("job-abcde", string(pod.UID))
+		ctrl, cli := newTestController(nil, pod, node)
+		Expect(ctrl.nodeInformer.GetStore().Add(node)).To(Succeed())
+		vmi := virtv1.NewVMIReferenceFromNameWithNS("tenant", "job-abcde")
+		Expect(ctrl.vmiInformer.GetStore().Add(vmi)).To(Succeed())
+		_, err := cli.KubevirtClient().KubevirtV1().VirtualMachineInstances("tenant").Create(context.Background(), vmi, metav1.CreateOptions{})
+		Expect(err).ToNot(HaveOccurred())
+
+		err, state := ctrl.handlePodCompletion(pod, "tenant/job-abcde")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(state).To(Equal(Forget))
+		_, err = cli.KubevirtClient().KubevirtV1().VirtualMachineInstances("tenant").Get(context.Background(), "job-abcde", metav1.GetOptions{})
+		Expect(errors.IsNotFound(err)).To(BeTrue())
+
+		Expect(ctrl.vmiInformer.GetStore().Delete(vmi)).To(Succeed())
+		err, _ = ctrl.handlePodCompletion(pod, "tenant/job-abcde")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(nodeExists(cli, "job-abcde")).To(BeFalse())
+
+		// The pod keeps its status and finalizer until it is deleted
+		current, err := cli.CoreV1().Pods("tenant").Get(context.Background(), "job-abcde", metav1.GetOptions{})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(current.Finalizers).To(ContainElement(util.MaroonedPodsFinalizer))
+	})
+
+	It("should never delete a host sharing the name of a pod", func() {
+		host := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "worker-1"}}
+		ctrl, cli := newTestController(nil, host)
+		Expect(ctrl.nodeInformer.GetStore().Add(host)).To(Succeed())
+
+		Expect(ctrl.deleteNode("worker-1")).To(Succeed())
+		Expect(nodeExists(cli, "worker-1")).To(BeTrue())
+	})
+})
@@ -218,6 +218,9 @@ func (r *ReconcileMaroonedPods) getConfigMap() (*corev1.ConfigMap, error) {
 
 func (r *ReconcileMaroonedPods) getCertificateDefinitions(mp *v1alpha1.MaroonedPods) []mpcerts.CertificateDefinition {
 	args := &mpcerts.FactoryArgs{Namespace: r.namespace}
+	if mp != nil {
+		args.RegistryMirror = mp.Spec.RegistryMirror != nil
+	}
 
 	if mp != nil && mp.Spec.CertConfig != nil {
 		if mp.Spec.CertConfig.CA != nil {
@@ -72,6 +72,7 @@ func (r *ReconcileMaroonedPods) getNamespacedArgs(cr *mpv1.MaroonedPods) *mpname
 			result.PriorityClassName = ""
 		}
 		result.InfraNodePlacement = &cr.Spec.Infra
+		result.RegistryMirror = cr.Spec.RegistryMirror
 	}
 
 	return &result
@@ -10,7 +10,9 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 
 	"github.com/go-logr/logr"
+	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
+	"maroonedpods.io/maroonedpods/pkg/util"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 )
@@ -67,9 +69,42 @@ func (r *ReconcileMaroonedPods) sync(cr client.Object, logger logr.Logger) error
 	if mp.DeletionTimestamp != nil {
 		return nil
 	}
+	if mp.Spec.RegistryMirror == nil {
+		if err := r.deleteRegistryMirror(); err != nil {
+			return err
+		}
+	}
 	return r.certManager.Sync(r.getCertificateDefinitions(mp))
 }
 
+// deleteRegistryMirror removes the registry mirror and its certs once it is disabled,
+// unused resources are otherwise only cleaned up on upgrades
+func (r *ReconcileMaroonedPods) deleteRegistryMirror() error {
+	meta := func(name string) metav1.ObjectMeta {
+		return metav1.ObjectMeta{Name: name, Namespace: r.namespace}
+	}
+	objects := []client.Object{
+		&appsv1.Deployment{ObjectMeta: meta(util.RegistryMirrorResourceName)},
+		&corev1.Service{ObjectMeta: meta(util.RegistryMirrorResourceName)},
+		&corev1.ServiceAccount{ObjectMeta: meta(util.RegistryMirrorResourceName)},
+		&corev1.Secret{ObjectMeta: meta(util.RegistryMirrorResourceName)},
+		&corev1.Secret{ObjectMeta: meta(util.RegistryMirrorCertSecretName)},
+		&corev1.ConfigMap{ObjectMeta: meta(util.RegistryMirrorCABundleName)},
+	}
+	for _, obj := range objects {
+		if err := r.client.Get(context.TODO(), client.ObjectKeyFromObject(obj), obj); err != nil {
+			if errors.IsNotFound(err) {
+				continue
+			}
+			return err
+		}
+		if err := r.client.Delete(context.TODO(), obj); err != nil && !errors.IsNotFound(err) {
+			return fmt.Errorf("failed to delete registry mirror resource %s: %v", obj.GetName(), err)
+		}
+	}
+	return nil
+}
+
 func (r *ReconcileMaroonedPods) configMapOwnerDeleted(cm *corev1.ConfigMap) (bool, error) {
 	ownerRef := metav1.GetControllerOf(cm)
 	if ownerRef != nil {
@@ -19,6 +19,9 @@ type FactoryArgs struct {
 	TargetDuration *time.Duration
 	// Duration to subtract from cert NotAfter value
 	TargetRenewBefore *time.Duration
+
+	// Whether the registry mirror is deployed and needs a serving cert
+	RegistryMirror bool
 }
 
 // CertificateConfig contains cert configuration data
@@ -53,6 +56,9 @@ type CertificateDefinition struct {
 // CreateCertificateDefinitions creates certificate definitions
 func CreateCertificateDefinitions(args *FactoryArgs) []CertificateDefinition {
 	defs := createCertificateDefinitions()
+	if args.RegistryMirror {
+		defs = append(defs, createRegistryMirrorCertificateDefinition())
+	}
 	for i := range defs {
 		def := &defs[i]
 
@@ -118,6 +124,26 @@ func createCertificateDefinitions() []CertificateDefinition {
 	}
 }
 
+// createRegistryMirrorCertificateDefinition creates the serving cert of the registry mirror,
+// the guests of marooned VMs trust the CA bundle. Guests get the bundle when they boot,
+// so the signer outlives any VM and only the serving cert rotates.
+func createRegistryMirrorCertificateDefinition() CertificateDefinition {
+	return CertificateDefinition{
+		SignerSecret: createSecret(util.RegistryMirrorResourceName),
+		SignerConfig: CertificateConfig{
+			Lifetime: 10 * 365 * 24 * time.Hour,
+			Refresh:  9 * 365 * 24 * time.Hour,
+		},
+		CertBundleConfigmap: createConfigMap(util.RegistryMirrorCABundleName),
+		TargetSecret:        createSecret(util.RegistryMirrorCertSecretName),
+		TargetConfig: CertificateConfig{
+			Lifetime: 24 * time.Hour,
+			Refresh:  12 * time.Hour,
+		},
+		TargetService: &[]string{util.RegistryMirrorResourceName}[0],
+	}
+}
+
 func createSecret(name string) *corev1.Secret {
 	return &corev1.Secret{
 		ObjectMeta: metav1.ObjectMeta{
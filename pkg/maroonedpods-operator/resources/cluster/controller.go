@@ -39,7 +39,7 @@ func getControllerClusterPolicyRules() []rbacv1.PolicyRule {
                 "nodes",
             },
             Verbs: []string{
-                "get", "list", "watch", "update", "patch",
+                "get", "list", "watch", "update", "patch", "delete",
             },
         },
 		{
@@ -55,6 +55,7 @@ func getControllerClusterPolicyRules() []rbacv1.PolicyRule {
 				"watch",
 				"get",
                 "patch",
+				"delete",
 			},
 		},
 		{
@@ -68,6 +69,69 @@ func getControllerClusterPolicyRules() []rbacv1.PolicyRule {
 				"list",
 				"watch",
 				"get",
+				"create",
+			},
+		},
+		{
+			APIGroups: []string{
+				"",
+			},
+			Resources: []string{
+				"endpoints",
+			},
+			Verbs: []string{
+				"get",
+			},
+		},
+		{
+			APIGroups: []string{
+				"",
+			},
+			Resources: []string{
+				"secrets",
+			},
+			Verbs: []string{
+				"get",
+				"create",
+				"update",
+				"delete",
+			},
+		},
+		{
+			APIGroups: []string{
+				"",
+			},
+			Resources: []string{
+				"serviceaccounts",
+			},
+			Verbs: []string{
+				"get",
+			},
+		},
+		{
+			APIGroups: []string{
+				"",
+			},
+			Resources: []string{
+				"namespaces",
+			},
+			Verbs: []string{
+				"get",
+				"list",
+				"watch",
+			},
+		},
+		{
+			APIGroups: []string{
+				"networking.k8s.io",
+			},
+			Resources: []string{
+				"networkpolicies",
+			},
+			Verbs: []string{
+				"get",
+				"create",
+				"delete",
 			},
 		},
 		{
@@ -180,6 +244,32 @@ func getControllerClusterPolicyRules() []rbacv1.PolicyRule {
                 "patch",
 			},
 		},
+		{
+			APIGroups: []string{
+				"kubevirt.io",
+			},
+			Resources: []string{
+				"virtualmachines",
+			},
+			Verbs: []string{
+				"get",
+				"create",
+				"update",
+				"delete",
+			},
+		},
+		{
+			APIGroups: []string{
+				"kubevirt.io",
+			},
+			Resources: []string{
+				"virtualmachineinstancemigrations",
+			},
+			Verbs: []string{
+				"watch",
+				"list",
+			},
+		},
 		{
 			APIGroups: []string{
 				"kubevirt.io",
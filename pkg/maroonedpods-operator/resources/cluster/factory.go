@@ -24,6 +24,7 @@ var staticFactoryFunctions = factoryFuncMap{
 	"maroonedpods-server-rbac": createStaticMaroonedPodsLockResources,
 	"controller-rbac": createStaticControllerResources,
 	"crd-resources":   createCRDResources,
+	"runtimeclass":    createStaticRuntimeClassResources,
 }
 
 var dynamicFactoryFunctions = factoryFuncMap{
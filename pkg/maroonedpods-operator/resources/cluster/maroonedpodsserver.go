@@ -62,6 +62,43 @@ func getMaroonedPodsServerClusterPolicyRules() []rbacv1.PolicyRule {
 				"create",
 			},
 		},
+		{
+			APIGroups: []string{
+				"",
+			},
+			Resources: []string{
+				"namespaces",
+			},
+			Verbs: []string{
+				"get",
+				"list",
+				"watch",
+			},
+		},
+		{
+			APIGroups: []string{
+				"node.k8s.io",
+			},
+			Resources: []string{
+				"runtimeclasses",
+			},
+			Verbs: []string{
+				"get",
+			},
+		},
+		{
+			APIGroups: []string{
+				"maroonedpods.io",
+			},
+			Resources: []string{
+				"maroonedpodsconfigs",
+			},
+			Verbs: []string{
+				"get",
+				"list",
+				"watch",
+			},
+		},
 	}
 }
 
@@ -168,76 +205,9 @@ func createGatingValidatingWebhook(namespace string, c client.Client, l logr.Log
 	if err != nil || controllerDeployment == nil || controllerDeployment.Status.ReadyReplicas < 1 {
 		includeHooks = false
 	}
-	path := mpserver.ServePath
-	defaultServicePort := int32(443)
-	namespacedScope := admissionregistrationv1.NamespacedScope
-	exactPolicy := admissionregistrationv1.Equivalent
-	failurePolicy := admissionregistrationv1.Fail
-	sideEffect := admissionregistrationv1.SideEffectClassNone
 	hooks := []admissionregistrationv1.ValidatingWebhook{}
 	if includeHooks {
-		hooks = []admissionregistrationv1.ValidatingWebhook{
-			{
-				Name:                    "marooned.pods.validator",
-				AdmissionReviewVersions: []string{"v1", "v1beta1"},
-				FailurePolicy:           &failurePolicy,
-				SideEffects:             &sideEffect,
-				MatchPolicy:             &exactPolicy,
-				Rules: []admissionregistrationv1.RuleWithOperations{
-					{
-						Operations: []admissionregistrationv1.OperationType{
-							admissionregistrationv1.Create,
-							admissionregistrationv1.Update,
-						},
-						Rule: admissionregistrationv1.Rule{
-							APIGroups:   []string{"*"},
-							APIVersions: []string{"*"},
-							Scope:       &namespacedScope,
-							Resources:   []string{"maroonedpods"},
-						},
-					},
-				},
-
-				ClientConfig: admissionregistrationv1.WebhookClientConfig{
-					Service: &admissionregistrationv1.ServiceReference{
-						Namespace: namespace,
-						Name:      MaroonedPodsServerServiceName,
-						Path:      &path,
-						Port:      &defaultServicePort,
-					},
-				},
-			},
-			{
-				Name:                    "remove.pod.gate.validator",
-				AdmissionReviewVersions: []string{"v1", "v1beta1"},
-				FailurePolicy:           &failurePolicy,
-				SideEffects:             &sideEffect,
-				MatchPolicy:             &exactPolicy,
-				NamespaceSelector:       cr.Spec.NamespaceSelector,
-				Rules: []admissionregistrationv1.RuleWithOperations{
-					{
-						Operations: []admissionregistrationv1.OperationType{
-							admissionregistrationv1.Update,
-						},
-						Rule: admissionregistrationv1.Rule{
-							APIGroups:   []string{"*"},
-							APIVersions: []string{"*"},
-							Scope:       &namespacedScope,
-							Resources:   []string{"pods"},
-						},
-					},
-				},
-
-				ClientConfig: admissionregistrationv1.WebhookClientConfig{
-					Service: &admissionregistrationv1.ServiceReference{
-						Namespace: namespace,
-						Name:      MaroonedPodsServerServiceName,
-						Path:      &path,
-						Port:      &defaultServicePort,
-					},
-				},
-			},
-		}
+		hooks = validatingWebhooks(namespace, cr.Spec.NamespaceSelector)
 	}
 
 	mhc := &admissionregistrationv1.ValidatingWebhookConfiguration{
@@ -266,6 +236,115 @@ func createGatingValidatingWebhook(namespace string, c client.Client, l logr.Log
 	return mhc
 }
 
+// validatingWebhooks returns the webhooks validating updates of marooned pods and virtual nodes
+func validatingWebhooks(namespace string, namespaceSelector *metav1.LabelSelector) []admissionregistrationv1.ValidatingWebhook {
+	path := mpserver.ServePath
+	defaultServicePort := int32(443)
+	namespacedScope := admissionregistrationv1.NamespacedScope
+	clusterScope := admissionregistrationv1.ClusterScope
+	exactPolicy := admissionregistrationv1.Equivalent
+	failurePolicy := admissionregistrationv1.Fail
+	sideEffect := admissionregistrationv1.SideEffectClassNone
+	return []admissionregistrationv1.ValidatingWebhook{
+		{
+			Name:                    "marooned.pods.validator",
+			AdmissionReviewVersions: []string{"v1", "v1beta1"},
+			FailurePolicy:           &failurePolicy,
+			SideEffects:             &sideEffect,
+			MatchPolicy:             &exactPolicy,
+			Rules: []admissionregistrationv1.RuleWithOperations{
+				{
+					Operations: []admissionregistrationv1.OperationType{
+						admissionregistrationv1.Create,
+						admissionregistrationv1.Update,
+					},
+					Rule: admissionregistrationv1.Rule{
+						APIGroups:   []string{"*"},
+						APIVersions: []string{"*"},
+						Scope:       &namespacedScope,
+						Resources:   []string{"maroonedpods"},
+					},
+				},
+			},
+
+			ClientConfig: admissionregistrationv1.WebhookClientConfig{
+				Service: &admissionregistrationv1.ServiceReference{
+					Namespace: namespace,
+					Name:      MaroonedPodsServerServiceName,
+					Path:      &path,
+					Port:      &defaultServicePort,
+				},
+			},
+		},
+		{
+			Name:                    "remove.pod.gate.validator",
+			AdmissionReviewVersions: []string{"v1", "v1beta1"},
+			FailurePolicy:           &failurePolicy,
+			SideEffects:             &sideEffect,
+			MatchPolicy:             &exactPolicy,
+			NamespaceSelector:       namespaceSelector,
+			Rules: []admissionregistrationv1.RuleWithOperations{
+				{
+					Operations: []admissionregistrationv1.OperationType{
+						admissionregistrationv1.Update,
+					},
+					Rule: admissionregistrationv1.Rule{
+						APIGroups:   []string{"*"},
+						APIVersions: []string{"*"},
+						Scope:       &namespacedScope,
+						Resources:   []string{"pods"},
+					},
+				},
+			},
+
+			ClientConfig: admissionregistrationv1.WebhookClientConfig{
+				Service: &admissionregistrationv1.ServiceReference{
+					Namespace: namespace,
+					Name:      MaroonedPodsServerServiceName,
+					Path:      &path,
+					Port:      &defaultServicePort,
+				},
+			},
+		},
+		{
+			Name:                    "marooned.node.taints.validator",
+			AdmissionReviewVersions: []string{"v1", "v1beta1"},
+			FailurePolicy:           &failurePolicy,
+			SideEffects:             &sideEffect,
+			MatchPolicy:             &exactPolicy,
+			// Every virtual node, dedicated to a pod or of a warm pool, updates of other nodes never wait on the webhook
+			ObjectSelector: &metav1.LabelSelector{
+				MatchExpressions: []metav1.LabelSelectorRequirement{{
+					Key:      util.MaroonedVirtualNodeLabel,
+					Operator: metav1.LabelSelectorOpExists,
+				}},
+			},
+			Rules: []admissionregistrationv1.RuleWithOperations{
+				{
+					Operations: []admissionregistrationv1.OperationType{
+						admissionregistrationv1.Update,
+					},
+					Rule: admissionregistrationv1.Rule{
+						APIGroups:   []string{""},
+						APIVersions: []string{"v1"},
+						Scope:       &clusterScope,
+						Resources:   []string{"nodes"},
+					},
+				},
+			},
+
+			ClientConfig: admissionregistrationv1.WebhookClientConfig{
+				Service: &admissionregistrationv1.ServiceReference{
+					Namespace: namespace,
+					Name:      MaroonedPodsServerServiceName,
+					Path:      &path,
+					Port:      &defaultServicePort,
+				},
+			},
+		},
+	}
+}
+
 func getAPIServerCABundle(namespace string, c client.Client, l logr.Logger) []byte {
 	cm := &corev1.ConfigMap{}
 	key := client.ObjectKey{Namespace: namespace, Name: "maroonedpods-server-signer-bundle"}
@@ -0,0 +1,29 @@
+package cluster
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"maroonedpods.io/maroonedpods/pkg/util"
+)
+
+var _ = Describe("Validating webhooks", func() {
+	DescribeTable("should validate taint updates of virtual nodes only", func(nodeLabels map[string]string, validated bool) {
+		var selector labels.Selector
+		for _, hook := range validatingWebhooks("maroonedpods", nil) {
+			if hook.Name == "marooned.node.taints.validator" {
+				var err error
+				selector, err = metav1.LabelSelectorAsSelector(hook.ObjectSelector)
+				Expect(err).ToNot(HaveOccurred())
+			}
+		}
+		Expect(selector).ToNot(BeNil())
+		Expect(selector.Matches(labels.Set(nodeLabels))).To(Equal(validated))
+	},
+		Entry("node of a marooned pod", map[string]string{util.MaroonedVirtualNodeLabel: "true", util.MaroonedNodePodUIDLabel: "uid"}, true),
+		Entry("warm pool node", map[string]string{util.MaroonedVirtualNodeLabel: "true"}, true),
+		Entry("host node", map[string]string{"kubernetes.io/os": "linux"}, false),
+	)
+})
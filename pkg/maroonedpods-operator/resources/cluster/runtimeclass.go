@@ -0,0 +1,34 @@
+package cluster
+
+import (
+	nodev1 "k8s.io/api/node/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"maroonedpods.io/maroonedpods/pkg/util"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func createStaticRuntimeClassResources(args *FactoryArgs) []client.Object {
+	return []client.Object{
+		createMaroonedPodsRuntimeClass(),
+	}
+}
+
+// createMaroonedPodsRuntimeClass creates the RuntimeClass that lets pods opt into marooning
+// through spec.runtimeClassName. The webhook strips the reference from marooned pods. A pod
+// admitted without the webhook still only fits virtual nodes, whose taints keep it pending.
+func createMaroonedPodsRuntimeClass() *nodev1.RuntimeClass {
+	return &nodev1.RuntimeClass{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: "node.k8s.io/v1",
+			Kind:       "RuntimeClass",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   util.MaroonedPodsRuntimeClassName,
+			Labels: util.ResourceBuilder.WithCommonLabels(nil),
+		},
+		Handler: util.MaroonedPodsRuntimeClassHandler,
+		Scheduling: &nodev1.Scheduling{
+			NodeSelector: map[string]string{util.MaroonedVirtualNodeLabel: "true"},
+		},
+	}
+}
@@ -1066,6 +1066,39 @@ spec:
               priorityClass:
                 description: PriorityClass of the MaroonedPods control plane
                 type: string
+              registryMirror:
+                description: 'Pull-through registry mirror deployed next to maroonedpods-server,
+                  marooned VMs pull the images of the mirrored registry through it
+                  Default: VMs pull images from the registries directly'
+                properties:
+                  cacheSize:
+                    anyOf:
+                    - type: integer
+                    - type: string
+                    description: 'Size limit of the image cache of the mirror Default:
+                      bounded by the ephemeral storage of the host'
+                    pattern: ^(\+|-)?(([0-9]+(\.[0-9]*)?)|(\.[0-9]+))(([KMGTPE]i)|[numkMGTPE]|([eE](\+|-)?(([0-9]+(\.[0-9]*)?)|(\.[0-9]+))))?$
+                    x-kubernetes-int-or-string: true
+                  credentialsSecret:
+                    description: 'Secret in the install namespace holding the credentials
+                      of the guests, the username and password keys, and the htpasswd
+                      key the mirror authenticates them with Default: the mirror accepts
+                      anonymous pulls'
+                    type: string
+                  image:
+                    description: 'Image of the registry serving the mirror Default:
+                      docker.io/library/registry:2'
+                    type: string
+                  registry:
+                    description: 'Registry mirrored for the guests, as referenced
+                      by image names Default: docker.io'
+                    type: string
+                  remoteURL:
+                    description: 'URL of the upstream registry the mirror pulls through
+                      Default: https://registry-1.docker.io for docker.io, https://<registry>
+                      otherwise'
+                    type: string
+                type: object
               workload:
                 description: Restrict on which nodes MaroonedPods workload pods will
                   be scheduled
@@ -2104,6 +2137,116 @@ spec:
             description: MaroonedPodsConfigSpec defines the configuration for MaroonedPods
               behavior
             properties:
+              admissionPolicy:
+                description: 'Admission policy restricting which pods may be marooned
+                  Default: every pod carrying the maroon label is accepted'
+                properties:
+                  allowedServiceAccounts:
+                    description: 'Service accounts allowed to run marooned pods, as
+                      <namespace>/<name>. A name of "*" matches every service account
+                      in the namespace. Default: all service accounts are allowed'
+                    items:
+                      type: string
+                    type: array
+                  deniedServiceAccounts:
+                    description: Service accounts never allowed to run marooned pods,
+                      as <namespace>/<name>. Takes precedence over AllowedServiceAccounts.
+                    items:
+                      type: string
+                    type: array
+                  namespaceSelector:
+                    description: Namespaces whose pods may be marooned Default to
+                      the empty LabelSelector, which matches everything.
+                    properties:
+                      matchExpressions:
+                        description: matchExpressions is a list of label selector
+                          requirements. The requirements are ANDed.
+                        items:
+                          description: A label selector requirement is a selector
+                            that contains values, a key, and an operator that relates
+                            the key and values.
+                          properties:
+                            key:
+                              description: key is the label key that the selector
+                                applies to.
+                              type: string
+                            operator:
+                              description: operator represents a key's relationship
+                                to a set of values. Valid operators are In, NotIn,
+                                Exists and DoesNotExist.
+                              type: string
+                            values:
+                              description: values is an array of string values. If
+                                the operator is In or NotIn, the values array must
+                                be non-empty. If the operator is Exists or DoesNotExist,
+                                the values array must be empty. This array is replaced
+                                during a strategic merge patch.
+                              items:
+                                type: string
+                              type: array
+                          required:
+                          - key
+                          - operator
+                          type: object
+                        type: array
+                      matchLabels:
+                        additionalProperties:
+                          type: string
+                        description: matchLabels is a map of {key,value} pairs. A
+                          single {key,value} in the matchLabels map is equivalent
+                          to an element of matchExpressions, whose key field is "key",
+                          the operator is "In", and the values array contains only
+                          "value". The requirements are ANDed.
+                        type: object
+                    type: object
+                    x-kubernetes-map-type: atomic
+                  podSelector:
+                    description: Pods that may be marooned Default to the empty LabelSelector,
+                      which matches everything.
+                    properties:
+                      matchExpressions:
+                        description: matchExpressions is a list of label selector
+                          requirements. The requirements are ANDed.
+                        items:
+                          description: A label selector requirement is a selector
+                            that contains values, a key, and an operator that relates
+                            the key and values.
+                          properties:
+                            key:
+                              description: key is the label key that the selector
+                                applies to.
+                              type: string
+                            operator:
+                              description: operator represents a key's relationship
+                                to a set of values. Valid operators are In, NotIn,
+                                Exists and DoesNotExist.
+                              type: string
+                            values:
+                              description: values is an array of string values. If
+                                the operator is In or NotIn, the values array must
+                                be non-empty. If the operator is Exists or DoesNotExist,
+                                the values array must be empty. This array is replaced
+                                during a strategic merge patch.
+                              items:
+                                type: string
+                              type: array
+                          required:
+                          - key
+                          - operator
+                          type: object
+                        type: array
+                      matchLabels:
+                        additionalProperties:
+                          type: string
+                        description: matchLabels is a map of {key,value} pairs. A
+                          single {key,value} in the matchLabels map is equivalent
+                          to an element of matchExpressions, whose key field is "key",
+                          the operator is "In", and the values array contains only
+                          "value". The requirements are ANDed.
+                        type: object
+                    type: object
+                    x-kubernetes-map-type: atomic
+                type: object
               baseVMResources:
                 description: Base VM resources (CPU/memory) for virtual nodes These
                   are the resources allocated to the VM itself
@@ -2119,6 +2262,443 @@ spec:
                     format: int64
                     type: integer
                 type: object
+              cpu:
+                description: 'CPU model, topology and placement of virtual node VMIs
+                  Default: a single socket with one thread per core, shared host CPUs'
+                properties:
+                  dedicatedCpuPlacement:
+                    description: Pin the vCPUs of VMs running Guaranteed QoS pods
+                      to dedicated host CPUs Requires the CPU manager on the hosts
+                    type: boolean
+                  fractionalCpu:
+                    description: Request the host CPU the pod asks for instead of
+                      whole cores, and limit it to the pod CPU limits when every container
+                      sets one
+                    type: boolean
+                  isolateEmulatorThread:
+                    description: Run the emulator thread of VMs with dedicated CPUs
+                      on an additional dedicated host CPU
+                    type: boolean
+                  model:
+                    description: 'CPU model of the VMs, e.g. host-passthrough, host-model
+                      or a named model Default: the KubeVirt default model'
+                    type: string
+                  sockets:
+                    description: 'Number of sockets the vCPUs are spread over Default:
+                      1'
+                    format: int32
+                    minimum: 1
+                    type: integer
+                  threads:
+                    description: 'Number of threads per core Default: 1'
+                    format: int32
+                    minimum: 1
+                    type: integer
+                type: object
+              evictionStrategy:
+                description: 'What happens to virtual node VMIs when their host is
+                  drained LiveMigrate moves VMIs to another host without disturbing
+                  the pod, VMIs that can''t be migrated are shut down instead of blocking
+                  the drain Default: LiveMigrate'
+                enum:
+                - LiveMigrate
+                - None
+                type: string
+              forceMaroon:
+                description: Pods matching this policy are always marooned, with or
+                  without the maroon label
+                properties:
+                  imagePatterns:
+                    description: Pods with a container image matching one of these
+                      patterns are always marooned. Patterns and images are normalized
+                      before matching, so "nginx" matches "docker.io/library/nginx:latest".
+                      A pattern without tag or digest matches every tag of the repository,
+                      and a pattern ending in "/*" matches every repository below
+                      the prefix, for example "docker.io/untrusted/*".
+                    items:
+                      type: string
+                    type: array
+                  namespaceSelector:
+                    description: Namespaces whose pods are always marooned The empty
+                      LabelSelector matches every namespace.
+                    properties:
+                      matchExpressions:
+                        description: matchExpressions is a list of label selector
+                          requirements. The requirements are ANDed.
+                        items:
+                          description: A label selector requirement is a selector
+                            that contains values, a key, and an operator that relates
+                            the key and values.
+                          properties:
+                            key:
+                              description: key is the label key that the selector
+                                applies to.
+                              type: string
+                            operator:
+                              description: operator represents a key's relationship
+                                to a set of values. Valid operators are In, NotIn,
+                                Exists and DoesNotExist.
+                              type: string
+                            values:
+                              description: values is an array of string values. If
+                                the operator is In or NotIn, the values array must
+                                be non-empty. If the operator is Exists or DoesNotExist,
+                                the values array must be empty. This array is replaced
+                                during a strategic merge patch.
+                              items:
+                                type: string
+                              type: array
+                          required:
+                          - key
+                          - operator
+                          type: object
+                        type: array
+                      matchLabels:
+                        additionalProperties:
+                          type: string
+                        description: matchLabels is a map of {key,value} pairs. A
+                          single {key,value} in the matchLabels map is equivalent
+                          to an element of matchExpressions, whose key field is "key",
+                          the operator is "In", and the values array contains only
+                          "value". The requirements are ANDed.
+                        type: object
+                    type: object
+                    x-kubernetes-map-type: atomic
+                  podSelector:
+                    description: Pods that are always marooned The empty LabelSelector
+                      matches every pod.
+                    properties:
+                      matchExpressions:
+                        description: matchExpressions is a list of label selector
+                          requirements. The requirements are ANDed.
+                        items:
+                          description: A label selector requirement is a selector
+                            that contains values, a key, and an operator that relates
+                            the key and values.
+                          properties:
+                            key:
+                              description: key is the label key that the selector
+                                applies to.
+                              type: string
+                            operator:
+                              description: operator represents a key's relationship
+                                to a set of values. Valid operators are In, NotIn,
+                                Exists and DoesNotExist.
+                              type: string
+                            values:
+                              description: values is an array of string values. If
+                                the operator is In or NotIn, the values array must
+                                be non-empty. If the operator is Exists or DoesNotExist,
+                                the values array must be empty. This array is replaced
+                                during a strategic merge patch.
+                              items:
+                                type: string
+                              type: array
+                          required:
+                          - key
+                          - operator
+                          type: object
+                        type: array
+                      matchLabels:
+                        additionalProperties:
+                          type: string
+                        description: matchLabels is a map of {key,value} pairs. A
+                          single {key,value} in the matchLabels map is equivalent
+                          to an element of matchExpressions, whose key field is "key",
+                          the operator is "In", and the values array contains only
+                          "value". The requirements are ANDed.
+                        type: object
+                    type: object
+                    x-kubernetes-map-type: atomic
+                  runtimeClassNames:
+                    description: Pods referencing one of these RuntimeClasses are
+                      always marooned
+                    items:
+                      type: string
+                    type: array
+                type: object
+              memory:
+                description: Hugepages backing and memory overcommit of virtual node
+                  VMIs
+                properties:
+                  hugepagesPageSize:
+                    description: 'Host hugepage size backing the memory of VMs running
+                      pods that request hugepages Default: the largest hugepage size
+                      the pod requests'
+                    enum:
+                    - 2Mi
+                    - 1Gi
+                    type: string
+                  overcommitPercent:
+                    description: 'Guest memory as a percentage of the memory requested
+                      from the host Values above 100 let VMs request less memory than
+                      the guest sees, trading safety for density. VMs backed by hugepages
+                      or with dedicated CPUs are never overcommitted. Default: 100
+                      (no overcommit)'
+                    format: int32
+                    maximum: 1000
+                    minimum: 100
+                    type: integer
+                type: object
+              networkBinding:
+                description: 'How virtual node VMIs are connected to the pod network
+                  masquerade puts the guest behind NAT in the virt-launcher pod, bridge
+                  and passt give the guest the pod IP so the node is reachable on
+                  its InternalIP Default: masquerade'
+                enum:
+                - masquerade
+                - bridge
+                - passt
+                type: string
+              networkIsolation:
+                description: 'Network isolation of virtual node VMIs through generated
+                  NetworkPolicies Default: disabled'
+                properties:
+                  additionalEgress:
+                    description: Additional egress rules appended to the generated
+                      NetworkPolicy
+                    items:
+                      description: NetworkPolicyEgressRule describes a particular
+                        set of traffic that is allowed out of pods matched by a NetworkPolicySpec's
+                        podSelector. The traffic must match both ports and to. This
+                        type is beta-level in 1.8
+                      properties:
+                        ports:
+                          description: List of destination ports for outgoing traffic.
+                            Each item in this list is combined using a logical OR.
+                            If this field is empty or missing, this rule matches all
+                            ports (traffic not restricted by port). If this field
+                            is present and contains at least one item, then this rule
+                            allows traffic only if the traffic matches at least one
+                            port in the list.
+                          items:
+                            description: NetworkPolicyPort describes a port to allow
+                              traffic on
+                            properties:
+                              endPort:
+                                description: If set, indicates that the range of ports
+                                  from port to endPort, inclusive, should be allowed
+                                  by the policy. This field cannot be defined if the
+                                  port field is not defined or if the port field is
+                                  defined as a named (string) port. The endPort must
+                                  be equal or greater than port.
+                                format: int32
+                                type: integer
+                              port:
+                                anyOf:
+                                - type: integer
+                                - type: string
+                                description: The port on the given protocol. This
+                                  can either be a numerical or named port on a pod.
+                                  If this field is not provided, this matches all
+                                  port names and numbers. If present, only traffic
+                                  on the specified protocol AND port will be matched.
+                                x-kubernetes-int-or-string: true
+                              protocol:
+                                default: TCP
+                                description: The protocol (TCP, UDP, or SCTP) which
+                                  traffic must match. If not specified, this field
+                                  defaults to TCP.
+                                type: string
+                            type: object
+                          type: array
+                        to:
+                          description: List of destinations for outgoing traffic of
+                            pods selected for this rule. Items in this list are combined
+                            using a logical OR operation. If this field is empty or
+                            missing, this rule matches all destinations (traffic not
+                            restricted by destination). If this field is present and
+                            contains at least one item, this rule allows traffic only
+                            if the traffic matches at least one item in the to list.
+                          items:
+                            description: NetworkPolicyPeer describes a peer to allow
+                              traffic to/from. Only certain combinations of fields
+                              are allowed
+                            properties:
+                              ipBlock:
+                                description: IPBlock defines policy on a particular
+                                  IPBlock. If this field is set then neither of the
+                                  other fields can be.
+                                properties:
+                                  cidr:
+                                    description: CIDR is a string representing the
+                                      IP Block Valid examples are "192.168.1.0/24"
+                                      or "2001:db8::/64"
+                                    type: string
+                                  except:
+                                    description: Except is a slice of CIDRs that should
+                                      not be included within an IP Block Valid examples
+                                      are "192.168.1.0/24" or "2001:db8::/64" Except
+                                      values will be rejected if they are outside
+                                      the CIDR range
+                                    items:
+                                      type: string
+                                    type: array
+                                required:
+                                - cidr
+                                type: object
+                              namespaceSelector:
+                                description: "Selects Namespaces using cluster-scoped
+                                  labels. This field follows standard label selector
+                                  semantics; if present but empty, it selects all
+                                  namespaces. \n If PodSelector is also set, then
+                                  the NetworkPolicyPeer as a whole selects the Pods
+                                  matching PodSelector in the Namespaces selected
+                                  by NamespaceSelector. Otherwise it selects all Pods
+                                  in the Namespaces selected by NamespaceSelector."
+                                properties:
+                                  matchExpressions:
+                                    description: matchExpressions is a list of label
+                                      selector requirements. The requirements are
+                                      ANDed.
+                                    items:
+                                      description: A label selector requirement is
+                                        a selector that contains values, a key, and
+                                        an operator that relates the key and values.
+                                      properties:
+                                        key:
+                                          description: key is the label key that the
+                                            selector applies to.
+                                          type: string
+                                        operator:
+                                          description: operator represents a key's
+                                            relationship to a set of values. Valid
+                                            operators are In, NotIn, Exists and DoesNotExist.
+                                          type: string
+                                        values:
+                                          description: values is an array of string
+                                            values. If the operator is In or NotIn,
+                                            the values array must be non-empty. If
+                                            the operator is Exists or DoesNotExist,
+                                            the values array must be empty. This array
+                                            is replaced during a strategic merge patch.
+                                          items:
+                                            type: string
+                                          type: array
+                                      required:
+                                      - key
+                                      - operator
+                                      type: object
+                                    type: array
+                                  matchLabels:
+                                    additionalProperties:
+                                      type: string
+                                    description: matchLabels is a map of {key,value}
+                                      pairs. A single {key,value} in the matchLabels
+                                      map is equivalent to an element of matchExpressions,
+                                      whose key field is "key", the operator is "In",
+                                      and the values array contains only "value".
+                                      The requirements are ANDed.
+                                    type: object
+                                type: object
+                                x-kubernetes-map-type: atomic
+                              podSelector:
+                                description: "This is a label selector which selects
+                                  Pods. This field follows standard label selector
+                                  semantics; if present but empty, it selects all
+                                  pods. \n If NamespaceSelector is also set, then
+                                  the NetworkPolicyPeer as a whole selects the Pods
+                                  matching PodSelector in the Namespaces selected
+                                  by NamespaceSelector. Otherwise it selects the Pods
+                                  matching PodSelector in the policy's own Namespace."
+                                properties:
+                                  matchExpressions:
+                                    description: matchExpressions is a list of label
+                                      selector requirements. The requirements are
+                                      ANDed.
+                                    items:
+                                      description: A label selector requirement is
+                                        a selector that contains values, a key, and
+                                        an operator that relates the key and values.
+                                      properties:
+                                        key:
+                                          description: key is the label key that the
+                                            selector applies to.
+                                          type: string
+                                        operator:
+                                          description: operator represents a key's
+                                            relationship to a set of values. Valid
+                                            operators are In, NotIn, Exists and DoesNotExist.
+                                          type: string
+                                        values:
+                                          description: values is an array of string
+                                            values. If the operator is In or NotIn,
+                                            the values array must be non-empty. If
+                                            the operator is Exists or DoesNotExist,
+                                            the values array must be empty. This array
+                                            is replaced during a strategic merge patch.
+                                          items:
+                                            type: string
+                                          type: array
+                                      required:
+                                      - key
+                                      - operator
+                                      type: object
+                                    type: array
+                                  matchLabels:
+                                    additionalProperties:
+                                      type: string
+                                    description: matchLabels is a map of {key,value}
+                                      pairs. A single {key,value} in the matchLabels
+                                      map is equivalent to an element of matchExpressions,
+                                      whose key field is "key", the operator is "In",
+                                      and the values array contains only "value".
+                                      The requirements are ANDed.
+                                    type: object
+                                type: object
+                                x-kubernetes-map-type: atomic
+                            type: object
+                          type: array
+                      type: object
+                    type: array
+                  apiServerCIDRs:
+                    description: 'CIDRs the API server is reachable on Default: the
+                      endpoints of the default/kubernetes service'
+                    items:
+                      type: string
+                    type: array
+                  enabled:
+                    description: Create a NetworkPolicy per VMI launcher pod
+                    type: boolean
+                  overlayCIDRs:
+                    description: 'CIDRs of the CNI overlay peers, usually the node
+                      network Default: any destination on the overlay ports'
+                    items:
+                      type: string
+                    type: array
+                  overlayPorts:
+                    description: 'Ports used by the CNI overlay Default: UDP 8472
+                      (flannel VXLAN)'
+                    items:
+                      description: NetworkPolicyPort describes a port to allow traffic
+                        on
+                      properties:
+                        endPort:
+                          description: If set, indicates that the range of ports from
+                            port to endPort, inclusive, should be allowed by the policy.
+                            This field cannot be defined if the port field is not
+                            defined or if the port field is defined as a named (string)
+                            port. The endPort must be equal or greater than port.
+                          format: int32
+                          type: integer
+                        port:
+                          anyOf:
+                          - type: integer
+                          - type: string
+                          description: The port on the given protocol. This can either
+                            be a numerical or named port on a pod. If this field is
+                            not provided, this matches all port names and numbers.
+                            If present, only traffic on the specified protocol AND
+                            port will be matched.
+                          x-kubernetes-int-or-string: true
+                        protocol:
+                          default: TCP
+                          description: The protocol (TCP, UDP, or SCTP) which traffic
+                            must match. If not specified, this field defaults to TCP.
+                          type: string
+                      type: object
+                    type: array
+                type: object
               nodeImage:
                 default: quay.io/capk/ubuntu-2004-container-disk:v1.26.0
                 description: 'Container disk image to use for virtual node VMs Default:
@@ -2129,6 +2709,22 @@ spec:
                 description: 'Taint key prefix for pod-specific node affinity Default:
                   "maroonedpods.io" The full taint key will be: <prefix>/<pod-name>'
                 type: string
+              prePullImages:
+                description: Images pulled into the VMs of the shared warm pool before
+                  they become available, so the pod claiming a VM doesn't pull them
+                  on its first start
+                items:
+                  type: string
+                type: array
+              preemptionPolicy:
+                description: 'Whether marooned pods whose VMs can''t be provisioned
+                  because of quota or host capacity preempt the VMs of lower priority
+                  marooned pods Pods with preemptionPolicy Never never preempt Default:
+                  Never'
+                enum:
+                - Never
+                - PreemptLowerPriority
+                type: string
               resourceOverhead:
                 additionalProperties:
                   anyOf:
@@ -2138,8 +2734,118 @@ spec:
                   x-kubernetes-int-or-string: true
                 description: 'Resource overhead to add on top of pod requests for
                   VM sizing This accounts for kubelet, kube-proxy, and other node
-                  components Default: 500m CPU, 512Mi memory'
+                  components The ephemeral-storage overhead is added to the scratch
+                  disk for image layers Default: 500m CPU, 512Mi memory, 10Gi ephemeral-storage'
+                type: object
+              resourceSizing:
+                description: 'Whether VMs are sized from the effective requests or
+                  limits of the pod With Limits the limit of each container is used
+                  where set, its request otherwise Default: Requests'
+                enum:
+                - Requests
+                - Limits
+                type: string
+              runStrategy:
+                description: 'Back virtual node VMIs with VirtualMachines owned by
+                  the pod, which KubeVirt restarts with this run strategy when the
+                  VM crashes or its host reboots Default: bare VMIs that are not restarted'
+                enum:
+                - Always
+                - RerunOnFailure
+                type: string
+              statefulSetDisk:
+                description: 'Keep the scratch disk of StatefulSet pods on a PVC named
+                  after the pod, so the VM of a recreated pod starts with the container
+                  images and kubelet data of its predecessor Default: StatefulSet
+                  pods get an empty scratch disk like other pods'
+                properties:
+                  storageClassName:
+                    description: 'Storage class of the scratch disk PVCs Default:
+                      the default storage class'
+                    type: string
                 type: object
+              tenantPools:
+                description: Warm pools dedicated to the namespaces they select. Their
+                  VMs live in the selected namespaces and are only claimed by pods
+                  of the same namespace. Pods of a selected namespace never claim
+                  VMs of the shared warm pool.
+                items:
+                  description: TenantPool is a warm pool dedicated to a set of namespaces
+                  properties:
+                    maxVMs:
+                      description: 'Maximum number of VMs of the pool across the selected
+                        namespaces, claimed or not Default: 0 (no limit)'
+                      format: int32
+                      minimum: 0
+                      type: integer
+                    name:
+                      description: Name of the pool, recorded on its VMs
+                      maxLength: 63
+                      pattern: ^[a-z0-9]([-a-z0-9]*[a-z0-9])?$
+                      type: string
+                    namespaceSelector:
+                      description: Namespaces entitled to the VMs of the pool
+                      properties:
+                        matchExpressions:
+                          description: matchExpressions is a list of label selector
+                            requirements. The requirements are ANDed.
+                          items:
+                            description: A label selector requirement is a selector
+                              that contains values, a key, and an operator that relates
+                              the key and values.
+                            properties:
+                              key:
+                                description: key is the label key that the selector
+                                  applies to.
+                                type: string
+                              operator:
+                                description: operator represents a key's relationship
+                                  to a set of values. Valid operators are In, NotIn,
+                                  Exists and DoesNotExist.
+                                type: string
+                              values:
+                                description: values is an array of string values.
+                                  If the operator is In or NotIn, the values array
+                                  must be non-empty. If the operator is Exists or
+                                  DoesNotExist, the values array must be empty. This
+                                  array is replaced during a strategic merge patch.
+                                items:
+                                  type: string
+                                type: array
+                            required:
+                            - key
+                            - operator
+                            type: object
+                          type: array
+                        matchLabels:
+                          additionalProperties:
+                            type: string
+                          description: matchLabels is a map of {key,value} pairs.
+                            A single {key,value} in the matchLabels map is equivalent
+                            to an element of matchExpressions, whose key field is
+                            "key", the operator is "In", and the values array contains
+                            only "value". The requirements are ANDed.
+                          type: object
+                      type: object
+                      x-kubernetes-map-type: atomic
+                    prePullImages:
+                      description: Images pulled into the VMs of the pool before they
+                        become available
+                      items:
+                        type: string
+                      type: array
+                    size:
+                      description: Number of pre-booted VMs kept available in each
+                        selected namespace
+                      format: int32
+                      minimum: 0
+                      type: integer
+                  required:
+                  - name
+                  - namespaceSelector
+                  - size
+                  type: object
+                type: array
               warmPoolSize:
                 default: 0
                 description: 'Number of pre-booted VM nodes to keep in warm pool Default:
@@ -2221,6 +2927,35 @@ spec:
                   - type
                   type: object
                 type: array
+              tenantPools:
+                description: VMs of each tenant pool
+                items:
+                  description: TenantPoolStatus reports the VMs of a tenant pool
+                  properties:
+                    available:
+                      description: Number of available (unclaimed) VMs in the pool
+                      format: int32
+                      type: integer
+                    claimed:
+                      description: Number of claimed VMs currently in use
+                      format: int32
+                      type: integer
+                    name:
+                      description: Name of the pool
+                      type: string
+                    namespaces:
+                      description: Number of namespaces selected by the pool
+                      format: int32
+                      type: integer
+                    total:
+                      description: Total number of VMs in the pool, claimed or not
+                      format: int32
+                      type: integer
+                  required:
+                  - name
+                  - namespaces
+                  type: object
+                type: array
               warmPoolAvailable:
                 description: Number of available (unclaimed) VMs in the warm pool
                 format: int32
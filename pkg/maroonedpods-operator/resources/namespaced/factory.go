@@ -6,6 +6,7 @@ import (
 	"k8s.io/apimachinery/pkg/runtime"
 	sdkapi "kubevirt.io/controller-lifecycle-operator-sdk/api"
 	utils "kubevirt.io/controller-lifecycle-operator-sdk/pkg/sdk/resources"
+	"maroonedpods.io/maroonedpods/staging/src/maroonedpods.io/api/pkg/apis/core/v1alpha1"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
@@ -21,6 +22,7 @@ type FactoryArgs struct {
 	PriorityClassName       string
 	Namespace               string
 	InfraNodePlacement      *sdkapi.NodePlacement
+	RegistryMirror          *v1alpha1.RegistryMirror `ignored:"true"`
 }
 
 type factoryFunc func(*FactoryArgs) []client.Object
@@ -33,6 +35,7 @@ type namespaceHaver interface {
 var factoryFunctions = map[string]factoryFunc{
 	"maroonedpodsServer":  createMaroonedPodsServerResources,
 	"controller": createMaroonedPodsControllerResources,
+	"registryMirror": createRegistryMirrorResources,
 }
 
 // CreateAllResources creates all namespaced resources
@@ -0,0 +1,174 @@
+package namespaced
+
+import (
+	"fmt"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/utils/pointer"
+	utils2 "maroonedpods.io/maroonedpods/pkg/util"
+	"maroonedpods.io/maroonedpods/staging/src/maroonedpods.io/api/pkg/apis/core/v1alpha1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	sdkapi "kubevirt.io/controller-lifecycle-operator-sdk/api"
+)
+
+const (
+	registryMirrorCacheDir = "/var/lib/registry"
+	registryMirrorTLSDir   = "/etc/registry/tls"
+	registryMirrorAuthDir  = "/etc/registry/auth"
+	// Non-root user the registry runs as, the cache is an emptyDir
+	registryMirrorUser = 1000
+)
+
+func createRegistryMirrorResources(args *FactoryArgs) []client.Object {
+	if args.RegistryMirror == nil {
+		return nil
+	}
+	return []client.Object{
+		createRegistryMirrorServiceAccount(),
+		createRegistryMirrorService(),
+		createRegistryMirrorDeployment(args.RegistryMirror, args.PullPolicy, args.ImagePullSecrets, args.PriorityClassName, args.InfraNodePlacement),
+	}
+}
+
+func createRegistryMirrorServiceAccount() *corev1.ServiceAccount {
+	return utils2.ResourceBuilder.CreateServiceAccount(utils2.RegistryMirrorResourceName)
+}
+
+func createRegistryMirrorService() *corev1.Service {
+	service := utils2.ResourceBuilder.CreateService(utils2.RegistryMirrorResourceName, utils2.MaroonedPodsLabel, utils2.RegistryMirrorResourceName, nil)
+	service.Spec.Ports = []corev1.ServicePort{
+		{
+			Port:       utils2.RegistryMirrorPort,
+			TargetPort: intstr.FromInt(utils2.RegistryMirrorPort),
+			Protocol:   corev1.ProtocolTCP,
+		},
+	}
+	return service
+}
+
+// registryMirrorRemoteURL returns the upstream registry the mirror pulls through
+func registryMirrorRemoteURL(mirror *v1alpha1.RegistryMirror) string {
+	if mirror.RemoteURL != "" {
+		return mirror.RemoteURL
+	}
+	if mirror.Registry != "" && mirror.Registry != utils2.DefaultRegistryMirrorRegistry {
+		return "https://" + mirror.Registry
+	}
+	return utils2.DefaultRegistryMirrorRemoteURL
+}
+
+func createRegistryMirrorDeployment(mirror *v1alpha1.RegistryMirror, pullPolicy string, imagePullSecrets []corev1.LocalObjectReference, priorityClassName string, infraNodePlacement *sdkapi.NodePlacement) *appsv1.Deployment {
+	defaultMode := corev1.SecretVolumeSourceDefaultMode
+	image := mirror.Image
+	if image == "" {
+		image = utils2.DefaultRegistryMirrorImage
+	}
+
+	deployment := utils2.CreateDeployment(utils2.RegistryMirrorResourceName, utils2.MaroonedPodsLabel, utils2.RegistryMirrorResourceName, utils2.RegistryMirrorResourceName, imagePullSecrets, 1, infraNodePlacement)
+	if priorityClassName != "" {
+		deployment.Spec.Template.Spec.PriorityClassName = priorityClassName
+	}
+	container := utils2.ResourceBuilder.CreateContainer(utils2.RegistryMirrorResourceName, image, pullPolicy)
+	container.Args = []string{"/etc/docker/registry/config.yml"}
+	container.SecurityContext = &corev1.SecurityContext{
+		SeccompProfile: &corev1.SeccompProfile{
+			Type: corev1.SeccompProfileTypeRuntimeDefault,
+		},
+		AllowPrivilegeEscalation: pointer.Bool(false),
+		RunAsNonRoot:             pointer.Bool(true),
+		RunAsUser:                pointer.Int64(registryMirrorUser),
+	}
+	container.Ports = []corev1.ContainerPort{
+		{
+			ContainerPort: utils2.RegistryMirrorPort,
+			Protocol:      corev1.ProtocolTCP,
+		},
+	}
+	container.Env = []corev1.EnvVar{
+		{Name: "REGISTRY_HTTP_ADDR", Value: fmt.Sprintf(":%d", utils2.RegistryMirrorPort)},
+		{Name: "REGISTRY_HTTP_TLS_CERTIFICATE", Value: registryMirrorTLSDir + "/tls.crt"},
+		{Name: "REGISTRY_HTTP_TLS_KEY", Value: registryMirrorTLSDir + "/tls.key"},
+		{Name: "REGISTRY_PROXY_REMOTEURL", Value: registryMirrorRemoteURL(mirror)},
+		{Name: "REGISTRY_STORAGE_FILESYSTEM_ROOTDIRECTORY", Value: registryMirrorCacheDir},
+	}
+	container.ReadinessProbe = &corev1.Probe{
+		ProbeHandler: corev1.ProbeHandler{
+			HTTPGet: &corev1.HTTPGetAction{
+				Path:   "/",
+				Port:   intstr.FromInt(utils2.RegistryMirrorPort),
+				Scheme: corev1.URISchemeHTTPS,
+			},
+		},
+		InitialDelaySeconds: 2,
+		PeriodSeconds:       5,
+		FailureThreshold:    3,
+		SuccessThreshold:    1,
+		TimeoutSeconds:      1,
+	}
+	container.Resources = corev1.ResourceRequirements{
+		Requests: corev1.ResourceList{
+			corev1.ResourceCPU:    resource.MustParse("10m"),
+			corev1.ResourceMemory: resource.MustParse("64Mi"),
+		},
+	}
+	container.VolumeMounts = []corev1.VolumeMount{
+		{
+			Name:      "cache",
+			MountPath: registryMirrorCacheDir,
+		},
+		{
+			Name:      "tls",
+			MountPath: registryMirrorTLSDir,
+			ReadOnly:  true,
+		},
+	}
+
+	volumes := []corev1.Volume{
+		{
+			Name: "cache",
+			VolumeSource: corev1.VolumeSource{
+				EmptyDir: &corev1.EmptyDirVolumeSource{SizeLimit: mirror.CacheSize},
+			},
+		},
+		{
+			Name: "tls",
+			VolumeSource: corev1.VolumeSource{
+				Secret: &corev1.SecretVolumeSource{
+					SecretName:  utils2.RegistryMirrorCertSecretName,
+					DefaultMode: &defaultMode,
+				},
+			},
+		},
+	}
+
+	// Only the guests holding the credentials pull through the mirror
+	if mirror.CredentialsSecret != "" {
+		container.Env = append(container.Env,
+			corev1.EnvVar{Name: "REGISTRY_AUTH", Value: "htpasswd"},
+			corev1.EnvVar{Name: "REGISTRY_AUTH_HTPASSWD_REALM", Value: utils2.RegistryMirrorResourceName},
+			corev1.EnvVar{Name: "REGISTRY_AUTH_HTPASSWD_PATH", Value: registryMirrorAuthDir + "/htpasswd"},
+		)
+		container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{
+			Name:      "auth",
+			MountPath: registryMirrorAuthDir,
+			ReadOnly:  true,
+		})
+		volumes = append(volumes, corev1.Volume{
+			Name: "auth",
+			VolumeSource: corev1.VolumeSource{
+				Secret: &corev1.SecretVolumeSource{
+					SecretName:  mirror.CredentialsSecret,
+					Items:       []corev1.KeyToPath{{Key: "htpasswd", Path: "htpasswd"}},
+					DefaultMode: &defaultMode,
+				},
+			},
+		})
+	}
+
+	deployment.Spec.Template.Spec.Containers = []corev1.Container{*container}
+	deployment.Spec.Template.Spec.Volumes = volumes
+	return deployment
+}
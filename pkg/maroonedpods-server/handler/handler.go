@@ -1,36 +1,57 @@
 package handler
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"gomodules.xyz/jsonpatch/v2"
 	admissionv1 "k8s.io/api/admission/v1"
 	v1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes"
+	v1listers "k8s.io/client-go/listers/core/v1"
+	"k8s.io/klog/v2"
 	"maroonedpods.io/maroonedpods/pkg/util"
+	"maroonedpods.io/maroonedpods/staging/src/maroonedpods.io/api/pkg/apis/core/v1alpha1"
+	"math/rand"
 	"net/http"
 	"strings"
 )
 
 const (
-	allowPodRequest                 = "Pod has successfully gated"
-	validPodUpdate                  = "Pod update did not remove MaroonedPodsGate"
-	maroonedpodsControllerPodUpdate = "MaroonedPods controller has permission to remove gate from pods"
-	invalidPodUpdate                = "Only MaroonedPods controller has permission to remove " + util.MaroonedPodsGate + " gate from pods"
+	allowPodRequest                  = "Pod has successfully gated"
+	validPodUpdate                   = "Pod update did not change marooning fields"
+	maroonedpodsControllerPodUpdate  = "MaroonedPods controller has permission to change marooning fields of pods"
+	invalidPodUpdate                 = "Only MaroonedPods controller has permission to remove " + util.MaroonedPodsGate + " gate from pods"
+	invalidPodFieldUpdate            = "Only MaroonedPods controller has permission to change the %s of marooned pods"
+	validNodeUpdate                  = "Node update did not change maroon taints"
+	maroonedpodsControllerNodeUpdate = "MaroonedPods controller has permission to change taints of marooned nodes"
+	invalidNodeUpdate                = "Only MaroonedPods controller has permission to change maroon taints of node %s"
+
+	// Generated names follow the API server: a prefix of up to 58 characters and a 5 character suffix
+	// of consonants and digits, so node names stay valid hostname label values
+	randomNameLength       = 5
+	maxGeneratedNameLength = 63 - randomNameLength
+	nameAlphabet           = "bcdfghjklmnpqrstvwxz2456789"
 )
 
 type Handler struct {
 	request         *admissionv1.AdmissionRequest
 	maroonedpodsCli kubernetes.Interface
+	namespaceLister v1listers.NamespaceLister
 	maroonedpodsNS  string
+	config          *v1alpha1.MaroonedPodsConfig
 }
 
-func NewHandler(Request *admissionv1.AdmissionRequest, maroonedpodsCli kubernetes.Interface, maroonedpodsNS string) *Handler {
+func NewHandler(Request *admissionv1.AdmissionRequest, maroonedpodsCli kubernetes.Interface, namespaceLister v1listers.NamespaceLister, maroonedpodsNS string, config *v1alpha1.MaroonedPodsConfig) *Handler {
 	return &Handler{
 		request:         Request,
 		maroonedpodsCli: maroonedpodsCli,
+		namespaceLister: namespaceLister,
 		maroonedpodsNS:  maroonedpodsNS,
+		config:          config,
 	}
 }
 
@@ -40,7 +61,31 @@ func (v Handler) Handle() (*admissionv1.AdmissionReview, error) {
 		if err := json.Unmarshal(v.request.Object.Raw, &pod); err != nil {
 			return nil, err
 		}
-		if _, exist := pod.Labels["maroonedpods.io/maroon"]; exist {
+		if pod.Namespace == "" {
+			pod.Namespace = v.request.Namespace
+		}
+		forced, err := v.isForceMarooned(&pod)
+		if err != nil {
+			return nil, err
+		}
+		_, labeled := pod.Labels[util.MaroonedPodLabel]
+		requested := labeled || usesMaroonedPodsRuntimeClass(&pod)
+		if forced && !requested {
+			// Pods that didn't ask for a VM, such as DaemonSet pods, keep running on the host
+			// rather than failing the admission of every pod the force-maroon policy matches
+			if reason := unsupportedPodReason(&pod); reason != "" {
+				klog.Infof("Not force-marooning pod %s/%s%s: %s", pod.Namespace, pod.GenerateName, pod.Name, reason)
+				return reviewResponse(v.request.UID, true, http.StatusAccepted, allowPodRequest), nil
+			}
+		}
+		if forced || requested {
+			reason, err := v.checkMaroonPolicy(&pod)
+			if err != nil {
+				return nil, err
+			}
+			if reason != "" {
+				return reviewResponse(v.request.UID, false, http.StatusForbidden, reason), nil
+			}
 			return v.mutatePod(&pod)
 		}
 		return reviewResponse(v.request.UID, true, http.StatusAccepted, allowPodRequest), nil
@@ -48,36 +93,159 @@ func (v Handler) Handle() (*admissionv1.AdmissionReview, error) {
 	switch v.request.Kind.Kind {
 	case "Pod":
 		return v.validatePodUpdate()
+	case "Node":
+		return v.validateNodeUpdate()
 	}
 	return nil, fmt.Errorf("MaroonedPods webhook doesn't recongnize request: %+v", v.request)
 }
 
 func (v Handler) mutatePod(pod *v1.Pod) (*admissionv1.AdmissionReview, error) {
-	schedulingGates := pod.Spec.SchedulingGates
-	if schedulingGates == nil {
-		schedulingGates = []v1.PodSchedulingGate{}
+	original, err := json.Marshal(pod)
+	if err != nil {
+		return nil, err
+	}
+	marooned, err := v.maroonPod(pod.DeepCopy())
+	if err != nil {
+		return nil, err
+	}
+	mutated, err := json.Marshal(marooned)
+	if err != nil {
+		return nil, err
 	}
-	schedulingGates = append(schedulingGates, v1.PodSchedulingGate{Name: util.MaroonedPodsGate})
 
-	schedulingGatesBytes, err := json.Marshal(schedulingGates)
+	// Diffing keeps the patch valid for any pod shape and merges with user supplied fields
+	operations, err := jsonpatch.CreatePatch(original, mutated)
+	if err != nil {
+		return nil, err
+	}
+	if len(operations) == 0 {
+		return reviewResponse(v.request.UID, true, http.StatusAccepted, allowPodRequest), nil
+	}
+	patch, err := json.Marshal(operations)
 	if err != nil {
 		return nil, err
 	}
+	return reviewResponseWithPatch(v.request.UID, true, http.StatusAccepted, allowPodRequest, patch), nil
+}
+
+// maroonPod applies the maroon mutation to the pod in place.
+// The mutation is idempotent, a pod that was already marooned is returned unchanged.
+func (v Handler) maroonPod(pod *v1.Pod) (*v1.Pod, error) {
+	// Always set the maroon label, force-marooned pods can't opt out
+	if pod.Labels == nil {
+		pod.Labels = map[string]string{}
+	}
+	pod.Labels[util.MaroonedPodLabel] = "true"
 
 	// Add finalizer to pod for VMI cleanup
-	finalizers := pod.Finalizers
-	if finalizers == nil {
-		finalizers = []string{}
+	if !hasFinalizer(pod.Finalizers, util.MaroonedPodsFinalizer) {
+		pod.Finalizers = append(pod.Finalizers, util.MaroonedPodsFinalizer)
 	}
-	finalizers = append(finalizers, util.MaroonedPodsFinalizer)
 
-	finalizersBytes, err := json.Marshal(finalizers)
-	if err != nil {
+	if !hasMaroonedPodsGate(pod.Spec.SchedulingGates) {
+		pod.Spec.SchedulingGates = append(pod.Spec.SchedulingGates, v1.PodSchedulingGate{Name: util.MaroonedPodsGate})
+	}
+
+	// The API server only generates the name of generateName pods after admission,
+	// the dedicated virtual node is named after the pod
+	if pod.Name == "" && pod.GenerateName != "" {
+		pod.Name = generatePodName(pod.GenerateName)
+	}
+
+	// The dedicated VM is the sandbox, the guest kubelet runs the pod with its default runtime.
+	// Stripped before pinning the pod, the constraints of the class select host nodes.
+	if v.shouldStripRuntimeClass(pod) {
+		if err := v.stripRuntimeClass(pod); err != nil {
+			return nil, err
+		}
+	}
+
+	// Pin the pod to its dedicated virtual node, or the one shared by its island
+	nodeName := util.VirtualNodeName(pod)
+	toleration := maroonToleration(nodeName)
+	if !hasToleration(pod.Spec.Tolerations, toleration) {
+		pod.Spec.Tolerations = append(pod.Spec.Tolerations, toleration)
+	}
+	if pod.Spec.NodeSelector == nil {
+		pod.Spec.NodeSelector = map[string]string{}
+	}
+	pod.Spec.NodeSelector[v1.LabelHostname] = nodeName
+
+	if err := passThroughVolumes(pod); err != nil {
+		return nil, err
+	}
+	if err := recordSidecarContainers(pod, v.request.Object.Raw); err != nil {
 		return nil, err
 	}
+	return pod, nil
+}
+
+// recordSidecarContainers records the init containers with restartPolicy Always on the pod.
+// The field is read from the raw object, it isn't part of the core API types we build against.
+// The record is always computed from the init containers, a value set by the client is replaced.
+func recordSidecarContainers(pod *v1.Pod, raw []byte) error {
+	delete(pod.Annotations, util.SidecarContainersAnnotation)
+	rawPod := struct {
+		Spec struct {
+			InitContainers []struct {
+				Name          string `json:"name"`
+				RestartPolicy string `json:"restartPolicy,omitempty"`
+			} `json:"initContainers,omitempty"`
+		} `json:"spec"`
+	}{}
+	if err := json.Unmarshal(raw, &rawPod); err != nil {
+		return err
+	}
 
-	patch := fmt.Sprintf(`[{"op": "add", "path": "/metadata/finalizers", "value": %s}, {"op": "add", "path": "/spec/schedulingGates", "value": %s}, {"op": "add", "path": "/spec/tolerations/-", "value": {"key": "%s.maroonedpods.io", "operator":"Exists", "effect": "NoSchedule"}}, {"op": "add", "path": "/spec/nodeSelector", "value": {"kubernetes.io/hostname": "%s"}}]`, string(finalizersBytes), string(schedulingGatesBytes), pod.Name, pod.Name)
-	return reviewResponseWithPatch(v.request.UID, true, http.StatusAccepted, allowPodRequest, []byte(patch)), nil
+	var sidecars []string
+	for _, container := range rawPod.Spec.InitContainers {
+		if container.RestartPolicy == string(v1.RestartPolicyAlways) {
+			sidecars = append(sidecars, container.Name)
+		}
+	}
+	if len(sidecars) == 0 {
+		return nil
+	}
+	if pod.Annotations == nil {
+		pod.Annotations = map[string]string{}
+	}
+	pod.Annotations[util.SidecarContainersAnnotation] = strings.Join(sidecars, ",")
+	return nil
+}
+
+// passThroughVolumes rewrites the volumes the guest can't attach itself to the
+// devices the VMI exposes in the guest, and records the original volumes for the controller.
+// The record is always computed from the pod volumes, a value set by the client is replaced.
+func passThroughVolumes(pod *v1.Pod) error {
+	delete(pod.Annotations, util.PassthroughVolumesAnnotation)
+	volumes := util.SelectPassthroughVolumes(pod)
+	if len(volumes) == 0 {
+		return nil
+	}
+
+	volumesBytes, err := json.Marshal(volumes)
+	if err != nil {
+		return err
+	}
+	if pod.Annotations == nil {
+		pod.Annotations = map[string]string{}
+	}
+	pod.Annotations[util.PassthroughVolumesAnnotation] = string(volumesBytes)
+
+	guestPaths := map[string]v1.HostPathVolumeSource{}
+	for _, volume := range volumes {
+		pathType := v1.HostPathDirectory
+		if volume.Block {
+			pathType = v1.HostPathBlockDev
+		}
+		guestPaths[volume.Name] = v1.HostPathVolumeSource{Path: volume.GuestPath(), Type: &pathType}
+	}
+	for i, volume := range pod.Spec.Volumes {
+		if hostPath, ok := guestPaths[volume.Name]; ok {
+			pod.Spec.Volumes[i].VolumeSource = v1.VolumeSource{HostPath: &hostPath}
+		}
+	}
+	return nil
 }
 
 func reviewResponseWithPatch(uid types.UID, allowed bool, httpCode int32,
@@ -113,16 +281,13 @@ func (v Handler) validatePodUpdate() (*admissionv1.AdmissionReview, error) {
 		return nil, err
 	}
 
-	if !hasMaroonedPodsGate(oldPod.Spec.SchedulingGates) {
-		return reviewResponse(v.request.UID, true, http.StatusAccepted, validPodUpdate), nil
-	}
-
 	currentPod := v1.Pod{}
 	if err := json.Unmarshal(v.request.Object.Raw, &currentPod); err != nil {
 		return nil, err
 	}
 
-	if hasMaroonedPodsGate(currentPod.Spec.SchedulingGates) {
+	reason := maroonedPodChange(&oldPod, &currentPod)
+	if reason == "" {
 		return reviewResponse(v.request.UID, true, http.StatusAccepted, validPodUpdate), nil
 	}
 
@@ -130,8 +295,57 @@ func (v Handler) validatePodUpdate() (*admissionv1.AdmissionReview, error) {
 		return reviewResponse(v.request.UID, true, http.StatusAccepted, maroonedpodsControllerPodUpdate), nil
 	}
 
-	return reviewResponse(v.request.UID, false, http.StatusForbidden, invalidPodUpdate), nil
+	return reviewResponse(v.request.UID, false, http.StatusForbidden, reason), nil
+}
 
+// usesMaroonedPodsRuntimeClass checks whether the pod opted into marooning through its RuntimeClass
+func usesMaroonedPodsRuntimeClass(pod *v1.Pod) bool {
+	return pod.Spec.RuntimeClassName != nil && *pod.Spec.RuntimeClassName == util.MaroonedPodsRuntimeClassName
+}
+
+// shouldStripRuntimeClass checks whether the pod's RuntimeClass expressed sandboxing intent
+// that is fulfilled by marooning, either the maroonedpods class or a force-maroon class
+func (v Handler) shouldStripRuntimeClass(pod *v1.Pod) bool {
+	if pod.Spec.RuntimeClassName == nil {
+		return false
+	}
+	if usesMaroonedPodsRuntimeClass(pod) {
+		return true
+	}
+	if v.config != nil && v.config.Spec.ForceMaroon != nil {
+		for _, name := range v.config.Spec.ForceMaroon.RuntimeClassNames {
+			if name == *pod.Spec.RuntimeClassName {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// stripRuntimeClass removes the RuntimeClass of the pod along with what the RuntimeClass admission
+// plugin merged into the pod before the webhook: the overhead and the scheduling constraints
+func (v Handler) stripRuntimeClass(pod *v1.Pod) error {
+	runtimeClass, err := v.maroonedpodsCli.NodeV1().RuntimeClasses().Get(context.Background(), *pod.Spec.RuntimeClassName, metav1.GetOptions{})
+	if err != nil && !k8serrors.IsNotFound(err) {
+		return fmt.Errorf("failed to get RuntimeClass %s: %v", *pod.Spec.RuntimeClassName, err)
+	}
+	if err == nil && runtimeClass.Scheduling != nil {
+		for key, value := range runtimeClass.Scheduling.NodeSelector {
+			if pod.Spec.NodeSelector[key] == value {
+				delete(pod.Spec.NodeSelector, key)
+			}
+		}
+		tolerations := []v1.Toleration{}
+		for _, toleration := range pod.Spec.Tolerations {
+			if !hasToleration(runtimeClass.Scheduling.Tolerations, toleration) {
+				tolerations = append(tolerations, toleration)
+			}
+		}
+		pod.Spec.Tolerations = tolerations
+	}
+	pod.Spec.RuntimeClassName = nil
+	pod.Spec.Overhead = nil
+	return nil
 }
 
 func hasMaroonedPodsGate(psgs []v1.PodSchedulingGate) bool {
@@ -146,6 +360,45 @@ func hasMaroonedPodsGate(psgs []v1.PodSchedulingGate) bool {
 	return false
 }
 
+// generatePodName appends a random suffix to the generateName prefix the way the API server does
+func generatePodName(base string) string {
+	if len(base) > maxGeneratedNameLength {
+		base = base[:maxGeneratedNameLength]
+	}
+	suffix := make([]byte, randomNameLength)
+	for i := range suffix {
+		suffix[i] = nameAlphabet[rand.Intn(len(nameAlphabet))]
+	}
+	return base + string(suffix)
+}
+
+// maroonToleration returns the toleration pinning a marooned pod to its dedicated node
+func maroonToleration(nodeName string) v1.Toleration {
+	return v1.Toleration{
+		Key:      fmt.Sprintf("%s.maroonedpods.io", nodeName),
+		Operator: v1.TolerationOpExists,
+		Effect:   v1.TaintEffectNoSchedule,
+	}
+}
+
+func hasFinalizer(finalizers []string, finalizer string) bool {
+	for _, f := range finalizers {
+		if f == finalizer {
+			return true
+		}
+	}
+	return false
+}
+
+func hasToleration(tolerations []v1.Toleration, toleration v1.Toleration) bool {
+	for _, t := range tolerations {
+		if t.MatchToleration(&toleration) {
+			return true
+		}
+	}
+	return false
+}
+
 func ignoreRqErr(err string) string {
 	return strings.TrimPrefix(err, strings.Split(err, ":")[0]+": ")
 }
@@ -0,0 +1,356 @@
+package handler
+
+import (
+	"encoding/json"
+	"strings"
+
+	jsonpatch "github.com/evanphx/json-patch"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	v1 "k8s.io/api/core/v1"
+	nodev1 "k8s.io/api/node/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"maroonedpods.io/maroonedpods/pkg/util"
+	"maroonedpods.io/maroonedpods/staging/src/maroonedpods.io/api/pkg/apis/core/v1alpha1"
+)
+
+// applyPatch applies the JSON patch of an admission response to the pod
+func applyPatch(pod *v1.Pod, response *admissionv1.AdmissionResponse) *v1.Pod {
+	original, err := json.Marshal(pod)
+	Expect(err).ToNot(HaveOccurred())
+	patch, err := jsonpatch.DecodePatch(response.Patch)
+	Expect(err).ToNot(HaveOccurred())
+	patched, err := patch.Apply(original)
+	Expect(err).ToNot(HaveOccurred())
+	result := &v1.Pod{}
+	Expect(json.Unmarshal(patched, result)).To(Succeed())
+	return result
+}
+
+// withDefaultTolerations adds the tolerations set by the DefaultTolerationSeconds admission plugin
+func withDefaultTolerations(pod *v1.Pod) *v1.Pod {
+	seconds := int64(300)
+	pod.Spec.Tolerations = []v1.Toleration{
+		{Key: v1.TaintNodeNotReady, Operator: v1.TolerationOpExists, Effect: v1.TaintEffectNoExecute, TolerationSeconds: &seconds},
+		{Key: v1.TaintNodeUnreachable, Operator: v1.TolerationOpExists, Effect: v1.TaintEffectNoExecute, TolerationSeconds: &seconds},
+	}
+	return pod
+}
+
+var _ = Describe("Maroon mutation", func() {
+	handle := func(pod *v1.Pod, config *v1alpha1.MaroonedPodsConfig) *admissionv1.AdmissionResponse {
+		review, err := NewHandler(newCreateRequest(pod), fake.NewSimpleClientset(), nil, util.DefaultMaroonedPodsNs, config).Handle()
+		Expect(err).ToNot(HaveOccurred())
+		return review.Response
+	}
+
+	DescribeTable("should generate a patch that merges with the pod", func(mutate func(*v1.Pod), verify func(*v1.Pod)) {
+		pod := newMaroonedPod()
+		mutate(pod)
+		response := handle(pod, nil)
+		Expect(response.Allowed).To(BeTrue())
+
+		mutated := pod
+		if len(response.Patch) > 0 {
+			mutated = applyPatch(pod, response)
+		}
+		Expect(mutated.Labels).To(HaveKeyWithValue(util.MaroonedPodLabel, "true"))
+		Expect(mutated.Finalizers).To(ContainElement(util.MaroonedPodsFinalizer))
+		Expect(mutated.Spec.SchedulingGates).To(ContainElement(v1.PodSchedulingGate{Name: util.MaroonedPodsGate}))
+		Expect(mutated.Spec.NodeSelector).To(HaveKeyWithValue(v1.LabelHostname, pod.Name))
+		Expect(mutated.Spec.Tolerations).To(ContainElement(v1.Toleration{
+			Key:      pod.Name + ".maroonedpods.io",
+			Operator: v1.TolerationOpExists,
+			Effect:   v1.TaintEffectNoSchedule,
+		}))
+		verify(mutated)
+
+		By("admitting the mutated pod again")
+		Expect(handle(mutated, nil).Patch).To(BeEmpty())
+	},
+		Entry("minimal pod", func(pod *v1.Pod) {}, func(pod *v1.Pod) {
+			Expect(pod.Finalizers).To(HaveLen(1))
+			Expect(pod.Spec.SchedulingGates).To(HaveLen(1))
+			Expect(pod.Spec.Tolerations).To(HaveLen(1))
+		}),
+		Entry("pod with default tolerations", func(pod *v1.Pod) { withDefaultTolerations(pod) }, func(pod *v1.Pod) {
+			Expect(pod.Spec.Tolerations).To(HaveLen(3))
+		}),
+		Entry("pod with a nodeSelector", func(pod *v1.Pod) {
+			pod.Spec.NodeSelector = map[string]string{"disktype": "ssd"}
+		}, func(pod *v1.Pod) {
+			Expect(pod.Spec.NodeSelector).To(HaveKeyWithValue("disktype", "ssd"))
+			Expect(pod.Spec.NodeSelector).To(HaveLen(2))
+		}),
+		Entry("pod with other scheduling gates", func(pod *v1.Pod) {
+			pod.Spec.SchedulingGates = []v1.PodSchedulingGate{{Name: "example.com/quota"}}
+		}, func(pod *v1.Pod) {
+			Expect(pod.Spec.SchedulingGates).To(ConsistOf(
+				v1.PodSchedulingGate{Name: "example.com/quota"},
+				v1.PodSchedulingGate{Name: util.MaroonedPodsGate},
+			))
+		}),
+		Entry("pod with other finalizers", func(pod *v1.Pod) {
+			pod.Finalizers = []string{"example.com/protect"}
+		}, func(pod *v1.Pod) {
+			Expect(pod.Finalizers).To(ConsistOf("example.com/protect", util.MaroonedPodsFinalizer))
+		}),
+		Entry("pod with other labels", func(pod *v1.Pod) {
+			pod.Labels["app"] = "web"
+		}, func(pod *v1.Pod) {
+			Expect(pod.Labels).To(HaveKeyWithValue("app", "web"))
+		}),
+		Entry("pod that already has the maroon gate and finalizer", func(pod *v1.Pod) {
+			pod.Finalizers = []string{util.MaroonedPodsFinalizer}
+			pod.Spec.SchedulingGates = []v1.PodSchedulingGate{{Name: util.MaroonedPodsGate}}
+		}, func(pod *v1.Pod) {
+			Expect(pod.Finalizers).To(HaveLen(1))
+			Expect(pod.Spec.SchedulingGates).To(HaveLen(1))
+		}),
+		Entry("pod that already tolerates its node", func(pod *v1.Pod) {
+			pod.Spec.Tolerations = []v1.Toleration{{
+				Key:      pod.Name + ".maroonedpods.io",
+				Operator: v1.TolerationOpExists,
+				Effect:   v1.TaintEffectNoSchedule,
+			}}
+		}, func(pod *v1.Pod) {
+			Expect(pod.Spec.Tolerations).To(HaveLen(1))
+		}),
+		Entry("pod selecting another hostname", func(pod *v1.Pod) {
+			pod.Spec.NodeSelector = map[string]string{v1.LabelHostname: "worker-1"}
+		}, func(pod *v1.Pod) {
+			Expect(pod.Spec.NodeSelector).To(HaveLen(1))
+		}),
+		Entry("pod with init containers and volumes", func(pod *v1.Pod) {
+			pod.Spec.InitContainers = []v1.Container{{Name: "init", Image: "busybox"}}
+			pod.Spec.Volumes = []v1.Volume{{Name: "scratch", VolumeSource: v1.VolumeSource{EmptyDir: &v1.EmptyDirVolumeSource{}}}}
+		}, func(pod *v1.Pod) {
+			Expect(pod.Spec.InitContainers).To(HaveLen(1))
+			Expect(pod.Spec.Volumes).To(HaveLen(1))
+		}),
+		Entry("pod that is already marooned", func(pod *v1.Pod) {
+			pod.Finalizers = []string{util.MaroonedPodsFinalizer}
+			pod.Spec.SchedulingGates = []v1.PodSchedulingGate{{Name: util.MaroonedPodsGate}}
+			pod.Spec.NodeSelector = map[string]string{v1.LabelHostname: pod.Name}
+			pod.Spec.Tolerations = []v1.Toleration{{
+				Key:      pod.Name + ".maroonedpods.io",
+				Operator: v1.TolerationOpExists,
+				Effect:   v1.TaintEffectNoSchedule,
+			}}
+		}, func(pod *v1.Pod) {
+			Expect(pod.Spec.Tolerations).To(HaveLen(1))
+		}),
+	)
+
+	Context("volume passthrough", func() {
+		It("should point PVC, ConfigMap and Secret volumes to their device in the guest", func() {
+			pod := withDefaultTolerations(newMaroonedPod())
+			pod.Spec.Containers[0].VolumeDevices = []v1.VolumeDevice{{Name: "raw", DevicePath: "/dev/xvda"}}
+			pod.Spec.Volumes = []v1.Volume{
+				{Name: "data", VolumeSource: v1.VolumeSource{PersistentVolumeClaim: &v1.PersistentVolumeClaimVolumeSource{ClaimName: "data"}}},
+				{Name: "raw", VolumeSource: v1.VolumeSource{PersistentVolumeClaim: &v1.PersistentVolumeClaimVolumeSource{ClaimName: "raw"}}},
+				{Name: "settings", VolumeSource: v1.VolumeSource{ConfigMap: &v1.ConfigMapVolumeSource{
+					LocalObjectReference: v1.LocalObjectReference{Name: "settings"},
+				}}},
+				{Name: "credentials", VolumeSource: v1.VolumeSource{Secret: &v1.SecretVolumeSource{SecretName: "credentials"}}},
+			}
+
+			mutated := applyPatch(pod, handle(pod, nil))
+			passthroughVolumes, err := util.GetPassthroughVolumes(mutated)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(passthroughVolumes).To(HaveLen(4))
+			Expect(passthroughVolumes[0].PersistentVolumeClaim.ClaimName).To(Equal("data"))
+			Expect(passthroughVolumes[1].Block).To(BeTrue())
+
+			directory, blockDevice := v1.HostPathDirectory, v1.HostPathBlockDev
+			Expect(mutated.Spec.Volumes[0].VolumeSource).To(Equal(v1.VolumeSource{HostPath: &v1.HostPathVolumeSource{
+				Path: "/var/lib/marooned/volumes/data", Type: &directory,
+			}}))
+			Expect(mutated.Spec.Volumes[1].VolumeSource).To(Equal(v1.VolumeSource{HostPath: &v1.HostPathVolumeSource{
+				Path: passthroughVolumes[1].GuestPath(), Type: &blockDevice,
+			}}))
+			Expect(mutated.Spec.Volumes[2].HostPath.Path).To(Equal("/var/lib/marooned/volumes/settings"))
+			Expect(mutated.Spec.Volumes[3].HostPath.Path).To(Equal("/var/lib/marooned/volumes/credentials"))
+		})
+
+		It("should leave projected keys to the guest kubelet", func() {
+			pod := withDefaultTolerations(newMaroonedPod())
+			pod.Spec.Volumes = []v1.Volume{{Name: "settings", VolumeSource: v1.VolumeSource{ConfigMap: &v1.ConfigMapVolumeSource{
+				LocalObjectReference: v1.LocalObjectReference{Name: "settings"},
+				Items:                []v1.KeyToPath{{Key: "app.conf", Path: "app.conf"}},
+			}}}}
+
+			mutated := applyPatch(pod, handle(pod, nil))
+			Expect(mutated.Annotations).ToNot(HaveKey(util.PassthroughVolumesAnnotation))
+			Expect(mutated.Spec.Volumes[0].ConfigMap).ToNot(BeNil())
+		})
+
+		It("should leave other volumes alone", func() {
+			pod := withDefaultTolerations(newMaroonedPod())
+			pod.Spec.Volumes = []v1.Volume{
+				{Name: "data", VolumeSource: v1.VolumeSource{PersistentVolumeClaim: &v1.PersistentVolumeClaimVolumeSource{ClaimName: "data"}}},
+				{Name: "scratch", VolumeSource: v1.VolumeSource{EmptyDir: &v1.EmptyDirVolumeSource{}}},
+			}
+
+			mutated := applyPatch(pod, handle(pod, nil))
+			Expect(mutated.Spec.Volumes[0].HostPath).ToNot(BeNil())
+			Expect(mutated.Spec.Volumes[1].EmptyDir).ToNot(BeNil())
+		})
+
+		It("should replace a volumes annotation set by the client", func() {
+			pod := withDefaultTolerations(newMaroonedPod())
+			pod.Annotations = map[string]string{util.PassthroughVolumesAnnotation: `[{"name":"data","persistentVolumeClaim":{"claimName":"other"}}]`}
+			pod.Spec.Volumes = []v1.Volume{{Name: "data", VolumeSource: v1.VolumeSource{
+				PersistentVolumeClaim: &v1.PersistentVolumeClaimVolumeSource{ClaimName: "data"},
+			}}}
+
+			mutated := applyPatch(pod, handle(pod, nil))
+			passthroughVolumes, err := util.GetPassthroughVolumes(mutated)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(passthroughVolumes).To(ConsistOf(HaveField("PersistentVolumeClaim.ClaimName", "data")))
+
+			pod.Spec.Volumes = []v1.Volume{{Name: "scratch", VolumeSource: v1.VolumeSource{EmptyDir: &v1.EmptyDirVolumeSource{}}}}
+			mutated = applyPatch(pod, handle(pod, nil))
+			Expect(mutated.Annotations).ToNot(HaveKey(util.PassthroughVolumesAnnotation))
+		})
+
+		It("should reject hostPath volumes even with a volumes annotation set by the client", func() {
+			pod := newMaroonedPod()
+			pod.Annotations = map[string]string{util.PassthroughVolumesAnnotation: `[{"name":"data","persistentVolumeClaim":{"claimName":"data"}}]`}
+			pod.Spec.Volumes = []v1.Volume{{Name: "data", VolumeSource: v1.VolumeSource{HostPath: &v1.HostPathVolumeSource{
+				Path: "/var/lib/marooned/volumes/data",
+			}}}}
+			Expect(handle(pod, nil).Allowed).To(BeFalse())
+		})
+	})
+
+	It("should record sidecar init containers", func() {
+		pod := withDefaultTolerations(newMaroonedPod())
+		pod.Spec.InitContainers = []v1.Container{{Name: "migrate", Image: "migrate"}, {Name: "mesh", Image: "envoy"}}
+		request := newCreateRequest(pod)
+		// restartPolicy of init containers isn't part of the core API types we build against
+		rawPod := map[string]interface{}{}
+		Expect(json.Unmarshal(request.Object.Raw, &rawPod)).To(Succeed())
+		initContainers := rawPod["spec"].(map[string]interface{})["initContainers"].([]interface{})
+		initContainers[1].(map[string]interface{})["restartPolicy"] = "Always"
+		raw, err := json.Marshal(rawPod)
+		Expect(err).ToNot(HaveOccurred())
+		request.Object.Raw = raw
+
+		review, err := NewHandler(request, fake.NewSimpleClientset(), nil, util.DefaultMaroonedPodsNs, nil).Handle()
+		Expect(err).ToNot(HaveOccurred())
+		mutated := applyPatch(pod, review.Response)
+		Expect(mutated.Annotations).To(HaveKeyWithValue(util.SidecarContainersAnnotation, "mesh"))
+	})
+
+	It("should replace sidecar containers recorded by the client", func() {
+		pod := withDefaultTolerations(newMaroonedPod())
+		pod.Annotations = map[string]string{util.SidecarContainersAnnotation: "app"}
+
+		mutated := applyPatch(pod, handle(pod, nil))
+		Expect(mutated.Annotations).ToNot(HaveKey(util.SidecarContainersAnnotation))
+	})
+
+	It("should name generateName pods so they can be pinned to their node", func() {
+		pod := newMaroonedPod()
+		pod.Name = ""
+		pod.GenerateName = "web-7d9f8b6c5d-"
+
+		mutated := applyPatch(pod, handle(pod, nil))
+		Expect(mutated.Name).To(MatchRegexp(`^web-7d9f8b6c5d-[bcdfghjklmnpqrstvwxz2456789]{5}$`))
+		Expect(mutated.Spec.NodeSelector).To(HaveKeyWithValue(v1.LabelHostname, mutated.Name))
+		Expect(mutated.Spec.Tolerations).To(ContainElement(maroonToleration(mutated.Name)))
+	})
+
+	It("should pin the pods of an island to their shared node", func() {
+		pod := newMaroonedPod()
+		pod.Labels[util.IslandLabel] = "shop"
+
+		mutated := applyPatch(pod, handle(pod, nil))
+		Expect(mutated.Spec.NodeSelector).To(HaveKeyWithValue(v1.LabelHostname, util.IslandVMName(testNamespace, "shop")))
+		Expect(mutated.Spec.Tolerations).To(ContainElement(maroonToleration(util.IslandVMName(testNamespace, "shop"))))
+	})
+
+	It("should keep generated names within the hostname label limit", func() {
+		name := generatePodName(strings.Repeat("a", 100))
+		Expect(name).To(HaveLen(63))
+		Expect(name).To(HavePrefix(strings.Repeat("a", 58)))
+	})
+
+	Context("RuntimeClass opt-in", func() {
+		newRuntimeClassPod := func(runtimeClassName string) *v1.Pod {
+			pod := newMaroonedPod()
+			pod.Labels = nil
+			pod.Spec.RuntimeClassName = &runtimeClassName
+			return withDefaultTolerations(pod)
+		}
+
+		It("should maroon pods referencing the maroonedpods RuntimeClass", func() {
+			pod := newRuntimeClassPod(util.MaroonedPodsRuntimeClassName)
+			response := handle(pod, nil)
+			Expect(response.Allowed).To(BeTrue())
+
+			mutated := applyPatch(pod, response)
+			Expect(mutated.Labels).To(HaveKeyWithValue(util.MaroonedPodLabel, "true"))
+			Expect(mutated.Spec.SchedulingGates).To(ContainElement(v1.PodSchedulingGate{Name: util.MaroonedPodsGate}))
+			Expect(mutated.Finalizers).To(ContainElement(util.MaroonedPodsFinalizer))
+			Expect(mutated.Spec.RuntimeClassName).To(BeNil())
+		})
+
+		It("should strip force-maroon RuntimeClasses and their overhead", func() {
+			pod := newRuntimeClassPod("kata")
+			pod.Spec.Overhead = v1.ResourceList{v1.ResourceMemory: resource.MustParse("160Mi")}
+			config := newConfigWithPolicy(nil)
+			config.Spec.ForceMaroon = &v1alpha1.ForceMaroonPolicy{RuntimeClassNames: []string{"kata"}}
+
+			mutated := applyPatch(pod, handle(pod, config))
+			Expect(mutated.Labels).To(HaveKeyWithValue(util.MaroonedPodLabel, "true"))
+			Expect(mutated.Spec.RuntimeClassName).To(BeNil())
+			Expect(mutated.Spec.Overhead).To(BeNil())
+		})
+
+		It("should remove the scheduling constraints of stripped RuntimeClasses", func() {
+			runtimeClass := &nodev1.RuntimeClass{
+				ObjectMeta: metav1.ObjectMeta{Name: "kata"},
+				Handler:    "kata",
+				Scheduling: &nodev1.Scheduling{
+					NodeSelector: map[string]string{"katacontainers.io/kata-runtime": "true"},
+					Tolerations:  []v1.Toleration{{Key: "kata", Operator: v1.TolerationOpExists, Effect: v1.TaintEffectNoSchedule}},
+				},
+			}
+			// Merged into the pod by the RuntimeClass admission plugin
+			pod := newRuntimeClassPod("kata")
+			pod.Spec.NodeSelector = map[string]string{"katacontainers.io/kata-runtime": "true", "disktype": "ssd"}
+			pod.Spec.Tolerations = append(pod.Spec.Tolerations, runtimeClass.Scheduling.Tolerations...)
+			config := newConfigWithPolicy(nil)
+			config.Spec.ForceMaroon = &v1alpha1.ForceMaroonPolicy{RuntimeClassNames: []string{"kata"}}
+
+			review, err := NewHandler(newCreateRequest(pod), fake.NewSimpleClientset(runtimeClass), nil, util.DefaultMaroonedPodsNs, config).Handle()
+			Expect(err).ToNot(HaveOccurred())
+			mutated := applyPatch(pod, review.Response)
+			Expect(mutated.Spec.RuntimeClassName).To(BeNil())
+			Expect(mutated.Spec.NodeSelector).To(Equal(map[string]string{"disktype": "ssd", v1.LabelHostname: pod.Name}))
+			Expect(mutated.Spec.Tolerations).To(HaveLen(3))
+			Expect(mutated.Spec.Tolerations).ToNot(ContainElement(runtimeClass.Scheduling.Tolerations[0]))
+		})
+
+		It("should keep unrelated RuntimeClasses of labelled pods", func() {
+			pod := withDefaultTolerations(newMaroonedPod())
+			runtimeClassName := "gvisor"
+			pod.Spec.RuntimeClassName = &runtimeClassName
+
+			mutated := applyPatch(pod, handle(pod, nil))
+			Expect(mutated.Spec.RuntimeClassName).To(HaveValue(Equal("gvisor")))
+		})
+
+		It("should ignore pods with other RuntimeClasses", func() {
+			response := handle(newRuntimeClassPod("gvisor"), nil)
+			Expect(response.Allowed).To(BeTrue())
+			Expect(response.Patch).To(BeEmpty())
+		})
+	})
+})
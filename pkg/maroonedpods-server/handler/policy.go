@@ -0,0 +1,262 @@
+package handler
+
+import (
+	"fmt"
+	"github.com/docker/distribution/reference"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"maroonedpods.io/maroonedpods/pkg/util"
+	"strings"
+)
+
+const (
+	defaultServiceAccountName = "default"
+	anyServiceAccountName     = "*"
+)
+
+// checkMaroonPolicy decides whether the pod may be marooned.
+// It returns an empty reason when the pod is accepted, or a human readable
+// rejection reason otherwise.
+func (v Handler) checkMaroonPolicy(pod *v1.Pod) (string, error) {
+	if reason := unsupportedPodReason(pod); reason != "" {
+		return reason, nil
+	}
+
+	if v.config == nil || v.config.Spec.AdmissionPolicy == nil {
+		return "", nil
+	}
+	policy := v.config.Spec.AdmissionPolicy
+
+	if policy.NamespaceSelector != nil {
+		matches, err := v.namespaceMatches(policy.NamespaceSelector, pod.Namespace)
+		if err != nil {
+			return "", fmt.Errorf("invalid admission policy namespaceSelector: %v", err)
+		}
+		if !matches {
+			return fmt.Sprintf("Namespace %s is not allowed to run marooned pods by the MaroonedPods admission policy", pod.Namespace), nil
+		}
+	}
+
+	if policy.PodSelector != nil {
+		selector, err := metav1.LabelSelectorAsSelector(policy.PodSelector)
+		if err != nil {
+			return "", fmt.Errorf("invalid admission policy podSelector: %v", err)
+		}
+		if !selector.Matches(labels.Set(pod.Labels)) {
+			return "Pod labels do not match the podSelector of the MaroonedPods admission policy", nil
+		}
+	}
+
+	serviceAccount := pod.Spec.ServiceAccountName
+	if serviceAccount == "" {
+		serviceAccount = defaultServiceAccountName
+	}
+	if matchesServiceAccount(policy.DeniedServiceAccounts, pod.Namespace, serviceAccount) {
+		return fmt.Sprintf("Service account %s/%s is denied by the MaroonedPods admission policy", pod.Namespace, serviceAccount), nil
+	}
+	if len(policy.AllowedServiceAccounts) > 0 &&
+		!matchesServiceAccount(policy.AllowedServiceAccounts, pod.Namespace, serviceAccount) {
+		return fmt.Sprintf("Service account %s/%s is not allowed by the MaroonedPods admission policy", pod.Namespace, serviceAccount), nil
+	}
+
+	return "", nil
+}
+
+// isForceMarooned checks whether the pod matches the force-maroon policy
+func (v Handler) isForceMarooned(pod *v1.Pod) (bool, error) {
+	if v.config == nil || v.config.Spec.ForceMaroon == nil {
+		return false, nil
+	}
+	policy := v.config.Spec.ForceMaroon
+
+	if policy.PodSelector != nil {
+		selector, err := metav1.LabelSelectorAsSelector(policy.PodSelector)
+		if err != nil {
+			return false, fmt.Errorf("invalid force-maroon podSelector: %v", err)
+		}
+		if selector.Matches(labels.Set(pod.Labels)) {
+			return true, nil
+		}
+	}
+
+	if pod.Spec.RuntimeClassName != nil {
+		for _, name := range policy.RuntimeClassNames {
+			if name == *pod.Spec.RuntimeClassName {
+				return true, nil
+			}
+		}
+	}
+
+	for _, pattern := range policy.ImagePatterns {
+		for _, image := range podImages(pod) {
+			matched, err := imageMatches(pattern, image)
+			if err != nil {
+				return false, fmt.Errorf("invalid force-maroon image pattern %q: %v", pattern, err)
+			}
+			if matched {
+				return true, nil
+			}
+		}
+	}
+
+	if policy.NamespaceSelector != nil {
+		matches, err := v.namespaceMatches(policy.NamespaceSelector, pod.Namespace)
+		if err != nil {
+			return false, fmt.Errorf("invalid force-maroon namespaceSelector: %v", err)
+		}
+		if matches {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// namespaceMatches checks the labels of the given namespace against a label selector
+func (v Handler) namespaceMatches(labelSelector *metav1.LabelSelector, namespace string) (bool, error) {
+	selector, err := metav1.LabelSelectorAsSelector(labelSelector)
+	if err != nil {
+		return false, err
+	}
+	if selector.Empty() {
+		return true, nil
+	}
+	ns, err := v.namespaceLister.Get(namespace)
+	if err != nil {
+		return false, err
+	}
+	return selector.Matches(labels.Set(ns.Labels)), nil
+}
+
+// imageMatches checks an image against a force-maroon image pattern.
+// Both sides are normalized, so "nginx" matches "docker.io/library/nginx:latest".
+// A pattern ending in "/*" matches every repository below the prefix,
+// a pattern without tag or digest matches every tag of the repository.
+func imageMatches(pattern, image string) (bool, error) {
+	named, err := reference.ParseNormalizedNamed(image)
+	if err != nil {
+		// The image can't be pulled anyway, leave it to the kubelet to report
+		return false, nil
+	}
+
+	if strings.HasSuffix(pattern, "/*") {
+		// Normalize the prefix with a placeholder repository, so "docker.io/*"
+		// doesn't become the official "docker.io/library" namespace
+		const placeholder = "x/x"
+		prefix, err := reference.ParseNormalizedNamed(strings.TrimSuffix(pattern, "*") + placeholder)
+		if err != nil {
+			return false, err
+		}
+		return strings.HasPrefix(named.Name(), strings.TrimSuffix(prefix.Name(), placeholder)), nil
+	}
+
+	ref, err := reference.ParseNormalizedNamed(pattern)
+	if err != nil {
+		return false, err
+	}
+	if reference.IsNameOnly(ref) {
+		return ref.Name() == named.Name(), nil
+	}
+	return ref.String() == reference.TagNameOnly(named).String(), nil
+}
+
+// podImages returns the images of all init and regular containers of the pod
+func podImages(pod *v1.Pod) []string {
+	var images []string
+	for _, container := range pod.Spec.InitContainers {
+		images = append(images, container.Image)
+	}
+	for _, container := range pod.Spec.Containers {
+		images = append(images, container.Image)
+	}
+	return images
+}
+
+// unsupportedPodReason returns a rejection reason for pods that cannot work on a dedicated VM node
+func unsupportedPodReason(pod *v1.Pod) string {
+	if pod.Spec.HostNetwork {
+		return "Pods using hostNetwork cannot be marooned: the host network is not available inside a dedicated VM"
+	}
+	if pod.Spec.HostPID {
+		return "Pods using hostPID cannot be marooned: the host PID namespace is not available inside a dedicated VM"
+	}
+	if pod.Spec.HostIPC {
+		return "Pods using hostIPC cannot be marooned: the host IPC namespace is not available inside a dedicated VM"
+	}
+	for _, volume := range pod.Spec.Volumes {
+		// Only the webhook points volumes to their device in the guest, after this check
+		if volume.HostPath != nil {
+			return fmt.Sprintf("Pods using hostPath volumes cannot be marooned: volume %s refers to a path on the host", volume.Name)
+		}
+	}
+	if pod.Spec.NodeName != "" {
+		return fmt.Sprintf("Pods with an explicit nodeName cannot be marooned: the pod is bound to node %s", pod.Spec.NodeName)
+	}
+	for _, owner := range pod.OwnerReferences {
+		if owner.Kind == "DaemonSet" {
+			return fmt.Sprintf("Pods owned by DaemonSet %s cannot be marooned: DaemonSet pods must run on every node", owner.Name)
+		}
+	}
+	if _, err := util.GetPodNetworks(pod); err != nil {
+		return err.Error()
+	}
+	if _, _, err := util.GetVMResourcesOverride(pod); err != nil {
+		return err.Error()
+	}
+	if reason := unsupportedIslandReason(pod); reason != "" {
+		return reason
+	}
+	if size := unsupportedHugepageSize(pod); size != "" {
+		return fmt.Sprintf("Pods requesting hugepages-%s cannot be marooned: VMs support hugepages of %s", size, strings.Join(util.HugepageSizes, ", "))
+	}
+	return ""
+}
+
+// unsupportedIslandReason returns a rejection reason for pods that cannot share the VM of their island.
+// The island VM is created for whichever members exist at the time, so it only carries what every pod shares.
+func unsupportedIslandReason(pod *v1.Pod) string {
+	island := util.GetIsland(pod)
+	if island == "" {
+		return ""
+	}
+	if volumes := util.SelectPassthroughVolumes(pod); len(volumes) > 0 {
+		return fmt.Sprintf("Pods of island %s cannot pass volume %s through: the VM is shared with the other pods of the island", island, volumes[0].Name)
+	}
+	if _, ok := pod.Annotations[util.MaroonedPodNetworksAnnotation]; ok {
+		return fmt.Sprintf("Pods of island %s cannot attach secondary networks: the VM is shared with the other pods of the island", island)
+	}
+	for _, annotation := range []string{util.VMCPUAnnotation, util.VMMemoryAnnotation} {
+		if _, ok := pod.Annotations[annotation]; ok {
+			return fmt.Sprintf("Pods of island %s cannot size the VM with %s: it is sized to the sum of the pods of the island", island, annotation)
+		}
+	}
+	return ""
+}
+
+// unsupportedHugepageSize returns the first hugepage size requested by the pod that VMs can't provide
+func unsupportedHugepageSize(pod *v1.Pod) string {
+	for _, containers := range [][]v1.Container{pod.Spec.InitContainers, pod.Spec.Containers} {
+		for _, container := range containers {
+			for _, resources := range []v1.ResourceList{container.Resources.Requests, container.Resources.Limits} {
+				for name := range resources {
+					if size := util.HugepageSize(name); size != "" && !util.IsSupportedHugepageSize(size) {
+						return size
+					}
+				}
+			}
+		}
+	}
+	return ""
+}
+
+// matchesServiceAccount checks whether namespace/name is listed in entries of the form <namespace>/<name>
+func matchesServiceAccount(entries []string, namespace, name string) bool {
+	for _, entry := range entries {
+		if entry == fmt.Sprintf("%s/%s", namespace, name) ||
+			entry == fmt.Sprintf("%s/%s", namespace, anyServiceAccountName) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,292 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	v1listers "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"maroonedpods.io/maroonedpods/pkg/util"
+	"maroonedpods.io/maroonedpods/staging/src/maroonedpods.io/api/pkg/apis/core/v1alpha1"
+)
+
+const testNamespace = "tenant"
+
+func newMaroonedPod() *v1.Pod {
+	return &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test-pod",
+			Namespace: testNamespace,
+			Labels:    map[string]string{util.MaroonedPodLabel: "true"},
+		},
+		Spec: v1.PodSpec{
+			Containers: []v1.Container{{Name: "nginx", Image: "nginx:latest"}},
+		},
+	}
+}
+
+func newCreateRequest(pod *v1.Pod) *admissionv1.AdmissionRequest {
+	raw, err := json.Marshal(pod)
+	Expect(err).ToNot(HaveOccurred())
+	return &admissionv1.AdmissionRequest{
+		UID:       "test-uid",
+		Kind:      metav1.GroupVersionKind{Kind: "Pod"},
+		Namespace: testNamespace,
+		Operation: admissionv1.Create,
+		Object:    runtime.RawExtension{Raw: raw},
+	}
+}
+
+func newConfigWithPolicy(policy *v1alpha1.AdmissionPolicy) *v1alpha1.MaroonedPodsConfig {
+	return &v1alpha1.MaroonedPodsConfig{
+		ObjectMeta: metav1.ObjectMeta{Name: "default"},
+		Spec:       v1alpha1.MaroonedPodsConfigSpec{AdmissionPolicy: policy},
+	}
+}
+
+var _ = Describe("Maroon admission policy", func() {
+	var namespaceLister v1listers.NamespaceLister
+
+	BeforeEach(func() {
+		indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+		Expect(indexer.Add(&v1.Namespace{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:   testNamespace,
+				Labels: map[string]string{"tenant": "untrusted"},
+			},
+		})).To(Succeed())
+		namespaceLister = v1listers.NewNamespaceLister(indexer)
+	})
+
+	handle := func(pod *v1.Pod, config *v1alpha1.MaroonedPodsConfig) *admissionv1.AdmissionResponse {
+		review, err := NewHandler(newCreateRequest(pod), fake.NewSimpleClientset(), namespaceLister, util.DefaultMaroonedPodsNs, config).Handle()
+		Expect(err).ToNot(HaveOccurred())
+		return review.Response
+	}
+
+	It("should mutate a marooned pod when no policy is configured", func() {
+		response := handle(newMaroonedPod(), nil)
+		Expect(response.Allowed).To(BeTrue())
+		Expect(response.Patch).ToNot(BeEmpty())
+	})
+
+	It("should maroon pods carrying the maroon label with any value", func() {
+		pod := newMaroonedPod()
+		pod.Labels[util.MaroonedPodLabel] = ""
+		response := handle(pod, nil)
+		Expect(response.Allowed).To(BeTrue())
+		Expect(response.Patch).ToNot(BeEmpty())
+	})
+
+	It("should not mutate pods without the maroon label", func() {
+		pod := newMaroonedPod()
+		delete(pod.Labels, util.MaroonedPodLabel)
+		response := handle(pod, nil)
+		Expect(response.Allowed).To(BeTrue())
+		Expect(response.Patch).To(BeEmpty())
+	})
+
+	DescribeTable("should reject pods that cannot run in a dedicated VM", func(mutate func(*v1.Pod), reason string) {
+		pod := newMaroonedPod()
+		mutate(pod)
+		response := handle(pod, nil)
+		Expect(response.Allowed).To(BeFalse())
+		Expect(response.Result.Code).To(Equal(int32(http.StatusForbidden)))
+		Expect(response.Result.Message).To(ContainSubstring(reason))
+	},
+		Entry("hostNetwork", func(pod *v1.Pod) { pod.Spec.HostNetwork = true }, "hostNetwork"),
+		Entry("hostPID", func(pod *v1.Pod) { pod.Spec.HostPID = true }, "hostPID"),
+		Entry("hostIPC", func(pod *v1.Pod) { pod.Spec.HostIPC = true }, "hostIPC"),
+		Entry("hostPath volume", func(pod *v1.Pod) {
+			pod.Spec.Volumes = []v1.Volume{{
+				Name:         "host-logs",
+				VolumeSource: v1.VolumeSource{HostPath: &v1.HostPathVolumeSource{Path: "/var/log"}},
+			}}
+		}, "volume host-logs"),
+		Entry("explicit nodeName", func(pod *v1.Pod) { pod.Spec.NodeName = "worker-1" }, "node worker-1"),
+		Entry("DaemonSet owner", func(pod *v1.Pod) {
+			pod.OwnerReferences = []metav1.OwnerReference{{APIVersion: "apps/v1", Kind: "DaemonSet", Name: "agent", UID: "uid"}}
+		}, "DaemonSet agent"),
+		Entry("invalid networks annotation", func(pod *v1.Pod) {
+			pod.Annotations = map[string]string{util.MaroonedPodNetworksAnnotation: `[{"name":"vlan100","ips":["10.0.0.5"]}]`}
+		}, "CIDR"),
+		Entry("network of another namespace", func(pod *v1.Pod) {
+			pod.Annotations = map[string]string{util.MaroonedPodNetworksAnnotation: "infra/storage"}
+		}, "outside the pod namespace"),
+		Entry("invalid VM resources override", func(pod *v1.Pod) {
+			pod.Annotations = map[string]string{util.VMMemoryAnnotation: "-1Gi"}
+		}, util.VMMemoryAnnotation),
+		Entry("unsupported hugepage size", func(pod *v1.Pod) {
+			pod.Spec.Containers[0].Resources.Limits = v1.ResourceList{"hugepages-32Mi": resource.MustParse("64Mi")}
+		}, "hugepages-32Mi"),
+		Entry("island pod passing a volume through", func(pod *v1.Pod) {
+			pod.Labels[util.IslandLabel] = "shop"
+			pod.Spec.Volumes = []v1.Volume{{
+				Name:         "data",
+				VolumeSource: v1.VolumeSource{PersistentVolumeClaim: &v1.PersistentVolumeClaimVolumeSource{ClaimName: "data"}},
+			}}
+		}, "volume data"),
+		Entry("island pod attaching networks", func(pod *v1.Pod) {
+			pod.Labels[util.IslandLabel] = "shop"
+			pod.Annotations = map[string]string{util.MaroonedPodNetworksAnnotation: "vlan100"}
+		}, "secondary networks"),
+		Entry("island pod sizing the VM", func(pod *v1.Pod) {
+			pod.Labels[util.IslandLabel] = "shop"
+			pod.Annotations = map[string]string{util.VMMemoryAnnotation: "8Gi"}
+		}, util.VMMemoryAnnotation),
+	)
+
+	DescribeTable("should apply the configured policy", func(policy *v1alpha1.AdmissionPolicy, mutate func(*v1.Pod), allowed bool) {
+		pod := newMaroonedPod()
+		if mutate != nil {
+			mutate(pod)
+		}
+		response := handle(pod, newConfigWithPolicy(policy))
+		Expect(response.Allowed).To(Equal(allowed))
+		if !allowed {
+			Expect(response.Result.Message).ToNot(BeEmpty())
+		}
+	},
+		Entry("matching namespace selector", &v1alpha1.AdmissionPolicy{
+			NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"tenant": "untrusted"}},
+		}, nil, true),
+		Entry("non-matching namespace selector", &v1alpha1.AdmissionPolicy{
+			NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"tenant": "trusted"}},
+		}, nil, false),
+		Entry("matching pod selector", &v1alpha1.AdmissionPolicy{
+			PodSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "web"}},
+		}, func(pod *v1.Pod) { pod.Labels["app"] = "web" }, true),
+		Entry("non-matching pod selector", &v1alpha1.AdmissionPolicy{
+			PodSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "web"}},
+		}, nil, false),
+		Entry("allowed service account", &v1alpha1.AdmissionPolicy{
+			AllowedServiceAccounts: []string{testNamespace + "/builder"},
+		}, func(pod *v1.Pod) { pod.Spec.ServiceAccountName = "builder" }, true),
+		Entry("wildcard service account", &v1alpha1.AdmissionPolicy{
+			AllowedServiceAccounts: []string{testNamespace + "/*"},
+		}, func(pod *v1.Pod) { pod.Spec.ServiceAccountName = "builder" }, true),
+		Entry("service account not in allowed list", &v1alpha1.AdmissionPolicy{
+			AllowedServiceAccounts: []string{testNamespace + "/builder"},
+		}, nil, false),
+		Entry("denied service account", &v1alpha1.AdmissionPolicy{
+			AllowedServiceAccounts: []string{testNamespace + "/*"},
+			DeniedServiceAccounts:  []string{testNamespace + "/default"},
+		}, nil, false),
+	)
+
+	Context("force-maroon policy", func() {
+		newUnlabeledPod := func() *v1.Pod {
+			pod := newMaroonedPod()
+			pod.Labels = nil
+			return pod
+		}
+
+		DescribeTable("should maroon matching pods without the maroon label", func(policy *v1alpha1.ForceMaroonPolicy, mutate func(*v1.Pod)) {
+			pod := newUnlabeledPod()
+			if mutate != nil {
+				mutate(pod)
+			}
+			config := newConfigWithPolicy(nil)
+			config.Spec.ForceMaroon = policy
+			response := handle(pod, config)
+			Expect(response.Allowed).To(BeTrue())
+			Expect(applyPatch(pod, response).Labels).To(HaveKeyWithValue(util.MaroonedPodLabel, "true"))
+		},
+			Entry("matching namespace", &v1alpha1.ForceMaroonPolicy{
+				NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"tenant": "untrusted"}},
+			}, nil),
+			Entry("matching pod selector", &v1alpha1.ForceMaroonPolicy{
+				PodSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "web"}},
+			}, func(pod *v1.Pod) { pod.Labels = map[string]string{"app": "web"} }),
+			Entry("matching runtime class", &v1alpha1.ForceMaroonPolicy{
+				RuntimeClassNames: []string{"kata"},
+			}, func(pod *v1.Pod) {
+				runtimeClass := "kata"
+				pod.Spec.RuntimeClassName = &runtimeClass
+			}),
+			Entry("matching image pattern", &v1alpha1.ForceMaroonPolicy{
+				ImagePatterns: []string{"docker.io/untrusted/*"},
+			}, func(pod *v1.Pod) { pod.Spec.Containers[0].Image = "docker.io/untrusted/app:v1" }),
+		)
+
+		It("should not let pods opt out with the maroon label", func() {
+			pod := newMaroonedPod()
+			pod.Labels[util.MaroonedPodLabel] = "false"
+			config := newConfigWithPolicy(nil)
+			config.Spec.ForceMaroon = &v1alpha1.ForceMaroonPolicy{
+				NamespaceSelector: &metav1.LabelSelector{},
+			}
+			response := handle(pod, config)
+			Expect(response.Allowed).To(BeTrue())
+			Expect(applyPatch(pod, response).Labels).To(HaveKeyWithValue(util.MaroonedPodLabel, "true"))
+		})
+
+		It("should leave non-matching pods alone", func() {
+			config := newConfigWithPolicy(nil)
+			config.Spec.ForceMaroon = &v1alpha1.ForceMaroonPolicy{
+				NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"tenant": "trusted"}},
+				ImagePatterns:     []string{"docker.io/untrusted/*"},
+			}
+			response := handle(newUnlabeledPod(), config)
+			Expect(response.Allowed).To(BeTrue())
+			Expect(response.Patch).To(BeEmpty())
+		})
+
+		DescribeTable("should admit force-marooned pods that cannot run in a dedicated VM unmarooned", func(mutate func(*v1.Pod)) {
+			pod := newUnlabeledPod()
+			mutate(pod)
+			config := newConfigWithPolicy(nil)
+			config.Spec.ForceMaroon = &v1alpha1.ForceMaroonPolicy{
+				NamespaceSelector: &metav1.LabelSelector{},
+			}
+			response := handle(pod, config)
+			Expect(response.Allowed).To(BeTrue())
+			Expect(response.Patch).To(BeEmpty())
+		},
+			Entry("hostNetwork", func(pod *v1.Pod) { pod.Spec.HostNetwork = true }),
+			Entry("DaemonSet owner", func(pod *v1.Pod) {
+				pod.OwnerReferences = []metav1.OwnerReference{{APIVersion: "apps/v1", Kind: "DaemonSet", Name: "agent", UID: "uid"}}
+			}),
+		)
+
+		It("should still reject labeled pods that cannot run in a dedicated VM", func() {
+			pod := newMaroonedPod()
+			pod.Spec.HostNetwork = true
+			config := newConfigWithPolicy(nil)
+			config.Spec.ForceMaroon = &v1alpha1.ForceMaroonPolicy{
+				NamespaceSelector: &metav1.LabelSelector{},
+			}
+			response := handle(pod, config)
+			Expect(response.Allowed).To(BeFalse())
+		})
+
+		DescribeTable("should match normalized images against image patterns", func(pattern, image string, expected bool) {
+			matched, err := imageMatches(pattern, image)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(matched).To(Equal(expected))
+		},
+			Entry("short name against a fully qualified pattern", "docker.io/library/nginx", "nginx", true),
+			Entry("repository prefix across path components", "docker.io/untrusted/*", "untrusted/team/app:v1", true),
+			Entry("registry prefix", "docker.io/*", "nginx:1.25", true),
+			Entry("other registry", "docker.io/untrusted/*", "quay.io/untrusted/app", false),
+			Entry("sibling namespace", "docker.io/untrusted/*", "docker.io/untrustedfoo/app", false),
+			Entry("pattern without tag against any tag", "quay.io/org/app", "quay.io/org/app:v2", true),
+			Entry("pattern with tag against the default tag", "nginx:latest", "docker.io/library/nginx", true),
+			Entry("pattern with tag against another tag", "nginx:latest", "nginx:1.25", false),
+		)
+
+		It("should report invalid image patterns", func() {
+			_, err := imageMatches("Not A Reference", "nginx")
+			Expect(err).To(HaveOccurred())
+		})
+	})
+})
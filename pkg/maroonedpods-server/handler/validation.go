@@ -0,0 +1,124 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	admissionv1 "k8s.io/api/admission/v1"
+	v1 "k8s.io/api/core/v1"
+	"maroonedpods.io/maroonedpods/pkg/util"
+	"net/http"
+	"strings"
+)
+
+// maroonedPodChange returns a rejection reason when the update changes a field
+// that only the MaroonedPods controller may change, or an empty string otherwise
+func maroonedPodChange(oldPod, currentPod *v1.Pod) string {
+	if hasMaroonedPodsGate(oldPod.Spec.SchedulingGates) != hasMaroonedPodsGate(currentPod.Spec.SchedulingGates) {
+		return invalidPodUpdate
+	}
+	if oldPod.Labels[util.MaroonedPodLabel] != currentPod.Labels[util.MaroonedPodLabel] {
+		return fmt.Sprintf(invalidPodFieldUpdate, util.MaroonedPodLabel+" label")
+	}
+	if hasFinalizer(oldPod.Finalizers, util.MaroonedPodsFinalizer) != hasFinalizer(currentPod.Finalizers, util.MaroonedPodsFinalizer) {
+		return fmt.Sprintf(invalidPodFieldUpdate, util.MaroonedPodsFinalizer+" finalizer")
+	}
+
+	// Scheduling constraints only matter for pods that were marooned
+	if oldPod.Labels[util.MaroonedPodLabel] != "true" {
+		return ""
+	}
+	if oldPod.Spec.NodeSelector[v1.LabelHostname] != currentPod.Spec.NodeSelector[v1.LabelHostname] {
+		return fmt.Sprintf(invalidPodFieldUpdate, v1.LabelHostname+" nodeSelector")
+	}
+	if util.GetIsland(oldPod) != util.GetIsland(currentPod) {
+		return fmt.Sprintf(invalidPodFieldUpdate, util.IslandLabel+" label")
+	}
+	if oldPod.Annotations[util.PassthroughVolumesAnnotation] != currentPod.Annotations[util.PassthroughVolumesAnnotation] {
+		return fmt.Sprintf(invalidPodFieldUpdate, util.PassthroughVolumesAnnotation+" annotation")
+	}
+	if oldPod.Annotations[util.SidecarContainersAnnotation] != currentPod.Annotations[util.SidecarContainersAnnotation] {
+		return fmt.Sprintf(invalidPodFieldUpdate, util.SidecarContainersAnnotation+" annotation")
+	}
+	toleration := maroonToleration(util.VirtualNodeName(oldPod))
+	if hasToleration(oldPod.Spec.Tolerations, toleration) != hasToleration(currentPod.Spec.Tolerations, toleration) {
+		return fmt.Sprintf(invalidPodFieldUpdate, toleration.Key+" toleration")
+	}
+	return ""
+}
+
+func (v Handler) validateNodeUpdate() (*admissionv1.AdmissionReview, error) {
+	oldNode := v1.Node{}
+	if err := json.Unmarshal(v.request.OldObject.Raw, &oldNode); err != nil {
+		return nil, err
+	}
+
+	currentNode := v1.Node{}
+	if err := json.Unmarshal(v.request.Object.Raw, &currentNode); err != nil {
+		return nil, err
+	}
+
+	taintKey := v.nodeTaintKey()
+	if !isMaroonedNode(&oldNode, taintKey) || sameMaroonTaints(oldNode.Spec.Taints, currentNode.Spec.Taints, taintKey) {
+		return reviewResponse(v.request.UID, true, http.StatusAccepted, validNodeUpdate), nil
+	}
+
+	if isMaroonedPodsControllerServiceAccount(v.request.UserInfo.Username, v.maroonedpodsNS) {
+		return reviewResponse(v.request.UID, true, http.StatusAccepted, maroonedpodsControllerNodeUpdate), nil
+	}
+
+	return reviewResponse(v.request.UID, false, http.StatusForbidden, fmt.Sprintf(invalidNodeUpdate, oldNode.Name)), nil
+}
+
+// nodeTaintKey returns the configured taint key prefix of virtual nodes
+func (v Handler) nodeTaintKey() string {
+	if v.config != nil && v.config.Spec.NodeTaintKey != "" {
+		return v.config.Spec.NodeTaintKey
+	}
+	return util.DefaultNodeTaintKey
+}
+
+// isMaroonedNode checks whether the node is a virtual node of a marooned pod or of the warm pool
+func isMaroonedNode(node *v1.Node, taintKey string) bool {
+	if _, ok := node.Labels[util.MaroonedNodePodUIDLabel]; ok {
+		return true
+	}
+	if strings.HasPrefix(node.Name, util.WarmPoolVMNamePrefix) {
+		return true
+	}
+	for _, taint := range node.Spec.Taints {
+		if isMaroonTaint(taint, taintKey) {
+			return true
+		}
+	}
+	return false
+}
+
+// isMaroonTaint checks whether the taint was set by MaroonedPods, either by the
+// boot script as <taintKey>/dedicated or by the controller as <podName>/<taintKey>
+func isMaroonTaint(taint v1.Taint, taintKey string) bool {
+	return strings.HasPrefix(taint.Key, taintKey+"/") || strings.HasSuffix(taint.Key, "/"+taintKey)
+}
+
+// sameMaroonTaints compares the maroon taints of two taint lists, ignoring their order
+func sameMaroonTaints(oldTaints, currentTaints []v1.Taint, taintKey string) bool {
+	maroonTaints := func(taints []v1.Taint) map[string]string {
+		result := map[string]string{}
+		for _, taint := range taints {
+			if isMaroonTaint(taint, taintKey) {
+				result[taint.Key+":"+string(taint.Effect)] = taint.Value
+			}
+		}
+		return result
+	}
+	oldMaroonTaints := maroonTaints(oldTaints)
+	currentMaroonTaints := maroonTaints(currentTaints)
+	if len(oldMaroonTaints) != len(currentMaroonTaints) {
+		return false
+	}
+	for key, value := range oldMaroonTaints {
+		if currentValue, ok := currentMaroonTaints[key]; !ok || currentValue != value {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,157 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	"maroonedpods.io/maroonedpods/pkg/util"
+)
+
+const tenantUser = "system:serviceaccount:tenant:default"
+
+var controllerUser = fmt.Sprintf("system:serviceaccount:%s:%s", util.DefaultMaroonedPodsNs, util.ControllerResourceName)
+
+func newUpdateRequest(kind string, oldObj, obj interface{}, username string) *admissionv1.AdmissionRequest {
+	oldRaw, err := json.Marshal(oldObj)
+	Expect(err).ToNot(HaveOccurred())
+	raw, err := json.Marshal(obj)
+	Expect(err).ToNot(HaveOccurred())
+	return &admissionv1.AdmissionRequest{
+		UID:       "test-uid",
+		Kind:      metav1.GroupVersionKind{Kind: kind},
+		Operation: admissionv1.Update,
+		UserInfo:  authenticationv1.UserInfo{Username: username},
+		OldObject: runtime.RawExtension{Raw: oldRaw},
+		Object:    runtime.RawExtension{Raw: raw},
+	}
+}
+
+var _ = Describe("Marooning invariants", func() {
+	validate := func(kind string, oldObj, obj interface{}, username string) *admissionv1.AdmissionResponse {
+		review, err := NewHandler(newUpdateRequest(kind, oldObj, obj, username), fake.NewSimpleClientset(), nil, util.DefaultMaroonedPodsNs, nil).Handle()
+		Expect(err).ToNot(HaveOccurred())
+		return review.Response
+	}
+
+	Context("pod updates", func() {
+		newGatedPod := func() *v1.Pod {
+			pod := newMaroonedPod()
+			pod.Finalizers = []string{util.MaroonedPodsFinalizer}
+			pod.Spec.SchedulingGates = []v1.PodSchedulingGate{{Name: util.MaroonedPodsGate}}
+			pod.Spec.NodeSelector = map[string]string{v1.LabelHostname: pod.Name}
+			pod.Spec.Tolerations = []v1.Toleration{maroonToleration(pod.Name)}
+			return pod
+		}
+
+		DescribeTable("should only let the controller change marooning fields", func(mutate func(*v1.Pod), reason string) {
+			oldPod := newGatedPod()
+			currentPod := oldPod.DeepCopy()
+			mutate(currentPod)
+
+			response := validate("Pod", oldPod, currentPod, tenantUser)
+			Expect(response.Allowed).To(BeFalse())
+			Expect(response.Result.Code).To(Equal(int32(http.StatusForbidden)))
+			Expect(response.Result.Message).To(ContainSubstring(reason))
+
+			Expect(validate("Pod", oldPod, currentPod, controllerUser).Allowed).To(BeTrue())
+		},
+			Entry("removing the gate", func(pod *v1.Pod) { pod.Spec.SchedulingGates = nil }, util.MaroonedPodsGate),
+			Entry("removing the finalizer", func(pod *v1.Pod) { pod.Finalizers = nil }, util.MaroonedPodsFinalizer),
+			Entry("removing the maroon label", func(pod *v1.Pod) { delete(pod.Labels, util.MaroonedPodLabel) }, util.MaroonedPodLabel),
+			Entry("changing the maroon label", func(pod *v1.Pod) { pod.Labels[util.MaroonedPodLabel] = "false" }, util.MaroonedPodLabel),
+			Entry("changing the hostname nodeSelector", func(pod *v1.Pod) {
+				pod.Spec.NodeSelector[v1.LabelHostname] = "shared-node"
+			}, v1.LabelHostname),
+			Entry("removing the toleration", func(pod *v1.Pod) { pod.Spec.Tolerations = nil }, "toleration"),
+			Entry("moving the pod to an island", func(pod *v1.Pod) { pod.Labels[util.IslandLabel] = "shop" }, util.IslandLabel),
+			Entry("changing the passed through volumes", func(pod *v1.Pod) {
+				pod.Annotations = map[string]string{util.PassthroughVolumesAnnotation: `[{"name":"data","persistentVolumeClaim":{"claimName":"other"}}]`}
+			}, util.PassthroughVolumesAnnotation),
+			Entry("changing the sidecar containers", func(pod *v1.Pod) {
+				pod.Annotations = map[string]string{util.SidecarContainersAnnotation: "app"}
+			}, util.SidecarContainersAnnotation),
+		)
+
+		It("should allow unrelated pod updates", func() {
+			oldPod := newGatedPod()
+			currentPod := oldPod.DeepCopy()
+			currentPod.Labels["app"] = "web"
+			currentPod.Spec.NodeSelector["disktype"] = "ssd"
+			Expect(validate("Pod", oldPod, currentPod, tenantUser).Allowed).To(BeTrue())
+		})
+
+		It("should not let users maroon an existing pod", func() {
+			oldPod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "plain", Namespace: testNamespace}}
+			currentPod := oldPod.DeepCopy()
+			currentPod.Labels = map[string]string{util.MaroonedPodLabel: "true"}
+			Expect(validate("Pod", oldPod, currentPod, tenantUser).Allowed).To(BeFalse())
+		})
+
+		It("should allow scheduling changes of pods that are not marooned", func() {
+			oldPod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "plain", Namespace: testNamespace}}
+			currentPod := oldPod.DeepCopy()
+			currentPod.Spec.NodeSelector = map[string]string{v1.LabelHostname: "worker-1"}
+			Expect(validate("Pod", oldPod, currentPod, tenantUser).Allowed).To(BeTrue())
+		})
+	})
+
+	Context("node updates", func() {
+		newMaroonedNode := func() *v1.Node {
+			return &v1.Node{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:   "test-pod",
+					Labels: map[string]string{util.MaroonedNodePodUIDLabel: "uid"},
+				},
+				Spec: v1.NodeSpec{
+					Taints: []v1.Taint{
+						{Key: util.DefaultNodeTaintKey + "/dedicated", Value: "uid", Effect: v1.TaintEffectNoSchedule},
+						{Key: "test-pod/" + util.DefaultNodeTaintKey, Value: "claimed", Effect: v1.TaintEffectNoSchedule},
+					},
+				},
+			}
+		}
+
+		DescribeTable("should only let the controller change maroon taints", func(mutate func(*v1.Node)) {
+			oldNode := newMaroonedNode()
+			currentNode := oldNode.DeepCopy()
+			mutate(currentNode)
+
+			response := validate("Node", oldNode, currentNode, "admin")
+			Expect(response.Allowed).To(BeFalse())
+			Expect(response.Result.Message).To(ContainSubstring(oldNode.Name))
+
+			Expect(validate("Node", oldNode, currentNode, controllerUser).Allowed).To(BeTrue())
+		},
+			Entry("removing all taints", func(node *v1.Node) { node.Spec.Taints = nil }),
+			Entry("removing the claim taint", func(node *v1.Node) { node.Spec.Taints = node.Spec.Taints[:1] }),
+			Entry("changing a taint value", func(node *v1.Node) { node.Spec.Taints[0].Value = "other" }),
+			Entry("adding a maroon taint", func(node *v1.Node) {
+				node.Spec.Taints = append(node.Spec.Taints, v1.Taint{Key: "other/" + util.DefaultNodeTaintKey, Effect: v1.TaintEffectNoSchedule})
+			}),
+		)
+
+		It("should allow other taint changes on marooned nodes", func() {
+			oldNode := newMaroonedNode()
+			currentNode := oldNode.DeepCopy()
+			currentNode.Spec.Taints = append([]v1.Taint{{Key: v1.TaintNodeNotReady, Effect: v1.TaintEffectNoExecute}}, currentNode.Spec.Taints...)
+			Expect(validate("Node", oldNode, currentNode, "system:node-controller").Allowed).To(BeTrue())
+		})
+
+		It("should ignore nodes that are not marooned", func() {
+			oldNode := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "worker-1"}}
+			currentNode := oldNode.DeepCopy()
+			currentNode.Spec.Taints = []v1.Taint{{Key: "example.com/maintenance", Effect: v1.TaintEffectNoSchedule}}
+			Expect(validate("Node", oldNode, currentNode, "admin").Allowed).To(BeTrue())
+		})
+	})
+})
@@ -6,18 +6,23 @@ import (
 	"fmt"
 	admissionv1 "k8s.io/api/admission/v1"
 	"k8s.io/client-go/kubernetes"
+	v1listers "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
 	"k8s.io/klog/v2"
 	handlerv1 "maroonedpods.io/maroonedpods/pkg/maroonedpods-server/handler"
+	"maroonedpods.io/maroonedpods/staging/src/maroonedpods.io/api/pkg/apis/core/v1alpha1"
 	"net/http"
 )
 
 type MaroonedPodsServerHandler struct {
 	maroonedpodsCli kubernetes.Interface
 	maroonedpodsNS  string
+	configInformer  cache.SharedIndexInformer
+	namespaceLister v1listers.NamespaceLister
 }
 
-func NewMaroonedPodsServerHandler(maroonedpodsNS string, maroonedpodsCli kubernetes.Interface) *MaroonedPodsServerHandler {
-	return &MaroonedPodsServerHandler{maroonedpodsCli, maroonedpodsNS}
+func NewMaroonedPodsServerHandler(maroonedpodsNS string, maroonedpodsCli kubernetes.Interface, configInformer, namespaceInformer cache.SharedIndexInformer) *MaroonedPodsServerHandler {
+	return &MaroonedPodsServerHandler{maroonedpodsCli, maroonedpodsNS, configInformer, v1listers.NewNamespaceLister(namespaceInformer.GetIndexer())}
 }
 
 func (ash *MaroonedPodsServerHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -27,7 +32,7 @@ func (ash *MaroonedPodsServerHandler) ServeHTTP(w http.ResponseWriter, r *http.R
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	handler := handlerv1.NewHandler(in.Request, ash.maroonedpodsCli, ash.maroonedpodsNS)
+	handler := handlerv1.NewHandler(in.Request, ash.maroonedpodsCli, ash.namespaceLister, ash.maroonedpodsNS, ash.getConfig())
 
 	out, err := handler.Handle()
 	if err != nil {
@@ -53,6 +58,15 @@ func (ash *MaroonedPodsServerHandler) ServeHTTP(w http.ResponseWriter, r *http.R
 
 }
 
+// getConfig returns the MaroonedPodsConfig from the informer cache, or nil if none exists
+func (ash *MaroonedPodsServerHandler) getConfig() *v1alpha1.MaroonedPodsConfig {
+	configs := ash.configInformer.GetStore().List()
+	if len(configs) == 0 {
+		return nil
+	}
+	return configs[0].(*v1alpha1.MaroonedPodsConfig)
+}
+
 // parseRequest extracts an AdmissionReview from an http.Request if possible
 func parseRequest(r http.Request) (*admissionv1.AdmissionReview, error) {
 	if r.Header.Get("Content-Type") != "application/json" {
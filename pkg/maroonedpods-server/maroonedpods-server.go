@@ -5,6 +5,7 @@ import (
 	"github.com/rs/cors"
 	"io"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/util/certificate"
 	"k8s.io/klog/v2"
 	"maroonedpods.io/maroonedpods/pkg/util"
@@ -36,6 +37,8 @@ func NewMaroonedPodsServer(maroonedpodsNS string,
 	bindPort uint,
 	secretCertManager certificate.Manager,
 	maroonedpodsCli kubernetes.Interface,
+	configInformer cache.SharedIndexInformer,
+	namespaceInformer cache.SharedIndexInformer,
 ) (Server, error) {
 	app := &MaroonedPodsServer{
 		secretCertManager: secretCertManager,
@@ -43,7 +46,7 @@ func NewMaroonedPodsServer(maroonedpodsNS string,
 		bindPort:          bindPort,
 		maroonedpodsNS:    maroonedpodsNS,
 	}
-	app.initHandler(maroonedpodsCli)
+	app.initHandler(maroonedpodsCli, configInformer, namespaceInformer)
 
 	return app, nil
 }
@@ -52,10 +55,10 @@ func (app *MaroonedPodsServer) ServeHTTP(w http.ResponseWriter, r *http.Request)
 	app.handler.ServeHTTP(w, r)
 }
 
-func (app *MaroonedPodsServer) initHandler(maroonedpodsCli kubernetes.Interface) {
+func (app *MaroonedPodsServer) initHandler(maroonedpodsCli kubernetes.Interface, configInformer, namespaceInformer cache.SharedIndexInformer) {
 	mux := http.NewServeMux()
 	mux.HandleFunc(healthzPath, app.handleHealthzRequest)
-	mux.Handle(ServePath, NewMaroonedPodsServerHandler(app.maroonedpodsNS, maroonedpodsCli))
+	mux.Handle(ServePath, NewMaroonedPodsServerHandler(app.maroonedpodsNS, maroonedpodsCli, configInformer, namespaceInformer))
 	app.handler = cors.AllowAll().Handler(mux)
 
 }
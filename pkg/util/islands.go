@@ -0,0 +1,30 @@
+package util
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// GetIsland returns the island whose VM the pod shares, or an empty string for pods with a VM of their own
+func GetIsland(pod *corev1.Pod) string {
+	return pod.Labels[IslandLabel]
+}
+
+// IslandVMName returns the name of the VM, and so of the virtual node, shared by the pods of an island.
+// Node names are cluster scoped, so the name is a hash of the namespace and the island: joining them
+// with a dash would give "a-b"/"c" and "a"/"b-c" the same node.
+func IslandVMName(namespace, island string) string {
+	// Namespaces can't contain a slash, so no two islands hash the same input
+	sum := sha256.Sum256([]byte(namespace + "/" + island))
+	return IslandVMNamePrefix + hex.EncodeToString(sum[:16])
+}
+
+// VirtualNodeName returns the name of the VM and virtual node running the pod
+func VirtualNodeName(pod *corev1.Pod) string {
+	if island := GetIsland(pod); island != "" {
+		return IslandVMName(pod.Namespace, island)
+	}
+	return pod.Name
+}
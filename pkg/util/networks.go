@@ -0,0 +1,76 @@
+package util
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// PodNetworkSelection references a NetworkAttachmentDefinition attached to the
+// virtual node of a marooned pod. It follows the Multus network selection format.
+type PodNetworkSelection struct {
+	// Name of the NetworkAttachmentDefinition
+	Name string `json:"name"`
+	// Namespace of the NetworkAttachmentDefinition, defaults to the pod namespace
+	Namespace string `json:"namespace,omitempty"`
+	// Static addresses of the guest interface in CIDR notation, DHCP is used when empty
+	IPs []string `json:"ips,omitempty"`
+	// MAC address of the guest interface
+	MAC string `json:"mac,omitempty"`
+}
+
+// NetworkName returns the Multus network name of the selection
+func (s PodNetworkSelection) NetworkName() string {
+	if s.Namespace == "" {
+		return s.Name
+	}
+	return fmt.Sprintf("%s/%s", s.Namespace, s.Name)
+}
+
+// GetPodNetworks parses the networks annotation of a pod. The annotation is either
+// a comma separated list of [<namespace>/]<name> entries, or a JSON list of selections.
+// Networks of other namespaces are rejected, tenants may only attach their own networks.
+func GetPodNetworks(pod *corev1.Pod) ([]PodNetworkSelection, error) {
+	annotation := strings.TrimSpace(pod.Annotations[MaroonedPodNetworksAnnotation])
+	if annotation == "" {
+		return nil, nil
+	}
+
+	var selections []PodNetworkSelection
+	if strings.HasPrefix(annotation, "[") {
+		if err := json.Unmarshal([]byte(annotation), &selections); err != nil {
+			return nil, fmt.Errorf("invalid %s annotation: %v", MaroonedPodNetworksAnnotation, err)
+		}
+	} else {
+		for _, entry := range strings.Split(annotation, ",") {
+			selection := PodNetworkSelection{Name: strings.TrimSpace(entry)}
+			if parts := strings.SplitN(selection.Name, "/", 2); len(parts) == 2 {
+				selection.Namespace, selection.Name = parts[0], parts[1]
+			}
+			selections = append(selections, selection)
+		}
+	}
+
+	for _, selection := range selections {
+		if selection.Name == "" {
+			return nil, fmt.Errorf("invalid %s annotation: empty network name", MaroonedPodNetworksAnnotation)
+		}
+		if selection.Namespace != "" && selection.Namespace != pod.Namespace {
+			return nil, fmt.Errorf("invalid %s annotation: network %s is outside the pod namespace %s", MaroonedPodNetworksAnnotation, selection.NetworkName(), pod.Namespace)
+		}
+		for _, ip := range selection.IPs {
+			if _, _, err := net.ParseCIDR(ip); err != nil {
+				return nil, fmt.Errorf("invalid %s annotation: address %q of network %s is not in CIDR notation", MaroonedPodNetworksAnnotation, ip, selection.Name)
+			}
+		}
+		if selection.MAC != "" {
+			if _, err := net.ParseMAC(selection.MAC); err != nil {
+				return nil, fmt.Errorf("invalid %s annotation: MAC address %q of network %s: %v", MaroonedPodNetworksAnnotation, selection.MAC, selection.Name, err)
+			}
+		}
+	}
+	return selections, nil
+}
@@ -0,0 +1,72 @@
+package util
+
+import (
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// GetVMResourcesOverride returns the CPU and memory of the virtual node set through the
+// pod annotations, nil for the resources that aren't overridden
+func GetVMResourcesOverride(pod *corev1.Pod) (cpu, memory *resource.Quantity, err error) {
+	if cpu, err = parsePositiveQuantity(pod, VMCPUAnnotation); err != nil {
+		return nil, nil, err
+	}
+	if memory, err = parsePositiveQuantity(pod, VMMemoryAnnotation); err != nil {
+		return nil, nil, err
+	}
+	return cpu, memory, nil
+}
+
+func parsePositiveQuantity(pod *corev1.Pod, annotation string) (*resource.Quantity, error) {
+	value, ok := pod.Annotations[annotation]
+	if !ok {
+		return nil, nil
+	}
+	quantity, err := resource.ParseQuantity(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s annotation %q: %v", annotation, value, err)
+	}
+	if quantity.Sign() <= 0 {
+		return nil, fmt.Errorf("invalid %s annotation %q: must be positive", annotation, value)
+	}
+	return &quantity, nil
+}
+
+// HugepageSizes are the hugepage sizes marooned VMs can back and allocate
+var HugepageSizes = []string{"2Mi", "1Gi"}
+
+// HugepageSize returns the page size of a hugepages resource, empty for other resources
+func HugepageSize(name corev1.ResourceName) string {
+	if !strings.HasPrefix(string(name), corev1.ResourceHugePagesPrefix) {
+		return ""
+	}
+	return strings.TrimPrefix(string(name), corev1.ResourceHugePagesPrefix)
+}
+
+// IsSupportedHugepageSize checks whether the hugepage size is one of HugepageSizes
+func IsSupportedHugepageSize(size string) bool {
+	quantity, err := resource.ParseQuantity(size)
+	if err != nil {
+		return false
+	}
+	for _, supportedSize := range HugepageSizes {
+		if quantity.Cmp(resource.MustParse(supportedSize)) == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// GetSidecarContainers returns the names of the sidecar init containers recorded on the pod
+func GetSidecarContainers(pod *corev1.Pod) map[string]bool {
+	sidecars := map[string]bool{}
+	for _, name := range strings.Split(pod.Annotations[SidecarContainersAnnotation], ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			sidecars[name] = true
+		}
+	}
+	return sidecars
+}
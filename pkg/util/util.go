@@ -83,12 +83,67 @@ const (
 	// Warm pool labels
 	WarmPoolStateLabel     = "maroonedpods.io/pool-state"
 	WarmPoolClaimedByLabel = "maroonedpods.io/claimed-by"
-	WarmPoolVMNamePrefix   = "maroonedpods-pool-"
+	// WarmPoolClaimedByNamespaceLabel holds the namespace of the pod named by WarmPoolClaimedByLabel
+	WarmPoolClaimedByNamespaceLabel = "maroonedpods.io/claimed-by-namespace"
+	WarmPoolNameLabel               = "maroonedpods.io/warm-pool"
+	WarmPoolVMNamePrefix            = "maroonedpods-pool-"
+
+	// PrePullImagesAnnotation lists the images a pool VMI pulls before it becomes available
+	PrePullImagesAnnotation = "maroonedpods.io/pre-pull-images"
+	// PrePulledImagesAnnotation lists the images the node boot script pulled, set on the node once the pre-pull finished
+	PrePulledImagesAnnotation = "maroonedpods.io/pre-pulled-images"
 
 	// Warm pool states
 	PoolStateAvailable = "available"
 	PoolStateClaimed   = "claimed"
 	PoolStateCreating  = "creating"
+
+	// RuntimeClass opt-in
+	MaroonedPodsRuntimeClassName    = "maroonedpods"
+	MaroonedPodsRuntimeClassHandler = "runc"
+
+	// Label set by the boot script on every virtual node
+	MaroonedVirtualNodeLabel = "maroonedpods.io/virtual-node"
+	// Label set by the boot script on virtual nodes joined for a marooned pod
+	MaroonedNodePodUIDLabel = "maroonedpods.io/pod-uid"
+	// Label set by the boot script on every virtual node to the firmware UUID of its VMI
+	MaroonedNodeVMUUIDLabel = "maroonedpods.io/vm-uuid"
+	// Default taint key prefix of virtual nodes
+	DefaultNodeTaintKey = "maroonedpods.io"
+
+	// Label selecting the virt-launcher pod of a virtual node VMI
+	MaroonedVMILabel = "maroonedpods.io/vmi"
+	// Prefix of the NetworkPolicy isolating a virtual node VMI
+	NetworkPolicyNamePrefix = "maroonedpods-"
+	// Prefix of the Secret holding the registries configuration of a virtual node VMI
+	RegistriesSecretNamePrefix = "maroonedpods-registries-"
+
+	// Pod annotation listing NetworkAttachmentDefinitions attached to the virtual node
+	MaroonedPodNetworksAnnotation = "maroonedpods.io/networks"
+	// Pod annotation recording the volumes passed through to the virtual node
+	PassthroughVolumesAnnotation = "maroonedpods.io/volumes"
+	// Pod annotation listing the sidecar init containers, recorded by the webhook
+	SidecarContainersAnnotation = "maroonedpods.io/sidecar-containers"
+	// Pod annotations overriding the CPU and memory of the virtual node
+	VMCPUAnnotation    = "maroonedpods.io/vm-cpu"
+	VMMemoryAnnotation = "maroonedpods.io/vm-memory"
+	// Pod annotation naming the pod a gated pod was preempted for
+	PreemptedByAnnotation = "maroonedpods.io/preempted-by"
+
+	// Pod label grouping the pods of a namespace that share one VM
+	IslandLabel = "maroonedpods.io/island"
+	// Prefix of the VMs shared by the pods of an island
+	IslandVMNamePrefix = "island-"
+
+	// Name of the pull-through registry mirror resources
+	RegistryMirrorResourceName = "maroonedpods-registry-mirror"
+	// Serving certificate of the registry mirror and the bundle of the CAs signing it
+	RegistryMirrorCertSecretName   = "maroonedpods-registry-mirror-cert"
+	RegistryMirrorCABundleName     = "maroonedpods-registry-mirror-signer-bundle"
+	RegistryMirrorPort             = 5000
+	DefaultRegistryMirrorImage     = "docker.io/library/registry:2"
+	DefaultRegistryMirrorRegistry  = "docker.io"
+	DefaultRegistryMirrorRemoteURL = "https://registry-1.docker.io"
 )
 
 var commonLabels = map[string]string{
@@ -0,0 +1,85 @@
+package util
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	// GuestVolumesDir is where the guest mounts the filesystems passed through to it
+	GuestVolumesDir = "/var/lib/marooned/volumes"
+	// virtio-blk serials are limited to 20 bytes
+	diskSerialLength = 20
+)
+
+// PassthroughVolume is a pod volume attached to the virtual node VMI instead of
+// being attached by the guest kubelet
+type PassthroughVolume struct {
+	corev1.Volume `json:",inline"`
+	// Block is set for PVCs consumed as raw block devices
+	Block bool `json:"block,omitempty"`
+}
+
+// GuestPath returns the path of the volume inside the guest
+func (v PassthroughVolume) GuestPath() string {
+	if v.Block {
+		return "/dev/disk/by-id/virtio-" + v.DiskSerial()
+	}
+	return fmt.Sprintf("%s/%s", GuestVolumesDir, v.Name)
+}
+
+// DiskSerial returns the serial of the disk backing a block volume
+func (v PassthroughVolume) DiskSerial() string {
+	return fmt.Sprintf("mp-%x", sha256.Sum256([]byte(v.Name)))[:diskSerialLength]
+}
+
+// VMIVolumeName returns the name of the VMI volume and device backing the pod volume
+func (v PassthroughVolume) VMIVolumeName() string {
+	return "pod-" + v.Name
+}
+
+// SelectPassthroughVolumes returns the PVC, ConfigMap and Secret volumes of the pod
+// that are attached to the virtual node VMI
+func SelectPassthroughVolumes(pod *corev1.Pod) []PassthroughVolume {
+	blockVolumes := map[string]bool{}
+	for _, containers := range [][]corev1.Container{pod.Spec.InitContainers, pod.Spec.Containers} {
+		for _, container := range containers {
+			for _, device := range container.VolumeDevices {
+				blockVolumes[device.Name] = true
+			}
+		}
+	}
+
+	var volumes []PassthroughVolume
+	for _, volume := range pod.Spec.Volumes {
+		if volume.PersistentVolumeClaim == nil && volume.ConfigMap == nil && volume.Secret == nil {
+			continue
+		}
+		// Key projections can't be shared with the guest, the guest kubelet projects those itself
+		if (volume.ConfigMap != nil && len(volume.ConfigMap.Items) > 0) ||
+			(volume.Secret != nil && len(volume.Secret.Items) > 0) {
+			continue
+		}
+		volumes = append(volumes, PassthroughVolume{
+			Volume: volume,
+			Block:  volume.PersistentVolumeClaim != nil && blockVolumes[volume.Name],
+		})
+	}
+	return volumes
+}
+
+// GetPassthroughVolumes returns the volumes recorded on the pod by the webhook
+func GetPassthroughVolumes(pod *corev1.Pod) ([]PassthroughVolume, error) {
+	annotation, ok := pod.Annotations[PassthroughVolumesAnnotation]
+	if !ok {
+		return nil, nil
+	}
+	var volumes []PassthroughVolume
+	if err := json.Unmarshal([]byte(annotation), &volumes); err != nil {
+		return nil, fmt.Errorf("invalid %s annotation: %v", PassthroughVolumesAnnotation, err)
+	}
+	return volumes, nil
+}
@@ -21,6 +21,8 @@ package v1alpha1
 
 import (
 	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	sdkapi "kubevirt.io/controller-lifecycle-operator-sdk/api"
 )
@@ -83,6 +85,40 @@ type MaroonedPodsSpec struct {
 	// namespaces where pods should be gated before scheduling
 	// Default to the empty LabelSelector, which matches everything.
 	NamespaceSelector *metav1.LabelSelector `json:"namespaceSelector,omitempty"`
+	// Pull-through registry mirror deployed next to maroonedpods-server, marooned VMs
+	// pull the images of the mirrored registry through it
+	// Default: VMs pull images from the registries directly
+	// +optional
+	RegistryMirror *RegistryMirror `json:"registryMirror,omitempty"`
+}
+
+// RegistryMirror configures the pull-through registry mirror shared by the marooned VMs
+type RegistryMirror struct {
+	// Image of the registry serving the mirror
+	// Default: docker.io/library/registry:2
+	// +optional
+	Image string `json:"image,omitempty"`
+
+	// Registry mirrored for the guests, as referenced by image names
+	// Default: docker.io
+	// +optional
+	Registry string `json:"registry,omitempty"`
+
+	// URL of the upstream registry the mirror pulls through
+	// Default: https://registry-1.docker.io for docker.io, https://<registry> otherwise
+	// +optional
+	RemoteURL string `json:"remoteURL,omitempty"`
+
+	// Secret in the install namespace holding the credentials of the guests, the username and
+	// password keys, and the htpasswd key the mirror authenticates them with
+	// Default: the mirror accepts anonymous pulls
+	// +optional
+	CredentialsSecret string `json:"credentialsSecret,omitempty"`
+
+	// Size limit of the image cache of the mirror
+	// Default: bounded by the ephemeral storage of the host
+	// +optional
+	CacheSize *resource.Quantity `json:"cacheSize,omitempty"`
 }
 
 // MaroonedPodsPriorityClass defines the priority class of the MaroonedPods control plane.
@@ -153,6 +189,17 @@ type MaroonedPodsConfigSpec struct {
 	// +optional
 	WarmPoolSize int32 `json:"warmPoolSize,omitempty"`
 
+	// Warm pools dedicated to the namespaces they select.
+	// Their VMs live in the selected namespaces and are only claimed by pods of the same namespace.
+	// Pods of a selected namespace never claim VMs of the shared warm pool.
+	// +optional
+	TenantPools []TenantPool `json:"tenantPools,omitempty"`
+
+	// Images pulled into the VMs of the shared warm pool before they become available,
+	// so the pod claiming a VM doesn't pull them on its first start
+	// +optional
+	PrePullImages []string `json:"prePullImages,omitempty"`
+
 	// Base VM resources (CPU/memory) for virtual nodes
 	// These are the resources allocated to the VM itself
 	// +optional
@@ -160,16 +207,287 @@ type MaroonedPodsConfigSpec struct {
 
 	// Resource overhead to add on top of pod requests for VM sizing
 	// This accounts for kubelet, kube-proxy, and other node components
-	// Default: 500m CPU, 512Mi memory
+	// The ephemeral-storage overhead is added to the scratch disk for image layers
+	// Default: 500m CPU, 512Mi memory, 10Gi ephemeral-storage
 	// +optional
 	ResourceOverhead *corev1.ResourceList `json:"resourceOverhead,omitempty"`
 
+	// Whether VMs are sized from the effective requests or limits of the pod
+	// With Limits the limit of each container is used where set, its request otherwise
+	// Default: Requests
+	// +kubebuilder:validation:Enum=Requests;Limits
+	// +optional
+	ResourceSizing ResourceSizing `json:"resourceSizing,omitempty"`
+
+	// CPU model, topology and placement of virtual node VMIs
+	// Default: a single socket with one thread per core, shared host CPUs
+	// +optional
+	CPU *CPUTopology `json:"cpu,omitempty"`
+
+	// Hugepages backing and memory overcommit of virtual node VMIs
+	// +optional
+	Memory *VMMemory `json:"memory,omitempty"`
+
 	// Taint key prefix for pod-specific node affinity
 	// Default: "maroonedpods.io"
 	// The full taint key will be: <prefix>/<pod-name>
 	// +kubebuilder:default="maroonedpods.io"
 	// +optional
 	NodeTaintKey string `json:"nodeTaintKey,omitempty"`
+
+	// Admission policy restricting which pods may be marooned
+	// Default: every pod carrying the maroon label is accepted
+	// +optional
+	AdmissionPolicy *AdmissionPolicy `json:"admissionPolicy,omitempty"`
+
+	// Pods matching this policy are always marooned, with or without the maroon label
+	// +optional
+	ForceMaroon *ForceMaroonPolicy `json:"forceMaroon,omitempty"`
+
+	// Network isolation of virtual node VMIs through generated NetworkPolicies
+	// Default: disabled
+	// +optional
+	NetworkIsolation *NetworkIsolation `json:"networkIsolation,omitempty"`
+
+	// How virtual node VMIs are connected to the pod network
+	// masquerade puts the guest behind NAT in the virt-launcher pod, bridge and passt
+	// give the guest the pod IP so the node is reachable on its InternalIP
+	// Default: masquerade
+	// +kubebuilder:validation:Enum=masquerade;bridge;passt
+	// +optional
+	NetworkBinding NetworkBinding `json:"networkBinding,omitempty"`
+
+	// Back virtual node VMIs with VirtualMachines owned by the pod, which KubeVirt
+	// restarts with this run strategy when the VM crashes or its host reboots
+	// Default: bare VMIs that are not restarted
+	// +kubebuilder:validation:Enum=Always;RerunOnFailure
+	// +optional
+	RunStrategy RunStrategy `json:"runStrategy,omitempty"`
+
+	// What happens to virtual node VMIs when their host is drained
+	// LiveMigrate moves VMIs to another host without disturbing the pod, VMIs that
+	// can't be migrated are shut down instead of blocking the drain
+	// Default: LiveMigrate
+	// +kubebuilder:validation:Enum=LiveMigrate;None
+	// +optional
+	EvictionStrategy EvictionStrategy `json:"evictionStrategy,omitempty"`
+
+	// Whether marooned pods whose VMs can't be provisioned because of quota or host
+	// capacity preempt the VMs of lower priority marooned pods
+	// Pods with preemptionPolicy Never never preempt
+	// Default: Never
+	// +kubebuilder:validation:Enum=Never;PreemptLowerPriority
+	// +optional
+	PreemptionPolicy corev1.PreemptionPolicy `json:"preemptionPolicy,omitempty"`
+
+	// Keep the scratch disk of StatefulSet pods on a PVC named after the pod, so the VM of
+	// a recreated pod starts with the container images and kubelet data of its predecessor
+	// Default: StatefulSet pods get an empty scratch disk like other pods
+	// +optional
+	StatefulSetDisk *StatefulSetDisk `json:"statefulSetDisk,omitempty"`
+}
+
+// StatefulSetDisk configures the persistent scratch disks of StatefulSet pods
+type StatefulSetDisk struct {
+	// Storage class of the scratch disk PVCs
+	// Default: the default storage class
+	// +optional
+	StorageClassName *string `json:"storageClassName,omitempty"`
+}
+
+// TenantPool is a warm pool dedicated to a set of namespaces
+type TenantPool struct {
+	// Name of the pool, recorded on its VMs
+	// +kubebuilder:validation:MaxLength=63
+	// +kubebuilder:validation:Pattern=`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`
+	Name string `json:"name"`
+
+	// Namespaces entitled to the VMs of the pool
+	NamespaceSelector metav1.LabelSelector `json:"namespaceSelector"`
+
+	// Number of pre-booted VMs kept available in each selected namespace
+	// +kubebuilder:validation:Minimum=0
+	Size int32 `json:"size"`
+
+	// Maximum number of VMs of the pool across the selected namespaces, claimed or not
+	// Default: 0 (no limit)
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	MaxVMs int32 `json:"maxVMs,omitempty"`
+
+	// Images pulled into the VMs of the pool before they become available
+	// +optional
+	PrePullImages []string `json:"prePullImages,omitempty"`
+}
+
+// CPUTopology configures the vCPUs of virtual node VMIs
+type CPUTopology struct {
+	// CPU model of the VMs, e.g. host-passthrough, host-model or a named model
+	// Default: the KubeVirt default model
+	// +optional
+	Model string `json:"model,omitempty"`
+
+	// Number of sockets the vCPUs are spread over
+	// Default: 1
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	Sockets uint32 `json:"sockets,omitempty"`
+
+	// Number of threads per core
+	// Default: 1
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	Threads uint32 `json:"threads,omitempty"`
+
+	// Pin the vCPUs of VMs running Guaranteed QoS pods to dedicated host CPUs
+	// Requires the CPU manager on the hosts
+	// +optional
+	DedicatedCPUPlacement bool `json:"dedicatedCpuPlacement,omitempty"`
+
+	// Run the emulator thread of VMs with dedicated CPUs on an additional dedicated host CPU
+	// +optional
+	IsolateEmulatorThread bool `json:"isolateEmulatorThread,omitempty"`
+
+	// Request the host CPU the pod asks for instead of whole cores, and limit it to
+	// the pod CPU limits when every container sets one
+	// +optional
+	FractionalCPU bool `json:"fractionalCpu,omitempty"`
+}
+
+// VMMemory configures the memory of virtual node VMIs
+type VMMemory struct {
+	// Host hugepage size backing the memory of VMs running pods that request hugepages
+	// Default: the largest hugepage size the pod requests
+	// +kubebuilder:validation:Enum="2Mi";"1Gi"
+	// +optional
+	HugepagesPageSize string `json:"hugepagesPageSize,omitempty"`
+
+	// Guest memory as a percentage of the memory requested from the host
+	// Values above 100 let VMs request less memory than the guest sees, trading safety for density.
+	// VMs backed by hugepages or with dedicated CPUs are never overcommitted.
+	// Default: 100 (no overcommit)
+	// +kubebuilder:validation:Minimum=100
+	// +kubebuilder:validation:Maximum=1000
+	// +optional
+	OvercommitPercent int32 `json:"overcommitPercent,omitempty"`
+}
+
+// ResourceSizing selects the pod resources virtual node VMIs are sized from
+type ResourceSizing string
+
+const (
+	// ResourceSizingRequests sizes VMs from the effective requests of the pod
+	ResourceSizingRequests ResourceSizing = "Requests"
+	// ResourceSizingLimits sizes VMs from the effective limits of the pod
+	ResourceSizingLimits ResourceSizing = "Limits"
+)
+
+// NetworkBinding is the binding of the pod network interface of virtual node VMIs
+type NetworkBinding string
+
+const (
+	// NetworkBindingMasquerade connects the guest through NAT
+	NetworkBindingMasquerade NetworkBinding = "masquerade"
+	// NetworkBindingBridge connects the guest through a linux bridge
+	NetworkBindingBridge NetworkBinding = "bridge"
+	// NetworkBindingPasst connects the guest through passt user networking
+	NetworkBindingPasst NetworkBinding = "passt"
+)
+
+// RunStrategy is the run strategy of the VirtualMachines backing virtual node VMIs
+type RunStrategy string
+
+const (
+	// RunStrategyAlways restarts the VMI whenever it stops
+	RunStrategyAlways RunStrategy = "Always"
+	// RunStrategyRerunOnFailure restarts the VMI when it fails, but not when the guest shuts down
+	RunStrategyRerunOnFailure RunStrategy = "RerunOnFailure"
+)
+
+// EvictionStrategy selects how virtual node VMIs leave a drained host
+type EvictionStrategy string
+
+const (
+	// EvictionStrategyLiveMigrate live migrates VMIs off drained hosts
+	EvictionStrategyLiveMigrate EvictionStrategy = "LiveMigrate"
+	// EvictionStrategyNone shuts VMIs down with their host, killing the marooned pod
+	EvictionStrategyNone EvictionStrategy = "None"
+)
+
+// NetworkIsolation configures the egress NetworkPolicy created for the
+// virt-launcher pod of every virtual node VMI
+type NetworkIsolation struct {
+	// Create a NetworkPolicy per VMI launcher pod
+	// +optional
+	Enabled bool `json:"enabled,omitempty"`
+
+	// CIDRs the API server is reachable on
+	// Default: the endpoints of the default/kubernetes service
+	// +optional
+	APIServerCIDRs []string `json:"apiServerCIDRs,omitempty"`
+
+	// CIDRs of the CNI overlay peers, usually the node network
+	// Default: any destination on the overlay ports
+	// +optional
+	OverlayCIDRs []string `json:"overlayCIDRs,omitempty"`
+
+	// Ports used by the CNI overlay
+	// Default: UDP 8472 (flannel VXLAN)
+	// +optional
+	OverlayPorts []networkingv1.NetworkPolicyPort `json:"overlayPorts,omitempty"`
+
+	// Additional egress rules appended to the generated NetworkPolicy
+	// +optional
+	AdditionalEgress []networkingv1.NetworkPolicyEgressRule `json:"additionalEgress,omitempty"`
+}
+
+// ForceMaroonPolicy selects pods that are marooned unconditionally.
+// A pod is force-marooned when it matches any of the configured criteria.
+type ForceMaroonPolicy struct {
+	// Namespaces whose pods are always marooned
+	// The empty LabelSelector matches every namespace.
+	// +optional
+	NamespaceSelector *metav1.LabelSelector `json:"namespaceSelector,omitempty"`
+
+	// Pods that are always marooned
+	// The empty LabelSelector matches every pod.
+	// +optional
+	PodSelector *metav1.LabelSelector `json:"podSelector,omitempty"`
+
+	// Pods referencing one of these RuntimeClasses are always marooned
+	// +optional
+	RuntimeClassNames []string `json:"runtimeClassNames,omitempty"`
+
+	// Pods with a container image matching one of these patterns are always marooned.
+	// Patterns and images are normalized before matching, so "nginx" matches "docker.io/library/nginx:latest".
+	// A pattern without tag or digest matches every tag of the repository, and a pattern
+	// ending in "/*" matches every repository below the prefix, for example "docker.io/untrusted/*".
+	// +optional
+	ImagePatterns []string `json:"imagePatterns,omitempty"`
+}
+
+// AdmissionPolicy defines which pods the webhook accepts for marooning
+type AdmissionPolicy struct {
+	// Namespaces whose pods may be marooned
+	// Default to the empty LabelSelector, which matches everything.
+	// +optional
+	NamespaceSelector *metav1.LabelSelector `json:"namespaceSelector,omitempty"`
+
+	// Pods that may be marooned
+	// Default to the empty LabelSelector, which matches everything.
+	// +optional
+	PodSelector *metav1.LabelSelector `json:"podSelector,omitempty"`
+
+	// Service accounts allowed to run marooned pods, as <namespace>/<name>.
+	// A name of "*" matches every service account in the namespace.
+	// Default: all service accounts are allowed
+	// +optional
+	AllowedServiceAccounts []string `json:"allowedServiceAccounts,omitempty"`
+
+	// Service accounts never allowed to run marooned pods, as <namespace>/<name>.
+	// Takes precedence over AllowedServiceAccounts.
+	// +optional
+	DeniedServiceAccounts []string `json:"deniedServiceAccounts,omitempty"`
 }
 
 // MaroonedPodsConfigStatus defines the observed state of MaroonedPodsConfig
@@ -186,11 +504,36 @@ type MaroonedPodsConfigStatus struct {
 	// +optional
 	WarmPoolClaimed int32 `json:"warmPoolClaimed,omitempty"`
 
+	// VMs of each tenant pool
+	// +optional
+	TenantPools []TenantPoolStatus `json:"tenantPools,omitempty"`
+
 	// Conditions represent the latest available observations of the config state
 	// +optional
 	Conditions []metav1.Condition `json:"conditions,omitempty"`
 }
 
+// TenantPoolStatus reports the VMs of a tenant pool
+type TenantPoolStatus struct {
+	// Name of the pool
+	Name string `json:"name"`
+
+	// Number of namespaces selected by the pool
+	Namespaces int32 `json:"namespaces"`
+
+	// Total number of VMs in the pool, claimed or not
+	// +optional
+	Total int32 `json:"total,omitempty"`
+
+	// Number of available (unclaimed) VMs in the pool
+	// +optional
+	Available int32 `json:"available,omitempty"`
+
+	// Number of claimed VMs currently in use
+	// +optional
+	Claimed int32 `json:"claimed,omitempty"`
+}
+
 // MaroonedPodsConfigList provides the list of MaroonedPodsConfig
 // +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
 type MaroonedPodsConfigList struct {
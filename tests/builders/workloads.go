@@ -0,0 +1,67 @@
+package builders
+
+import (
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"maroonedpods.io/maroonedpods/pkg/util"
+)
+
+// maroonedPodTemplate returns a template of marooned pods labelled app=<app>
+func maroonedPodTemplate(app string, container v1.Container, restartPolicy v1.RestartPolicy) v1.PodTemplateSpec {
+	return v1.PodTemplateSpec{
+		ObjectMeta: metav1.ObjectMeta{
+			Labels: map[string]string{
+				"app":                 app,
+				util.MaroonedPodLabel: "true",
+			},
+		},
+		Spec: v1.PodSpec{
+			Containers:    []v1.Container{container},
+			RestartPolicy: restartPolicy,
+		},
+	}
+}
+
+// NewMaroonedDeployment creates a Deployment of marooned nginx pods
+func NewMaroonedDeployment(name, namespace string, replicas int32) *appsv1.Deployment {
+	return &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+		Spec: appsv1.DeploymentSpec{
+			Replicas: &replicas,
+			Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": name}},
+			Template: maroonedPodTemplate(name, v1.Container{Name: "nginx", Image: "nginx:latest"}, v1.RestartPolicyAlways),
+		},
+	}
+}
+
+// NewMaroonedStatefulSet creates a StatefulSet of marooned nginx pods
+func NewMaroonedStatefulSet(name, namespace string, replicas int32) *appsv1.StatefulSet {
+	return &appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+		Spec: appsv1.StatefulSetSpec{
+			Replicas:    &replicas,
+			ServiceName: name,
+			Selector:    &metav1.LabelSelector{MatchLabels: map[string]string{"app": name}},
+			Template:    maroonedPodTemplate(name, v1.Container{Name: "nginx", Image: "nginx:latest"}, v1.RestartPolicyAlways),
+		},
+	}
+}
+
+// NewMaroonedJob creates a Job running a single marooned pod to completion
+func NewMaroonedJob(name, namespace string) *batchv1.Job {
+	backoffLimit := int32(0)
+	return &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+		Spec: batchv1.JobSpec{
+			BackoffLimit: &backoffLimit,
+			Template: maroonedPodTemplate(name, v1.Container{
+				Name:    "task",
+				Image:   "busybox:latest",
+				Command: []string{"sh", "-c", "echo done"},
+			}, v1.RestartPolicyNever),
+		},
+	}
+}
@@ -0,0 +1,79 @@
+package tests
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	v1 "k8s.io/api/core/v1"
+
+	"maroonedpods.io/maroonedpods/pkg/util"
+	"maroonedpods.io/maroonedpods/tests/builders"
+	"maroonedpods.io/maroonedpods/tests/framework"
+	testutils "maroonedpods.io/maroonedpods/tests/utils"
+)
+
+var _ = Describe("[e2e] Islands", func() {
+	var (
+		f  *framework.Framework
+		ns string
+	)
+
+	BeforeEach(func() {
+		f = framework.DefaultFramework
+		nsName := testutils.GenerateNamespaceName("islands")
+		createdNs, err := f.CreateNamespace(nsName)
+		Expect(err).ToNot(HaveOccurred())
+		ns = createdNs.Name
+	})
+
+	AfterEach(func() {
+		if ns != "" {
+			err := f.DeleteNamespace(ns)
+			Expect(err).ToNot(HaveOccurred())
+		}
+	})
+
+	newIslandPod := func(name string) *v1.Pod {
+		return builders.NewPod(name, ns).
+			WithMaroonedLabel().
+			WithLabel("maroonedpods.io/island", "shop").
+			WithContainer("nginx", "nginx:latest").
+			WithContainerResources("250m", "256Mi").
+			WithRestartPolicy(v1.RestartPolicyAlways).
+			Build()
+	}
+
+	It("should run the pods of an island on one VM until the last pod leaves", func() {
+		vmName := util.IslandVMName(ns, "shop")
+
+		By("Creating two pods of the island")
+		for _, name := range []string{"web", "cache"} {
+			_, err := f.CreatePod(newIslandPod(name))
+			Expect(err).ToNot(HaveOccurred())
+		}
+
+		By("Waiting for both pods to run on the node of the island")
+		for _, name := range []string{"web", "cache"} {
+			Expect(f.WaitForPodPhase(name, v1.PodRunning, testutils.LongTimeout)).To(Succeed())
+			pod, err := f.GetPod(name)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(pod.Spec.NodeName).To(Equal(vmName))
+		}
+		vmis, err := f.ListVMIs(ns)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(vmis.Items).To(HaveLen(1))
+
+		By("Deleting one pod and verifying the VM keeps running")
+		Expect(f.DeletePod("web")).To(Succeed())
+		Expect(f.WaitForPodDeleted("web", testutils.DefaultTimeout)).To(Succeed())
+		Consistently(func() error {
+			_, err := f.GetVMI(ns, vmName)
+			return err
+		}, 30*time.Second, 5*time.Second).Should(Succeed())
+
+		By("Deleting the last pod and verifying the VM is torn down")
+		Expect(f.DeletePod("cache")).To(Succeed())
+		Expect(f.WaitForVMIDeleted(ns, vmName, testutils.DefaultTimeout)).To(Succeed())
+	})
+})
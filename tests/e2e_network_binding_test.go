@@ -0,0 +1,108 @@
+package tests
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	v1 "k8s.io/api/core/v1"
+	virtv1 "kubevirt.io/api/core/v1"
+
+	mpv1alpha1 "maroonedpods.io/maroonedpods/staging/src/maroonedpods.io/api/pkg/apis/core/v1alpha1"
+	"maroonedpods.io/maroonedpods/tests/builders"
+	"maroonedpods.io/maroonedpods/tests/framework"
+	testutils "maroonedpods.io/maroonedpods/tests/utils"
+)
+
+var _ = Describe("[e2e] Network Binding", func() {
+	var (
+		f               *framework.Framework
+		ns              string
+		originalBinding mpv1alpha1.NetworkBinding
+	)
+
+	BeforeEach(func() {
+		f = framework.DefaultFramework
+		config, err := f.GetMaroonedPodsConfig()
+		if err != nil {
+			Skip("No MaroonedPodsConfig found, skipping network binding tests")
+		}
+		originalBinding = config.Spec.NetworkBinding
+
+		nsName := testutils.GenerateNamespaceName("network-binding")
+		createdNs, err := f.CreateNamespace(nsName)
+		Expect(err).ToNot(HaveOccurred())
+		ns = createdNs.Name
+	})
+
+	AfterEach(func() {
+		if ns != "" {
+			err := f.DeleteNamespace(ns)
+			Expect(err).ToNot(HaveOccurred())
+		}
+		err := f.UpdateMaroonedPodsConfig(func(spec *mpv1alpha1.MaroonedPodsConfigSpec) {
+			spec.NetworkBinding = originalBinding
+		})
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	DescribeTable("should run a marooned pod", func(binding mpv1alpha1.NetworkBinding, hasBinding func(virtv1.Interface) bool, ownsPodIP bool) {
+		podName := "test-binding"
+
+		By("Configuring the network binding")
+		err := f.UpdateMaroonedPodsConfig(func(spec *mpv1alpha1.MaroonedPodsConfigSpec) {
+			spec.NetworkBinding = binding
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		By("Creating a marooned pod")
+		_, err = f.CreatePod(builders.NewMaroonedPod(podName, ns))
+		Expect(err).ToNot(HaveOccurred())
+
+		By("Verifying the VMI uses the configured binding")
+		vmi, err := f.WaitForVMI(ns, podName, testutils.DefaultTimeout)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(vmi.Spec.Domain.Devices.Interfaces).ToNot(BeEmpty())
+		Expect(hasBinding(vmi.Spec.Domain.Devices.Interfaces[0])).To(BeTrue())
+
+		By("Waiting for the node to join the cluster")
+		err = f.WaitForVMIPhase(ns, podName, virtv1.Running, testutils.LongTimeout)
+		Expect(err).ToNot(HaveOccurred())
+		err = f.WaitForNodeReady(podName, testutils.DefaultTimeout)
+		Expect(err).ToNot(HaveOccurred())
+
+		if ownsPodIP {
+			By("Verifying the node registered with the pod IP of the VMI")
+			Eventually(func() string {
+				vmi, err := f.GetVMI(ns, podName)
+				if err != nil || len(vmi.Status.Interfaces) == 0 {
+					return ""
+				}
+				return vmi.Status.Interfaces[0].IP
+			}, testutils.ShortTimeout, 2*time.Second).ShouldNot(BeEmpty())
+			vmi, err = f.GetVMI(ns, podName)
+			Expect(err).ToNot(HaveOccurred())
+
+			node, err := f.GetNode(podName)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(node.Status.Addresses).To(ContainElement(v1.NodeAddress{
+				Type:    v1.NodeInternalIP,
+				Address: vmi.Status.Interfaces[0].IP,
+			}))
+		}
+
+		By("Waiting for the pod to be running on its node")
+		err = f.WaitForPodPhase(podName, v1.PodRunning, testutils.DefaultTimeout)
+		Expect(err).ToNot(HaveOccurred())
+		pod, err := f.GetPod(podName)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(pod.Spec.NodeName).To(Equal(podName))
+	},
+		Entry("with masquerade binding", mpv1alpha1.NetworkBindingMasquerade,
+			func(iface virtv1.Interface) bool { return iface.Masquerade != nil }, false),
+		Entry("with bridge binding", mpv1alpha1.NetworkBindingBridge,
+			func(iface virtv1.Interface) bool { return iface.Bridge != nil }, true),
+		Entry("with passt binding", mpv1alpha1.NetworkBindingPasst,
+			func(iface virtv1.Interface) bool { return iface.Passt != nil }, true),
+	)
+})
@@ -10,6 +10,7 @@ import (
 	virtv1 "kubevirt.io/api/core/v1"
 
 	"maroonedpods.io/maroonedpods/pkg/util"
+	mpv1alpha1 "maroonedpods.io/maroonedpods/staging/src/maroonedpods.io/api/pkg/apis/core/v1alpha1"
 	"maroonedpods.io/maroonedpods/tests/builders"
 	"maroonedpods.io/maroonedpods/tests/framework"
 	testutils "maroonedpods.io/maroonedpods/tests/utils"
@@ -128,4 +129,57 @@ var _ = Describe("[e2e] Warm Pool", func() {
 			Skip("No pool VMIs found in cluster")
 		}
 	})
+
+	It("should keep tenant pool VMIs in the selected namespace", func() {
+		By("Selecting the test namespace with a tenant pool")
+		namespace, err := f.K8sClient.CoreV1().Namespaces().Get(context.Background(), ns, metav1.GetOptions{})
+		Expect(err).ToNot(HaveOccurred())
+		if namespace.Labels == nil {
+			namespace.Labels = map[string]string{}
+		}
+		namespace.Labels["maroonedpods.io/tenant-pool-test"] = ns
+		_, err = f.K8sClient.CoreV1().Namespaces().Update(context.Background(), namespace, metav1.UpdateOptions{})
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(f.UpdateMaroonedPodsConfig(func(spec *mpv1alpha1.MaroonedPodsConfigSpec) {
+			spec.TenantPools = []mpv1alpha1.TenantPool{{
+				Name:              "e2e",
+				NamespaceSelector: metav1.LabelSelector{MatchLabels: map[string]string{"maroonedpods.io/tenant-pool-test": ns}},
+				Size:              1,
+			}}
+		})).To(Succeed())
+		DeferCleanup(func() {
+			Expect(f.UpdateMaroonedPodsConfig(func(spec *mpv1alpha1.MaroonedPodsConfigSpec) {
+				spec.TenantPools = nil
+			})).To(Succeed())
+		})
+
+		By("Waiting for a VMI of the pool in the tenant namespace")
+		Eventually(func() int {
+			vmiList, err := f.ListVMIs(ns)
+			if err != nil {
+				return 0
+			}
+			poolVMIs := 0
+			for _, vmi := range vmiList.Items {
+				if vmi.Labels[util.WarmPoolNameLabel] == "e2e" {
+					poolVMIs++
+				}
+			}
+			return poolVMIs
+		}, testutils.LongTimeout, 10*time.Second).Should(Equal(1))
+
+		By("Verifying the pool is reported separately")
+		Eventually(func() []mpv1alpha1.TenantPoolStatus {
+			config, err := f.GetMaroonedPodsConfig()
+			if err != nil {
+				return nil
+			}
+			return config.Status.TenantPools
+		}, testutils.LongTimeout, 10*time.Second).Should(ContainElement(And(
+			HaveField("Name", "e2e"),
+			HaveField("Namespaces", int32(1)),
+			HaveField("Total", int32(1)),
+		)))
+	})
 })
@@ -0,0 +1,142 @@
+package tests
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	virtv1 "kubevirt.io/api/core/v1"
+
+	mpv1alpha1 "maroonedpods.io/maroonedpods/staging/src/maroonedpods.io/api/pkg/apis/core/v1alpha1"
+	"maroonedpods.io/maroonedpods/tests/builders"
+	"maroonedpods.io/maroonedpods/tests/framework"
+	testutils "maroonedpods.io/maroonedpods/tests/utils"
+)
+
+var _ = Describe("[e2e] Workload Controllers", func() {
+	var (
+		f  *framework.Framework
+		ns string
+	)
+
+	BeforeEach(func() {
+		f = framework.DefaultFramework
+		nsName := testutils.GenerateNamespaceName("workloads")
+		createdNs, err := f.CreateNamespace(nsName)
+		Expect(err).ToNot(HaveOccurred())
+		ns = createdNs.Name
+	})
+
+	AfterEach(func() {
+		if ns != "" {
+			err := f.DeleteNamespace(ns)
+			Expect(err).ToNot(HaveOccurred())
+		}
+	})
+
+	// waitForRunningPod waits for a running pod of the app other than the excluded one
+	waitForRunningPod := func(app string, excludedUID types.UID) *v1.Pod {
+		var running *v1.Pod
+		Eventually(func() bool {
+			pods, err := f.ListPods("app=" + app)
+			if err != nil {
+				return false
+			}
+			for i := range pods.Items {
+				pod := &pods.Items[i]
+				if pod.UID != excludedUID && pod.DeletionTimestamp == nil && pod.Status.Phase == v1.PodRunning {
+					running = pod
+					return true
+				}
+			}
+			return false
+		}, testutils.LongTimeout, 5*time.Second).Should(BeTrue())
+		return running
+	}
+
+	It("should run the generateName pods of a Deployment on their own VMs", func() {
+		By("Creating a Deployment of marooned pods")
+		deployment := builders.NewMaroonedDeployment("web", ns, 1)
+		_, err := f.K8sClient.AppsV1().Deployments(ns).Create(context.Background(), deployment, metav1.CreateOptions{})
+		Expect(err).ToNot(HaveOccurred())
+
+		By("Waiting for the pod to run on the node named after it")
+		pod := waitForRunningPod("web", "")
+		Expect(strings.HasPrefix(pod.Name, "web-")).To(BeTrue())
+		Expect(pod.Spec.NodeName).To(Equal(pod.Name))
+
+		vmi, err := f.GetVMI(ns, pod.Name)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(vmi.Status.Phase).To(Equal(virtv1.Running))
+	})
+
+	It("should bring a recreated StatefulSet pod back on a VM with the same identity and disk", func() {
+		By("Keeping the scratch disks of StatefulSet pods")
+		Expect(f.UpdateMaroonedPodsConfig(func(spec *mpv1alpha1.MaroonedPodsConfigSpec) {
+			spec.StatefulSetDisk = &mpv1alpha1.StatefulSetDisk{}
+		})).To(Succeed())
+		DeferCleanup(func() {
+			Expect(f.UpdateMaroonedPodsConfig(func(spec *mpv1alpha1.MaroonedPodsConfigSpec) {
+				spec.StatefulSetDisk = nil
+			})).To(Succeed())
+		})
+
+		By("Creating a StatefulSet of marooned pods")
+		statefulSet := builders.NewMaroonedStatefulSet("db", ns, 1)
+		_, err := f.K8sClient.AppsV1().StatefulSets(ns).Create(context.Background(), statefulSet, metav1.CreateOptions{})
+		Expect(err).ToNot(HaveOccurred())
+
+		pod := waitForRunningPod("db", "")
+		Expect(pod.Name).To(Equal("db-0"))
+		Expect(pod.Spec.NodeName).To(Equal("db-0"))
+
+		By("Deleting the pod")
+		Expect(f.DeletePod("db-0")).To(Succeed())
+
+		By("Waiting for the recreated pod to run on a new node with the same name")
+		recreated := waitForRunningPod("db", pod.UID)
+		Expect(recreated.Name).To(Equal("db-0"))
+		Expect(recreated.Spec.NodeName).To(Equal("db-0"))
+		node, err := f.GetNode("db-0")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(node.Labels).To(HaveKeyWithValue("maroonedpods.io/pod-uid", string(recreated.UID)))
+
+		By("Verifying the VM reuses the scratch disk PVC")
+		vmi, err := f.GetVMI(ns, "db-0")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(vmi.Spec.Volumes).To(ContainElement(HaveField("PersistentVolumeClaim.ClaimName", "db-0-marooned-scratch")))
+	})
+
+	It("should tear down the VM of a completed Job pod", func() {
+		By("Creating a Job running a marooned pod")
+		job := builders.NewMaroonedJob("task", ns)
+		_, err := f.K8sClient.BatchV1().Jobs(ns).Create(context.Background(), job, metav1.CreateOptions{})
+		Expect(err).ToNot(HaveOccurred())
+
+		By("Waiting for the Job to complete")
+		Eventually(func() int32 {
+			current, err := f.K8sClient.BatchV1().Jobs(ns).Get(context.Background(), "task", metav1.GetOptions{})
+			if err != nil {
+				return 0
+			}
+			return current.Status.Succeeded
+		}, testutils.LongTimeout, 5*time.Second).Should(Equal(int32(1)))
+
+		pods, err := f.ListPods("app=task")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(pods.Items).To(HaveLen(1))
+		podName := pods.Items[0].Name
+
+		By("Verifying the VM is torn down")
+		Expect(f.WaitForVMIDeleted(ns, podName, testutils.DefaultTimeout)).To(Succeed())
+		Eventually(func() error {
+			_, err := f.GetNode(podName)
+			return err
+		}, testutils.DefaultTimeout, 5*time.Second).Should(HaveOccurred())
+	})
+})
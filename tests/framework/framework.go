@@ -13,18 +13,20 @@ import (
 	virtv1 "kubevirt.io/api/core/v1"
 	kubevirtclient "kubevirt.io/client-go/kubecli"
 
+	generatedclient "maroonedpods.io/maroonedpods/pkg/generated/maroonedpods/clientset/versioned"
 	mpv1alpha1 "maroonedpods.io/maroonedpods/staging/src/maroonedpods.io/api/pkg/apis/core/v1alpha1"
 	"maroonedpods.io/maroonedpods/tests/flags"
 )
 
 // Framework provides access to Kubernetes and KubeVirt clients for tests
 type Framework struct {
-	K8sClient       kubernetes.Interface
-	KubevirtClient  kubevirtclient.KubevirtClient
-	RestConfig      *rest.Config
-	Namespace       *v1.Namespace
-	NamespaceName   string
-	mpNamespace     string
+	K8sClient          kubernetes.Interface
+	KubevirtClient     kubevirtclient.KubevirtClient
+	MaroonedPodsClient generatedclient.Interface
+	RestConfig         *rest.Config
+	Namespace          *v1.Namespace
+	NamespaceName      string
+	mpNamespace        string
 }
 
 var (
@@ -71,6 +73,12 @@ func NewFramework() (*Framework, error) {
 		return nil, fmt.Errorf("failed to create kubevirt client: %v", err)
 	}
 
+	// Create MaroonedPods client
+	f.MaroonedPodsClient, err = generatedclient.NewForConfig(f.RestConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create maroonedpods client: %v", err)
+	}
+
 	return f, nil
 }
 
@@ -216,3 +224,31 @@ func (f *Framework) WaitForVMIDeleted(namespace, name string, timeout time.Durat
 	}
 	return fmt.Errorf("timeout waiting for VMI %s/%s to be deleted", namespace, name)
 }
+
+// GetMaroonedPodsConfig gets the first MaroonedPodsConfig, the one the controller uses
+func (f *Framework) GetMaroonedPodsConfig() (*mpv1alpha1.MaroonedPodsConfig, error) {
+	configs, err := f.MaroonedPodsClient.MaroonedpodsV1alpha1().MaroonedPodsConfigs().List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	if len(configs.Items) == 0 {
+		return nil, fmt.Errorf("no MaroonedPodsConfig found")
+	}
+	return &configs.Items[0], nil
+}
+
+// UpdateMaroonedPodsConfig applies a change to the MaroonedPodsConfig spec
+func (f *Framework) UpdateMaroonedPodsConfig(update func(*mpv1alpha1.MaroonedPodsConfigSpec)) error {
+	config, err := f.GetMaroonedPodsConfig()
+	if err != nil {
+		return err
+	}
+	update(&config.Spec)
+	_, err = f.MaroonedPodsClient.MaroonedpodsV1alpha1().MaroonedPodsConfigs().Update(context.Background(), config, metav1.UpdateOptions{})
+	return err
+}
+
+// ListPods lists the pods of the test namespace matching the label selector
+func (f *Framework) ListPods(selector string) (*v1.PodList, error) {
+	return f.K8sClient.CoreV1().Pods(f.NamespaceName).List(context.Background(), metav1.ListOptions{LabelSelector: selector})
+}